@@ -10,6 +10,7 @@ import (
 	"github.com/felixge/fgprof"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/weaveworks/common/middleware"
@@ -290,33 +291,111 @@ func (a *API) RegisterQueryable(
 
 // RegisterQueryAPI registers the Prometheus API routes with the provided handler.
 func (a *API) RegisterQueryAPI(handler http.Handler) {
-	a.RegisterRoute(a.cfg.PrometheusHTTPPrefix+"/api/v1/read", handler, true, "POST")
-	a.RegisterRoute(a.cfg.PrometheusHTTPPrefix+"/api/v1/query", handler, true, "GET", "POST")
-	a.RegisterRoute(a.cfg.PrometheusHTTPPrefix+"/api/v1/query_range", handler, true, "GET", "POST")
-	a.RegisterRoute(a.cfg.PrometheusHTTPPrefix+"/api/v1/labels", handler, true, "GET", "POST")
-	a.RegisterRoute(a.cfg.PrometheusHTTPPrefix+"/api/v1/label/{name}/values", handler, true, "GET")
-	a.RegisterRoute(a.cfg.PrometheusHTTPPrefix+"/api/v1/series", handler, true, "GET", "POST", "DELETE")
-	a.RegisterRoute(a.cfg.PrometheusHTTPPrefix+"/api/v1/metadata", handler, true, "GET")
+	a.registerQueryAPIWithPrefix(a.cfg.PrometheusHTTPPrefix, handler)
 
 	// Register Legacy Routers
-	a.RegisterRoute(a.cfg.LegacyHTTPPrefix+"/api/v1/read", handler, true, "POST")
-	a.RegisterRoute(a.cfg.LegacyHTTPPrefix+"/api/v1/query", handler, true, "GET", "POST")
-	a.RegisterRoute(a.cfg.LegacyHTTPPrefix+"/api/v1/query_range", handler, true, "GET", "POST")
-	a.RegisterRoute(a.cfg.LegacyHTTPPrefix+"/api/v1/labels", handler, true, "GET", "POST")
-	a.RegisterRoute(a.cfg.LegacyHTTPPrefix+"/api/v1/label/{name}/values", handler, true, "GET")
-	a.RegisterRoute(a.cfg.LegacyHTTPPrefix+"/api/v1/series", handler, true, "GET", "POST", "DELETE")
-	a.RegisterRoute(a.cfg.LegacyHTTPPrefix+"/api/v1/metadata", handler, true, "GET")
+	a.registerQueryAPIWithPrefix(a.cfg.LegacyHTTPPrefix, handler)
+}
+
+func (a *API) registerQueryAPIWithPrefix(prefix string, handler http.Handler) {
+	a.registerQueryAPIRoutes(prefix, handler, true)
+}
+
+func (a *API) registerQueryAPIRoutes(prefix string, handler http.Handler, auth bool) {
+	a.RegisterRoute(prefix+"/api/v1/read", handler, auth, "POST")
+	a.RegisterRoute(prefix+"/api/v1/query", handler, auth, "GET", "POST")
+	a.RegisterRoute(prefix+"/api/v1/query_range", handler, auth, "GET", "POST")
+	a.RegisterRoute(prefix+"/api/v1/labels", handler, auth, "GET", "POST")
+	a.RegisterRoute(prefix+"/api/v1/label/{name}/values", handler, auth, "GET")
+	a.RegisterRoute(prefix+"/api/v1/series", handler, auth, "GET", "POST", "DELETE")
+	a.RegisterRoute(prefix+"/api/v1/metadata", handler, auth, "GET")
 }
 
 // RegisterQueryFrontend registers the Prometheus routes supported by the
 // Cortex querier service. Currently this can not be registered simultaneously
-// with the Querier.
-func (a *API) RegisterQueryFrontendHandler(h http.Handler) {
-	a.RegisterQueryAPI(h)
+// with the Querier. If corsPreflight is true, the OPTIONS requests are routed
+// to the handler without authentication, as the CORS preflight requests don't
+// carry the tenant ID. The routes are also registered under the path prefix the
+// query-frontend is hosted under, if not empty, as the handler strips it. The
+// tenant resolver, if not nil, sets the X-Scope-OrgID header of the requests
+// before they're authenticated.
+func (a *API) RegisterQueryFrontendHandler(h http.Handler, corsPreflight bool, pathPrefix string, tenantResolver middleware.Interface) {
+	prefixes := []string{""}
+	if pathPrefix != "" {
+		prefixes = append(prefixes, pathPrefix)
+	}
+
+	authenticated := a.queryFrontendAuthMiddleware(tenantResolver).Wrap(h)
+	for _, prefix := range prefixes {
+		a.registerQueryAPIRoutes(prefix+a.cfg.PrometheusHTTPPrefix, authenticated, false)
+		a.registerQueryAPIRoutes(prefix+a.cfg.LegacyHTTPPrefix, authenticated, false)
+
+		if corsPreflight {
+			a.RegisterRoutesWithPrefix(prefix+a.cfg.PrometheusHTTPPrefix+"/api/v1/", h, false, "OPTIONS")
+			a.RegisterRoutesWithPrefix(prefix+a.cfg.LegacyHTTPPrefix+"/api/v1/", h, false, "OPTIONS")
+		}
+	}
+}
+
+// RegisterQueryFrontendPassthrough registers the query-frontend handler for the requests it
+// forwards as is, matched by the function.
+func (a *API) RegisterQueryFrontendPassthrough(h http.Handler, matches func(*http.Request) bool, tenantResolver middleware.Interface) {
+	level.Debug(a.logger).Log("msg", "api: registering query-frontend passthrough route", "auth", true)
+	a.server.HTTP.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		return matches(r)
+	}).Handler(a.queryFrontendAuthMiddleware(tenantResolver).Wrap(h))
+}
+
+// queryFrontendAuthMiddleware returns the authentication middleware of the query-frontend
+// requests, preceded by the tenant resolver if not nil.
+func (a *API) queryFrontendAuthMiddleware(tenantResolver middleware.Interface) middleware.Interface {
+	if tenantResolver == nil {
+		return a.authMiddleware
+	}
+	return middleware.Merge(tenantResolver, a.authMiddleware)
+}
+
+// RegisterQueryFrontendBuildInfo registers the endpoint exposing the version and the
+// configuration hash of the query-frontend.
+func (a *API) RegisterQueryFrontendBuildInfo(h http.Handler) {
+	a.indexPage.AddLink(SectionAdminEndpoints, "/frontend/buildinfo", "Query Frontend Build Info")
+	a.RegisterRoute("/frontend/buildinfo", h, false, "GET")
+}
+
+// RegisterQueryFrontendHealth registers the liveness and readiness endpoints of the query-frontend,
+// so that a wedged query-frontend can be restarted without restarting the ones missing queriers.
+func (a *API) RegisterQueryFrontendHealth(live, ready http.Handler) {
+	a.RegisterRoute("/frontend/live", live, false, "GET")
+	a.RegisterRoute("/frontend/ready", ready, false, "GET")
+}
+
+// RegisterQueryFrontendCacheWarmer registers the endpoint starting, reporting the status of and
+// cancelling the cache warming jobs. The handler is expected to authenticate the requests, as they
+// don't carry the tenant ID.
+func (a *API) RegisterQueryFrontendCacheWarmer(h http.Handler) {
+	a.indexPage.AddLink(SectionAdminEndpoints, "/frontend/cache/warm", "Query Frontend Cache Warming")
+	a.RegisterRoute("/frontend/cache/warm", h, false, "GET", "POST", "DELETE")
+}
+
+// RegisterQueryFrontendTenantLimits registers the endpoint exposing the limits of the tenant
+// of the request.
+func (a *API) RegisterQueryFrontendTenantLimits(h http.Handler) {
+	a.RegisterRoute("/frontend/tenant/limits", h, true, "GET")
 }
 
 func (a *API) RegisterQueryFrontend1(f *frontend.Frontend) {
 	frontend.RegisterFrontendServer(a.server.GRPC, f)
+
+	a.indexPage.AddLink(SectionAdminEndpoints, "/frontend/queriers", "Query Frontend Queriers")
+	a.RegisterRoute("/frontend/queriers", http.HandlerFunc(f.QueriersHandler), false, "GET")
+	a.indexPage.AddLink(SectionAdminEndpoints, "/frontend/queue", "Query Frontend Queue")
+	a.RegisterRoute("/frontend/queue", http.HandlerFunc(f.QueueHandler), false, "GET")
+}
+
+// RegisterQueryFrontendCancelTenant registers the endpoint cancelling the queries of a tenant. The
+// handler is expected to authenticate the requests, as they don't carry the tenant ID.
+func (a *API) RegisterQueryFrontendCancelTenant(h http.Handler) {
+	a.RegisterRoute("/frontend/tenant/{id}/cancel", h, false, "POST")
 }
 
 func (a *API) RegisterQueryFrontend2(f *frontend2.Frontend2) {
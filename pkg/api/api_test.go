@@ -1,11 +1,18 @@
 package api
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/go-kit/kit/log"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/server"
+	"github.com/weaveworks/common/user"
 )
 
 type FakeLogger struct{}
@@ -59,3 +66,120 @@ func TestNewApiWithInvalidSourceIPExtractor(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, api)
 }
+
+func TestRegisterQueryFrontendHandler_CORSPreflight(t *testing.T) {
+	for _, corsPreflight := range []bool{false, true} {
+		s := &server.Server{HTTP: mux.NewRouter()}
+		api, err := New(Config{PrometheusHTTPPrefix: "/prometheus", LegacyHTTPPrefix: "/api/prom"}, server.Config{}, s, log.NewNopLogger())
+		require.NoError(t, err)
+
+		api.RegisterQueryFrontendHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}), corsPreflight, "", nil)
+
+		// The preflight request doesn't carry the tenant ID.
+		req := httptest.NewRequest(http.MethodOptions, "/prometheus/api/v1/query_range", nil)
+		w := httptest.NewRecorder()
+		s.HTTP.ServeHTTP(w, req)
+
+		if corsPreflight {
+			assert.Equal(t, http.StatusNoContent, w.Code)
+		} else {
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		}
+
+		// Other requests still require the tenant ID.
+		req = httptest.NewRequest(http.MethodGet, "/prometheus/api/v1/query_range", nil)
+		w = httptest.NewRecorder()
+		s.HTTP.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestRegisterQueryFrontendHandler_PathPrefix(t *testing.T) {
+	s := &server.Server{HTTP: mux.NewRouter()}
+	api, err := New(Config{PrometheusHTTPPrefix: "/prometheus", LegacyHTTPPrefix: "/api/prom"}, server.Config{}, s, log.NewNopLogger())
+	require.NoError(t, err)
+
+	api.RegisterQueryFrontendHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), false, "/frontend-prefix", nil)
+
+	for path, expectedStatus := range map[string]int{
+		"/prometheus/api/v1/query_range":                 http.StatusNoContent,
+		"/frontend-prefix/prometheus/api/v1/query_range": http.StatusNoContent,
+		"/frontend-prefix/api/prom/api/v1/query":         http.StatusNoContent,
+		"/frontend-prefix/other":                         http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Scope-OrgID", "1")
+		w := httptest.NewRecorder()
+		s.HTTP.ServeHTTP(w, req)
+		assert.Equal(t, expectedStatus, w.Code, path)
+	}
+}
+
+func TestRegisterQueryFrontendPassthrough(t *testing.T) {
+	s := &server.Server{HTTP: mux.NewRouter()}
+	api, err := New(Config{PrometheusHTTPPrefix: "/prometheus", LegacyHTTPPrefix: "/api/prom"}, server.Config{}, s, log.NewNopLogger())
+	require.NoError(t, err)
+
+	api.RegisterQueryFrontendPassthrough(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/prometheus/api/v1/admin/tsdb/")
+	}, nil)
+
+	for path, expectedStatus := range map[string]int{
+		"/prometheus/api/v1/admin/tsdb/snapshot": http.StatusNoContent,
+		"/prometheus/api/v1/admin/other":         http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-Scope-OrgID", "1")
+		w := httptest.NewRecorder()
+		s.HTTP.ServeHTTP(w, req)
+		assert.Equal(t, expectedStatus, w.Code, path)
+	}
+
+	// The requests still require the tenant ID.
+	w := httptest.NewRecorder()
+	s.HTTP.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/prometheus/api/v1/admin/tsdb/snapshot", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRegisterQueryFrontendHandler_TenantResolver(t *testing.T) {
+	s := &server.Server{HTTP: mux.NewRouter()}
+	api, err := New(Config{PrometheusHTTPPrefix: "/prometheus", LegacyHTTPPrefix: "/api/prom"}, server.Config{}, s, log.NewNopLogger())
+	require.NoError(t, err)
+
+	tenantResolver := middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set(user.OrgIDHeaderName, r.Header.Get("X-Tenant"))
+			next.ServeHTTP(w, r)
+		})
+	})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := user.ExtractOrgID(r.Context())
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(orgID))
+	})
+	api.RegisterQueryFrontendHandler(h, false, "", tenantResolver)
+	api.RegisterQueryFrontendPassthrough(h, func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/prometheus/api/v1/admin/tsdb/")
+	}, tenantResolver)
+
+	for _, path := range []string{"/prometheus/api/v1/query", "/prometheus/api/v1/admin/tsdb/snapshot"} {
+		// The tenant ID is injected in the context as if it was sent in the X-Scope-OrgID header.
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-Tenant", "team-a")
+		w := httptest.NewRecorder()
+		s.HTTP.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Equal(t, "team-a", w.Body.String(), path)
+
+		// The requests still require a tenant ID.
+		w = httptest.NewRecorder()
+		s.HTTP.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+	}
+}
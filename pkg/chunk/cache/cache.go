@@ -29,6 +29,9 @@ type Config struct {
 
 	DefaultValidity time.Duration `yaml:"default_validity"`
 
+	// Fraction of the expiration the expiration of each key is randomized by, set by the results cache.
+	TTLJitter float64 `yaml:"-"`
+
 	Background     BackgroundConfig      `yaml:"background"`
 	Memcache       MemcachedConfig       `yaml:"memcached"`
 	MemcacheClient MemcachedClientConfig `yaml:"memcached_client"`
@@ -72,6 +75,7 @@ func New(cfg Config, reg prometheus.Registerer, logger log.Logger) (Cache, error
 		if cfg.Fifocache.Validity == 0 && cfg.DefaultValidity != 0 {
 			cfg.Fifocache.Validity = cfg.DefaultValidity
 		}
+		cfg.Fifocache.TTLJitter = cfg.TTLJitter
 
 		if cache := NewFifoCache(cfg.Prefix+"fifocache", cfg.Fifocache, reg, logger); cache != nil {
 			caches = append(caches, Instrument(cfg.Prefix+"fifocache", cache, reg))
@@ -86,6 +90,7 @@ func New(cfg Config, reg prometheus.Registerer, logger log.Logger) (Cache, error
 		if cfg.Memcache.Expiration == 0 && cfg.DefaultValidity != 0 {
 			cfg.Memcache.Expiration = cfg.DefaultValidity
 		}
+		cfg.Memcache.TTLJitter = cfg.TTLJitter
 
 		client := NewMemcachedClient(cfg.MemcacheClient, cfg.Prefix, reg, logger)
 		cache := NewMemcached(cfg.Memcache, client, cfg.Prefix, reg, logger)
@@ -98,6 +103,7 @@ func New(cfg Config, reg prometheus.Registerer, logger log.Logger) (Cache, error
 		if cfg.Redis.Expiration == 0 && cfg.DefaultValidity != 0 {
 			cfg.Redis.Expiration = cfg.DefaultValidity
 		}
+		cfg.Redis.TTLJitter = cfg.TTLJitter
 		cacheName := cfg.Prefix + "redis"
 		cache := NewRedisCache(cacheName, NewRedisClient(&cfg.Redis), logger)
 		caches = append(caches, NewBackground(cacheName, cfg.Background, Instrument(cacheName, cache, reg), reg))
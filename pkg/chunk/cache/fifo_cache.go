@@ -34,6 +34,8 @@ type FifoCacheConfig struct {
 	MaxSizeBytes string        `yaml:"max_size_bytes"`
 	MaxSizeItems int           `yaml:"max_size_items"`
 	Validity     time.Duration `yaml:"validity"`
+	// Fraction of the validity the validity of each key is randomized by, set by the results cache.
+	TTLJitter float64 `yaml:"-"`
 
 	DeprecatedSize int `yaml:"size"`
 }
@@ -71,6 +73,7 @@ type FifoCache struct {
 	maxSizeBytes  uint64
 	currSizeBytes uint64
 	validity      time.Duration
+	ttlJitter     float64
 
 	entries map[string]*list.Element
 	lru     *list.List
@@ -111,6 +114,7 @@ func NewFifoCache(name string, cfg FifoCacheConfig, reg prometheus.Registerer, l
 		maxSizeItems: cfg.MaxSizeItems,
 		maxSizeBytes: maxSizeBytes,
 		validity:     cfg.Validity,
+		ttlJitter:    cfg.TTLJitter,
 		entries:      make(map[string]*list.Element),
 		lru:          list.New(),
 
@@ -284,7 +288,7 @@ func (c *FifoCache) Get(ctx context.Context, key string) ([]byte, bool) {
 	element, ok := c.entries[key]
 	if ok {
 		entry := element.Value.(*cacheEntry)
-		if c.validity == 0 || time.Since(entry.updated) < c.validity {
+		if c.validity == 0 || time.Since(entry.updated) < JitteredExpiration(key, c.validity, c.ttlJitter) {
 			return entry.value, true
 		}
 
@@ -33,6 +33,8 @@ func (o observableVecCollector) After(method, statusCode string, start time.Time
 // MemcachedConfig is config to make a Memcached
 type MemcachedConfig struct {
 	Expiration time.Duration `yaml:"expiration"`
+	// Fraction of the expiration the expiration of each key is randomized by, set by the results cache.
+	TTLJitter float64 `yaml:"-"`
 
 	BatchSize   int `yaml:"batch_size"`
 	Parallelism int `yaml:"parallelism"`
@@ -227,7 +229,7 @@ func (c *Memcached) Store(ctx context.Context, keys []string, bufs [][]byte) {
 			item := memcache.Item{
 				Key:        keys[i],
 				Value:      bufs[i],
-				Expiration: int32(c.cfg.Expiration.Seconds()),
+				Expiration: int32(JitteredExpiration(keys[i], c.cfg.Expiration, c.cfg.TTLJitter).Seconds()),
 			}
 			return c.memcache.Set(&item)
 		})
@@ -27,6 +27,9 @@ type RedisConfig struct {
 	InsecureSkipVerify bool           `yaml:"tls_insecure_skip_verify"`
 	IdleTimeout        time.Duration  `yaml:"idle_timeout"`
 	MaxConnAge         time.Duration  `yaml:"max_connection_age"`
+
+	// Fraction of the expiration the expiration of each key is randomized by, set by the results cache.
+	TTLJitter float64 `yaml:"-"`
 }
 
 // RegisterFlagsWithPrefix adds the flags required to config this to the given FlagSet
@@ -46,6 +49,7 @@ func (cfg *RedisConfig) RegisterFlagsWithPrefix(prefix, description string, f *f
 
 type RedisClient struct {
 	expiration time.Duration
+	ttlJitter  float64
 	timeout    time.Duration
 	rdb        redis.UniversalClient
 }
@@ -66,6 +70,7 @@ func NewRedisClient(cfg *RedisConfig) *RedisClient {
 	}
 	return &RedisClient{
 		expiration: cfg.Expiration,
+		ttlJitter:  cfg.TTLJitter,
 		timeout:    cfg.Timeout,
 		rdb:        redis.NewUniversalClient(opt),
 	}
@@ -97,7 +102,7 @@ func (c *RedisClient) MSet(ctx context.Context, keys []string, values [][]byte)
 
 	pipe := c.rdb.TxPipeline()
 	for i := range keys {
-		pipe.Set(ctx, keys[i], values[i], c.expiration)
+		pipe.Set(ctx, keys[i], values[i], JitteredExpiration(keys[i], c.expiration, c.ttlJitter))
 	}
 	_, err := pipe.Exec(ctx)
 	return err
@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/cespare/xxhash"
+)
+
+// JitteredExpiration returns the expiration of the key, randomized by up to the jitter fraction of the
+// expiration, in either direction, so that the entries written at the same time don't all expire together.
+// The jitter is derived from the key, so all the replicas writing the same key agree on its expiration.
+func JitteredExpiration(key string, expiration time.Duration, jitter float64) time.Duration {
+	if expiration <= 0 || jitter <= 0 {
+		return expiration
+	}
+
+	// Maps the hash of the key to [-1, 1).
+	f := float64(xxhash.Sum64String(key)>>11)/(1<<52) - 1
+	return expiration + time.Duration(f*jitter*float64(expiration))
+}
@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitteredExpiration(t *testing.T) {
+	assert.Equal(t, time.Hour, JitteredExpiration("key", time.Hour, 0))
+	assert.Equal(t, time.Duration(0), JitteredExpiration("key", 0, 0.1))
+
+	// The expiration is the same for the same key.
+	assert.Equal(t, JitteredExpiration("key", time.Hour, 0.1), JitteredExpiration("key", time.Hour, 0.1))
+
+	var below, above int
+	for i := 0; i < 1000; i++ {
+		expiration := JitteredExpiration(fmt.Sprintf("key-%d", i), time.Hour, 0.1)
+		assert.True(t, expiration >= 54*time.Minute && expiration < 66*time.Minute, "expiration %s out of the jitter band", expiration)
+		if expiration < time.Hour {
+			below++
+		} else {
+			above++
+		}
+	}
+	// The expirations are spread on both sides of the configured one.
+	assert.Greater(t, below, 400)
+	assert.Greater(t, above, 400)
+}
@@ -51,6 +51,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/modules"
 	"github.com/cortexproject/cortex/pkg/util/runtimeconfig"
 	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/tracing"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
@@ -75,6 +76,7 @@ import (
 type Config struct {
 	Target      flagext.StringSliceCSV `yaml:"target"`
 	AuthEnabled bool                   `yaml:"auth_enabled"`
+	AuthOrgID   string                 `yaml:"auth_default_org_id"`
 	PrintConfig bool                   `yaml:"-"`
 	HTTPPrefix  string                 `yaml:"http_prefix"`
 
@@ -106,6 +108,7 @@ type Config struct {
 	RuntimeConfig  runtimeconfig.ManagerConfig                `yaml:"runtime_config"`
 	MemberlistKV   memberlist.KVConfig                        `yaml:"memberlist"`
 	QueryScheduler scheduler.Config                           `yaml:"query_scheduler"`
+	Tracing        tracing.Config                             `yaml:"tracing"`
 }
 
 // RegisterFlags registers flag.
@@ -121,6 +124,7 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 		"Use '-modules' command line flag to get a list of available modules, and to see which modules are included in 'all'.")
 
 	f.BoolVar(&c.AuthEnabled, "auth.enabled", true, "Set to false to disable auth.")
+	f.StringVar(&c.AuthOrgID, "auth.default-org-id", fakeauth.DefaultOrgID, "Tenant ID injected in all the requests when auth is disabled, so that clients don't need to set the X-Scope-OrgID header. It must be the same in all the Cortex components.")
 	f.BoolVar(&c.PrintConfig, "print.config", false, "Print the config and exit.")
 	f.StringVar(&c.HTTPPrefix, "http.prefix", "/api/prom", "HTTP path prefix for Cortex API.")
 
@@ -152,6 +156,7 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	c.RuntimeConfig.RegisterFlags(f)
 	c.MemberlistKV.RegisterFlags(f, "")
 	c.QueryScheduler.RegisterFlags(f)
+	c.Tracing.RegisterFlags(f)
 
 	// These don't seem to have a home.
 	f.IntVar(&chunk_util.QueryParallelism, "querier.query-parallelism", 100, "Max subqueries run in parallel per higher-level query.")
@@ -200,12 +205,18 @@ func (c *Config) Validate(log log.Logger) error {
 	if err := c.QueryRange.Validate(log); err != nil {
 		return errors.Wrap(err, "invalid query_range config")
 	}
+	if err := c.Frontend.Validate(); err != nil {
+		return errors.Wrap(err, "invalid frontend config")
+	}
 	if err := c.TableManager.Validate(); err != nil {
 		return errors.Wrap(err, "invalid table-manager config")
 	}
 	if err := c.StoreGateway.Validate(c.LimitsConfig); err != nil {
 		return errors.Wrap(err, "invalid store-gateway config")
 	}
+	if err := c.Tracing.Validate(); err != nil {
+		return errors.Wrap(err, "invalid tracing config")
+	}
 
 	if c.Storage.Engine == storage.StorageEngineBlocks && c.Querier.SecondStoreEngine != storage.StorageEngineChunks && len(c.Schema.Configs) > 0 {
 		level.Warn(log).Log("schema configuration is not used by the blocks storage engine, and will have no effect")
@@ -263,6 +274,7 @@ type Cortex struct {
 	Store                    chunk.Store
 	DeletesStore             *purger.DeleteStore
 	Frontend                 *frontend.Frontend
+	FrontendDownstreamProbe  *frontend.DownstreamProbe
 	TableManager             *chunk.TableManager
 	RuntimeConfig            *runtimeconfig.Manager
 	Purger                   *purger.Purger
@@ -296,7 +308,7 @@ func New(cfg Config) (*Cortex, error) {
 
 	// Don't check auth header on TransferChunks, as we weren't originally
 	// sending it and this could cause transfers to fail on update.
-	cfg.API.HTTPAuthMiddleware = fakeauth.SetupAuthMiddleware(&cfg.Server, cfg.AuthEnabled,
+	cfg.API.HTTPAuthMiddleware = fakeauth.SetupAuthMiddleware(&cfg.Server, cfg.AuthEnabled, cfg.AuthOrgID,
 		// Also don't check auth for these gRPC methods, since single call is used for multiple users (or no user like health check).
 		[]string{
 			"/grpc.health.v1.Health/Check",
@@ -448,6 +460,15 @@ func (t *Cortex) readyHandler(sm *services.Manager) http.HandlerFunc {
 			}
 		}
 
+		// When a downstream Prometheus is used instead, the Query Frontend can optionally check
+		// that it's reachable.
+		if t.FrontendDownstreamProbe != nil {
+			if err := t.FrontendDownstreamProbe.CheckReady(r.Context()); err != nil {
+				http.Error(w, "Query Frontend not ready: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
 		http.Error(w, "ready", http.StatusOK)
 	}
 }
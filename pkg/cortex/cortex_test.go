@@ -71,6 +71,8 @@ func TestCortex(t *testing.T) {
 
 		Target: []string{All, Compactor},
 	}
+	// The query-frontend and querier worker configs are validated when initialising the modules.
+	flagext.DefaultValues(&cfg.Frontend, &cfg.Worker)
 
 	c, err := New(cfg)
 	require.NoError(t, err)
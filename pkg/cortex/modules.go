@@ -1,7 +1,9 @@
 package cortex
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
@@ -153,7 +155,7 @@ func (t *Cortex) initRuntimeConfig() (services.Service, error) {
 		// no need to initialize module if load path is empty
 		return nil, nil
 	}
-	t.Cfg.RuntimeConfig.Loader = loadRuntimeConfig
+	t.Cfg.RuntimeConfig.Loader = runtimeConfigLoader(t.Cfg.Distributor.ShardByAllLabels)
 
 	// make sure to set default limits before we start loading configuration into memory
 	validation.SetDefaultLimitsForYAMLUnmarshalling(t.Cfg.LimitsConfig)
@@ -466,6 +468,11 @@ func (t *Cortex) initQueryFrontendTripperware() (serv services.Service, err erro
 		}
 	}
 
+	// The range queries are split at the boundary of the historical downstream, to route each part.
+	if t.Cfg.Frontend.Handler.Historical.URL != "" {
+		t.Cfg.QueryRange.HistoricalQueryThreshold = t.Cfg.Frontend.Handler.Historical.Threshold
+	}
+
 	tripperware, cache, err := queryrange.NewTripperware(
 		t.Cfg.QueryRange,
 		util.Logger,
@@ -508,31 +515,104 @@ func (t *Cortex) initQueryFrontend() (serv services.Service, err error) {
 		return nil, err
 	}
 
-	// Wrap roundtripper into Tripperware.
-	roundTripper = t.QueryFrontendTripperware(roundTripper)
+	passthrough, err := frontend.NewPassthroughPaths(t.Cfg.Frontend.Handler)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrap roundtripper into Tripperware, except for the requests forwarded as is.
+	roundTripper = passthrough.Wrap(roundTripper, t.QueryFrontendTripperware(roundTripper))
 
-	handler := frontend.NewHandler(t.Cfg.Frontend.Handler, roundTripper, util.Logger)
+	frontendHandler := frontend.NewHandler(t.Cfg.Frontend.Handler, t.Overrides, roundTripper, util.Logger, prometheus.DefaultRegisterer)
+	handler := http.Handler(frontendHandler)
 	if t.Cfg.Frontend.CompressResponses {
 		handler = gziphandler.GzipHandler(handler)
 	}
 
-	t.API.RegisterQueryFrontendHandler(handler)
+	// The tenant ID is read from the X-Scope-OrgID header by the authentication middleware alone, unless
+	// other sources are configured.
+	var tenantResolver middleware.Interface
+	if resolver := frontend.NewTenantResolver(t.Cfg.Frontend.Handler.TenantResolver, util.Logger); resolver != nil {
+		tenantResolver = resolver
+	}
+	t.API.RegisterQueryFrontendHandler(handler, t.Cfg.Frontend.Handler.CORS.Enabled(), t.Cfg.Frontend.Handler.PathPrefix, tenantResolver)
+	if passthrough != nil {
+		t.API.RegisterQueryFrontendPassthrough(handler, passthrough.Matches, tenantResolver)
+	}
+	// The server isn't serving yet, as the modules are initialised before the services start.
+	t.Cfg.Frontend.Handler.HTTPServer.Apply(t.Server.HTTPServer, prometheus.DefaultRegisterer)
+
+	configHash, err := frontend.ConfigHash(t.Cfg.Frontend, t.Cfg.Worker, t.Cfg.LimitsConfig)
+	if err != nil {
+		return nil, err
+	}
+	t.API.RegisterQueryFrontendBuildInfo(frontend.NewBuildInfoHandler(t.Cfg.Frontend.Handler, configHash, util.Logger))
+	t.API.RegisterQueryFrontendTenantLimits(frontend.NewTenantLimitsHandler(t.Cfg.Frontend.Handler, t.Overrides, util.Logger))
+
+	if t.Cfg.Frontend.DownstreamURL != "" && t.Cfg.Frontend.DownstreamProbe.Enabled {
+		t.FrontendDownstreamProbe, err = frontend.NewDownstreamProbe(t.Cfg.Frontend.DownstreamProbe, t.Cfg.Frontend.DownstreamURL, util.Logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Unlike /ready, these checks only cover the query-frontend.
+	var liveChecks, readyChecks []func(context.Context) error
+	switch {
+	case frontendV1 != nil:
+		liveChecks = append(liveChecks, frontendV1.CheckAlive)
+		readyChecks = append(readyChecks, frontendV1.CheckReady)
+	case frontendV2 != nil:
+		readyChecks = append(readyChecks, frontendV2.CheckReady)
+	case t.FrontendDownstreamProbe != nil:
+		readyChecks = append(readyChecks, t.FrontendDownstreamProbe.CheckReady)
+	}
+	t.API.RegisterQueryFrontendHealth(frontend.HealthHandler(liveChecks...), frontend.HealthHandler(readyChecks...))
+
+	// The cache warming jobs are stopped along with the query-frontend.
+	stopCacheWarming := func() {}
+	if t.Cfg.Frontend.CacheWarming.Enabled {
+		warmer := frontend.NewCacheWarmer(t.Cfg.Frontend.CacheWarming, frontendHandler, t.Cfg.API.PrometheusHTTPPrefix, util.Logger, prometheus.DefaultRegisterer)
+		t.API.RegisterQueryFrontendCacheWarmer(frontend.NewAdminHandler(t.Cfg.Frontend.Handler, "/frontend/cache/warm", warmer, util.Logger))
+		stopCacheWarming = warmer.Stop
+	}
 
+	// The requests in flight are drained before the query-frontend stops, while the server
+	// and the queriers connected to it still serve them.
 	if frontendV1 != nil {
 		t.API.RegisterQueryFrontend1(frontendV1)
+		t.API.RegisterQueryFrontendCancelTenant(frontend.NewAdminHandler(t.Cfg.Frontend.Handler, "/frontend/tenant/{id}/cancel", http.HandlerFunc(frontendV1.CancelTenantHandler), util.Logger))
 		t.Frontend = frontendV1
 
 		return services.NewIdleService(nil, func(_ error) error {
+			stopCacheWarming()
+			frontendHandler.Drain(t.Server.HTTPServer)
 			frontendV1.Close()
 			return nil
 		}), nil
 	} else if frontendV2 != nil {
 		t.API.RegisterQueryFrontend2(frontendV2)
 
-		return frontendV2, nil
+		return services.NewBasicService(func(ctx context.Context) error {
+			return services.StartAndAwaitRunning(ctx, frontendV2)
+		}, func(ctx context.Context) error {
+			// The query-frontend fails if the frontend stops on its own.
+			if err := frontendV2.AwaitTerminated(ctx); ctx.Err() == nil {
+				return fmt.Errorf("frontend stopped unexpectedly: %v", err)
+			}
+			return nil
+		}, func(_ error) error {
+			stopCacheWarming()
+			frontendHandler.Drain(t.Server.HTTPServer)
+			return services.StopAndAwaitTerminated(context.Background(), frontendV2)
+		}), nil
 	}
 
-	return nil, nil
+	return services.NewIdleService(nil, func(_ error) error {
+		stopCacheWarming()
+		frontendHandler.Drain(t.Server.HTTPServer)
+		return nil
+	}), nil
 }
 
 func (t *Cortex) initTableManager() (services.Service, error) {
@@ -3,6 +3,7 @@ package cortex
 import (
 	"io"
 
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 
 	"github.com/cortexproject/cortex/pkg/ring/kv"
@@ -19,16 +20,29 @@ type runtimeConfigValues struct {
 	Multi kv.MultiRuntimeConfig `yaml:"multi_kv_config"`
 }
 
-func loadRuntimeConfig(r io.Reader) (interface{}, error) {
-	var overrides = &runtimeConfigValues{}
+// runtimeConfigLoader returns the loader of the runtime config, rejecting the files with invalid
+// per-tenant limits so that the previously loaded config is kept.
+func runtimeConfigLoader(shardByAllLabels bool) runtimeconfig.Loader {
+	return func(r io.Reader) (interface{}, error) {
+		var overrides = &runtimeConfigValues{}
 
-	decoder := yaml.NewDecoder(r)
-	decoder.SetStrict(true)
-	if err := decoder.Decode(&overrides); err != nil {
-		return nil, err
-	}
+		decoder := yaml.NewDecoder(r)
+		decoder.SetStrict(true)
+		if err := decoder.Decode(&overrides); err != nil {
+			return nil, err
+		}
 
-	return overrides, nil
+		for userID, limits := range overrides.TenantLimits {
+			if limits == nil {
+				continue
+			}
+			if err := limits.Validate(shardByAllLabels); err != nil {
+				return nil, errors.Wrapf(err, "invalid limits of tenant %s", userID)
+			}
+		}
+
+		return overrides, nil
+	}
 }
 
 func tenantLimitsFromRuntimeConfig(c *runtimeconfig.Manager) validation.TenantLimits {
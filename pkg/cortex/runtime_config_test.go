@@ -0,0 +1,69 @@
+package cortex
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeConfigLoader(t *testing.T) {
+	for name, tc := range map[string]struct {
+		shardByAllLabels bool
+		config           string
+		expectedErr      string
+	}{
+		"valid limits": {
+			config: `
+overrides:
+  user-1:
+    max_queriers_per_tenant: 5
+  user-2:
+`,
+		},
+		"unknown field": {
+			config: `
+overrides:
+  user-1:
+    unknown: 5
+`,
+			expectedErr: "field unknown not found",
+		},
+		"invalid limits": {
+			config: `
+overrides:
+  user-1:
+    max_global_series_per_user: 5
+`,
+			expectedErr: "invalid limits of tenant user-1",
+		},
+		"invalid blocked query pattern": {
+			config: `
+overrides:
+  user-1:
+    blocked_queries: ["rate("]
+`,
+			expectedErr: "invalid limits of tenant user-1: invalid blocked query pattern",
+		},
+		"limits valid with sharding by all labels": {
+			shardByAllLabels: true,
+			config: `
+overrides:
+  user-1:
+    max_global_series_per_user: 5
+`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := runtimeConfigLoader(tc.shardByAllLabels)(strings.NewReader(tc.config))
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, cfg.(*runtimeConfigValues).TenantLimits, "user-1")
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package frontend
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/weaveworks/common/httpgrpc"
+)
+
+// Types of the errors of the Prometheus API.
+const (
+	errorTimeout  = "timeout"
+	errorCanceled = "canceled"
+	errorBadData  = "bad_data"
+	errorInternal = "internal"
+)
+
+// queryAPIPath matches the endpoints of the Prometheus query API, whose errors are returned in the
+// Prometheus format.
+var queryAPIPath = regexp.MustCompile(`/api/v1/(query|query_range|query_exemplars|series|labels|label/[^/]+/values|metadata)$`)
+
+func isQueryAPIRequest(r *http.Request) bool {
+	return queryAPIPath.MatchString(r.URL.Path)
+}
+
+// prometheusError is the body of the error responses of the Prometheus API.
+type prometheusError struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
+
+// prometheusErrorResponse returns the error response with its body in the Prometheus format, unless
+// it's already in this format, eg. when the error was returned by the querier.
+func prometheusErrorResponse(resp *httpgrpc.HTTPResponse, outcome string) *httpgrpc.HTTPResponse {
+	var existing prometheusError
+	if err := json.Unmarshal(resp.Body, &existing); err == nil && existing.Status == "error" {
+		return resp
+	}
+
+	body, err := json.Marshal(prometheusError{
+		Status:    "error",
+		ErrorType: prometheusErrorType(outcome, int(resp.Code)),
+		Error:     string(resp.Body),
+	})
+	if err != nil {
+		return resp
+	}
+
+	headers := []*httpgrpc.Header{{Key: "Content-Type", Values: []string{"application/json"}}}
+	for _, h := range resp.Headers {
+		if http.CanonicalHeaderKey(h.Key) != "Content-Type" {
+			headers = append(headers, h)
+		}
+	}
+	return &httpgrpc.HTTPResponse{Code: resp.Code, Headers: headers, Body: body}
+}
+
+// prometheusErrorType returns the type of the error of the Prometheus API, from the outcome of the
+// query and the status code it's mapped to.
+func prometheusErrorType(outcome string, code int) string {
+	switch {
+	case outcome == outcomeDeadlineExceeded:
+		return errorTimeout
+	case outcome == outcomeCanceled:
+		return errorCanceled
+	case code/100 == 4:
+		return errorBadData
+	default:
+		return errorInternal
+	}
+}
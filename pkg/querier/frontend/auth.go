@@ -0,0 +1,210 @@
+package frontend
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// How often the credentials file is checked for changes, at most.
+const credentialsFileCheckInterval = 10 * time.Second
+
+// AuthConfig configures the credentials required to query the frontend. This is unrelated to
+// the tenant ID, and it's checked in addition to it.
+type AuthConfig struct {
+	BearerToken     flagext.Secret `yaml:"auth_bearer_token"`
+	BasicUsername   string         `yaml:"auth_basic_username"`
+	BasicPassword   flagext.Secret `yaml:"auth_basic_password"`
+	CredentialsFile string         `yaml:"auth_credentials_file"`
+}
+
+func (cfg *AuthConfig) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.BearerToken, "frontend.auth.bearer-token", "Bearer token required to query the frontend, in the Authorization header. Authentication is disabled if no bearer token, basic auth credentials or credentials file are configured. The Authorization header is still forwarded, unless configured in -frontend.strip-request-headers.")
+	f.StringVar(&cfg.BasicUsername, "frontend.auth.basic-username", "", "Username of the basic auth credentials required to query the frontend.")
+	f.Var(&cfg.BasicPassword, "frontend.auth.basic-password", "Password of the basic auth credentials required to query the frontend.")
+	f.StringVar(&cfg.CredentialsFile, "frontend.auth.credentials-file", "", "File with the credentials allowed to query the frontend, one per line: 'bearer <token>' or 'basic <username>:<password>'. Empty lines and lines starting with # are ignored. The file is reloaded when it changes.")
+}
+
+func (cfg *AuthConfig) Validate() error {
+	if (cfg.BasicUsername == "") != (cfg.BasicPassword.Value == "") {
+		return fmt.Errorf("both the basic auth username and password must be configured")
+	}
+	if cfg.CredentialsFile != "" {
+		if _, err := loadCredentialsFile(cfg.CredentialsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *AuthConfig) enabled() bool {
+	return cfg.BearerToken.Value != "" || cfg.BasicUsername != "" || cfg.CredentialsFile != ""
+}
+
+type credentials struct {
+	bearerTokens []string
+	basic        map[string]string
+}
+
+func (c *credentials) allows(r *http.Request) bool {
+	if username, password, ok := r.BasicAuth(); ok {
+		expected, found := c.basic[username]
+		return found && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	}
+
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return false
+	}
+
+	token := []byte(header[len(prefix):])
+	allowed := false
+	for _, expected := range c.bearerTokens {
+		// Don't stop at the first match, not to leak which token matched.
+		if subtle.ConstantTimeCompare(token, []byte(expected)) == 1 {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func loadCredentialsFile(filename string) (*credentials, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %v", err)
+	}
+
+	c := &credentials{basic: map[string]string{}}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid credentials file %s at line %d: expected 'bearer <token>' or 'basic <username>:<password>'", filename, i+1)
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "bearer":
+			c.bearerTokens = append(c.bearerTokens, fields[1])
+		case "basic":
+			parts := strings.SplitN(fields[1], ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid credentials file %s at line %d: expected 'basic <username>:<password>'", filename, i+1)
+			}
+			c.basic[parts[0]] = parts[1]
+		default:
+			return nil, fmt.Errorf("invalid credentials file %s at line %d: unsupported scheme %s", filename, i+1, fields[0])
+		}
+	}
+	return c, nil
+}
+
+// authenticator checks the credentials of the requests, reloading the credentials file
+// when it changes.
+type authenticator struct {
+	cfg    AuthConfig
+	log    log.Logger
+	static *credentials
+
+	mtx         sync.Mutex
+	credentials *credentials
+	lastCheck   time.Time
+	lastModTime time.Time
+}
+
+// newAuthenticator returns the authenticator of the requests, or nil if authentication is disabled.
+func newAuthenticator(cfg AuthConfig, log log.Logger) *authenticator {
+	if !cfg.enabled() {
+		return nil
+	}
+
+	static := &credentials{basic: map[string]string{}}
+	if cfg.BearerToken.Value != "" {
+		static.bearerTokens = []string{cfg.BearerToken.Value}
+	}
+	if cfg.BasicUsername != "" {
+		static.basic[cfg.BasicUsername] = cfg.BasicPassword.Value
+	}
+	return &authenticator{cfg: cfg, log: log, static: static}
+}
+
+func (a *authenticator) allows(r *http.Request) bool {
+	if a.static.allows(r) {
+		return true
+	}
+	return a.cfg.CredentialsFile != "" && a.fileCredentials().allows(r)
+}
+
+// writeUnauthorized rejects a request which doesn't have valid credentials.
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="cortex"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// NewAdminHandler returns the handler of the admin endpoint at the path, requiring the credentials
+// configured with the -frontend.auth flags. Without credentials configured, the endpoint refuses all
+// the requests, as anyone reaching the query-frontend could otherwise use it.
+func NewAdminHandler(cfg HandlerConfig, path string, h http.Handler, log log.Logger) http.Handler {
+	authenticator := newAuthenticator(cfg.Auth, log)
+	if authenticator == nil {
+		level.Warn(log).Log("msg", "the admin endpoint is disabled, as it requires the -frontend.auth credentials and none are configured", "path", path)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator == nil {
+			http.Error(w, "this endpoint requires the -frontend.auth credentials, and none are configured", http.StatusForbidden)
+			return
+		}
+		if !authenticator.allows(r) {
+			writeUnauthorized(w)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// fileCredentials returns the credentials of the file, reloading it if it changed. If the
+// file can't be reloaded, the previous credentials are kept.
+func (a *authenticator) fileCredentials() *credentials {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := time.Now()
+	if a.credentials != nil && now.Sub(a.lastCheck) < credentialsFileCheckInterval {
+		return a.credentials
+	}
+	a.lastCheck = now
+
+	info, err := os.Stat(a.cfg.CredentialsFile)
+	if err != nil {
+		level.Error(a.log).Log("msg", "failed to check the credentials file", "file", a.cfg.CredentialsFile, "err", err)
+	} else if a.credentials == nil || !info.ModTime().Equal(a.lastModTime) {
+		c, err := loadCredentialsFile(a.cfg.CredentialsFile)
+		if err != nil {
+			level.Error(a.log).Log("msg", "failed to reload the credentials file", "err", err)
+		} else {
+			a.credentials = c
+			a.lastModTime = info.ModTime()
+		}
+	}
+
+	if a.credentials == nil {
+		return &credentials{}
+	}
+	return a.credentials
+}
@@ -0,0 +1,143 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestHandler_Auth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frontend-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "credentials")
+	require.NoError(t, ioutil.WriteFile(file, []byte("# Grafana\nbearer file-token\nbasic alice:secret\n"), 0600))
+
+	for name, tc := range map[string]struct {
+		cfg            AuthConfig
+		authorize      func(r *http.Request)
+		expectedStatus int
+	}{
+		"disabled": {
+			expectedStatus: http.StatusOK,
+		},
+		"missing credentials": {
+			cfg:            AuthConfig{BearerToken: flagext.Secret{Value: "token"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"valid bearer token": {
+			cfg:            AuthConfig{BearerToken: flagext.Secret{Value: "token"}},
+			authorize:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") },
+			expectedStatus: http.StatusOK,
+		},
+		"invalid bearer token": {
+			cfg:            AuthConfig{BearerToken: flagext.Secret{Value: "token"}},
+			authorize:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"valid basic auth": {
+			cfg:            AuthConfig{BasicUsername: "user", BasicPassword: flagext.Secret{Value: "pass"}},
+			authorize:      func(r *http.Request) { r.SetBasicAuth("user", "pass") },
+			expectedStatus: http.StatusOK,
+		},
+		"invalid basic auth": {
+			cfg:            AuthConfig{BasicUsername: "user", BasicPassword: flagext.Secret{Value: "pass"}},
+			authorize:      func(r *http.Request) { r.SetBasicAuth("user", "other") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"bearer token from file": {
+			cfg:            AuthConfig{CredentialsFile: file},
+			authorize:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer file-token") },
+			expectedStatus: http.StatusOK,
+		},
+		"basic auth from file": {
+			cfg:            AuthConfig{CredentialsFile: file},
+			authorize:      func(r *http.Request) { r.SetBasicAuth("alice", "secret") },
+			expectedStatus: http.StatusOK,
+		},
+		"invalid credentials with file": {
+			cfg:            AuthConfig{CredentialsFile: file, BearerToken: flagext.Secret{Value: "token"}},
+			authorize:      func(r *http.Request) { r.SetBasicAuth("alice", "token") },
+			expectedStatus: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, tc.cfg.Validate())
+
+			cfg := defaultHandlerConfig()
+			cfg.Auth = tc.cfg
+			handler := NewHandler(cfg, nil, okRoundTripper(), log.NewNopLogger(), nil)
+
+			req := httptest.NewRequest("GET", query, nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+			if tc.authorize != nil {
+				tc.authorize(req)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tc.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestAuthenticator_ReloadsCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frontend-auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "credentials")
+	require.NoError(t, ioutil.WriteFile(file, []byte("bearer old\n"), 0600))
+
+	a := newAuthenticator(AuthConfig{CredentialsFile: file}, log.NewNopLogger())
+	withToken := func(token string) *http.Request {
+		r := httptest.NewRequest("GET", query, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+	assert.True(t, a.allows(withToken("old")))
+	assert.False(t, a.allows(withToken("new")))
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("bearer new\n"), 0600))
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)))
+	a.lastCheck = time.Time{}
+	assert.False(t, a.allows(withToken("old")))
+	assert.True(t, a.allows(withToken("new")))
+
+	// Invalid credentials files are ignored, keeping the previous credentials.
+	require.NoError(t, ioutil.WriteFile(file, []byte("invalid\n"), 0600))
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(2*time.Minute)))
+	a.lastCheck = time.Time{}
+	assert.True(t, a.allows(withToken("new")))
+}
+
+func TestAuthConfig_Validate(t *testing.T) {
+	cfg := AuthConfig{BasicUsername: "user"}
+	assert.EqualError(t, cfg.Validate(), "both the basic auth username and password must be configured")
+
+	cfg = AuthConfig{CredentialsFile: "/non-existent"}
+	assert.Error(t, cfg.Validate())
+}
+
+func okRoundTripper() http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+}
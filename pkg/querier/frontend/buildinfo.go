@@ -0,0 +1,79 @@
+package frontend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"net/http"
+	"runtime"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/version"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// BuildInfoConfig configures the /frontend/buildinfo endpoint.
+type BuildInfoConfig struct {
+	RequireAuth bool `yaml:"buildinfo_require_auth"`
+}
+
+func (cfg *BuildInfoConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.RequireAuth, "frontend.buildinfo-require-auth", false, "Require the credentials configured with the -frontend.auth.* flags to access the /frontend/buildinfo endpoint. The endpoint doesn't require the tenant ID.")
+}
+
+// ConfigHash returns a hash of the configuration of the query-frontend, of the queriers workers
+// and of the default limits, to detect configuration differences between replicas. The hash
+// covers whether secrets are configured, but not their values.
+func ConfigHash(frontendCfg CombinedFrontendConfig, workerCfg CombinedWorkerConfig, limits validation.Limits) (string, error) {
+	// YAML is deterministic, as the fields of maps are sorted.
+	b, err := yaml.Marshal(struct {
+		Frontend CombinedFrontendConfig `yaml:"frontend"`
+		Worker   CombinedWorkerConfig   `yaml:"frontend_worker"`
+		Limits   validation.Limits      `yaml:"limits"`
+	}{
+		Frontend: frontendCfg,
+		Worker:   workerCfg,
+		Limits:   limits,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type buildInfoResponse struct {
+	Version    string `json:"version"`
+	Revision   string `json:"revision"`
+	Branch     string `json:"branch"`
+	GoVersion  string `json:"goVersion"`
+	ConfigHash string `json:"configHash"`
+}
+
+// NewBuildInfoHandler returns the handler exposing the version of the query-frontend and
+// the hash of its configuration.
+func NewBuildInfoHandler(cfg HandlerConfig, configHash string, log log.Logger) http.Handler {
+	var authenticator *authenticator
+	if cfg.BuildInfo.RequireAuth {
+		authenticator = newAuthenticator(cfg.Auth, log)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator != nil && !authenticator.allows(r) {
+			writeUnauthorized(w)
+			return
+		}
+
+		util.WriteJSONResponse(w, buildInfoResponse{
+			Version:    version.Version,
+			Revision:   version.Revision,
+			Branch:     version.Branch,
+			GoVersion:  runtime.Version(),
+			ConfigHash: configHash,
+		})
+	})
+}
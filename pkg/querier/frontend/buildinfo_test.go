@@ -0,0 +1,95 @@
+package frontend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestConfigHash(t *testing.T) {
+	var (
+		frontendCfg CombinedFrontendConfig
+		workerCfg   CombinedWorkerConfig
+		limits      validation.Limits
+	)
+	flagext.DefaultValues(&frontendCfg, &workerCfg, &limits)
+	frontendCfg.Handler.DownstreamHeaders = map[string]string{"a": "1", "b": "2", "c": "3"}
+
+	hash, err := ConfigHash(frontendCfg, workerCfg, limits)
+	require.NoError(t, err)
+
+	// The hash is deterministic.
+	for i := 0; i < 10; i++ {
+		again, err := ConfigHash(frontendCfg, workerCfg, limits)
+		require.NoError(t, err)
+		require.Equal(t, hash, again)
+	}
+
+	// And covers the frontend, workers and limits configs.
+	frontendCfg.DownstreamURL = "http://prometheus"
+	frontendHash, err := ConfigHash(frontendCfg, workerCfg, limits)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, frontendHash)
+
+	workerCfg.WorkerV1.Parallelism++
+	workerHash, err := ConfigHash(frontendCfg, workerCfg, limits)
+	require.NoError(t, err)
+	assert.NotEqual(t, frontendHash, workerHash)
+
+	limits.MaxQueriersPerTenant++
+	limitsHash, err := ConfigHash(frontendCfg, workerCfg, limits)
+	require.NoError(t, err)
+	assert.NotEqual(t, workerHash, limitsHash)
+}
+
+func TestBuildInfoHandler(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.Auth.BearerToken = flagext.Secret{Value: "token"}
+
+	for name, tc := range map[string]struct {
+		requireAuth    bool
+		token          string
+		expectedStatus int
+	}{
+		"not gated": {
+			expectedStatus: http.StatusOK,
+		},
+		"gated without credentials": {
+			requireAuth:    true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"gated with credentials": {
+			requireAuth:    true,
+			token:          "token",
+			expectedStatus: http.StatusOK,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.BuildInfo.RequireAuth = tc.requireAuth
+			h := NewBuildInfoHandler(cfg, "hash", log.NewNopLogger())
+
+			req := httptest.NewRequest("GET", "/frontend/buildinfo", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			require.Equal(t, tc.expectedStatus, w.Code)
+
+			if tc.expectedStatus == http.StatusOK {
+				var resp buildInfoResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Equal(t, "hash", resp.ConfigHash)
+				assert.NotEmpty(t, resp.GoVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/user"
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// CacheWarmingConfig configures the jobs executing a list of range queries ahead of time, to
+// populate the results cache.
+type CacheWarmingConfig struct {
+	Enabled    bool    `yaml:"cache_warming_enabled"`
+	Rate       float64 `yaml:"cache_warming_rate"`
+	MaxQueries int     `yaml:"cache_warming_max_queries"`
+}
+
+func (cfg *CacheWarmingConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "frontend.cache-warming-enabled", false, "Enable the /frontend/cache/warm endpoint, running a job which executes the posted range queries, eg. of the critical dashboards, to populate the results cache before they're requested. The queries go through the same limits and queue as the other queries. The endpoint requires the credentials configured with the -frontend.auth.* flags.")
+	f.Float64Var(&cfg.Rate, "frontend.cache-warming-rate", 1, "Maximum number of queries per second executed by a cache warming job. The queries are executed one at a time, so that the job doesn't starve the other queries.")
+	f.IntVar(&cfg.MaxQueries, "frontend.cache-warming-max-queries", 1000, "Maximum number of queries of a cache warming job. 0 for no limit.")
+}
+
+func (cfg *CacheWarmingConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Rate <= 0 {
+		return fmt.Errorf("invalid -frontend.cache-warming-rate %v: must be positive", cfg.Rate)
+	}
+	if cfg.MaxQueries < 0 {
+		return fmt.Errorf("invalid -frontend.cache-warming-max-queries %d: must not be negative, 0 for no limit", cfg.MaxQueries)
+	}
+	return nil
+}
+
+// cacheWarmingQuery is a range query of a cache warming job, covering the range until the time
+// it's executed.
+type cacheWarmingQuery struct {
+	Tenant string `json:"tenant"`
+	Query  string `json:"query"`
+	Range  string `json:"range"`
+	Step   string `json:"step"`
+
+	rangeDuration time.Duration
+	stepDuration  time.Duration
+}
+
+type cacheWarmingJobStatus struct {
+	Status    string    `json:"status"`
+	Started   time.Time `json:"started"`
+	Queries   int       `json:"queries"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+}
+
+const (
+	cacheWarmingRunning   = "running"
+	cacheWarmingDone      = "done"
+	cacheWarmingCancelled = "cancelled"
+)
+
+// CacheWarmer runs the cache warming jobs, one at a time. The job is started by posting its queries
+// to the cache warming endpoint, its status is returned by a GET and it's cancelled by a DELETE.
+type CacheWarmer struct {
+	cfg        CacheWarmingConfig
+	handler    http.Handler
+	pathPrefix string
+	logger     log.Logger
+
+	queries *prometheus.CounterVec
+
+	mtx    sync.Mutex
+	status *cacheWarmingJobStatus
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewCacheWarmer makes a new CacheWarmer executing the queries through the query-frontend handler,
+// on the Prometheus API served under the path prefix, so that they're limited like the other queries.
+func NewCacheWarmer(cfg CacheWarmingConfig, handler http.Handler, pathPrefix string, logger log.Logger, reg prometheus.Registerer) *CacheWarmer {
+	return &CacheWarmer{
+		cfg:        cfg,
+		handler:    handler,
+		pathPrefix: pathPrefix,
+		logger:     logger,
+		queries: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_cache_warming_queries_total",
+			Help:      "Total number of queries executed by the cache warming jobs.",
+		}, []string{"status"}),
+	}
+}
+
+func (w *CacheWarmer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		w.start(rw, r)
+
+	case http.MethodDelete:
+		w.mtx.Lock()
+		if w.cancel != nil {
+			w.cancel()
+		}
+		w.mtx.Unlock()
+		w.writeStatus(rw, http.StatusOK)
+
+	default:
+		w.writeStatus(rw, http.StatusOK)
+	}
+}
+
+func (w *CacheWarmer) start(rw http.ResponseWriter, r *http.Request) {
+	var job struct {
+		Queries []cacheWarmingQuery `json:"queries"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(rw, fmt.Sprintf("invalid cache warming job: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(job.Queries) == 0 {
+		http.Error(rw, "invalid cache warming job: no queries", http.StatusBadRequest)
+		return
+	}
+	if w.cfg.MaxQueries > 0 && len(job.Queries) > w.cfg.MaxQueries {
+		http.Error(rw, fmt.Sprintf("invalid cache warming job: %d queries exceed the limit of %d", len(job.Queries), w.cfg.MaxQueries), http.StatusBadRequest)
+		return
+	}
+	for i := range job.Queries {
+		if err := job.Queries[i].parse(); err != nil {
+			http.Error(rw, fmt.Sprintf("invalid cache warming query %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.mtx.Lock()
+	if w.status != nil && w.status.Status == cacheWarmingRunning {
+		w.mtx.Unlock()
+		http.Error(rw, "a cache warming job is already running", http.StatusConflict)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.status = &cacheWarmingJobStatus{Status: cacheWarmingRunning, Started: time.Now(), Queries: len(job.Queries)}
+	w.cancel = cancel
+	w.wg.Add(1)
+	w.mtx.Unlock()
+
+	// The queries are authenticated with the credentials of the job, if any.
+	go w.run(ctx, cancel, job.Queries, r.Header.Get("Authorization"))
+
+	w.writeStatus(rw, http.StatusAccepted)
+}
+
+func (w *CacheWarmer) run(ctx context.Context, cancel context.CancelFunc, queries []cacheWarmingQuery, authorization string) {
+	defer w.wg.Done()
+	defer cancel()
+
+	limiter := rate.NewLimiter(rate.Limit(w.cfg.Rate), 1)
+	for _, q := range queries {
+		if err := limiter.Wait(ctx); err != nil {
+			break
+		}
+
+		err := w.warm(ctx, q, authorization)
+		if ctx.Err() != nil {
+			break
+		}
+
+		w.mtx.Lock()
+		if err != nil {
+			level.Warn(w.logger).Log("msg", "cache warming query failed", "user", q.Tenant, "query", q.Query, "err", err)
+			w.status.Failed++
+			w.queries.WithLabelValues("failure").Inc()
+		} else {
+			w.status.Succeeded++
+			w.queries.WithLabelValues("success").Inc()
+		}
+		w.mtx.Unlock()
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.status.Status = cacheWarmingDone
+	if ctx.Err() != nil && w.status.Succeeded+w.status.Failed < w.status.Queries {
+		w.status.Status = cacheWarmingCancelled
+	}
+	level.Info(w.logger).Log("msg", "cache warming job finished", "status", w.status.Status, "succeeded", w.status.Succeeded, "failed", w.status.Failed)
+}
+
+// warm executes the range query, discarding its response.
+func (w *CacheWarmer) warm(ctx context.Context, q cacheWarmingQuery, authorization string) error {
+	end := time.Now()
+	params := url.Values{
+		"query": []string{q.Query},
+		"start": []string{formatSeconds(util.TimeToMillis(end.Add(-q.rangeDuration)))},
+		"end":   []string{formatSeconds(util.TimeToMillis(end))},
+		"step":  []string{strconv.FormatFloat(q.stepDuration.Seconds(), 'f', -1, 64)},
+	}
+	req, err := http.NewRequest("GET", w.pathPrefix+"/api/v1/query_range?"+params.Encode(), http.NoBody)
+	if err != nil {
+		return err
+	}
+	ctx = user.InjectOrgID(ctx, q.Tenant)
+	req = req.WithContext(ctx)
+	if err := user.InjectOrgIDIntoHTTPRequest(ctx, req); err != nil {
+		return err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	rw := &discardResponseWriter{header: http.Header{}}
+	w.handler.ServeHTTP(rw, req)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if rw.code != 0 && rw.code/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", rw.code)
+	}
+	return nil
+}
+
+// discardResponseWriter records the status code of the response, and discards its body.
+type discardResponseWriter struct {
+	header http.Header
+	code   int
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponseWriter) WriteHeader(code int) {
+	if d.code == 0 {
+		d.code = code
+	}
+}
+
+func (d *discardResponseWriter) Write(p []byte) (int, error) {
+	d.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
+
+func (w *CacheWarmer) writeStatus(rw http.ResponseWriter, statusCode int) {
+	w.mtx.Lock()
+	if w.status == nil {
+		w.mtx.Unlock()
+		http.Error(rw, "no cache warming job", http.StatusNotFound)
+		return
+	}
+	status := *w.status
+	w.mtx.Unlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	util.WriteJSONResponse(rw, status)
+}
+
+// Stop cancels the running job, if any, and waits for it to return.
+func (w *CacheWarmer) Stop() {
+	w.mtx.Lock()
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.mtx.Unlock()
+	w.wg.Wait()
+}
+
+func (q *cacheWarmingQuery) parse() error {
+	if q.Tenant == "" {
+		return fmt.Errorf("missing tenant")
+	}
+	if q.Query == "" {
+		return fmt.Errorf("missing query")
+	}
+
+	r, err := model.ParseDuration(q.Range)
+	if err != nil || r <= 0 {
+		return fmt.Errorf("invalid range %q: must be a positive duration", q.Range)
+	}
+	step, err := model.ParseDuration(q.Step)
+	if err != nil || step <= 0 {
+		return fmt.Errorf("invalid step %q: must be a positive duration", q.Step)
+	}
+	q.rangeDuration, q.stepDuration = time.Duration(r), time.Duration(step)
+	return nil
+}
+
+func formatSeconds(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1e3, 'f', -1, 64)
+}
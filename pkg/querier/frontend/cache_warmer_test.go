@@ -0,0 +1,219 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+const cacheWarmingJob = `{"queries": [
+	{"tenant": "1", "query": "sum(rate(http_requests_total[5m]))", "range": "1d", "step": "1m"},
+	{"tenant": "2", "query": "up", "range": "1h", "step": "15s"}
+]}`
+
+func TestCacheWarmer(t *testing.T) {
+	var (
+		mtx  sync.Mutex
+		reqs []*http.Request
+	)
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		reqs = append(reqs, r)
+		if len(reqs) == 2 {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	h := NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), nil)
+	w := NewCacheWarmer(CacheWarmingConfig{Enabled: true, Rate: 100}, h, "/prometheus", log.NewNopLogger(), nil)
+	defer w.Stop()
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("POST", "/frontend/cache/warm", strings.NewReader(cacheWarmingJob)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	test.Poll(t, time.Second, cacheWarmingDone, func() interface{} {
+		return cacheWarmingStatus(t, w).Status
+	})
+	status := cacheWarmingStatus(t, w)
+	assert.Equal(t, 2, status.Queries)
+	assert.Equal(t, 1, status.Succeeded)
+	assert.Equal(t, 1, status.Failed)
+
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "/prometheus/api/v1/query_range", reqs[0].URL.Path)
+	assert.Equal(t, "sum(rate(http_requests_total[5m]))", reqs[0].URL.Query().Get("query"))
+	assert.Equal(t, "60", reqs[0].URL.Query().Get("step"))
+	orgID, err := user.ExtractOrgID(reqs[0].Context())
+	require.NoError(t, err)
+	assert.Equal(t, "1", orgID)
+	assert.Equal(t, "2", reqs[1].Header.Get(user.OrgIDHeaderName))
+	assert.Equal(t, "15", reqs[1].URL.Query().Get("step"))
+}
+
+func TestCacheWarmer_Cancel(t *testing.T) {
+	started := make(chan struct{})
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		close(started)
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+
+	h := NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), nil)
+	w := NewCacheWarmer(CacheWarmingConfig{Enabled: true, Rate: 100}, h, "", log.NewNopLogger(), nil)
+	defer w.Stop()
+
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("POST", "/frontend/cache/warm", strings.NewReader(cacheWarmingJob)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	<-started
+
+	// A single job runs at a time.
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("POST", "/frontend/cache/warm", strings.NewReader(cacheWarmingJob)))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("DELETE", "/frontend/cache/warm", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	test.Poll(t, time.Second, cacheWarmingCancelled, func() interface{} {
+		return cacheWarmingStatus(t, w).Status
+	})
+	assert.Equal(t, 0, cacheWarmingStatus(t, w).Succeeded+cacheWarmingStatus(t, w).Failed)
+}
+
+func TestCacheWarmer_RequiresCredentials(t *testing.T) {
+	var warmed bool
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		warmed = true
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	w := NewCacheWarmer(CacheWarmingConfig{Enabled: true, Rate: 100}, NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), nil), "", log.NewNopLogger(), nil)
+	defer w.Stop()
+
+	cfg := defaultHandlerConfig()
+	cfg.Auth.BearerToken = flagext.Secret{Value: "token"}
+	h := NewAdminHandler(cfg, "/frontend/cache/warm", w, log.NewNopLogger())
+
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/frontend/cache/warm", strings.NewReader(cacheWarmingJob)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, method)
+	}
+
+	rec := httptest.NewRecorder()
+	NewAdminHandler(defaultHandlerConfig(), "/frontend/cache/warm", w, log.NewNopLogger()).ServeHTTP(rec, httptest.NewRequest("POST", "/frontend/cache/warm", strings.NewReader(cacheWarmingJob)))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// No job was started.
+	rec = httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("GET", "/frontend/cache/warm", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.False(t, warmed)
+}
+
+func TestCacheWarmer_ThroughHandler(t *testing.T) {
+	var (
+		mtx            sync.Mutex
+		authorizations []string
+	)
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	// The queries of the job are authenticated with its credentials, and count against the query rate of the tenant.
+	cfg := defaultHandlerConfig()
+	cfg.Auth.BearerToken = flagext.Secret{Value: "token"}
+	h := NewHandler(cfg, limits{queryRate: 0.01, queryBurst: 1}, rt, log.NewNopLogger(), nil)
+	w := NewCacheWarmer(CacheWarmingConfig{Enabled: true, Rate: 100}, h, "", log.NewNopLogger(), nil)
+	defer w.Stop()
+
+	job := `{"queries": [
+		{"tenant": "1", "query": "up", "range": "1h", "step": "1m"},
+		{"tenant": "1", "query": "up", "range": "1d", "step": "1m"}
+	]}`
+	req := httptest.NewRequest("POST", "/frontend/cache/warm", strings.NewReader(job))
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	NewAdminHandler(cfg, "/frontend/cache/warm", w, log.NewNopLogger()).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	test.Poll(t, time.Second, cacheWarmingDone, func() interface{} {
+		return cacheWarmingStatus(t, w).Status
+	})
+	status := cacheWarmingStatus(t, w)
+	assert.Equal(t, 1, status.Succeeded)
+	assert.Equal(t, 1, status.Failed)
+	assert.Equal(t, []string{"Bearer token"}, authorizations)
+}
+
+func TestCacheWarmer_InvalidJobs(t *testing.T) {
+	w := NewCacheWarmer(CacheWarmingConfig{Enabled: true, Rate: 1, MaxQueries: 1}, nil, "", log.NewNopLogger(), nil)
+
+	for name, tc := range map[string]struct {
+		job         string
+		expectedErr string
+	}{
+		"invalid JSON": {
+			job:         `{`,
+			expectedErr: "invalid cache warming job: unexpected EOF",
+		},
+		"no queries": {
+			job:         `{"queries": []}`,
+			expectedErr: "invalid cache warming job: no queries",
+		},
+		"too many queries": {
+			job:         cacheWarmingJob,
+			expectedErr: "invalid cache warming job: 2 queries exceed the limit of 1",
+		},
+		"missing tenant": {
+			job:         `{"queries": [{"query": "up", "range": "1h", "step": "1m"}]}`,
+			expectedErr: "invalid cache warming query 0: missing tenant",
+		},
+		"invalid step": {
+			job:         `{"queries": [{"tenant": "1", "query": "up", "range": "1h", "step": "0s"}]}`,
+			expectedErr: `invalid cache warming query 0: invalid step "0s": must be a positive duration`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			w.ServeHTTP(rec, httptest.NewRequest("POST", "/frontend/cache/warm", strings.NewReader(tc.job)))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, tc.expectedErr+"\n", rec.Body.String())
+		})
+	}
+
+	// No job has been started.
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("GET", "/frontend/cache/warm", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func cacheWarmingStatus(t *testing.T, w *CacheWarmer) cacheWarmingJobStatus {
+	rec := httptest.NewRecorder()
+	w.ServeHTTP(rec, httptest.NewRequest("GET", "/frontend/cache/warm", nil).WithContext(context.Background()))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var status cacheWarmingJobStatus
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+	return status
+}
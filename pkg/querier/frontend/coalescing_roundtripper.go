@@ -0,0 +1,213 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+)
+
+// RoundTripper that coalesces concurrent identical requests, so that only one
+// of them is sent downstream and all the callers receive the same response.
+type coalescingRoundTripper struct {
+	next http.RoundTripper
+
+	mtx   sync.Mutex
+	calls map[string]*inFlightCall
+}
+
+// inFlightCall is a request being executed downstream on behalf of all its callers.
+type inFlightCall struct {
+	done chan struct{}
+
+	// Number of callers waiting for the call, and the function cancelling it once they're all gone. Protected by mtx.
+	waiters int
+	cancel  context.CancelFunc
+
+	// Set before done is closed.
+	statusCode int
+	header     http.Header
+	body       []byte
+	err        error
+}
+
+func newCoalescingRoundTripper(next http.RoundTripper) *coalescingRoundTripper {
+	return &coalescingRoundTripper{
+		next:  next,
+		calls: map[string]*inFlightCall{},
+	}
+}
+
+func (c *coalescingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	// Only the read-only queries whose parameters are all in the key are coalesced. The caller asking
+	// for fresh results may not get them from an in-flight call either.
+	if !isCoalescable(r) || noStore(r) {
+		return c.next.RoundTrip(r)
+	}
+
+	key, err := coalescingKey(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	call, ok := c.calls[key]
+	if !ok {
+		// The call outlives the caller starting it if other callers are still waiting for it, so it
+		// doesn't run on its context, but it doesn't outlive its deadline.
+		ctx, cancel := detachedContext(r.Context())
+		call = &inFlightCall{done: make(chan struct{}), cancel: cancel}
+		c.calls[key] = call
+
+		go func() {
+			defer cancel()
+			call.do(c.next, r.WithContext(ctx))
+
+			c.mtx.Lock()
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+			c.mtx.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	c.mtx.Unlock()
+
+	select {
+	case <-r.Context().Done():
+		c.mtx.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody is waiting for the call anymore, the next callers start a new one.
+			call.cancel()
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+		}
+		c.mtx.Unlock()
+		return nil, r.Context().Err()
+	case <-call.done:
+		return call.response()
+	}
+}
+
+// detachedContext returns a context which isn't cancelled with the context of the request, but
+// carries its deadline and its values used downstream.
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := context.Background()
+	if orgID, err := user.ExtractOrgID(ctx); err == nil {
+		detached = user.InjectOrgID(detached, orgID)
+	}
+	if queryID := extractQueryID(ctx); queryID != "" {
+		detached = injectQueryID(detached, queryID)
+	}
+	if headers := extractForwardedHeaders(ctx); headers != nil {
+		detached = injectForwardedHeaders(detached, headers)
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		detached = opentracing.ContextWithSpan(detached, span)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+	return context.WithCancel(detached)
+}
+
+// do executes the request downstream, and buffers the response so that it can be shared.
+func (call *inFlightCall) do(next http.RoundTripper, r *http.Request) {
+	resp, err := next.RoundTrip(r)
+	if err != nil {
+		call.err = err
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	call.body, call.err = ioutil.ReadAll(resp.Body)
+	call.statusCode = resp.StatusCode
+	call.header = resp.Header
+}
+
+func (call *inFlightCall) response() (*http.Response, error) {
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	return &http.Response{
+		StatusCode:    call.statusCode,
+		Header:        call.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(call.body)),
+		ContentLength: int64(len(call.body)),
+	}, nil
+}
+
+// Request headers changing the response, which coalesced requests must have in common.
+var coalescingKeyHeaders = []string{"Accept", queryrange.ExplainHeader}
+
+// isCoalescable returns whether the request is a query sent with GET, or with POST and its
+// parameters in a form.
+func isCoalescable(r *http.Request) bool {
+	if !isQueryAPIRequest(r) {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return true
+	case http.MethodPost:
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		return err == nil && mediaType == "application/x-www-form-urlencoded"
+	}
+	return false
+}
+
+// noStore returns whether the request asks for results not served from the cache.
+func noStore(r *http.Request) bool {
+	for _, value := range r.Header.Values("Cache-Control") {
+		if strings.Contains(value, "no-store") {
+			return true
+		}
+	}
+	return false
+}
+
+// coalescingKey returns the key identifying requests which are guaranteed to get the same
+// response: they belong to the same tenant, and have the same method, path, parameters and headers
+// changing the response, no matter the order of the parameters or whether they're sent in the
+// URL or in the body.
+func coalescingKey(r *http.Request) (string, error) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		return "", err
+	}
+
+	// Parse the form on a copy of the request, so that the body can still be forwarded downstream.
+	clone := r.Clone(r.Context())
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err := clone.ParseForm(); err != nil {
+		return "", err
+	}
+
+	// Encode() sorts the parameters by name.
+	key := userID + "\x00" + r.Method + "\x00" + r.URL.Path + "\x00" + clone.Form.Encode()
+	for _, name := range coalescingKeyHeaders {
+		key += "\x00" + strings.Join(r.Header.Values(name), ",")
+	}
+	return key, nil
+}
@@ -0,0 +1,370 @@
+package frontend
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestCoalescingRoundTripper(t *testing.T) {
+	for name, tc := range map[string]struct {
+		downstreamErr error
+	}{
+		"successful request": {},
+		"failed request":     {downstreamErr: errors.New("downstream failed")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			const numRequests = 5
+
+			calls := atomic.NewInt32(0)
+			release := make(chan struct{})
+			c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls.Inc()
+				<-release
+
+				if tc.downstreamErr != nil {
+					return nil, tc.downstreamErr
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{"application/json"}},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			}))
+
+			ctx := user.InjectOrgID(context.Background(), "1")
+			wg := sync.WaitGroup{}
+			for i := 0; i < numRequests; i++ {
+				// The same parameters in a different order are coalesced too.
+				u := "/api/v1/query_range?query=up&start=0&end=3600&step=60"
+				if i%2 == 1 {
+					u = "/api/v1/query_range?step=60&end=3600&start=0&query=up"
+				}
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					resp, err := c.RoundTrip(httptest.NewRequest("GET", u, nil).WithContext(ctx))
+					if tc.downstreamErr != nil {
+						assert.Equal(t, tc.downstreamErr, err)
+						return
+					}
+
+					require.NoError(t, err)
+					body, err := ioutil.ReadAll(resp.Body)
+					require.NoError(t, err)
+					assert.Equal(t, http.StatusOK, resp.StatusCode)
+					assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+					assert.Equal(t, responseBody, string(body))
+				}()
+			}
+
+			// Wait until all the requests are waiting for the one being executed.
+			test.Poll(t, time.Second, numRequests, func() interface{} {
+				c.mtx.Lock()
+				defer c.mtx.Unlock()
+				for _, call := range c.calls {
+					return call.waiters
+				}
+				return 0
+			})
+
+			close(release)
+			wg.Wait()
+
+			assert.Equal(t, int32(1), calls.Load())
+			test.Poll(t, time.Second, 0, func() interface{} {
+				c.mtx.Lock()
+				defer c.mtx.Unlock()
+				return len(c.calls)
+			})
+		})
+	}
+}
+
+func TestCoalescingRoundTripper_DifferentRequestsAreNotCoalesced(t *testing.T) {
+	calls := atomic.NewInt32(0)
+	release := make(chan struct{})
+	c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Inc()
+		<-release
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	}))
+
+	reqs := []*http.Request{
+		httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil).WithContext(user.InjectOrgID(context.Background(), "1")),
+		httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil).WithContext(user.InjectOrgID(context.Background(), "2")),
+		httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=7200&step=60", nil).WithContext(user.InjectOrgID(context.Background(), "1")),
+		httptest.NewRequest("GET", "/api/v1/query?query=up&start=0&end=3600&step=60", nil).WithContext(user.InjectOrgID(context.Background(), "1")),
+	}
+	// Requests asking for a different response format, or for an explanation, aren't coalesced either.
+	for name, value := range map[string]string{"Accept": "application/x-protobuf", queryrange.ExplainHeader: "true"} {
+		req := httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil).WithContext(user.InjectOrgID(context.Background(), "1"))
+		req.Header.Set(name, value)
+		reqs = append(reqs, req)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, req := range reqs {
+		wg.Add(1)
+		go func(req *http.Request) {
+			defer wg.Done()
+
+			_, err := c.RoundTrip(req)
+			assert.NoError(t, err)
+		}(req)
+	}
+
+	test.Poll(t, time.Second, int32(len(reqs)), func() interface{} {
+		return calls.Load()
+	})
+
+	close(release)
+	wg.Wait()
+}
+
+func TestCoalescingRoundTripper_PreservesRequestBody(t *testing.T) {
+	const form = "query=up&start=0&end=3600&step=60"
+
+	c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, form, string(body))
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v1/query_range", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err := c.RoundTrip(req.WithContext(user.InjectOrgID(context.Background(), "1")))
+	require.NoError(t, err)
+}
+
+func TestCoalescingRoundTripper_NoStoreRequestsAreNotCoalesced(t *testing.T) {
+	calls := atomic.NewInt32(0)
+	release := make(chan struct{})
+	c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Inc()
+		<-release
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	}))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil).WithContext(user.InjectOrgID(context.Background(), "1"))
+		// The first request is coalesced with none of the others asking for fresh results.
+		if i > 0 {
+			req.Header.Set("Cache-Control", "no-store")
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := c.RoundTrip(req)
+			assert.NoError(t, err)
+		}()
+	}
+
+	test.Poll(t, time.Second, int32(3), func() interface{} {
+		return calls.Load()
+	})
+
+	close(release)
+	wg.Wait()
+}
+
+func TestCoalescingRoundTripper_CallerCancellation(t *testing.T) {
+	calls := atomic.NewInt32(0)
+	release := make(chan struct{})
+	c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Inc()
+
+		// The call carries the tenant and query ID of the request starting it.
+		orgID, err := user.ExtractOrgID(r.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, "1", orgID)
+		assert.Equal(t, "query-id", extractQueryID(r.Context()))
+
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	}))
+
+	newRequest := func() (*http.Request, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(injectQueryID(user.InjectOrgID(context.Background(), "1"), "query-id"))
+		return httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil).WithContext(ctx), cancel
+	}
+	waiters := func() interface{} {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		for _, call := range c.calls {
+			return call.waiters
+		}
+		return 0
+	}
+
+	// The call goes on when the caller starting it is gone, as another one is waiting for it.
+	first, cancelFirst := newRequest()
+	firstErr := make(chan error)
+	go func() {
+		_, err := c.RoundTrip(first)
+		firstErr <- err
+	}()
+	test.Poll(t, time.Second, 1, waiters)
+
+	second, cancelSecond := newRequest()
+	defer cancelSecond()
+	secondResp := make(chan *http.Response)
+	go func() {
+		resp, err := c.RoundTrip(second)
+		assert.NoError(t, err)
+		secondResp <- resp
+	}()
+	test.Poll(t, time.Second, 2, waiters)
+
+	cancelFirst()
+	assert.Equal(t, context.Canceled, <-firstErr)
+	test.Poll(t, time.Second, 1, waiters)
+
+	close(release)
+	resp := <-secondResp
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestCoalescingRoundTripper_CancelledWhenAllCallersAreGone(t *testing.T) {
+	cancelled := make(chan struct{})
+	c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		<-r.Context().Done()
+		close(cancelled)
+		return nil, r.Context().Err()
+	}))
+
+	ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "1"))
+	cancel()
+	_, err := c.RoundTrip(httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil).WithContext(ctx))
+	assert.Equal(t, context.Canceled, err)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the downstream call wasn't cancelled")
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	assert.Empty(t, c.calls)
+}
+
+func TestCoalescingRoundTripper_OnlyQueriesAreCoalesced(t *testing.T) {
+	for name, reqs := range map[string][]*http.Request{
+		"remote read requests with different bodies": {
+			httptest.NewRequest("POST", "/api/v1/read", strings.NewReader("query-A")),
+			httptest.NewRequest("POST", "/api/v1/read", strings.NewReader("query-B")),
+		},
+		"GET and DELETE of the same series": {
+			httptest.NewRequest("GET", "/api/v1/series?match[]=up", nil),
+			httptest.NewRequest("DELETE", "/api/v1/series?match[]=up", nil),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			release := make(chan struct{})
+			calls := atomic.NewInt32(0)
+			c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls.Inc()
+				<-release
+
+				// Each caller gets the response to its own request.
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(r.Method + " " + string(body))),
+				}, nil
+			}))
+
+			wg := sync.WaitGroup{}
+			for _, req := range reqs {
+				body, err := ioutil.ReadAll(req.Body)
+				require.NoError(t, err)
+				req.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+				expected := req.Method + " " + string(body)
+
+				wg.Add(1)
+				go func(req *http.Request) {
+					defer wg.Done()
+
+					resp, err := c.RoundTrip(req.WithContext(user.InjectOrgID(context.Background(), "1")))
+					require.NoError(t, err)
+					body, err := ioutil.ReadAll(resp.Body)
+					require.NoError(t, err)
+					assert.Equal(t, expected, string(body))
+				}(req)
+			}
+
+			test.Poll(t, time.Second, int32(len(reqs)), func() interface{} {
+				return calls.Load()
+			})
+			close(release)
+			wg.Wait()
+		})
+	}
+}
+
+func TestCoalescingRoundTripper_KeepsDeadline(t *testing.T) {
+	c := newCoalescingRoundTripper(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		deadline, ok := r.Context().Deadline()
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 10*time.Second)
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(user.InjectOrgID(context.Background(), "1"), time.Minute)
+	defer cancel()
+	_, err := c.RoundTrip(httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil).WithContext(ctx))
+	require.NoError(t, err)
+}
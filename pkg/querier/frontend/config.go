@@ -3,6 +3,7 @@ package frontend
 import (
 	"flag"
 	"net/http"
+	"net/url"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -22,8 +23,10 @@ type CombinedFrontendConfig struct {
 	FrontendV1 Config           `yaml:",inline"`
 	FrontendV2 frontend2.Config `yaml:",inline"`
 
-	CompressResponses bool   `yaml:"compress_responses"`
-	DownstreamURL     string `yaml:"downstream_url"`
+	CompressResponses bool                  `yaml:"compress_responses"`
+	DownstreamURL     string                `yaml:"downstream_url"`
+	DownstreamProbe   DownstreamProbeConfig `yaml:",inline"`
+	CacheWarming      CacheWarmingConfig    `yaml:",inline"`
 }
 
 func (cfg *CombinedFrontendConfig) RegisterFlags(f *flag.FlagSet) {
@@ -33,6 +36,48 @@ func (cfg *CombinedFrontendConfig) RegisterFlags(f *flag.FlagSet) {
 
 	f.BoolVar(&cfg.CompressResponses, "querier.compress-http-responses", false, "Compress HTTP responses.")
 	f.StringVar(&cfg.DownstreamURL, "frontend.downstream-url", "", "URL of downstream Prometheus.")
+	cfg.DownstreamProbe.RegisterFlags(f)
+	cfg.CacheWarming.RegisterFlags(f)
+}
+
+func (cfg *CombinedFrontendConfig) Validate() error {
+	if err := cfg.Handler.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.CacheWarming.Validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case cfg.DownstreamURL != "":
+		if cfg.FrontendV2.SchedulerAddress != "" {
+			return errors.New("-frontend.downstream-url and -frontend.scheduler-address can't be both configured, as queries are either sent to the downstream Prometheus or to the query-schedulers")
+		}
+		if err := validateDownstreamURL(cfg.DownstreamURL); err != nil {
+			return errors.Wrapf(err, "invalid -frontend.downstream-url %q", cfg.DownstreamURL)
+		}
+		return cfg.DownstreamProbe.Validate()
+
+	case cfg.FrontendV2.SchedulerAddress != "":
+		return nil
+
+	default:
+		return cfg.FrontendV1.Validate()
+	}
+}
+
+func validateDownstreamURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("the scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errors.New("the host is missing")
+	}
+	return nil
 }
 
 // Configuration for both querier workers, V1 (using frontend) and V2 (using scheduler). Since many flags are reused
@@ -58,10 +103,37 @@ func (cfg *CombinedWorkerConfig) Validate(logger log.Logger) error {
 // into HTTP server using the Handler from this package. Returned RoundTripper is always non-nil
 // (if there are no errors), and it uses the returned frontend (if any).
 func InitFrontend(cfg CombinedFrontendConfig, limits Limits, grpcListenPort int, log log.Logger, reg prometheus.Registerer) (http.RoundTripper, *Frontend, *frontend2.Frontend2, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "invalid query-frontend config")
+	}
+
+	// The downstream connections are shared by the default and the tenants downstream URLs.
+	transport := newDownstreamTransport(cfg.Handler.DownstreamTransport, cfg.Handler.DownstreamHTTP2)
+
+	rt, fr1, fr2, err := initFrontend(cfg, limits, transport, grpcListenPort, log, reg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if cfg.Handler.Historical.URL != "" {
+		historical, err := NewDownstreamRoundTripper(cfg.Handler.Historical.URL, cfg.Handler.PreserveHostHeader, limits, transport)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rt = newHistoricalDownstreamRoundTripper(cfg.Handler.Historical.Threshold, historical, rt)
+	}
+
+	// The tenants with a downstream URL override are routed to it, regardless of how the
+	// other tenants are handled.
+	rt = newTenantDownstreamRoundTripper(limits, cfg.Handler.PreserveHostHeader, transport, rt)
+	return withDownstreamHeaders(cfg.Handler, withForwardedHeaders(cfg.Handler, withLongGETsAsPOST(cfg.Handler, rt))), fr1, fr2, nil
+}
+
+func initFrontend(cfg CombinedFrontendConfig, limits Limits, transport http.RoundTripper, grpcListenPort int, log log.Logger, reg prometheus.Registerer) (http.RoundTripper, *Frontend, *frontend2.Frontend2, error) {
 	switch {
 	case cfg.DownstreamURL != "":
 		// If the user has specified a downstream Prometheus, then we should use that.
-		rt, err := NewDownstreamRoundTripper(cfg.DownstreamURL)
+		rt, err := NewDownstreamRoundTripper(cfg.DownstreamURL, cfg.Handler.PreserveHostHeader, limits, transport)
 		return rt, nil, nil, err
 
 	case cfg.FrontendV2.SchedulerAddress != "":
@@ -84,7 +156,8 @@ func InitFrontend(cfg CombinedFrontendConfig, limits Limits, grpcListenPort int,
 
 	default:
 		// No scheduler = use original frontend.
-		fr, err := New(cfg.FrontendV1, limits, log, reg)
+		cfg.FrontendV1.TenantLabels = cfg.Handler.TenantLabels
+		fr, err := newFrontend(cfg.FrontendV1, newUserQueues(cfg.FrontendV1.MaxOutstandingPerTenant), limits, log, reg)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -111,7 +184,7 @@ func InitQuerierWorker(cfg CombinedWorkerConfig, querierCfg querier.Config, hand
 
 	case cfg.WorkerV1.FrontendAddress != "":
 		level.Info(log).Log("msg", "Starting querier worker connected to query-frontend", "frontend", cfg.WorkerV1.FrontendAddress)
-		return NewWorker(cfg.WorkerV1, querierCfg, httpgrpc_server.NewServer(handler), log)
+		return NewWorker(cfg.WorkerV1, querierCfg, handler, log)
 
 	default:
 		return nil, nil
@@ -0,0 +1,283 @@
+package frontend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestCombinedFrontendConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		setup       func(cfg *CombinedFrontendConfig)
+		expectedErr string
+	}{
+		"defaults": {
+			setup: func(cfg *CombinedFrontendConfig) {},
+		},
+		"downstream URL": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.DownstreamURL = "https://prometheus:9090/prefix"
+			},
+		},
+		"downstream URL and scheduler address": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.DownstreamURL = "http://prometheus"
+				cfg.FrontendV2.SchedulerAddress = "scheduler:9095"
+			},
+			expectedErr: "-frontend.downstream-url and -frontend.scheduler-address can't be both configured, as queries are either sent to the downstream Prometheus or to the query-schedulers",
+		},
+		"downstream URL without scheme": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.DownstreamURL = "prometheus:9090"
+			},
+			expectedErr: `invalid -frontend.downstream-url "prometheus:9090": the scheme must be http or https`,
+		},
+		"downstream URL without host": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.DownstreamURL = "http:///api"
+			},
+			expectedErr: `invalid -frontend.downstream-url "http:///api": the host is missing`,
+		},
+		"downstream probe without timeout": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.DownstreamURL = "http://prometheus"
+				cfg.DownstreamProbe.Enabled = true
+				cfg.DownstreamProbe.Timeout = 0
+			},
+			expectedErr: "invalid -frontend.downstream-probe-timeout 0s: must be positive",
+		},
+		"path prefix": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.PathPrefix = "/prometheus"
+			},
+		},
+		"path prefix ending with a slash": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.PathPrefix = "/prometheus/"
+			},
+			expectedErr: `invalid -frontend.path-prefix "/prometheus/": must start with a / and not end with one`,
+		},
+		"invalid passthrough path regular expression": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.PassthroughPaths = []string{"/api/v1/admin/", "^/api/v1/(custom"}
+			},
+			expectedErr: "invalid -frontend.passthrough-paths regular expression \"^/api/v1/(custom\": error parsing regexp: missing closing ): `^/api/v1/(custom`",
+		},
+		"negative endpoint timeout": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.Timeouts.Series = -time.Second
+			},
+			expectedErr: "invalid -frontend.timeouts.series -1s: must not be negative, 0 to disable",
+		},
+		"negative shutdown grace period": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.HTTPServer.ShutdownGracePeriod = -time.Second
+			},
+			expectedErr: "invalid -frontend.shutdown-grace-period -1s: must not be negative, 0 to disable",
+		},
+		"max body size of 0": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.MaxBodySize = 0
+			},
+			expectedErr: "invalid -frontend.max-body-size 0: must be positive, otherwise all the requests with a body are rejected",
+		},
+		"small max body size": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.MaxBodySize = 1
+			},
+		},
+		"small max body size in strict mode": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.MaxBodySize = 1
+				cfg.Handler.MaxBodySizeStrict = true
+			},
+			expectedErr: "invalid -frontend.max-body-size 1: must be at least 4096 bytes when -frontend.max-body-size-strict is enabled",
+		},
+		"no long GET query length": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.ConvertLongGETsToPOST = true
+				cfg.Handler.LongGETQueryLength = 0
+			},
+			expectedErr: "invalid -frontend.long-get-query-length 0: must be positive when -frontend.convert-long-gets-to-post is enabled",
+		},
+		"no long GET query length when disabled": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.LongGETQueryLength = 0
+			},
+		},
+		"negative default retry after": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.DefaultRetryAfter = -time.Second
+			},
+			expectedErr: "invalid -frontend.default-retry-after -1s: must not be negative, 0 to disable",
+		},
+		"negative idle connection timeout": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.DownstreamTransport.IdleConnTimeout = -time.Second
+			},
+			expectedErr: "invalid -frontend.downstream-idle-connection-timeout -1s: must not be negative",
+		},
+		"negative max connections per host": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.DownstreamTransport.MaxConnsPerHost = -1
+			},
+			expectedErr: "invalid -frontend.downstream-max-connections-per-host -1: must not be negative, 0 for no limit",
+		},
+		"HTTP/2 without ping timeout": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.DownstreamHTTP2.Enabled = true
+				cfg.Handler.DownstreamHTTP2.PingTimeout = 0
+			},
+			expectedErr: "invalid -frontend.downstream-http2.ping-timeout 0s: must be positive",
+		},
+		"historical downstream URL": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.Historical.URL = "http://prometheus-historical"
+			},
+		},
+		"historical downstream URL without host": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.Historical.URL = "http:///api"
+			},
+			expectedErr: `invalid -frontend.historical-downstream-url "http:///api": the host is missing`,
+		},
+		"historical downstream URL without threshold": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.Historical.URL = "http://prometheus-historical"
+				cfg.Handler.Historical.Threshold = 0
+			},
+			expectedErr: "invalid -frontend.historical-query-threshold 0s: must be positive",
+		},
+		"no outstanding requests per tenant": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.FrontendV1.MaxOutstandingPerTenant = 0
+			},
+			expectedErr: "invalid -querier.max-outstanding-requests-per-tenant 0: must be positive, otherwise all the requests are rejected",
+		},
+		"v1 frontend settings are ignored with the downstream URL": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.DownstreamURL = "http://prometheus"
+				cfg.FrontendV1.MaxOutstandingPerTenant = 0
+			},
+		},
+		"negative cancelled tenant block duration": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.FrontendV1.CancelledTenantBlockDuration = -time.Second
+			},
+			expectedErr: "invalid -frontend.cancelled-tenant-block-duration -1s: must not be negative",
+		},
+		"liveness timeout not longer than the heartbeat period": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.FrontendV1.LivenessTimeout = time.Second
+			},
+			expectedErr: "invalid -frontend.liveness-timeout 1s: must be longer than the 1s heartbeat period",
+		},
+		"queue webhook": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.FrontendV1.QueueWebhook.URL = "https://alerts.example.com/hook"
+				cfg.FrontendV1.QueueWebhook.TenantHighWatermark = 50
+			},
+		},
+		"queue webhook without high-watermark": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.FrontendV1.QueueWebhook.URL = "https://alerts.example.com/hook"
+			},
+			expectedErr: "-frontend.queue-webhook.high-watermark or -frontend.queue-webhook.tenant-high-watermark must be configured along with -frontend.queue-webhook.url",
+		},
+		"queue webhook URL without scheme": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.FrontendV1.QueueWebhook.URL = "alerts.example.com/hook"
+				cfg.FrontendV1.QueueWebhook.HighWatermark = 50
+			},
+			expectedErr: `invalid -frontend.queue-webhook.url "alerts.example.com/hook": must be an http or https URL`,
+		},
+		"JWT tenant claim": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.JWKSURL = "https://auth.example.com/.well-known/jwks.json"
+				cfg.Handler.TenantResolver.JWTTenantClaim = "tenant_id"
+			},
+		},
+		"JWT without tenant claim": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.JWKSURL = "https://auth.example.com/.well-known/jwks.json"
+			},
+			expectedErr: "-frontend.jwt.tenant-claim must be configured along with -frontend.jwt.jwks-url",
+		},
+		"JWT along with org ID header": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.JWKSURL = "https://auth.example.com/.well-known/jwks.json"
+				cfg.Handler.TenantResolver.JWTTenantClaim = "tenant_id"
+				cfg.Handler.TenantResolver.OrgIDHeader = "X-Tenant"
+			},
+			expectedErr: "-frontend.org-id-header and -frontend.jwt.jwks-url can't be both configured, use -frontend.tenant-sources instead",
+		},
+		"JWT along with bearer token": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.JWKSURL = "https://auth.example.com/.well-known/jwks.json"
+				cfg.Handler.TenantResolver.JWTTenantClaim = "tenant_id"
+				cfg.Handler.Auth.BearerToken.Value = "secret"
+			},
+			expectedErr: "-frontend.jwt.jwks-url can't be configured along with the -frontend.auth credentials, as both are read from the Authorization header",
+		},
+		"tenant sources": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.TenantSources = []string{"X-Scope-OrgID", "jwt"}
+				cfg.Handler.TenantResolver.JWKSURL = "https://auth.example.com/.well-known/jwks.json"
+				cfg.Handler.TenantResolver.JWTTenantClaim = "tenant_id"
+			},
+		},
+		"tenant sources with duplicates": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.TenantSources = []string{"X-Tenant", "x-tenant"}
+			},
+			expectedErr: `invalid -frontend.tenant-sources "X-Tenant,x-tenant": the sources must be unique and not empty`,
+		},
+		"tenant sources with jwt but no JWKS": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.TenantSources = []string{"X-Scope-OrgID", "jwt"}
+			},
+			expectedErr: "jwt must be in -frontend.tenant-sources if and only if -frontend.jwt.jwks-url is configured",
+		},
+		"tenant sources along with org ID header": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.TenantSources = []string{"X-Scope-OrgID"}
+				cfg.Handler.TenantResolver.OrgIDHeader = "X-Tenant"
+			},
+			expectedErr: "-frontend.tenant-sources and -frontend.org-id-header can't be both configured, add the header to -frontend.tenant-sources instead",
+		},
+		"org ID header is X-Scope-OrgID": {
+			setup: func(cfg *CombinedFrontendConfig) {
+				cfg.Handler.TenantResolver.OrgIDHeader = "x-scope-orgid"
+			},
+			expectedErr: `invalid -frontend.org-id-header "x-scope-orgid": the tenant ID is already read from this header`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := CombinedFrontendConfig{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+
+			err := cfg.Validate()
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestInitFrontend_InvalidConfig(t *testing.T) {
+	cfg := CombinedFrontendConfig{}
+	flagext.DefaultValues(&cfg)
+	cfg.Handler.MaxBodySize = 0
+
+	_, _, _, err := InitFrontend(cfg, nil, 0, log.NewNopLogger(), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-frontend.max-body-size")
+}
@@ -0,0 +1,79 @@
+package frontend
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// CORSConfig configures the CORS headers of the query-frontend responses, allowing browser
+// based clients to query the frontend directly.
+type CORSConfig struct {
+	AllowedOrigins flagext.StringSliceCSV `yaml:"cors_allowed_origins"`
+	AllowedMethods flagext.StringSliceCSV `yaml:"cors_allowed_methods"`
+	AllowedHeaders flagext.StringSliceCSV `yaml:"cors_allowed_headers"`
+}
+
+func (cfg *CORSConfig) RegisterFlags(f *flag.FlagSet) {
+	cfg.AllowedMethods = []string{http.MethodGet, http.MethodPost}
+	cfg.AllowedHeaders = []string{"Authorization", "Content-Type", user.OrgIDHeaderName}
+
+	f.Var(&cfg.AllowedOrigins, "frontend.cors-allowed-origins", "Comma-separated list of origins allowed to query the frontend from the browser, or * to allow any origin. CORS is disabled if empty. When enabled, the CORS preflight requests are answered by the query-frontend without requiring authentication, and aren't forwarded.")
+	f.Var(&cfg.AllowedMethods, "frontend.cors-allowed-methods", "Comma-separated list of methods allowed in CORS requests.")
+	f.Var(&cfg.AllowedHeaders, "frontend.cors-allowed-headers", "Comma-separated list of request headers allowed in CORS requests.")
+}
+
+// Enabled returns whether CORS requests are allowed.
+func (cfg *CORSConfig) Enabled() bool {
+	return len(cfg.AllowedOrigins) > 0
+}
+
+func (cfg *CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCORS sets the CORS headers of the response, and returns true if the request is
+// a preflight request which has been answered, and must not be forwarded.
+func (cfg *CORSConfig) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	if !cfg.Enabled() {
+		return false
+	}
+
+	preflight := r.Method == http.MethodOptions
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not a CORS request. OPTIONS requests are still answered here, as they're only routed
+		// to the frontend, without authentication, for the preflight requests.
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return preflight
+	}
+
+	w.Header().Add("Vary", "Origin")
+	if !cfg.allowsOrigin(origin) {
+		if preflight {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if preflight {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return preflight
+}
@@ -0,0 +1,44 @@
+package frontend
+
+import (
+	"net/http"
+)
+
+// RoundTripper that adds static headers to the requests forwarded to the downstream or queriers.
+type downstreamHeadersRoundTripper struct {
+	headers  http.Header
+	override bool
+	next     http.RoundTripper
+}
+
+// withDownstreamHeaders wraps the RoundTripper to add the configured downstream headers, if any.
+func withDownstreamHeaders(cfg HandlerConfig, next http.RoundTripper) http.RoundTripper {
+	if len(cfg.DownstreamHeaders) == 0 || next == nil {
+		return next
+	}
+
+	headers := make(http.Header, len(cfg.DownstreamHeaders))
+	for name, value := range cfg.DownstreamHeaders {
+		headers.Set(name, value)
+	}
+
+	return &downstreamHeadersRoundTripper{
+		headers:  headers,
+		override: cfg.OverrideDownstreamHeaders,
+		next:     next,
+	}
+}
+
+func (d *downstreamHeadersRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	// Don't modify the headers of the caller's request.
+	r = r.Clone(r.Context())
+
+	for name, values := range d.headers {
+		if _, ok := r.Header[name]; ok && !d.override {
+			continue
+		}
+		r.Header[name] = values
+	}
+
+	return d.next.RoundTrip(r)
+}
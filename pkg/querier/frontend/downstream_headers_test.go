@@ -0,0 +1,96 @@
+package frontend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownstreamHeadersRoundTripper(t *testing.T) {
+	for name, tc := range map[string]struct {
+		override bool
+		expected string
+	}{
+		"client headers take precedence": {expected: "client"},
+		"configured headers override":    {override: true, expected: "configured"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultHandlerConfig()
+			cfg.DownstreamHeaders = map[string]string{
+				"authorization": "Bearer secret-token",
+				"X-Route":       "configured",
+			}
+			cfg.OverrideDownstreamHeaders = tc.override
+
+			var observed http.Header
+			rt := withDownstreamHeaders(cfg, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				observed = r.Header
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			}))
+
+			req := httptest.NewRequest("GET", query, nil)
+			req.Header.Set("X-Route", "client")
+			_, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, "Bearer secret-token", observed.Get("Authorization"))
+			assert.Equal(t, tc.expected, observed.Get("X-Route"))
+
+			// The headers of the original request are left untouched.
+			assert.Empty(t, req.Header.Get("Authorization"))
+			assert.Equal(t, "client", req.Header.Get("X-Route"))
+		})
+	}
+}
+
+func TestDownstreamHeaders_NotLoggedInSlowQueries(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.LogQueriesLongerThan = -1
+	cfg.DownstreamHeaders = map[string]string{"Authorization": "Bearer secret-token"}
+
+	rt := withDownstreamHeaders(cfg, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(responseBody))}, nil
+	}))
+
+	var buf syncBuf
+	h := NewHandler(cfg, nil, rt, log.NewLogfmtLogger(&buf), nil)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", query, nil))
+
+	assert.Contains(t, buf.String(), "slow query detected")
+	assert.NotContains(t, buf.String(), "secret-token")
+}
+
+func TestFrontend_DownstreamHeaders(t *testing.T) {
+	observed := make(chan string, 2)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		observed <- r.Header.Get("X-Route")
+		_, err := w.Write([]byte(responseBody))
+		require.NoError(t, err)
+	})
+
+	config := defaultFrontendConfig()
+	config.Handler.DownstreamHeaders = map[string]string{"X-Route": "querier-pool-1"}
+
+	test := func(addr string) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/%s", addr, query), nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Scope-OrgID", "1")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		_ = resp.Body.Close()
+
+		// The header is sent to the querier along with the request.
+		assert.Equal(t, "querier-pool-1", <-observed)
+	}
+
+	testFrontend(t, config, handler, test, false, nil)
+}
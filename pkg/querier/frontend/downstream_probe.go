@@ -0,0 +1,113 @@
+package frontend
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// DownstreamProbeConfig configures the readiness probe of the downstream Prometheus.
+type DownstreamProbeConfig struct {
+	Enabled  bool          `yaml:"downstream_probe_enabled"`
+	Path     string        `yaml:"downstream_probe_path"`
+	Timeout  time.Duration `yaml:"downstream_probe_timeout"`
+	CacheTTL time.Duration `yaml:"downstream_probe_cache_ttl"`
+}
+
+func (cfg *DownstreamProbeConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "frontend.downstream-probe-enabled", false, "When the downstream URL is configured, probe the downstream Prometheus on readiness checks, and report the query-frontend as not ready if it's unreachable.")
+	f.StringVar(&cfg.Path, "frontend.downstream-probe-path", "/api/v1/status/buildinfo", "Path of the downstream Prometheus requested by the readiness probe. The downstream is considered reachable if it responds with a 2xx status code.")
+	f.DurationVar(&cfg.Timeout, "frontend.downstream-probe-timeout", time.Second, "Timeout of the downstream readiness probe.")
+	f.DurationVar(&cfg.CacheTTL, "frontend.downstream-probe-cache-ttl", 5*time.Second, "How long the result of the downstream readiness probe is cached, to limit the probes sent downstream.")
+}
+
+func (cfg *DownstreamProbeConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("invalid -frontend.downstream-probe-timeout %s: must be positive", cfg.Timeout)
+	}
+	if cfg.CacheTTL < 0 {
+		return fmt.Errorf("invalid -frontend.downstream-probe-cache-ttl %s: must not be negative", cfg.CacheTTL)
+	}
+	return nil
+}
+
+// DownstreamProbe checks whether the downstream Prometheus is reachable, caching the result.
+type DownstreamProbe struct {
+	cfg    DownstreamProbeConfig
+	url    string
+	client *http.Client
+	log    log.Logger
+
+	// Held while probing, so that concurrent readiness checks share the same probe.
+	mtx       sync.Mutex
+	lastProbe time.Time
+	lastErr   error
+}
+
+func NewDownstreamProbe(cfg DownstreamProbeConfig, downstreamURL string, log log.Logger) (*DownstreamProbe, error) {
+	u, err := url.Parse(downstreamURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, cfg.Path)
+
+	return &DownstreamProbe{
+		cfg:    cfg,
+		url:    u.String(),
+		client: &http.Client{Timeout: cfg.Timeout},
+		log:    log,
+	}, nil
+}
+
+// CheckReady returns an error if the downstream Prometheus is unreachable. Function parameters/return
+// chosen to match the same method in the ingester
+func (p *DownstreamProbe) CheckReady(ctx context.Context) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if !p.lastProbe.IsZero() && time.Since(p.lastProbe) < p.cfg.CacheTTL {
+		return p.lastErr
+	}
+
+	p.lastErr = p.probe(ctx)
+	p.lastProbe = time.Now()
+	if p.lastErr != nil {
+		level.Info(p.log).Log("msg", "downstream readiness probe failed", "url", p.url, "err", p.lastErr)
+	}
+	return p.lastErr
+}
+
+func (p *DownstreamProbe) probe(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("downstream unreachable: %v", err)
+	}
+	defer func() {
+		// Drain the body so that the connection can be reused.
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("downstream responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
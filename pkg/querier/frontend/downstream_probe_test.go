@@ -0,0 +1,103 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestDownstreamProbe(t *testing.T) {
+	for name, tc := range map[string]struct {
+		statusCode  int
+		expectedErr string
+	}{
+		"downstream healthy": {
+			statusCode: http.StatusOK,
+		},
+		"downstream failing": {
+			statusCode:  http.StatusServiceUnavailable,
+			expectedErr: "downstream responded with status code 503",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var path atomic.String
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path.Store(r.URL.Path)
+				w.WriteHeader(tc.statusCode)
+			}))
+			defer server.Close()
+
+			p, err := NewDownstreamProbe(DownstreamProbeConfig{Path: "/api/v1/status/buildinfo", Timeout: time.Second}, server.URL+"/prometheus", log.NewNopLogger())
+			require.NoError(t, err)
+
+			err = p.CheckReady(context.Background())
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+			assert.Equal(t, "/prometheus/api/v1/status/buildinfo", path.Load())
+		})
+	}
+}
+
+func TestDownstreamProbe_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	p, err := NewDownstreamProbe(DownstreamProbeConfig{Path: "/api/v1/status/buildinfo", Timeout: time.Second}, server.URL, log.NewNopLogger())
+	require.NoError(t, err)
+
+	err = p.CheckReady(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "downstream unreachable")
+}
+
+func TestDownstreamProbe_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p, err := NewDownstreamProbe(DownstreamProbeConfig{Path: "/", Timeout: 50 * time.Millisecond}, server.URL, log.NewNopLogger())
+	require.NoError(t, err)
+
+	err = p.CheckReady(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "downstream unreachable")
+}
+
+func TestDownstreamProbe_CachesResult(t *testing.T) {
+	probes := atomic.NewInt32(0)
+	healthy := atomic.NewBool(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Inc()
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewDownstreamProbe(DownstreamProbeConfig{Path: "/", Timeout: time.Second, CacheTTL: time.Hour}, server.URL, log.NewNopLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, p.CheckReady(context.Background()))
+
+	// The downstream failure isn't noticed until the cached result expires.
+	healthy.Store(false)
+	require.NoError(t, p.CheckReady(context.Background()))
+	assert.Equal(t, int32(1), probes.Load())
+
+	p.lastProbe = time.Now().Add(-2 * time.Hour)
+	assert.Error(t, p.CheckReady(context.Background()))
+	assert.Equal(t, int32(2), probes.Load())
+}
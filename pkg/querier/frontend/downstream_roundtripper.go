@@ -1,25 +1,40 @@
 package frontend
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"sync"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 // RoundTripper that forwards requests to downstream URL.
 type downstreamRoundTripper struct {
 	downstreamURL *url.URL
+
+	// Whether to keep the Host header of the original request.
+	preserveHost bool
+
+	// Used to limit the time the queries run downstream, if not nil.
+	limits Limits
+
+	transport http.RoundTripper
 }
 
-func NewDownstreamRoundTripper(downstreamURL string) (http.RoundTripper, error) {
+func NewDownstreamRoundTripper(downstreamURL string, preserveHost bool, limits Limits, transport http.RoundTripper) (http.RoundTripper, error) {
 	u, err := url.Parse(downstreamURL)
 	if err != nil {
 		return nil, err
 	}
 
-	return &downstreamRoundTripper{downstreamURL: u}, nil
+	return &downstreamRoundTripper{downstreamURL: u, preserveHost: preserveHost, limits: limits, transport: transport}, nil
 }
 
 func (d downstreamRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -32,9 +47,115 @@ func (d downstreamRoundTripper) RoundTrip(r *http.Request) (*http.Response, erro
 		}
 	}
 
+	// Sub-queries don't keep the headers of the original request.
+	if queryID := extractQueryID(r.Context()); queryID != "" {
+		r.Header.Set(QueryIDHeader, queryID)
+	}
+
+	var cancel context.CancelFunc
+	if d.limits != nil {
+		if tenantIDs, err := tenant.TenantIDs(r.Context()); err == nil {
+			if timeout := validation.SmallestPositiveDurationPerTenant(tenantIDs, d.limits.QueryTimeout); timeout > 0 {
+				var ctx context.Context
+				ctx, cancel = context.WithTimeout(r.Context(), timeout)
+				r = r.WithContext(ctx)
+			}
+		}
+	}
+
 	r.URL.Scheme = d.downstreamURL.Scheme
 	r.URL.Host = d.downstreamURL.Host
 	r.URL.Path = path.Join(d.downstreamURL.Path, r.URL.Path)
-	r.Host = ""
-	return http.DefaultTransport.RoundTrip(r)
+	if !d.preserveHost {
+		r.Host = ""
+	}
+
+	resp, err := d.transport.RoundTrip(r)
+	if cancel != nil {
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// The response body is read after returning, so the timeout is cancelled once it's closed.
+		resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	}
+	return resp, err
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// RoundTripper that forwards the requests of the tenants with a downstream URL override
+// to their downstream URL, and the other requests to next.
+type tenantDownstreamRoundTripper struct {
+	limits       Limits
+	preserveHost bool
+	transport    http.RoundTripper
+	next         http.RoundTripper
+
+	mtx         sync.Mutex
+	downstreams map[string]http.RoundTripper
+}
+
+func newTenantDownstreamRoundTripper(limits Limits, preserveHost bool, transport, next http.RoundTripper) http.RoundTripper {
+	return &tenantDownstreamRoundTripper{
+		limits:       limits,
+		preserveHost: preserveHost,
+		transport:    transport,
+		next:         next,
+		downstreams:  map[string]http.RoundTripper{},
+	}
+}
+
+func (t *tenantDownstreamRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	tenantIDs, err := tenant.TenantIDs(r.Context())
+	if err != nil {
+		return t.next.RoundTrip(r)
+	}
+
+	downstreamURL := t.tenantDownstreamURL(tenantIDs)
+	if downstreamURL == "" {
+		return t.next.RoundTrip(r)
+	}
+
+	downstream, err := t.downstream(downstreamURL)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "invalid downstream URL of tenant: %v", err)
+	}
+	return downstream.RoundTrip(r)
+}
+
+// tenantDownstreamURL returns the downstream URL override of the tenants, or an empty string if
+// they don't share the same override.
+func (t *tenantDownstreamRoundTripper) tenantDownstreamURL(tenantIDs []string) string {
+	downstreamURL := t.limits.DownstreamURL(tenantIDs[0])
+	for _, tenantID := range tenantIDs[1:] {
+		if t.limits.DownstreamURL(tenantID) != downstreamURL {
+			return ""
+		}
+	}
+	return downstreamURL
+}
+
+func (t *tenantDownstreamRoundTripper) downstream(downstreamURL string) (http.RoundTripper, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if rt, ok := t.downstreams[downstreamURL]; ok {
+		return rt, nil
+	}
+
+	rt, err := NewDownstreamRoundTripper(downstreamURL, t.preserveHost, t.limits, t.transport)
+	if err != nil {
+		return nil, err
+	}
+	t.downstreams[downstreamURL] = rt
+	return rt, nil
 }
@@ -0,0 +1,125 @@
+package frontend
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// DownstreamTransportConfig configures the connections pool of the transport used to send the queries
+// to the downstream URL over HTTP/1.1.
+type DownstreamTransportConfig struct {
+	MaxIdleConns        int           `yaml:"downstream_max_idle_connections"`
+	MaxIdleConnsPerHost int           `yaml:"downstream_max_idle_connections_per_host"`
+	MaxConnsPerHost     int           `yaml:"downstream_max_connections_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"downstream_idle_connection_timeout"`
+}
+
+func (cfg *DownstreamTransportConfig) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxIdleConns, "frontend.downstream-max-idle-connections", 100, "Maximum number of idle connections kept open to the downstream URLs, across all hosts. 0 for no limit.")
+	f.IntVar(&cfg.MaxIdleConnsPerHost, "frontend.downstream-max-idle-connections-per-host", 100, "Maximum number of idle connections kept open to each downstream host. Keeping enough idle connections avoids opening new ones for each burst of requests created by splitting the queries.")
+	f.IntVar(&cfg.MaxConnsPerHost, "frontend.downstream-max-connections-per-host", 0, "Maximum number of connections to each downstream host, including the ones in use. Requests wait for a connection once the limit is reached. 0 for no limit.")
+	f.DurationVar(&cfg.IdleConnTimeout, "frontend.downstream-idle-connection-timeout", 90*time.Second, "Time after which an idle connection to the downstream is closed. 0 to keep idle connections open.")
+}
+
+func (cfg *DownstreamTransportConfig) Validate() error {
+	for _, limit := range []struct {
+		flagName string
+		value    int
+	}{
+		{"frontend.downstream-max-idle-connections", cfg.MaxIdleConns},
+		{"frontend.downstream-max-idle-connections-per-host", cfg.MaxIdleConnsPerHost},
+		{"frontend.downstream-max-connections-per-host", cfg.MaxConnsPerHost},
+	} {
+		if limit.value < 0 {
+			return fmt.Errorf("invalid -%s %d: must not be negative, 0 for no limit", limit.flagName, limit.value)
+		}
+	}
+	if cfg.IdleConnTimeout < 0 {
+		return fmt.Errorf("invalid -frontend.downstream-idle-connection-timeout %s: must not be negative", cfg.IdleConnTimeout)
+	}
+	return nil
+}
+
+// DownstreamHTTP2Config configures the HTTP/2 transport used to send the queries to the downstream URL.
+type DownstreamHTTP2Config struct {
+	Enabled                    bool          `yaml:"downstream_http2_enabled"`
+	ReadIdleTimeout            time.Duration `yaml:"downstream_http2_read_idle_timeout"`
+	PingTimeout                time.Duration `yaml:"downstream_http2_ping_timeout"`
+	StrictMaxConcurrentStreams bool          `yaml:"downstream_http2_strict_max_concurrent_streams"`
+}
+
+func (cfg *DownstreamHTTP2Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "frontend.downstream-http2.enabled", false, "Use HTTP/2 to send the queries to the downstream URL: cleartext HTTP/2 (h2c, with prior knowledge) for http:// URLs, and HTTP/2 over TLS for https:// URLs. The downstream must support HTTP/2, as HTTP/1.1 isn't used as fallback.")
+	f.DurationVar(&cfg.ReadIdleTimeout, "frontend.downstream-http2.read-idle-timeout", 30*time.Second, "When HTTP/2 is enabled, interval after which a ping is sent on a downstream connection which didn't receive any frame, to detect broken connections and keep idle ones alive. 0 to disable.")
+	f.DurationVar(&cfg.PingTimeout, "frontend.downstream-http2.ping-timeout", 15*time.Second, "When HTTP/2 is enabled, time after which a downstream connection is closed if a ping isn't answered.")
+	f.BoolVar(&cfg.StrictMaxConcurrentStreams, "frontend.downstream-http2.strict-max-concurrent-streams", false, "When HTTP/2 is enabled, whether the max concurrent streams advertised by the downstream is a global limit, so that requests wait for a stream to be available instead of opening more connections.")
+}
+
+func (cfg *DownstreamHTTP2Config) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.ReadIdleTimeout < 0 {
+		return fmt.Errorf("invalid -frontend.downstream-http2.read-idle-timeout %s: must not be negative, 0 to disable", cfg.ReadIdleTimeout)
+	}
+	if cfg.PingTimeout <= 0 {
+		return fmt.Errorf("invalid -frontend.downstream-http2.ping-timeout %s: must be positive", cfg.PingTimeout)
+	}
+	return nil
+}
+
+// newDownstreamTransport returns the transport of the requests sent to the downstream URL. The
+// connections pool settings only apply to HTTP/1.1, as HTTP/2 multiplexes the requests on a single
+// connection to each host.
+func newDownstreamTransport(transportCfg DownstreamTransportConfig, cfg DownstreamHTTP2Config) http.RoundTripper {
+	if !cfg.Enabled {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = transportCfg.MaxIdleConns
+		transport.MaxIdleConnsPerHost = transportCfg.MaxIdleConnsPerHost
+		transport.MaxConnsPerHost = transportCfg.MaxConnsPerHost
+		transport.IdleConnTimeout = transportCfg.IdleConnTimeout
+		return transport
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &downstreamHTTP2Transport{
+		h2c: &http2.Transport{
+			// Connections to http:// URLs aren't encrypted.
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			},
+			ReadIdleTimeout:            cfg.ReadIdleTimeout,
+			PingTimeout:                cfg.PingTimeout,
+			StrictMaxConcurrentStreams: cfg.StrictMaxConcurrentStreams,
+		},
+		h2: &http2.Transport{
+			ReadIdleTimeout:            cfg.ReadIdleTimeout,
+			PingTimeout:                cfg.PingTimeout,
+			StrictMaxConcurrentStreams: cfg.StrictMaxConcurrentStreams,
+		},
+	}
+}
+
+// downstreamHTTP2Transport sends the requests to http:// URLs with h2c, and the other ones with HTTP/2 over TLS.
+type downstreamHTTP2Transport struct {
+	h2c http.RoundTripper
+	h2  http.RoundTripper
+}
+
+func (t *downstreamHTTP2Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(r)
+	}
+	return t.h2.RoundTrip(r)
+}
@@ -0,0 +1,92 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestDownstreamTransport_H2C(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+
+	// Cleartext HTTP/2 server, with prior knowledge.
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+
+	var cfg HandlerConfig
+	flagext.DefaultValues(&cfg)
+	cfg.DownstreamHTTP2.Enabled = true
+
+	rt, err := NewDownstreamRoundTripper("http://"+listener.Addr().String(), false, nil, newDownstreamTransport(cfg.DownstreamTransport, cfg.DownstreamHTTP2))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		resp, err := rt.RoundTrip(httptest.NewRequest("GET", "/api/v1/query", nil))
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "HTTP/2.0", string(body))
+	}
+}
+
+func TestDownstreamTransport_HTTP1ByDefault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	defer server.Close()
+
+	var cfg HandlerConfig
+	flagext.DefaultValues(&cfg)
+
+	rt, err := NewDownstreamRoundTripper(server.URL, false, nil, newDownstreamTransport(cfg.DownstreamTransport, cfg.DownstreamHTTP2))
+	require.NoError(t, err)
+
+	resp, err := rt.RoundTrip(httptest.NewRequest("GET", "/api/v1/query", nil))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "HTTP/1.1", string(body))
+}
+
+func TestDownstreamTransport_ConnectionsPool(t *testing.T) {
+	cfg := DownstreamTransportConfig{
+		MaxIdleConns:        10,
+		MaxIdleConnsPerHost: 5,
+		MaxConnsPerHost:     20,
+		IdleConnTimeout:     time.Minute,
+	}
+
+	transport, ok := newDownstreamTransport(cfg, DownstreamHTTP2Config{}).(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, 10, transport.MaxIdleConns)
+	assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 20, transport.MaxConnsPerHost)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+
+	// The default transport is left unchanged.
+	assert.NotEqual(t, 5, http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost)
+}
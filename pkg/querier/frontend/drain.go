@@ -0,0 +1,88 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// Drain rejects the new requests with HTTP 503 and disables the keep-alive connections of the
+// server, so that load balancers steer the traffic to the other replicas, then waits up to the
+// shutdown grace period for the requests in flight to complete. It's a no-op if the grace period
+// is disabled.
+func (f *Handler) Drain(s *http.Server) {
+	if f.drainer == nil {
+		return
+	}
+	s.SetKeepAlivesEnabled(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.cfg.HTTPServer.ShutdownGracePeriod)
+	defer cancel()
+
+	level.Info(f.log).Log("msg", "draining the requests in flight", "grace_period", f.cfg.HTTPServer.ShutdownGracePeriod)
+	if inflight := f.drainer.drain(ctx); inflight > 0 {
+		level.Warn(f.log).Log("msg", "requests still in flight at the end of the shutdown grace period", "requests", inflight)
+	}
+}
+
+// drainer tracks the requests in flight, so that they're given a chance to complete on shutdown
+// while the new ones are rejected.
+type drainer struct {
+	mtx      sync.Mutex
+	inflight int
+	draining bool
+
+	// Closed once draining and no request is in flight anymore.
+	drained chan struct{}
+}
+
+func newDrainer() *drainer {
+	return &drainer{drained: make(chan struct{})}
+}
+
+// acquire tracks a new request in flight, unless draining. The request must be released once
+// served if it's tracked.
+func (d *drainer) acquire() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.draining {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+func (d *drainer) release() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.inflight--
+	if d.draining && d.inflight == 0 {
+		close(d.drained)
+	}
+}
+
+// drain rejects the new requests and waits for the requests in flight to complete, or the context
+// to be done. It returns the number of requests still in flight.
+func (d *drainer) drain(ctx context.Context) int {
+	d.mtx.Lock()
+	if !d.draining {
+		d.draining = true
+		if d.inflight == 0 {
+			close(d.drained)
+		}
+	}
+	d.mtx.Unlock()
+
+	select {
+	case <-d.drained:
+		return 0
+	case <-ctx.Done():
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return d.inflight
+	}
+}
@@ -0,0 +1,100 @@
+package frontend
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Drain(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.HTTPServer.ShutdownGracePeriod = 5 * time.Second
+
+	started, release := make(chan struct{}), make(chan struct{})
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		close(started)
+		<-release
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+	h := NewHandler(cfg, nil, rt, log.NewNopLogger(), nil)
+
+	inflight := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		h.ServeHTTP(inflight, httptest.NewRequest("GET", query, nil))
+	}()
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		h.Drain(&http.Server{})
+	}()
+
+	// The new requests are rejected while the request in flight is drained.
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+		if w.Code != http.StatusServiceUnavailable {
+			return false
+		}
+		assert.Equal(t, "close", w.Header().Get("Connection"))
+		assert.Equal(t, "5", w.Header().Get(retryAfterHeader))
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	select {
+	case <-drained:
+		require.Fail(t, "drained before the request in flight completed")
+	default:
+	}
+
+	close(release)
+	<-served
+	<-drained
+	assert.Equal(t, http.StatusOK, inflight.Code)
+	assert.Equal(t, responseBody, inflight.Body.String())
+}
+
+func TestHandler_DrainGracePeriodElapsed(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.HTTPServer.ShutdownGracePeriod = 100 * time.Millisecond
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		close(started)
+		<-release
+		return nil, context.Canceled
+	})
+	h := NewHandler(cfg, nil, rt, log.NewNopLogger(), nil)
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", query, nil))
+	<-started
+
+	start := time.Now()
+	h.Drain(&http.Server{})
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(cfg.HTTPServer.ShutdownGracePeriod))
+}
+
+func TestHandler_DrainDisabled(t *testing.T) {
+	h := NewHandler(defaultHandlerConfig(), nil, okRoundTripper(), log.NewNopLogger(), nil)
+	h.Drain(&http.Server{})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,54 @@
+package frontend
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EndpointTimeoutsConfig configures the timeouts of the requests forwarded downstream, per type of
+// endpoint, as their latency profiles differ.
+type EndpointTimeoutsConfig struct {
+	Range   time.Duration `yaml:"range"`
+	Instant time.Duration `yaml:"instant"`
+	Series  time.Duration `yaml:"series"`
+	Labels  time.Duration `yaml:"labels"`
+}
+
+func (cfg *EndpointTimeoutsConfig) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.Range, "frontend.timeouts.range", 0, "Timeout of the range queries forwarded downstream. 0 to only apply -frontend.query-timeout.")
+	f.DurationVar(&cfg.Instant, "frontend.timeouts.instant", 0, "Timeout of the instant queries forwarded downstream. 0 to only apply -frontend.query-timeout.")
+	f.DurationVar(&cfg.Series, "frontend.timeouts.series", 0, "Timeout of the series requests forwarded downstream. 0 to only apply -frontend.query-timeout.")
+	f.DurationVar(&cfg.Labels, "frontend.timeouts.labels", 0, "Timeout of the label names and label values requests forwarded downstream. 0 to only apply -frontend.query-timeout.")
+}
+
+func (cfg *EndpointTimeoutsConfig) Validate() error {
+	for _, t := range []struct {
+		name    string
+		timeout time.Duration
+	}{{"range", cfg.Range}, {"instant", cfg.Instant}, {"series", cfg.Series}, {"labels", cfg.Labels}} {
+		if t.timeout < 0 {
+			return fmt.Errorf("invalid -frontend.timeouts.%s %s: must not be negative, 0 to disable", t.name, t.timeout)
+		}
+	}
+	return nil
+}
+
+// timeout returns the timeout of the request, or 0 if it's not configured for its endpoint.
+func (cfg *EndpointTimeoutsConfig) timeout(r *http.Request) time.Duration {
+	path := r.URL.Path
+	switch {
+	case strings.HasSuffix(path, "/api/v1/query_range"):
+		return cfg.Range
+	case strings.HasSuffix(path, "/api/v1/query"):
+		return cfg.Instant
+	case strings.HasSuffix(path, "/api/v1/series"):
+		return cfg.Series
+	case strings.HasSuffix(path, "/api/v1/labels"), isLabelValuesRequest(r):
+		return cfg.Labels
+	default:
+		return 0
+	}
+}
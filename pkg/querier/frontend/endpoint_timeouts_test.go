@@ -0,0 +1,54 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_EndpointTimeouts(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.Timeouts = EndpointTimeoutsConfig{Range: 5 * time.Minute, Instant: 10 * time.Second, Labels: time.Minute}
+
+	var (
+		deadline    time.Time
+		hasDeadline bool
+	)
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		deadline, hasDeadline = r.Context().Deadline()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+	h := NewHandler(cfg, nil, rt, log.NewNopLogger(), nil)
+
+	for path, expected := range map[string]time.Duration{
+		"/prometheus/api/v1/query_range":       5 * time.Minute,
+		"/prometheus/api/v1/query":             10 * time.Second,
+		"/prometheus/api/v1/labels":            time.Minute,
+		"/prometheus/api/v1/label/job/values":  time.Minute,
+		"/prometheus/api/v1/series":            0,
+		"/prometheus/api/v1/admin/tsdb/status": 0,
+	} {
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusOK, w.Code, path)
+
+		if expected == 0 {
+			assert.False(t, hasDeadline, path)
+			continue
+		}
+		require.True(t, hasDeadline, path)
+		assert.WithinDuration(t, start.Add(expected), deadline, time.Second, path)
+	}
+}
@@ -0,0 +1,44 @@
+package frontend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// labelValuesPath matches the Prometheus label values endpoint, eg. /api/v1/label/job/values.
+var labelValuesPath = regexp.MustCompile(`/api/v1/label/[^/]+/values$`)
+
+// ETagConfig configures the conditional label values requests.
+type ETagConfig struct {
+	LabelValuesETag bool `yaml:"label_values_etag"`
+}
+
+func (cfg *ETagConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.LabelValuesETag, "frontend.label-values-etag", false, "Set a weak ETag, computed from the payload, on the successful label values responses, and answer with HTTP 304 the requests whose If-None-Match header matches it. The responses are buffered in memory to compute the ETag.")
+}
+
+func isLabelValuesRequest(r *http.Request) bool {
+	return labelValuesPath.MatchString(r.URL.Path)
+}
+
+// weakETag returns a weak ETag of the payload, changing whenever the payload changes.
+func weakETag(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches returns whether the If-None-Match header matches the ETag, using the weak
+// comparison as required by RFC 7232.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const labelValuesBody = `{"status":"success","data":["api","ingester"]}`
+
+func TestHandler_LabelValuesETag(t *testing.T) {
+	etag := weakETag([]byte(labelValuesBody))
+
+	for name, tc := range map[string]struct {
+		enabled        bool
+		path           string
+		ifNoneMatch    string
+		expectedStatus int
+		expectedETag   string
+		expectedBody   string
+	}{
+		"disabled": {
+			path:           "/api/v1/label/job/values",
+			ifNoneMatch:    etag,
+			expectedStatus: http.StatusOK,
+			expectedBody:   labelValuesBody,
+		},
+		"without If-None-Match": {
+			enabled:        true,
+			path:           "/api/v1/label/job/values",
+			expectedStatus: http.StatusOK,
+			expectedETag:   etag,
+			expectedBody:   labelValuesBody,
+		},
+		"matching If-None-Match": {
+			enabled:        true,
+			path:           "/api/v1/label/job/values",
+			ifNoneMatch:    `W/"other", ` + etag,
+			expectedStatus: http.StatusNotModified,
+			expectedETag:   etag,
+		},
+		"If-None-Match matching any ETag": {
+			enabled:        true,
+			path:           "/api/v1/label/job/values",
+			ifNoneMatch:    "*",
+			expectedStatus: http.StatusNotModified,
+			expectedETag:   etag,
+		},
+		"stale If-None-Match": {
+			enabled:        true,
+			path:           "/api/v1/label/job/values",
+			ifNoneMatch:    weakETag([]byte(`{"status":"success","data":["api"]}`)),
+			expectedStatus: http.StatusOK,
+			expectedETag:   etag,
+			expectedBody:   labelValuesBody,
+		},
+		"not a label values request": {
+			enabled:        true,
+			path:           "/api/v1/labels",
+			ifNoneMatch:    etag,
+			expectedStatus: http.StatusOK,
+			expectedBody:   labelValuesBody,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{"application/json"}},
+					Body:       ioutil.NopCloser(strings.NewReader(labelValuesBody)),
+				}, nil
+			})
+
+			cfg := defaultHandlerConfig()
+			cfg.ETag.LabelValuesETag = tc.enabled
+
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			NewHandler(cfg, nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedETag, w.Header().Get("ETag"))
+			assert.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestWeakETag(t *testing.T) {
+	etag := weakETag([]byte(labelValuesBody))
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+	assert.Equal(t, etag, weakETag([]byte(labelValuesBody)))
+	assert.NotEqual(t, etag, weakETag([]byte(`{"status":"success","data":["api"]}`)))
+
+	// The weak comparison ignores the W/ prefix.
+	assert.True(t, etagMatches(strings.TrimPrefix(etag, "W/"), etag))
+	assert.False(t, etagMatches(`W/"other"`, etag))
+}
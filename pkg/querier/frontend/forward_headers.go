@@ -0,0 +1,56 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+)
+
+type forwardedHeadersContextKey int
+
+const forwardedHeadersKey forwardedHeadersContextKey = 0
+
+// injectForwardedHeaders returns a derived context carrying the headers of the client request which
+// are always forwarded, so that they're propagated to the sub-queries which don't keep the headers
+// of the original request.
+func injectForwardedHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, forwardedHeadersKey, headers)
+}
+
+// extractForwardedHeaders returns the forwarded headers carried by the context, or nil if none.
+func extractForwardedHeaders(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(forwardedHeadersKey).(http.Header)
+	return headers
+}
+
+// RoundTripper that sets the forwarded headers of the client request on the requests sent to the
+// downstream or queriers which don't have them.
+type forwardedHeadersRoundTripper struct {
+	next http.RoundTripper
+}
+
+// withForwardedHeaders wraps the RoundTripper to set the forwarded headers, if any are configured.
+func withForwardedHeaders(cfg HandlerConfig, next http.RoundTripper) http.RoundTripper {
+	if len(cfg.ForwardHeaders) == 0 || next == nil {
+		return next
+	}
+	return &forwardedHeadersRoundTripper{next: next}
+}
+
+func (f *forwardedHeadersRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	headers := extractForwardedHeaders(r.Context())
+	if len(headers) == 0 {
+		return f.next.RoundTrip(r)
+	}
+
+	// Don't modify the headers of the caller's request.
+	r = r.Clone(r.Context())
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	for name, values := range headers {
+		if _, ok := r.Header[name]; !ok {
+			r.Header[name] = values
+		}
+	}
+	return f.next.RoundTrip(r)
+}
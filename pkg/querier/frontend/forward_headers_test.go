@@ -0,0 +1,51 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardedHeadersRoundTripper(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	require.NoError(t, cfg.ForwardHeaders.Set("Accept-Language"))
+
+	var observed http.Header
+	rt := withForwardedHeaders(cfg, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		observed = r.Header
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}))
+
+	// The sub-queries don't keep the headers of the original request.
+	subQuery := httptest.NewRequest("GET", query, nil)
+	subQuery.Header = nil
+	subQuery = subQuery.WithContext(injectForwardedHeaders(subQuery.Context(), http.Header{"Accept-Language": {"fr"}}))
+	_, err := rt.RoundTrip(subQuery)
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"Accept-Language": {"fr"}}, observed)
+	assert.Nil(t, subQuery.Header)
+
+	// The headers already set are kept.
+	req := httptest.NewRequest("GET", query, nil)
+	req.Header.Set("Accept-Language", "de")
+	req = req.WithContext(injectForwardedHeaders(req.Context(), http.Header{"Accept-Language": {"fr"}}))
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, "de", observed.Get("Accept-Language"))
+
+	// Nothing is forwarded without headers in the context.
+	_, err = rt.RoundTrip(httptest.NewRequest("GET", query, nil))
+	require.NoError(t, err)
+	assert.Empty(t, observed.Get("Accept-Language"))
+}
+
+func TestWithForwardedHeaders_Disabled(t *testing.T) {
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) { return nil, nil })
+	_, ok := withForwardedHeaders(defaultHandlerConfig(), next).(roundTripperFunc)
+	assert.True(t, ok)
+}
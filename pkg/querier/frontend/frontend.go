@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,27 +16,112 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/weaveworks/common/httpgrpc"
-	"github.com/weaveworks/common/user"
 	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 var (
 	errTooManyRequest = httpgrpc.Errorf(http.StatusTooManyRequests, "too many outstanding requests")
 )
 
+// Weight of the last observed queue duration in the moving average used to compute the Retry-After hint.
+const queueDurationDecay = 0.1
+
 // Config for a Frontend.
 type Config struct {
 	MaxOutstandingPerTenant int `yaml:"max_outstanding_per_tenant"`
+	MinConnectedClients     int `yaml:"min_connected_clients"`
+
+	CancelledTenantBlockDuration time.Duration `yaml:"cancelled_tenant_block_duration"`
+
+	QueryBudgetWindow        time.Duration `yaml:"query_budget_window"`
+	QueryBudgetThrottleDelay time.Duration `yaml:"query_budget_throttle_delay"`
+
+	QuerierDisconnectRetries int           `yaml:"querier_disconnect_retries"`
+	QuerierBusyPeriod        time.Duration `yaml:"querier_busy_period"`
+
+	LivenessTimeout time.Duration `yaml:"liveness_timeout"`
+
+	QueueWebhook QueueWebhookConfig `yaml:",inline"`
+
+	// Copied from the handler config, so that the same tenant label values are used in the metrics.
+	TenantLabels TenantLabelsConfig `yaml:"-"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet.
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&cfg.MaxOutstandingPerTenant, "querier.max-outstanding-requests-per-tenant", 100, "Maximum number of outstanding requests per tenant per frontend; requests beyond this error with HTTP 429.")
+	f.IntVar(&cfg.MinConnectedClients, "frontend.min-connected-clients", 1, "Minimum number of queriers connected to the query-frontend for it to report itself as ready. Values lower than 1 are treated as 1.")
+	f.DurationVar(&cfg.CancelledTenantBlockDuration, "frontend.cancelled-tenant-block-duration", 30*time.Second, "How long new queries of a tenant are refused after its outstanding queries have been cancelled with the admin endpoint.")
+	f.DurationVar(&cfg.QueryBudgetWindow, "frontend.query-budget-window", time.Minute, "Window over which the querier-seconds used by each tenant are accounted against its -frontend.query-budget.")
+	f.DurationVar(&cfg.QueryBudgetThrottleDelay, "frontend.query-budget-throttle-delay", time.Second, "Delay added before queueing the queries of a tenant which used its -frontend.query-budget in the current window.")
+	f.IntVar(&cfg.QuerierDisconnectRetries, "frontend.querier-disconnect-retries", 2, "Maximum number of times a read-only query is queued again, to be executed by another querier, when the querier executing it disconnects. The query then fails, as it may be the cause of the querier crashes. 0 to fail the query when the querier disconnects.")
+	f.DurationVar(&cfg.QuerierBusyPeriod, "frontend.querier-busy-period", 5*time.Second, "How long the queries are dispatched to the other queriers when possible, after a querier signaled it's busy along a response (-querier.worker-busy-threshold). The querier is no longer considered busy as soon as it responds without signaling it. 0 to ignore the signal.")
+	f.DurationVar(&cfg.LivenessTimeout, "frontend.liveness-timeout", time.Minute, fmt.Sprintf("How long the internal heartbeat of the query-frontend, beating every %s, can be late before the query-frontend reports itself as not alive on /frontend/live, eg. because it's wedged. Unlike the readiness, the liveness doesn't depend on the connected queriers.", heartbeatPeriod))
+	cfg.QueueWebhook.RegisterFlags(f)
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.MaxOutstandingPerTenant <= 0 {
+		return fmt.Errorf("invalid -querier.max-outstanding-requests-per-tenant %d: must be positive, otherwise all the requests are rejected", cfg.MaxOutstandingPerTenant)
+	}
+	if cfg.CancelledTenantBlockDuration < 0 {
+		return fmt.Errorf("invalid -frontend.cancelled-tenant-block-duration %s: must not be negative", cfg.CancelledTenantBlockDuration)
+	}
+	if cfg.QueryBudgetWindow <= 0 {
+		return fmt.Errorf("invalid -frontend.query-budget-window %s: must be positive", cfg.QueryBudgetWindow)
+	}
+	if cfg.QueryBudgetThrottleDelay < 0 {
+		return fmt.Errorf("invalid -frontend.query-budget-throttle-delay %s: must not be negative, 0 to disable", cfg.QueryBudgetThrottleDelay)
+	}
+	if cfg.QuerierDisconnectRetries < 0 {
+		return fmt.Errorf("invalid -frontend.querier-disconnect-retries %d: must not be negative, 0 to disable", cfg.QuerierDisconnectRetries)
+	}
+	if cfg.QuerierBusyPeriod < 0 {
+		return fmt.Errorf("invalid -frontend.querier-busy-period %s: must not be negative, 0 to disable", cfg.QuerierBusyPeriod)
+	}
+	if cfg.LivenessTimeout <= heartbeatPeriod {
+		return fmt.Errorf("invalid -frontend.liveness-timeout %s: must be longer than the %s heartbeat period", cfg.LivenessTimeout, heartbeatPeriod)
+	}
+	return cfg.QueueWebhook.Validate()
 }
 
+// Limits of the tenants. The limits of a query spanning multiple tenants are the strictest
+// of the limits of its tenants.
 type Limits interface {
-	// Returns max queriers to use per tenant, or 0 if shuffle sharding is disabled.
-	MaxQueriersPerUser(user string) int
+	// Returns max queriers to use per tenant, the fraction of the available queriers if lower than 1,
+	// or 0 if shuffle sharding is disabled.
+	MaxQueriersPerUser(user string) float64
+
+	// Returns min queriers to use per tenant, when the max queriers is a fraction of the available queriers.
+	MinQueriersPerUser(user string) int
+
+	// Returns the maximum time a query can run once forwarded to a querier, or 0 if unbounded.
+	QueryTimeout(user string) time.Duration
+
+	// Returns the rate of queries allowed for the tenant, or 0 if unlimited.
+	QueryRate(user string) rate.Limit
+
+	// Returns the burst of queries allowed for the tenant, on top of the rate.
+	QueryBurst(user string) int
+
+	// Returns the max number of queries of the tenant executed by queriers at the same time, or 0 if unlimited.
+	MaxConcurrentQueries(user string) int
+
+	// Returns the downstream URL the queries of the tenant are forwarded to, or an empty string
+	// to handle them as the queries of the other tenants.
+	DownstreamURL(user string) string
+
+	// Returns the querier-seconds the tenant can use per budget window before its queries are
+	// delayed, or 0 if unlimited.
+	QueryBudget(user string) float64
+
+	// Returns the share of the queriers the tenant gets relative to the other tenants with queued
+	// queries, 1 by default.
+	QueryWeight(user string) float64
 }
 
 // Frontend queues HTTP requests, dispatches them to backends, and handles retries
@@ -47,34 +133,89 @@ type Frontend struct {
 
 	mtx    sync.Mutex
 	cond   *sync.Cond // Notified when request is enqueued or dequeued, or querier is disconnected.
-	queues *queues
+	queues requestQueues
 
 	connectedClients *atomic.Int32
 
+	// Exponentially weighted moving average of the time spent by requests in the queue,
+	// used to hint clients when to retry rejected requests. Protected by mtx.
+	avgQueueDuration time.Duration
+
+	// Requests per tenant dispatched to queriers and not completed yet. Protected by mtx.
+	inflightQueries map[string]map[*request]struct{}
+
+	// Tenants whose queries are refused until the given time, after their outstanding
+	// queries have been cancelled. Protected by mtx.
+	cancelledTenants map[string]time.Time
+
+	// Querier-seconds used by each tenant in the current budget window.
+	costsMtx    sync.Mutex
+	tenantCosts map[string]*tenantCost
+
+	// Number of queued requests, all tenants included. Protected by mtx.
+	queuedRequests int
+
+	// Nil if the queue webhook is disabled.
+	queueWebhook *queueWebhook
+
+	// Unix nanoseconds of the last heartbeat, and the channel stopping them.
+	lastHeartbeat atomic.Int64
+	stopHeartbeat chan struct{}
+
 	// Metrics.
 	numClients    prometheus.GaugeFunc
+	busyQueriers  prometheus.GaugeFunc
+	querierLoad   *prometheus.GaugeVec
 	queueDuration prometheus.Histogram
 	queueLength   *prometheus.GaugeVec
+	tenantLabeler *tenantLabeler
+
+	querierSeconds     *prometheus.CounterVec
+	fetchedSeries      *prometheus.CounterVec
+	fetchedSamples     *prometheus.CounterVec
+	throttledQueries   *prometheus.CounterVec
+	querierDisconnects prometheus.Counter
+	requeuedRequests   prometheus.Counter
 }
 
 type request struct {
+	userID      string
 	enqueueTime time.Time
 	queueSpan   opentracing.Span
 	originalCtx context.Context
 
+	// Cancels originalCtx. The request is flagged when cancelled by an operator,
+	// so that the client gets a meaningful error.
+	cancel    context.CancelFunc
+	cancelled atomic.Bool
+
 	request  *httpgrpc.HTTPRequest
 	err      chan error
 	response chan *httpgrpc.HTTPResponse
+
+	// Number of times the request has been queued again after the querier executing it disconnected.
+	retries int
+	// Querier the request has been dispatched to.
+	querierID string
 }
 
 // New creates a new frontend.
 func New(cfg Config, limits Limits, log log.Logger, registerer prometheus.Registerer) (*Frontend, error) {
+	return newFrontend(cfg, newUserQueues(cfg.MaxOutstandingPerTenant), limits, log, registerer)
+}
+
+// newFrontend creates a new frontend queueing the requests in the queues.
+func newFrontend(cfg Config, queues requestQueues, limits Limits, log log.Logger, registerer prometheus.Registerer) (*Frontend, error) {
 	connectedClients := atomic.NewInt32(0)
 	f := &Frontend{
-		cfg:    cfg,
-		log:    log,
-		limits: limits,
-		queues: newUserQueues(cfg.MaxOutstandingPerTenant),
+		cfg:              cfg,
+		log:              log,
+		limits:           limits,
+		queues:           queues,
+		tenantLabeler:    newTenantLabeler(cfg.TenantLabels),
+		inflightQueries:  map[string]map[*request]struct{}{},
+		cancelledTenants: map[string]time.Time{},
+		tenantCosts:      map[string]*tenantCost{},
 		queueDuration: promauto.With(registerer).NewHistogram(prometheus.HistogramOpts{
 			Namespace: "cortex",
 			Name:      "query_frontend_queue_duration_seconds",
@@ -86,20 +227,77 @@ func New(cfg Config, limits Limits, log log.Logger, registerer prometheus.Regist
 			Name:      "query_frontend_queue_length",
 			Help:      "Number of queries in the queue.",
 		}, []string{"user"}),
+		querierSeconds: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_querier_seconds_total",
+			Help:      "Total time spent by queriers executing the queries of the tenant, as reported by queriers.",
+		}, []string{"user"}),
+		fetchedSeries: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_querier_fetched_series_total",
+			Help:      "Total number of series fetched by queriers executing the queries of the tenant, as reported by queriers.",
+		}, []string{"user"}),
+		fetchedSamples: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_querier_fetched_samples_total",
+			Help:      "Total number of samples fetched by queriers executing the queries of the tenant, as reported by queriers.",
+		}, []string{"user"}),
+		throttledQueries: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_throttled_queries_total",
+			Help:      "Total number of queries delayed because the tenant used its query budget.",
+		}, []string{"user"}),
+		querierDisconnects: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_querier_disconnects_total",
+			Help:      "Total number of queriers which disconnected while executing a query.",
+		}),
+		requeuedRequests: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_requeued_requests_total",
+			Help:      "Total number of queries queued again after the querier executing them disconnected.",
+		}),
+		querierLoad: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_querier_inflight_requests",
+			Help:      "Number of requests dispatched to the connected querier and not completed yet.",
+		}, []string{"querier"}),
 		numClients: promauto.With(registerer).NewGaugeFunc(prometheus.GaugeOpts{
 			Namespace: "cortex",
 			Name:      "query_frontend_connected_clients",
 			Help:      "Number of worker clients currently connected to the frontend.",
 		}, func() float64 { return float64(connectedClients.Load()) }),
 		connectedClients: connectedClients,
+		stopHeartbeat:    make(chan struct{}),
 	}
 	f.cond = sync.NewCond(&f.mtx)
+	f.busyQueriers = promauto.With(registerer).NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "cortex",
+		Name:      "query_frontend_busy_queriers",
+		Help:      "Number of connected queriers currently signaling they're busy.",
+	}, func() float64 {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		return float64(f.queues.busyQueriersCount(time.Now()))
+	})
+
+	if cfg.QueueWebhook.URL != "" {
+		f.queueWebhook = newQueueWebhook(cfg.QueueWebhook, log, registerer)
+	}
+
+	f.lastHeartbeat.Store(time.Now().UnixNano())
+	go f.heartbeatLoop()
 
 	return f, nil
 }
 
 // Close stops new requests and errors out any pending requests.
 func (f *Frontend) Close() {
+	close(f.stopHeartbeat)
+	if f.queueWebhook != nil {
+		defer f.queueWebhook.stop()
+	}
+
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 	for f.queues.len() > 0 {
@@ -118,6 +316,19 @@ func (c *httpgrpcHeadersCarrier) Set(key, val string) {
 
 // RoundTripGRPC round trips a proto (instead of a HTTP request).
 func (f *Frontend) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The deadline is sent to the querier along with the request, so that it
+	// stops working on it once it expires.
+	if timeout := validation.SmallestPositiveDurationPerTenant(tenantIDs, f.limits.QueryTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	// Propagate trace context in gRPC too - this will be ignored if using HTTP.
 	tracer, span := opentracing.GlobalTracer(), opentracing.SpanFromContext(ctx)
 	if tracer != nil && span != nil {
@@ -125,9 +336,13 @@ func (f *Frontend) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTPRequest)
 		tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier)
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	request := request{
 		request:     req,
 		originalCtx: ctx,
+		cancel:      cancel,
 
 		// Buffer of 1 to ensure response can be written by the server side
 		// of the Process stream, even if this goroutine goes away due to
@@ -136,12 +351,19 @@ func (f *Frontend) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTPRequest)
 		response: make(chan *httpgrpc.HTTPResponse, 1),
 	}
 
+	if err := f.throttleOverBudget(ctx, tenantIDs); err != nil {
+		return nil, err
+	}
+
 	if err := f.queueRequest(ctx, &request); err != nil {
 		return nil, err
 	}
 
 	select {
 	case <-ctx.Done():
+		if request.cancelled.Load() {
+			return nil, errCancelledByOperator
+		}
 		return nil, ctx.Err()
 
 	case resp := <-request.response:
@@ -154,12 +376,12 @@ func (f *Frontend) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTPRequest)
 
 // Process allows backends to pull requests from the frontend.
 func (f *Frontend) Process(server Frontend_ProcessServer) error {
-	querierID, err := getQuerierID(server)
+	querierID, weight, err := getQuerierID(server)
 	if err != nil {
 		return err
 	}
 
-	f.registerQuerierConnection(querierID)
+	f.registerQuerierConnection(querierID, weight)
 	defer f.unregisterQuerierConnection(querierID)
 
 	// If the downstream request(from querier -> frontend) is cancelled,
@@ -170,23 +392,27 @@ func (f *Frontend) Process(server Frontend_ProcessServer) error {
 		f.cond.Broadcast()
 	}()
 
-	lastUserIndex := -1
-
 	for {
-		req, idx, err := f.getNextRequestForQuerier(server.Context(), lastUserIndex, querierID)
+		req, err := f.getNextRequestForQuerier(server.Context(), querierID)
 		if err != nil {
 			return err
 		}
-		lastUserIndex = idx
 
 		// Handle the stream sending & receiving on a goroutine so we can
 		// monitoring the contexts in a select and cancel things appropriately.
-		resps := make(chan *httpgrpc.HTTPResponse, 1)
+		resps := make(chan *ClientToFrontend, 1)
 		errs := make(chan error, 1)
 		go func() {
+			var timeout time.Duration
+			if deadline, ok := req.originalCtx.Deadline(); ok {
+				timeout = time.Until(deadline)
+			}
+
 			err = server.Send(&FrontendToClient{
 				Type:        HTTP_REQUEST,
 				HttpRequest: req.request,
+				Timeout:     timeout,
+				QueryID:     extractQueryID(req.originalCtx),
 			})
 			if err != nil {
 				errs <- err
@@ -199,7 +425,7 @@ func (f *Frontend) Process(server Frontend_ProcessServer) error {
 				return
 			}
 
-			resps <- resp.HttpResponse
+			resps <- resp
 		}()
 
 		select {
@@ -207,22 +433,92 @@ func (f *Frontend) Process(server Frontend_ProcessServer) error {
 		// downstream req.  Only way we can do that is to close the stream.
 		// The worker client is expecting this semantics.
 		case <-req.originalCtx.Done():
+			f.releaseRequest(req)
 			return req.originalCtx.Err()
 
 		// Is there was an error handling this request due to network IO,
 		// then error out this upstream request _and_ stream.
 		case err := <-errs:
-			req.err <- err
+			f.releaseRequest(req)
+			f.handleQuerierDisconnect(req, querierID, err)
 			return err
 
 		// Happy path: propagate the response.
 		case resp := <-resps:
-			req.response <- resp
+			f.recordQuerierBusy(querierID, resp.Busy)
+			f.releaseRequest(req)
+			f.recordQueryCost(req, resp.Stats)
+			req.response <- resp.HttpResponse
+		}
+	}
+}
+
+// handleQuerierDisconnect queues the request again, so that it's executed by another querier, if it's
+// read-only and hasn't been retried too many times already. Otherwise, the request fails with the error.
+// The cancelled requests are never queued again: the queued requests are only dispatched if they aren't
+// cancelled in the meantime.
+func (f *Frontend) handleQuerierDisconnect(req *request, querierID string, err error) {
+	// The stream may also fail because the request has been cancelled, concurrently.
+	if req.originalCtx.Err() != nil {
+		req.err <- err
+		return
+	}
+	f.querierDisconnects.Inc()
+
+	retry := req.retries < f.cfg.QuerierDisconnectRetries && isReadOnlyRequest(req.request)
+	level.Warn(f.log).Log("msg", "querier disconnected while executing a query", "querier", querierID, "user", req.userID, "method", req.request.Method, "url", req.request.Url, "retries", req.retries, "requeued", retry, "err", err)
+	if !retry {
+		req.err <- err
+		return
+	}
+
+	req.retries++
+	if err := f.queueRequest(req.originalCtx, req); err != nil {
+		req.err <- err
+		return
+	}
+	f.requeuedRequests.Inc()
+}
+
+// recordQuerierBusy flags the querier as busy for the configured period when it signals it along its
+// response, or clears the flag as soon as it doesn't anymore.
+func (f *Frontend) recordQuerierBusy(querierID string, busy bool) {
+	if f.cfg.QuerierBusyPeriod <= 0 {
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var until time.Time
+	if busy {
+		until = time.Now().Add(f.cfg.QuerierBusyPeriod)
+	}
+	f.queues.setQuerierBusy(querierID, until)
+}
+
+// isReadOnlyRequest returns whether the request can be executed again without side effects: the queries are
+// also sent with POST, when they're too long for the URL.
+func isReadOnlyRequest(req *httpgrpc.HTTPRequest) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		path := req.Url
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+		for _, suffix := range []string{"/api/v1/query", "/api/v1/query_range", "/api/v1/series", "/api/v1/labels", "/api/v1/query_exemplars"} {
+			if strings.HasSuffix(path, suffix) {
+				return true
+			}
 		}
 	}
+	return false
 }
 
-func getQuerierID(server Frontend_ProcessServer) (string, error) {
+// getQuerierID returns the ID and the weight of the querier.
+func getQuerierID(server Frontend_ProcessServer) (string, int, error) {
 	err := server.Send(&FrontendToClient{
 		Type: GET_ID,
 		// Old queriers don't support GET_ID, and will try to use the request.
@@ -234,7 +530,7 @@ func getQuerierID(server Frontend_ProcessServer) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	resp, err := server.Recv()
@@ -242,24 +538,50 @@ func getQuerierID(server Frontend_ProcessServer) (string, error) {
 	// Old queriers will return empty string, which is fine. All old queriers will be
 	// treated as single querier with lot of connections.
 	// (Note: if resp is nil, GetClientID() returns "")
-	return resp.GetClientID(), err
+	return resp.GetClientID(), int(resp.GetWeight()), err
 }
 
 func (f *Frontend) queueRequest(ctx context.Context, req *request) error {
-	userID, err := user.ExtractOrgID(ctx)
+	tenantIDs, err := tenant.TenantIDs(ctx)
 	if err != nil {
 		return err
 	}
 
+	// Queries spanning multiple tenants are queued separately from the queries of each tenant.
+	userID := tenant.JoinTenantIDs(tenantIDs)
+
+	req.userID = userID
 	req.enqueueTime = time.Now()
 	req.queueSpan, _ = opentracing.StartSpanFromContext(ctx, "queued")
 
-	maxQueriers := f.limits.MaxQueriersPerUser(userID)
+	maxQueriers := validation.SmallestPositiveFloat64PerTenant(tenantIDs, f.limits.MaxQueriersPerUser)
+	minQueriers := validation.SmallestPositiveIntPerTenant(tenantIDs, f.limits.MinQueriersPerUser)
+	weight := validation.SmallestPositiveFloat64PerTenant(tenantIDs, f.limits.QueryWeight)
 
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
-	queue := f.queues.getOrAddQueue(userID, maxQueriers)
+	// A cancelled request would be dequeued and dropped anyway.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, tenantID := range tenantIDs {
+		until, ok := f.cancelledTenants[tenantID]
+		if !ok {
+			continue
+		}
+		if remaining := time.Until(until); remaining > 0 {
+			return httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+				Code:    http.StatusServiceUnavailable,
+				Body:    []byte("queries of the tenant are temporarily refused after being cancelled by an operator"),
+				Headers: []*httpgrpc.Header{{Key: retryAfterHeader, Values: []string{formatRetryAfter(remaining)}}},
+			})
+		}
+		delete(f.cancelledTenants, tenantID)
+	}
+
+	queue := f.queues.getOrAddQueue(userID, maxQueriers, minQueriers, weight)
 	if queue == nil {
 		// This can only happen if userID is "".
 		return errors.New("no queue found")
@@ -267,20 +589,48 @@ func (f *Frontend) queueRequest(ctx context.Context, req *request) error {
 
 	select {
 	case queue <- req:
-		f.queueLength.WithLabelValues(userID).Inc()
+		f.queueLengthChanged(userID, 1, len(queue))
 		f.cond.Broadcast()
 		return nil
 	default:
+		return f.tooManyRequestsError()
+	}
+}
+
+// queueLengthChanged tracks a request queued or dequeued, given the new length of the queue of the
+// tenant. Must be called with mtx held.
+func (f *Frontend) queueLengthChanged(userID string, delta, queueLength int) {
+	f.queuedRequests += delta
+	f.queueLength.WithLabelValues(f.tenantLabeler.label(userID)).Add(float64(delta))
+	if f.queueWebhook != nil {
+		f.queueWebhook.observe(userID, queueLength, f.queuedRequests)
+	}
+}
+
+// tooManyRequestsError returns the error for a request rejected because the queue is full. If the
+// queue has been drained before, the error hints the client to retry once the time requests currently
+// spend in the queue has passed. Must be called with mtx held.
+func (f *Frontend) tooManyRequestsError() error {
+	if f.avgQueueDuration <= 0 {
 		return errTooManyRequest
 	}
+
+	return httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+		Code:    http.StatusTooManyRequests,
+		Body:    []byte("too many outstanding requests"),
+		Headers: []*httpgrpc.Header{{Key: retryAfterHeader, Values: []string{formatRetryAfter(f.avgQueueDuration)}}},
+	})
 }
 
-// getQueue picks a random queue and takes the next unexpired request off of it, so we
-// fairly process users queries.  Will block if there are no requests.
-func (f *Frontend) getNextRequestForQuerier(ctx context.Context, lastUserIndex int, querierID string) (*request, int, error) {
+// getNextRequestForQuerier takes the next unexpired request off the queue of the next user the querier
+// should handle, so that the users get their fair share of the queriers. Will block if there are no requests.
+func (f *Frontend) getNextRequestForQuerier(ctx context.Context, querierID string) (*request, error) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
+	f.queues.addWaitingQuerier(querierID)
+	defer f.queues.removeWaitingQuerier(querierID)
+
 	querierWait := false
 
 FindQueue:
@@ -291,18 +641,18 @@ FindQueue:
 	}
 
 	if err := ctx.Err(); err != nil {
-		return nil, lastUserIndex, err
+		return nil, err
 	}
 
 	for {
-		queue, userID, idx := f.queues.getNextQueueForQuerier(lastUserIndex, querierID)
-		lastUserIndex = idx
+		// Leave the requests in the queue until one of the tenant's queries completes.
+		queue, userID := f.queues.getNextQueueForQuerier(querierID, f.maxConcurrentQueriesReached)
 		if queue == nil {
 			break
 		}
+
 		/*
 		  We want to dequeue the next unexpired request from the chosen tenant queue.
-		  The chance of choosing a particular tenant for dequeueing is (1/active_tenants).
 		  This is problematic under load, especially with other middleware enabled such as
 		  querier.split-by-interval, where one request may fan out into many.
 		  If expired requests aren't exhausted before checking another tenant, it would take
@@ -310,6 +660,7 @@ FindQueue:
 		  before an active request was handled for the tenant in question.
 		  If this tenant meanwhile continued to queue requests,
 		  it's possible that it's own queue would perpetually contain only expired requests.
+		  The expired requests don't cost the tenant any of its share of the queriers.
 		*/
 
 		// Pick the first non-expired request from this user's queue (if any).
@@ -324,13 +675,17 @@ FindQueue:
 			// Tell close() we've processed a request.
 			f.cond.Broadcast()
 
-			f.queueDuration.Observe(time.Since(request.enqueueTime).Seconds())
-			f.queueLength.WithLabelValues(userID).Dec()
+			queueDuration := time.Since(request.enqueueTime)
+			f.queueDuration.Observe(queueDuration.Seconds())
+			f.avgQueueDuration = time.Duration(queueDurationDecay*float64(queueDuration) + (1-queueDurationDecay)*float64(f.avgQueueDuration))
+			f.queueLengthChanged(userID, -1, len(queue))
 			request.queueSpan.Finish()
 
 			// Ensure the request has not already expired.
 			if request.originalCtx.Err() == nil {
-				return request, lastUserIndex, nil
+				f.queues.dispatched(userID)
+				f.trackInflightRequest(request, querierID)
+				return request, nil
 			}
 
 			// Stop iterating on this queue if we've just consumed the last request.
@@ -346,26 +701,151 @@ FindQueue:
 	goto FindQueue
 }
 
+// maxConcurrentQueriesReached returns whether the queue has as many queries executed by queriers as
+// allowed. Must be called with mtx held.
+func (f *Frontend) maxConcurrentQueriesReached(queueID string) bool {
+	max := f.maxConcurrentQueries(queueID)
+	return max > 0 && len(f.inflightQueries[queueID]) >= max
+}
+
+// maxConcurrentQueries returns the max number of queries of the queue executed by queriers at the same time.
+func (f *Frontend) maxConcurrentQueries(queueID string) int {
+	tenantIDs, err := tenant.TenantIDsFromOrgID(queueID)
+	if err != nil {
+		return 0
+	}
+	return validation.SmallestPositiveIntPerTenant(tenantIDs, f.limits.MaxConcurrentQueries)
+}
+
+// trackInflightRequest tracks a request dispatched to a querier. Must be called with mtx held.
+func (f *Frontend) trackInflightRequest(req *request, querierID string) {
+	if f.inflightQueries[req.userID] == nil {
+		f.inflightQueries[req.userID] = map[*request]struct{}{}
+	}
+	f.inflightQueries[req.userID][req] = struct{}{}
+
+	req.querierID = querierID
+	f.queues.startQuerierRequest(querierID)
+	f.updateQuerierLoad(querierID)
+}
+
+// releaseRequest tracks the completion of a request dispatched to a querier, so that more
+// requests of the same tenant can be dispatched.
+func (f *Frontend) releaseRequest(req *request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	delete(f.inflightQueries[req.userID], req)
+	if len(f.inflightQueries[req.userID]) == 0 {
+		delete(f.inflightQueries, req.userID)
+	}
+	f.queues.finishQuerierRequest(req.querierID)
+	f.updateQuerierLoad(req.querierID)
+	f.cond.Broadcast()
+}
+
+// updateQuerierLoad updates the in-flight requests of the querier, which is removed from the metric
+// once it's disconnected and its requests are completed. Must be called with mtx held.
+func (f *Frontend) updateQuerierLoad(querierID string) {
+	inflight := f.queues.inflightRequests(querierID)
+	if inflight == 0 && f.queues.connections(querierID) == 0 {
+		f.querierLoad.DeleteLabelValues(querierID)
+		return
+	}
+	f.querierLoad.WithLabelValues(querierID).Set(float64(inflight))
+}
+
+// CancelTenant cancels the queued and in-flight queries of the tenant, including the ones spanning
+// multiple tenants, and refuses its new queries for the configured duration. It returns the number
+// of queued and in-flight queries cancelled.
+func (f *Frontend) CancelTenant(userID string) (queued, inflight int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.cfg.CancelledTenantBlockDuration > 0 {
+		f.cancelledTenants[userID] = time.Now().Add(f.cfg.CancelledTenantBlockDuration)
+	}
+
+	// Queued requests are removed from the queue, so that queriers don't pick them up.
+	for _, queueID := range f.queues.queueIDs() {
+		if !includesTenant(queueID, userID) {
+			continue
+		}
+		queue := f.queues.getQueue(queueID)
+		for len(queue) > 0 {
+			req := <-queue
+			f.queueLengthChanged(queueID, -1, len(queue))
+			req.queueSpan.Finish()
+			cancelRequest(req)
+			queued++
+		}
+		f.queues.deleteQueue(queueID)
+	}
+
+	// In-flight requests are released by Process, which closes the stream to the querier
+	// once their context is cancelled.
+	for queueID, reqs := range f.inflightQueries {
+		if !includesTenant(queueID, userID) {
+			continue
+		}
+		for req := range reqs {
+			cancelRequest(req)
+			inflight++
+		}
+	}
+
+	// Tell close() we've processed requests.
+	f.cond.Broadcast()
+	return queued, inflight
+}
+
+// includesTenant returns whether the queries of the queue include the tenant.
+func includesTenant(queueID, tenantID string) bool {
+	tenantIDs, err := tenant.TenantIDsFromOrgID(queueID)
+	if err != nil {
+		return false
+	}
+	for _, id := range tenantIDs {
+		if id == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+func cancelRequest(req *request) {
+	req.cancelled.Store(true)
+	if req.cancel != nil {
+		req.cancel()
+	}
+}
+
 // CheckReady determines if the query frontend is ready.  Function parameters/return
 // chosen to match the same method in the ingester
 func (f *Frontend) CheckReady(_ context.Context) error {
-	// if we have more than one querier connected we will consider ourselves ready
+	minConnectedClients := f.cfg.MinConnectedClients
+	if minConnectedClients < 1 {
+		minConnectedClients = 1
+	}
+
+	// if we have enough queriers connected we will consider ourselves ready
 	connectedClients := f.connectedClients.Load()
-	if connectedClients > 0 {
+	if connectedClients >= int32(minConnectedClients) {
 		return nil
 	}
 
-	msg := fmt.Sprintf("not ready: number of queriers connected to query-frontend is %d", connectedClients)
+	msg := fmt.Sprintf("not ready: number of queriers connected to query-frontend is %d, minimum required is %d", connectedClients, minConnectedClients)
 	level.Info(f.log).Log("msg", msg)
 	return errors.New(msg)
 }
 
-func (f *Frontend) registerQuerierConnection(querier string) {
+func (f *Frontend) registerQuerierConnection(querier string, weight int) {
 	f.connectedClients.Inc()
 
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
-	f.queues.addQuerierConnection(querier)
+	f.queues.addQuerierConnection(querier, weight)
+	f.updateQuerierLoad(querier)
 }
 
 func (f *Frontend) unregisterQuerierConnection(querier string) {
@@ -374,4 +854,5 @@ func (f *Frontend) unregisterQuerierConnection(querier string) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 	f.queues.removeQuerierConnection(querier)
+	f.updateQuerierLoad(querier)
 }
@@ -8,6 +8,8 @@ import (
 	fmt "fmt"
 	_ "github.com/gogo/protobuf/gogoproto"
 	proto "github.com/gogo/protobuf/proto"
+	github_com_gogo_protobuf_types "github.com/gogo/protobuf/types"
+	_ "github.com/golang/protobuf/ptypes/duration"
 	httpgrpc "github.com/weaveworks/common/httpgrpc"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
@@ -18,12 +20,14 @@ import (
 	reflect "reflect"
 	strconv "strconv"
 	strings "strings"
+	time "time"
 )
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
 var _ = fmt.Errorf
 var _ = math.Inf
+var _ = time.Kitchen
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the proto package it is being compiled against.
@@ -55,6 +59,10 @@ func (Type) EnumDescriptor() ([]byte, []int) {
 type FrontendToClient struct {
 	HttpRequest *httpgrpc.HTTPRequest `protobuf:"bytes,1,opt,name=httpRequest,proto3" json:"httpRequest,omitempty"`
 	Type        Type                  `protobuf:"varint,2,opt,name=type,proto3,enum=frontend.Type" json:"type,omitempty"`
+	// Time left to the querier to execute the request, or 0 if not bounded.
+	Timeout time.Duration `protobuf:"bytes,3,opt,name=timeout,proto3,stdduration" json:"timeout"`
+	// ID correlating the logs of the query-frontend and the querier for the request.
+	QueryID string `protobuf:"bytes,4,opt,name=queryID,proto3" json:"queryID,omitempty"`
 }
 
 func (m *FrontendToClient) Reset()      { *m = FrontendToClient{} }
@@ -103,9 +111,29 @@ func (m *FrontendToClient) GetType() Type {
 	return HTTP_REQUEST
 }
 
+func (m *FrontendToClient) GetTimeout() time.Duration {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *FrontendToClient) GetQueryID() string {
+	if m != nil {
+		return m.QueryID
+	}
+	return ""
+}
+
 type ClientToFrontend struct {
 	HttpResponse *httpgrpc.HTTPResponse `protobuf:"bytes,1,opt,name=httpResponse,proto3" json:"httpResponse,omitempty"`
 	ClientID     string                 `protobuf:"bytes,2,opt,name=clientID,proto3" json:"clientID,omitempty"`
+	// Resources used by the querier to execute the request. Not set by old queriers.
+	Stats *QueryStats `protobuf:"bytes,3,opt,name=stats,proto3" json:"stats,omitempty"`
+	// Share of the requests dispatched to the querier, relative to the other queriers. Not set by old queriers.
+	Weight int32 `protobuf:"varint,4,opt,name=weight,proto3" json:"weight,omitempty"`
+	// Whether the querier is busy, signaling the frontend to dispatch fewer requests to it for a while.
+	Busy bool `protobuf:"varint,5,opt,name=busy,proto3" json:"busy,omitempty"`
 }
 
 func (m *ClientToFrontend) Reset()      { *m = ClientToFrontend{} }
@@ -154,39 +182,134 @@ func (m *ClientToFrontend) GetClientID() string {
 	return ""
 }
 
+func (m *ClientToFrontend) GetStats() *QueryStats {
+	if m != nil {
+		return m.Stats
+	}
+	return nil
+}
+
+func (m *ClientToFrontend) GetWeight() int32 {
+	if m != nil {
+		return m.Weight
+	}
+	return 0
+}
+
+func (m *ClientToFrontend) GetBusy() bool {
+	if m != nil {
+		return m.Busy
+	}
+	return false
+}
+
+type QueryStats struct {
+	// Time spent by the querier executing the request.
+	WallTime time.Duration `protobuf:"bytes,1,opt,name=wallTime,proto3,stdduration" json:"wallTime"`
+	// Number of series fetched from the storage and the ingesters.
+	FetchedSeries uint64 `protobuf:"varint,2,opt,name=fetchedSeries,proto3" json:"fetchedSeries,omitempty"`
+	// Number of samples of the fetched series read while evaluating the query.
+	FetchedSamples uint64 `protobuf:"varint,3,opt,name=fetchedSamples,proto3" json:"fetchedSamples,omitempty"`
+}
+
+func (m *QueryStats) Reset()      { *m = QueryStats{} }
+func (*QueryStats) ProtoMessage() {}
+func (*QueryStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_eca3873955a29cfe, []int{2}
+}
+func (m *QueryStats) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *QueryStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_QueryStats.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *QueryStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryStats.Merge(m, src)
+}
+func (m *QueryStats) XXX_Size() int {
+	return m.Size()
+}
+func (m *QueryStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryStats proto.InternalMessageInfo
+
+func (m *QueryStats) GetWallTime() time.Duration {
+	if m != nil {
+		return m.WallTime
+	}
+	return 0
+}
+
+func (m *QueryStats) GetFetchedSeries() uint64 {
+	if m != nil {
+		return m.FetchedSeries
+	}
+	return 0
+}
+
+func (m *QueryStats) GetFetchedSamples() uint64 {
+	if m != nil {
+		return m.FetchedSamples
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("frontend.Type", Type_name, Type_value)
 	proto.RegisterType((*FrontendToClient)(nil), "frontend.FrontendToClient")
 	proto.RegisterType((*ClientToFrontend)(nil), "frontend.ClientToFrontend")
+	proto.RegisterType((*QueryStats)(nil), "frontend.QueryStats")
 }
 
 func init() { proto.RegisterFile("frontend.proto", fileDescriptor_eca3873955a29cfe) }
 
 var fileDescriptor_eca3873955a29cfe = []byte{
-	// 362 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0x91, 0xb1, 0x4e, 0x2a, 0x41,
-	0x14, 0x86, 0x67, 0x6e, 0x08, 0x97, 0x3b, 0x10, 0xb2, 0x99, 0xe4, 0xde, 0x90, 0x2d, 0x26, 0x64,
-	0x73, 0x0b, 0x62, 0xe2, 0xae, 0x41, 0x13, 0x13, 0x0b, 0x0b, 0x05, 0x91, 0x0e, 0x97, 0xb1, 0xb1,
-	0x21, 0xb2, 0x0e, 0x0b, 0x2a, 0x7b, 0xd6, 0xdd, 0x41, 0x42, 0xe7, 0x23, 0xf8, 0x18, 0x3e, 0x8a,
-	0x25, 0x25, 0xa5, 0x0c, 0x8d, 0x25, 0x8f, 0x60, 0x98, 0x85, 0x15, 0xe9, 0xce, 0x9f, 0xff, 0x3f,
-	0xe7, 0x3b, 0x33, 0x87, 0x14, 0x7b, 0x11, 0x04, 0x52, 0x04, 0x77, 0x76, 0x18, 0x81, 0x04, 0x9a,
-	0xdb, 0x68, 0x73, 0xdf, 0x1f, 0xc8, 0xfe, 0xa8, 0x6b, 0x7b, 0x30, 0x74, 0x7c, 0xf0, 0xc1, 0xd1,
-	0x81, 0xee, 0xa8, 0xa7, 0x95, 0x16, 0xba, 0x4a, 0x1a, 0xcd, 0xa3, 0xad, 0xf8, 0x58, 0xdc, 0x3e,
-	0x8b, 0x31, 0x44, 0x0f, 0xb1, 0xe3, 0xc1, 0x70, 0x08, 0x81, 0xd3, 0x97, 0x32, 0xf4, 0xa3, 0xd0,
-	0x4b, 0x8b, 0xa4, 0xcb, 0x02, 0x62, 0x5c, 0xac, 0x81, 0x1c, 0xce, 0x1f, 0x07, 0x22, 0x90, 0xf4,
-	0x98, 0xe4, 0x57, 0x29, 0x57, 0x3c, 0x8d, 0x44, 0x2c, 0x4b, 0xb8, 0x8c, 0x2b, 0xf9, 0xea, 0x5f,
-	0x3b, 0xed, 0xbc, 0xe4, 0xbc, 0xb5, 0x36, 0xdd, 0xed, 0x24, 0xb5, 0x48, 0x46, 0x4e, 0x42, 0x51,
-	0xfa, 0x55, 0xc6, 0x95, 0x62, 0xb5, 0x68, 0xa7, 0x4f, 0xe3, 0x93, 0x50, 0xb8, 0xda, 0xb3, 0xee,
-	0x89, 0x91, 0x60, 0x38, 0x6c, 0xc0, 0xf4, 0x84, 0x14, 0x92, 0x31, 0x71, 0x08, 0x41, 0x2c, 0xd6,
-	0xc4, 0x7f, 0xbb, 0xc4, 0xc4, 0x75, 0x7f, 0x64, 0xa9, 0x49, 0x72, 0x9e, 0x9e, 0xd7, 0xac, 0x69,
-	0xee, 0x1f, 0x37, 0xd5, 0x7b, 0xff, 0x49, 0x66, 0x45, 0xa6, 0x06, 0x29, 0xac, 0x26, 0x74, 0xdc,
-	0xfa, 0xd5, 0x75, 0xbd, 0xcd, 0x0d, 0x44, 0x09, 0xc9, 0x36, 0xea, 0xbc, 0xd3, 0xac, 0x19, 0xb8,
-	0xda, 0x26, 0xb9, 0x74, 0x93, 0x06, 0xf9, 0xdd, 0x8a, 0xc0, 0x13, 0x71, 0x4c, 0xcd, 0xef, 0xf5,
-	0x77, 0x17, 0x36, 0xb7, 0xbc, 0xdd, 0xdf, 0xb3, 0x50, 0x05, 0x1f, 0xe0, 0xb3, 0xd3, 0xe9, 0x9c,
-	0xa1, 0xd9, 0x9c, 0xa1, 0xe5, 0x9c, 0xe1, 0x17, 0xc5, 0xf0, 0x9b, 0x62, 0xf8, 0x5d, 0x31, 0x3c,
-	0x55, 0x0c, 0x7f, 0x28, 0x86, 0x3f, 0x15, 0x43, 0x4b, 0xc5, 0xf0, 0xeb, 0x82, 0xa1, 0xe9, 0x82,
-	0xa1, 0xd9, 0x82, 0xa1, 0x9b, 0xf4, 0xf8, 0xdd, 0xac, 0x3e, 0xcf, 0xe1, 0x57, 0x00, 0x00, 0x00,
-	0xff, 0xff, 0x14, 0x4d, 0x19, 0x1d, 0x1f, 0x02, 0x00, 0x00,
+	// 536 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x93, 0x4f, 0x8f, 0xd2, 0x40,
+	0x18, 0xc6, 0x3b, 0x5a, 0xa0, 0xfb, 0xee, 0x4a, 0xc8, 0x44, 0x37, 0x95, 0xc3, 0x2c, 0x21, 0x1b,
+	0x43, 0x36, 0xb1, 0x18, 0x34, 0x31, 0x31, 0x51, 0x93, 0x15, 0x5c, 0xb9, 0xed, 0x0e, 0xf5, 0xe2,
+	0x65, 0x03, 0x65, 0x28, 0x8d, 0xb4, 0xd3, 0x6d, 0xa7, 0x12, 0x6e, 0x7e, 0x04, 0x8f, 0x26, 0x7e,
+	0x01, 0x3f, 0x87, 0x27, 0x8e, 0x1c, 0xf7, 0xa4, 0x52, 0x2e, 0x1e, 0xf7, 0x23, 0x18, 0xa6, 0x7f,
+	0x60, 0x39, 0xed, 0x6d, 0x9e, 0x79, 0x9e, 0xf7, 0xcd, 0xfb, 0x7b, 0xa7, 0x85, 0xf2, 0x28, 0xe0,
+	0x9e, 0x60, 0xde, 0xd0, 0xf0, 0x03, 0x2e, 0x38, 0xd6, 0x32, 0x5d, 0x7d, 0x6a, 0x3b, 0x62, 0x1c,
+	0x0d, 0x0c, 0x8b, 0xbb, 0x4d, 0x9b, 0xdb, 0xbc, 0x29, 0x03, 0x83, 0x68, 0x24, 0x95, 0x14, 0xf2,
+	0x94, 0x14, 0x56, 0x89, 0xcd, 0xb9, 0x3d, 0x61, 0x9b, 0xd4, 0x30, 0x0a, 0xfa, 0xc2, 0xe1, 0x5e,
+	0xea, 0xbf, 0xd8, 0x6a, 0x37, 0x65, 0xfd, 0x2f, 0x6c, 0xca, 0x83, 0xcf, 0x61, 0xd3, 0xe2, 0xae,
+	0xcb, 0xbd, 0xe6, 0x58, 0x08, 0xdf, 0x0e, 0x7c, 0x2b, 0x3f, 0x24, 0x55, 0xf5, 0x39, 0x82, 0xca,
+	0xfb, 0x74, 0x22, 0x93, 0xbf, 0x9b, 0x38, 0xcc, 0x13, 0xf8, 0x25, 0xec, 0xaf, 0x63, 0x94, 0x5d,
+	0x45, 0x2c, 0x14, 0x3a, 0xaa, 0xa1, 0xc6, 0x7e, 0xeb, 0x91, 0x91, 0x97, 0x7e, 0x30, 0xcd, 0xf3,
+	0xd4, 0xa4, 0xdb, 0x49, 0x5c, 0x07, 0x55, 0xcc, 0x7c, 0xa6, 0xdf, 0xab, 0xa1, 0x46, 0xb9, 0x55,
+	0x36, 0x72, 0x76, 0x73, 0xe6, 0x33, 0x2a, 0x3d, 0xfc, 0x1a, 0x4a, 0xc2, 0x71, 0x19, 0x8f, 0x84,
+	0x7e, 0x5f, 0x36, 0x7e, 0x6c, 0x24, 0x64, 0x46, 0x46, 0x66, 0xb4, 0x53, 0xb2, 0x53, 0x6d, 0xfe,
+	0xfb, 0x48, 0xf9, 0xfe, 0xe7, 0x08, 0xd1, 0xac, 0x06, 0xeb, 0x50, 0xba, 0x8a, 0x58, 0x30, 0xeb,
+	0xb6, 0x75, 0xb5, 0x86, 0x1a, 0x7b, 0x34, 0x93, 0xf5, 0x5f, 0x08, 0x2a, 0x09, 0x80, 0xc9, 0x33,
+	0x24, 0xfc, 0x0a, 0x0e, 0x92, 0x01, 0x43, 0x9f, 0x7b, 0x21, 0x4b, 0x59, 0x0e, 0x77, 0x59, 0x12,
+	0x97, 0xde, 0xca, 0xe2, 0x2a, 0x68, 0x96, 0xec, 0xd7, 0x6d, 0x4b, 0xa2, 0x3d, 0x9a, 0x6b, 0x7c,
+	0x02, 0x85, 0x50, 0xf4, 0x45, 0x98, 0x32, 0x3c, 0xdc, 0xa0, 0x5e, 0xac, 0xc7, 0xe9, 0xad, 0x3d,
+	0x9a, 0x44, 0xf0, 0x21, 0x14, 0xa7, 0xcc, 0xb1, 0xc7, 0x42, 0x4e, 0x5c, 0xa0, 0xa9, 0xc2, 0x18,
+	0xd4, 0x41, 0x14, 0xce, 0xf4, 0x42, 0x0d, 0x35, 0x34, 0x2a, 0xcf, 0xf5, 0x1f, 0x08, 0x60, 0xd3,
+	0x01, 0xbf, 0x05, 0x6d, 0xda, 0x9f, 0x4c, 0x4c, 0xc7, 0xcd, 0x46, 0xbf, 0xd3, 0xb6, 0xf2, 0x22,
+	0x7c, 0x0c, 0x0f, 0x46, 0x4c, 0x58, 0x63, 0x36, 0xec, 0xb1, 0xc0, 0x61, 0xa1, 0x04, 0x51, 0xe9,
+	0xed, 0x4b, 0xfc, 0x04, 0xca, 0xd9, 0x45, 0xdf, 0xf5, 0x27, 0x2c, 0xc1, 0x52, 0xe9, 0xce, 0xed,
+	0xc9, 0x31, 0xa8, 0xeb, 0x97, 0xc4, 0x15, 0x38, 0x58, 0xef, 0xed, 0x92, 0x76, 0x2e, 0x3e, 0x76,
+	0x7a, 0x66, 0x45, 0xc1, 0x00, 0xc5, 0xb3, 0x8e, 0x79, 0xd9, 0x6d, 0x57, 0x50, 0xab, 0x07, 0x5a,
+	0xbe, 0xff, 0x33, 0x28, 0x9d, 0x07, 0xdc, 0x62, 0x61, 0x88, 0xab, 0x9b, 0x1d, 0xed, 0x3e, 0x53,
+	0x75, 0xcb, 0xdb, 0xfd, 0x1a, 0xeb, 0x4a, 0x03, 0x3d, 0x43, 0xa7, 0x6f, 0x16, 0x4b, 0xa2, 0x5c,
+	0x2f, 0x89, 0x72, 0xb3, 0x24, 0xe8, 0x6b, 0x4c, 0xd0, 0xcf, 0x98, 0xa0, 0x79, 0x4c, 0xd0, 0x22,
+	0x26, 0xe8, 0x6f, 0x4c, 0xd0, 0xbf, 0x98, 0x28, 0x37, 0x31, 0x41, 0xdf, 0x56, 0x44, 0x59, 0xac,
+	0x88, 0x72, 0xbd, 0x22, 0xca, 0xa7, 0xfc, 0x6f, 0x1b, 0x14, 0xe5, 0xbe, 0x9e, 0xff, 0x1f, 0x00,
+	0x48, 0xc2, 0x7c, 0xbf, 0x90, 0x03, 0x00, 0x00,
 }
 
 func (x Type) String() string {
@@ -221,6 +344,12 @@ func (this *FrontendToClient) Equal(that interface{}) bool {
 	if this.Type != that1.Type {
 		return false
 	}
+	if this.Timeout != that1.Timeout {
+		return false
+	}
+	if this.QueryID != that1.QueryID {
+		return false
+	}
 	return true
 }
 func (this *ClientToFrontend) Equal(that interface{}) bool {
@@ -248,18 +377,59 @@ func (this *ClientToFrontend) Equal(that interface{}) bool {
 	if this.ClientID != that1.ClientID {
 		return false
 	}
+	if !this.Stats.Equal(that1.Stats) {
+		return false
+	}
+	if this.Weight != that1.Weight {
+		return false
+	}
+	if this.Busy != that1.Busy {
+		return false
+	}
+	return true
+}
+func (this *QueryStats) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*QueryStats)
+	if !ok {
+		that2, ok := that.(QueryStats)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.WallTime != that1.WallTime {
+		return false
+	}
+	if this.FetchedSeries != that1.FetchedSeries {
+		return false
+	}
+	if this.FetchedSamples != that1.FetchedSamples {
+		return false
+	}
 	return true
 }
 func (this *FrontendToClient) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 6)
+	s := make([]string, 0, 8)
 	s = append(s, "&frontend.FrontendToClient{")
 	if this.HttpRequest != nil {
 		s = append(s, "HttpRequest: "+fmt.Sprintf("%#v", this.HttpRequest)+",\n")
 	}
 	s = append(s, "Type: "+fmt.Sprintf("%#v", this.Type)+",\n")
+	s = append(s, "Timeout: "+fmt.Sprintf("%#v", this.Timeout)+",\n")
+	s = append(s, "QueryID: "+fmt.Sprintf("%#v", this.QueryID)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -267,12 +437,29 @@ func (this *ClientToFrontend) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 6)
+	s := make([]string, 0, 9)
 	s = append(s, "&frontend.ClientToFrontend{")
 	if this.HttpResponse != nil {
 		s = append(s, "HttpResponse: "+fmt.Sprintf("%#v", this.HttpResponse)+",\n")
 	}
 	s = append(s, "ClientID: "+fmt.Sprintf("%#v", this.ClientID)+",\n")
+	if this.Stats != nil {
+		s = append(s, "Stats: "+fmt.Sprintf("%#v", this.Stats)+",\n")
+	}
+	s = append(s, "Weight: "+fmt.Sprintf("%#v", this.Weight)+",\n")
+	s = append(s, "Busy: "+fmt.Sprintf("%#v", this.Busy)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *QueryStats) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 7)
+	s = append(s, "&frontend.QueryStats{")
+	s = append(s, "WallTime: "+fmt.Sprintf("%#v", this.WallTime)+",\n")
+	s = append(s, "FetchedSeries: "+fmt.Sprintf("%#v", this.FetchedSeries)+",\n")
+	s = append(s, "FetchedSamples: "+fmt.Sprintf("%#v", this.FetchedSamples)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -297,8 +484,6 @@ const _ = grpc.SupportPackageIsVersion4
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type FrontendClient interface {
-	// After calling this method, client enters a loop, in which it waits for
-	// a "FrontendToClient" message and replies with single "ClientToFrontend" message.
 	Process(ctx context.Context, opts ...grpc.CallOption) (Frontend_ProcessClient, error)
 }
 
@@ -343,8 +528,6 @@ func (x *frontendProcessClient) Recv() (*FrontendToClient, error) {
 
 // FrontendServer is the server API for Frontend service.
 type FrontendServer interface {
-	// After calling this method, client enters a loop, in which it waits for
-	// a "FrontendToClient" message and replies with single "ClientToFrontend" message.
 	Process(Frontend_ProcessServer) error
 }
 
@@ -421,6 +604,21 @@ func (m *FrontendToClient) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.QueryID) > 0 {
+		i -= len(m.QueryID)
+		copy(dAtA[i:], m.QueryID)
+		i = encodeVarintFrontend(dAtA, i, uint64(len(m.QueryID)))
+		i--
+		dAtA[i] = 0x22
+	}
+	n1, err1 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.Timeout, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.Timeout):])
+	if err1 != nil {
+		return 0, err1
+	}
+	i -= n1
+	i = encodeVarintFrontend(dAtA, i, uint64(n1))
+	i--
+	dAtA[i] = 0x1a
 	if m.Type != 0 {
 		i = encodeVarintFrontend(dAtA, i, uint64(m.Type))
 		i--
@@ -461,6 +659,33 @@ func (m *ClientToFrontend) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.Busy {
+		i--
+		if m.Busy {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.Weight != 0 {
+		i = encodeVarintFrontend(dAtA, i, uint64(m.Weight))
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.Stats != nil {
+		{
+			size, err := m.Stats.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintFrontend(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x1a
+	}
 	if len(m.ClientID) > 0 {
 		i -= len(m.ClientID)
 		copy(dAtA[i:], m.ClientID)
@@ -483,6 +708,47 @@ func (m *ClientToFrontend) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *QueryStats) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *QueryStats) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *QueryStats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.FetchedSamples != 0 {
+		i = encodeVarintFrontend(dAtA, i, uint64(m.FetchedSamples))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.FetchedSeries != 0 {
+		i = encodeVarintFrontend(dAtA, i, uint64(m.FetchedSeries))
+		i--
+		dAtA[i] = 0x10
+	}
+	n5, err5 := github_com_gogo_protobuf_types.StdDurationMarshalTo(m.WallTime, dAtA[i-github_com_gogo_protobuf_types.SizeOfStdDuration(m.WallTime):])
+	if err5 != nil {
+		return 0, err5
+	}
+	i -= n5
+	i = encodeVarintFrontend(dAtA, i, uint64(n5))
+	i--
+	dAtA[i] = 0xa
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintFrontend(dAtA []byte, offset int, v uint64) int {
 	offset -= sovFrontend(v)
 	base := offset
@@ -507,6 +773,12 @@ func (m *FrontendToClient) Size() (n int) {
 	if m.Type != 0 {
 		n += 1 + sovFrontend(uint64(m.Type))
 	}
+	l = github_com_gogo_protobuf_types.SizeOfStdDuration(m.Timeout)
+	n += 1 + l + sovFrontend(uint64(l))
+	l = len(m.QueryID)
+	if l > 0 {
+		n += 1 + l + sovFrontend(uint64(l))
+	}
 	return n
 }
 
@@ -524,6 +796,33 @@ func (m *ClientToFrontend) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovFrontend(uint64(l))
 	}
+	if m.Stats != nil {
+		l = m.Stats.Size()
+		n += 1 + l + sovFrontend(uint64(l))
+	}
+	if m.Weight != 0 {
+		n += 1 + sovFrontend(uint64(m.Weight))
+	}
+	if m.Busy {
+		n += 2
+	}
+	return n
+}
+
+func (m *QueryStats) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = github_com_gogo_protobuf_types.SizeOfStdDuration(m.WallTime)
+	n += 1 + l + sovFrontend(uint64(l))
+	if m.FetchedSeries != 0 {
+		n += 1 + sovFrontend(uint64(m.FetchedSeries))
+	}
+	if m.FetchedSamples != 0 {
+		n += 1 + sovFrontend(uint64(m.FetchedSamples))
+	}
 	return n
 }
 
@@ -540,6 +839,8 @@ func (this *FrontendToClient) String() string {
 	s := strings.Join([]string{`&FrontendToClient{`,
 		`HttpRequest:` + strings.Replace(fmt.Sprintf("%v", this.HttpRequest), "HTTPRequest", "httpgrpc.HTTPRequest", 1) + `,`,
 		`Type:` + fmt.Sprintf("%v", this.Type) + `,`,
+		`Timeout:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.Timeout), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
+		`QueryID:` + fmt.Sprintf("%v", this.QueryID) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -551,6 +852,21 @@ func (this *ClientToFrontend) String() string {
 	s := strings.Join([]string{`&ClientToFrontend{`,
 		`HttpResponse:` + strings.Replace(fmt.Sprintf("%v", this.HttpResponse), "HTTPResponse", "httpgrpc.HTTPResponse", 1) + `,`,
 		`ClientID:` + fmt.Sprintf("%v", this.ClientID) + `,`,
+		`Stats:` + strings.Replace(this.Stats.String(), "QueryStats", "QueryStats", 1) + `,`,
+		`Weight:` + fmt.Sprintf("%v", this.Weight) + `,`,
+		`Busy:` + fmt.Sprintf("%v", this.Busy) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *QueryStats) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&QueryStats{`,
+		`WallTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.WallTime), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
+		`FetchedSeries:` + fmt.Sprintf("%v", this.FetchedSeries) + `,`,
+		`FetchedSamples:` + fmt.Sprintf("%v", this.FetchedSamples) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -647,6 +963,71 @@ func (m *FrontendToClient) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timeout", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := github_com_gogo_protobuf_types.StdDurationUnmarshal(&m.Timeout, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field QueryID", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.QueryID = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFrontend(dAtA[iNdEx:])
@@ -768,6 +1149,205 @@ func (m *ClientToFrontend) Unmarshal(dAtA []byte) error {
 			}
 			m.ClientID = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Stats", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Stats == nil {
+				m.Stats = &QueryStats{}
+			}
+			if err := m.Stats.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Weight", wireType)
+			}
+			m.Weight = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Weight |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Busy", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Busy = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipFrontend(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *QueryStats) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowFrontend
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: QueryStats: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: QueryStats: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field WallTime", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthFrontend
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := github_com_gogo_protobuf_types.StdDurationUnmarshal(&m.WallTime, dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FetchedSeries", wireType)
+			}
+			m.FetchedSeries = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FetchedSeries |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FetchedSamples", wireType)
+			}
+			m.FetchedSamples = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFrontend
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FetchedSamples |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFrontend(dAtA[iNdEx:])
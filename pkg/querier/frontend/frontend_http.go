@@ -0,0 +1,229 @@
+package frontend
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const queriersTpl = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>Cortex Query Frontend Queriers</title>
+	</head>
+	<body>
+		<h1>Cortex Query Frontend Queriers</h1>
+		<p>Current time: {{ .Now }}</p>
+		<h2>Connected queriers</h2>
+		<table width="100%" border="1">
+			<thead>
+				<tr>
+					<th>Querier ID</th>
+					<th>Connections</th>
+					<th>In-flight Requests</th>
+				</tr>
+			</thead>
+			<tbody>
+				{{ range .Queriers }}
+				<tr>
+					<td>{{ .ID }}</td>
+					<td>{{ .Connections }}</td>
+					<td>{{ .InflightRequests }}</td>
+				</tr>
+				{{ end }}
+			</tbody>
+		</table>
+		<h2>Tenants</h2>
+		<p>Tenants with queued requests, and the ones requested with the <code>tenant</code> parameter.</p>
+		<table width="100%" border="1">
+			<thead>
+				<tr>
+					<th>Tenant ID</th>
+					<th>Max Queriers</th>
+					<th>Queued Requests</th>
+					<th>Assigned Queriers</th>
+				</tr>
+			</thead>
+			<tbody>
+				{{ range .Tenants }}
+				<tr>
+					<td>{{ .ID }}</td>
+					<td>{{ .MaxQueriers }}</td>
+					<td>{{ .QueuedRequests }}</td>
+					<td>{{ if .Queriers }}{{ range $i, $q := .Queriers }}{{ if $i }}, {{ end }}{{ $q }}{{ end }}{{ else }}all{{ end }}</td>
+				</tr>
+				{{ end }}
+			</tbody>
+		</table>
+	</body>
+</html>`
+
+var queriersTmpl *template.Template
+
+func init() {
+	queriersTmpl = template.Must(template.New("queriers").Parse(queriersTpl))
+}
+
+type querierStatus struct {
+	ID               string `json:"id"`
+	Connections      int    `json:"connections"`
+	InflightRequests int    `json:"inflightRequests"`
+}
+
+type tenantStatus struct {
+	ID             string  `json:"id"`
+	MaxQueriers    float64 `json:"maxQueriers"`
+	QueuedRequests int     `json:"queuedRequests"`
+
+	// Queriers handling the requests of the tenant, or empty if all queriers handle them.
+	Queriers []string `json:"queriers"`
+}
+
+// QueriersHandler shows the connected queriers, and the queriers assigned to each tenant by shuffle sharding.
+// The tenants shown are the ones with queued requests, plus the ones passed with the "tenant" parameter.
+func (f *Frontend) QueriersHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mtx.Lock()
+
+	connectedQueriers := f.queues.connectedQueriers()
+	queriers := make([]querierStatus, 0, len(connectedQueriers))
+	for _, id := range connectedQueriers {
+		queriers = append(queriers, querierStatus{ID: id, Connections: f.queues.connections(id), InflightRequests: f.queues.inflightRequests(id)})
+	}
+
+	tenantIDs := map[string]struct{}{}
+	for _, userID := range f.queues.queueIDs() {
+		tenantIDs[userID] = struct{}{}
+	}
+	for _, userID := range r.Form["tenant"] {
+		if userID != "" {
+			tenantIDs[userID] = struct{}{}
+		}
+	}
+
+	tenants := make([]tenantStatus, 0, len(tenantIDs))
+	for userID := range tenantIDs {
+		status := tenantStatus{ID: userID, MaxQueriers: f.limits.MaxQueriersPerUser(userID)}
+		status.QueuedRequests = len(f.queues.getQueue(userID))
+
+		// The assignment is computed the same way as for the queues, so that it's shown
+		// even for tenants without queued requests.
+		selected := shuffleQueriersForUser(util.ShuffleShardSeed(userID, ""), queriersToSelect(status.MaxQueriers, f.limits.MinQueriersPerUser(userID), len(connectedQueriers)), connectedQueriers, nil)
+		for id := range selected {
+			status.Queriers = append(status.Queriers, id)
+		}
+		sort.Strings(status.Queriers)
+
+		tenants = append(tenants, status)
+	}
+
+	f.mtx.Unlock()
+
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+
+	util.RenderHTTPResponse(w, struct {
+		Now      time.Time       `json:"now"`
+		Queriers []querierStatus `json:"queriers"`
+		Tenants  []tenantStatus  `json:"tenants"`
+	}{
+		Now:      time.Now(),
+		Queriers: queriers,
+		Tenants:  tenants,
+	}, queriersTmpl, r)
+}
+
+type tenantQueueStatus struct {
+	ID         string `json:"id"`
+	QueueDepth int    `json:"queueDepth"`
+
+	// Age of the oldest request waiting in the queue, in seconds.
+	OldestRequestAge float64 `json:"oldestRequestAge"`
+}
+
+// QueueHandler returns the state of the queues as JSON: the connected queriers and, for each tenant
+// with queued requests, the number of queued requests and how long the oldest one has been waiting.
+func (f *Frontend) QueueHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	f.mtx.Lock()
+
+	connectedQueriers := len(f.queues.connectedQueriers())
+	queueIDs := f.queues.queueIDs()
+	tenants := make([]tenantQueueStatus, 0, len(queueIDs))
+	for _, userID := range queueIDs {
+		queue := f.queues.getQueue(userID)
+		status := tenantQueueStatus{ID: userID, QueueDepth: len(queue)}
+		if oldest := oldestRequest(queue); oldest != nil {
+			status.OldestRequestAge = now.Sub(oldest.enqueueTime).Seconds()
+		}
+		tenants = append(tenants, status)
+	}
+
+	f.mtx.Unlock()
+
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].ID < tenants[j].ID
+	})
+
+	util.WriteJSONResponse(w, struct {
+		ConnectedQueriers  int                 `json:"connectedQueriers"`
+		QuerierConnections int32               `json:"querierConnections"`
+		Tenants            []tenantQueueStatus `json:"tenants"`
+	}{
+		ConnectedQueriers:  connectedQueriers,
+		QuerierConnections: f.connectedClients.Load(),
+		Tenants:            tenants,
+	})
+}
+
+// oldestRequest returns the request at the front of the queue, leaving the queue unchanged.
+// The requests are taken off the queue and put back in the same order, so this must be
+// called with mtx held.
+func oldestRequest(queue chan *request) *request {
+	var oldest *request
+	for i, n := 0, len(queue); i < n; i++ {
+		req := <-queue
+		if i == 0 {
+			oldest = req
+		}
+		queue <- req
+	}
+	return oldest
+}
+
+// CancelTenantHandler cancels the queued and in-flight queries of the tenant passed in the path,
+// and temporarily refuses its new queries.
+func (f *Frontend) CancelTenantHandler(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+	if userID == "" {
+		http.Error(w, "missing tenant ID", http.StatusBadRequest)
+		return
+	}
+
+	queued, inflight := f.CancelTenant(userID)
+	level.Info(f.log).Log("msg", "cancelled queries of tenant", "user", userID, "queued", queued, "inflight", inflight, "blocked_for", f.cfg.CancelledTenantBlockDuration)
+
+	util.WriteJSONResponse(w, struct {
+		QueuedQueries   int    `json:"queuedQueries"`
+		InflightQueries int    `json:"inflightQueries"`
+		BlockedFor      string `json:"blockedFor"`
+	}{
+		QueuedQueries:   queued,
+		InflightQueries: inflight,
+		BlockedFor:      f.cfg.CancelledTenantBlockDuration.String(),
+	})
+}
@@ -0,0 +1,167 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestFrontend_QueriersHandler(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	f, err := New(config, limits{queriers: 2}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		f.registerQuerierConnection(fmt.Sprintf("querier-%d", i), 1)
+	}
+	f.registerQuerierConnection("querier-0", 1)
+
+	ctx := user.InjectOrgID(context.Background(), "queued")
+	require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+
+	req := httptest.NewRequest("GET", "/frontend/queriers?tenant=idle", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	f.QueriersHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Queriers []querierStatus `json:"queriers"`
+		Tenants  []tenantStatus  `json:"tenants"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	assert.Equal(t, []querierStatus{
+		{ID: "querier-0", Connections: 2},
+		{ID: "querier-1", Connections: 1},
+		{ID: "querier-2", Connections: 1},
+		{ID: "querier-3", Connections: 1},
+	}, resp.Queriers)
+
+	require.Len(t, resp.Tenants, 2)
+	assert.Equal(t, "idle", resp.Tenants[0].ID)
+	assert.Equal(t, 0, resp.Tenants[0].QueuedRequests)
+	assert.Equal(t, "queued", resp.Tenants[1].ID)
+	assert.Equal(t, 1, resp.Tenants[1].QueuedRequests)
+
+	for _, tenant := range resp.Tenants {
+		assert.Equal(t, float64(2), tenant.MaxQueriers)
+		assert.Len(t, tenant.Queriers, 2)
+	}
+
+	// The assignment shown matches the one used by the queues.
+	queued := f.queues.(*queues).userQueues["queued"].queriers
+	assert.Len(t, queued, 2)
+	for _, id := range resp.Tenants[1].Queriers {
+		assert.Contains(t, queued, id)
+	}
+}
+
+func TestFrontend_QueueHandler(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	f, err := New(config, limits{queriers: 3}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	f.registerQuerierConnection("querier-0", 1)
+	f.registerQuerierConnection("querier-1", 1)
+
+	ctx1 := user.InjectOrgID(context.Background(), "1")
+	ctx2 := user.InjectOrgID(context.Background(), "2")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, f.queueRequest(ctx1, testReq(ctx1)))
+	}
+	require.NoError(t, f.queueRequest(ctx2, testReq(ctx2)))
+
+	// Make the first request of tenant 1 look older.
+	first := oldestRequest(f.queues.getQueue("1"))
+	first.enqueueTime = time.Now().Add(-time.Minute)
+
+	rec := httptest.NewRecorder()
+	f.QueueHandler(rec, httptest.NewRequest("GET", "/frontend/queue", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		ConnectedQueriers int                 `json:"connectedQueriers"`
+		Tenants           []tenantQueueStatus `json:"tenants"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	assert.Equal(t, 2, resp.ConnectedQueriers)
+	require.Len(t, resp.Tenants, 2)
+	assert.Equal(t, "1", resp.Tenants[0].ID)
+	assert.Equal(t, 3, resp.Tenants[0].QueueDepth)
+	assert.InDelta(t, 60, resp.Tenants[0].OldestRequestAge, 5)
+	assert.Equal(t, "2", resp.Tenants[1].ID)
+	assert.Equal(t, 1, resp.Tenants[1].QueueDepth)
+	assert.Less(t, resp.Tenants[1].OldestRequestAge, float64(5))
+
+	// Looking at the queue doesn't change the order of the requests.
+	assert.Equal(t, first, <-f.queues.getQueue("1"))
+}
+
+func TestFrontend_CancelTenantHandler(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	f, err := New(config, limits{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+
+	req := mux.SetURLVars(httptest.NewRequest("POST", "/frontend/tenant/1/cancel", nil), map[string]string{"id": "1"})
+	rec := httptest.NewRecorder()
+	f.CancelTenantHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"queuedQueries":1,"inflightQueries":0,"blockedFor":"30s"}`, rec.Body.String())
+	assert.Equal(t, 0, f.queues.len())
+}
+
+func TestFrontend_CancelTenantHandlerRequiresCredentials(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	f, err := New(config, limits{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+
+	cancelTenant := func(cfg HandlerConfig, authorize func(r *http.Request)) int {
+		req := mux.SetURLVars(httptest.NewRequest("POST", "/frontend/tenant/1/cancel", nil), map[string]string{"id": "1"})
+		authorize(req)
+		rec := httptest.NewRecorder()
+		NewAdminHandler(cfg, "/frontend/tenant/{id}/cancel", http.HandlerFunc(f.CancelTenantHandler), log.NewNopLogger()).ServeHTTP(rec, req)
+		return rec.Code
+	}
+	noCredentials := func(*http.Request) {}
+
+	// The endpoint is refused when no credentials are configured.
+	assert.Equal(t, http.StatusForbidden, cancelTenant(defaultHandlerConfig(), noCredentials))
+	assert.Equal(t, 1, f.queues.len())
+
+	cfg := defaultHandlerConfig()
+	cfg.Auth.BearerToken = flagext.Secret{Value: "token"}
+	assert.Equal(t, http.StatusUnauthorized, cancelTenant(cfg, noCredentials))
+	assert.Equal(t, http.StatusUnauthorized, cancelTenant(cfg, func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }))
+	assert.Equal(t, 1, f.queues.len())
+
+	assert.Equal(t, http.StatusOK, cancelTenant(cfg, func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }))
+	assert.Equal(t, 0, f.queues.len())
+}
@@ -0,0 +1,37 @@
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Period of the heartbeats of the frontend, reporting it's alive.
+const heartbeatPeriod = time.Second
+
+// heartbeatLoop beats until the frontend is closed. Each heartbeat needs the lock of the queues,
+// which all the requests go through, so that the heartbeats stop if the frontend is wedged.
+func (f *Frontend) heartbeatLoop() {
+	ticker := time.NewTicker(heartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stopHeartbeat:
+			return
+		case <-ticker.C:
+			f.mtx.Lock()
+			f.lastHeartbeat.Store(time.Now().UnixNano())
+			f.mtx.Unlock()
+		}
+	}
+}
+
+// CheckAlive determines if the query frontend is alive, regardless of the connected queriers.
+// Function parameters/return chosen to match CheckReady.
+func (f *Frontend) CheckAlive(_ context.Context) error {
+	if late := time.Since(time.Unix(0, f.lastHeartbeat.Load())); late > f.cfg.LivenessTimeout {
+		return fmt.Errorf("not alive: last heartbeat of the query-frontend %s ago, maximum allowed is %s", late.Truncate(time.Millisecond), f.cfg.LivenessTimeout)
+	}
+	return nil
+}
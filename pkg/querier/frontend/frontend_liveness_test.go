@@ -0,0 +1,53 @@
+package frontend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestFrontendCheckAlive(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	f, err := New(cfg, limits{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// The frontend is alive without queriers, while it's not ready.
+	require.NoError(t, f.CheckAlive(context.Background()))
+	require.Error(t, f.CheckReady(context.Background()))
+
+	f.lastHeartbeat.Store(time.Now().Add(-2 * cfg.LivenessTimeout).UnixNano())
+	err = f.CheckAlive(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "maximum allowed is 1m0s")
+
+	// The frontend is alive again as soon as it beats.
+	assert.Eventually(t, func() bool {
+		return f.CheckAlive(context.Background()) == nil
+	}, 3*heartbeatPeriod, 10*time.Millisecond)
+}
+
+func TestFrontendHeartbeatStopsWhenWedged(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	f, err := New(cfg, limits{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer f.Close()
+
+	f.mtx.Lock()
+	last := f.lastHeartbeat.Load()
+	time.Sleep(heartbeatPeriod + 200*time.Millisecond)
+	assert.Equal(t, last, f.lastHeartbeat.Load())
+	f.mtx.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return f.lastHeartbeat.Load() != last
+	}, time.Second, 10*time.Millisecond)
+}
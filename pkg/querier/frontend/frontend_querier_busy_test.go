@@ -0,0 +1,67 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestFrontend_QuerierBusy(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	reg := prometheus.NewPedanticRegistry()
+	f, err := New(cfg, limits{}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+
+	busy := true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = f.Process(&processServer{
+			ctx: ctx,
+			id:  "querier-1",
+			respond: func(*httpgrpc.HTTPRequest) (*ClientToFrontend, error) {
+				return &ClientToFrontend{HttpResponse: &httpgrpc.HTTPResponse{Code: http.StatusOK}, Busy: busy}, nil
+			},
+		})
+	}()
+
+	expectBusyQueriers := func(expected string) {
+		assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP cortex_query_frontend_busy_queriers Number of connected queriers currently signaling they're busy.
+			# TYPE cortex_query_frontend_busy_queriers gauge
+			cortex_query_frontend_busy_queriers `+expected+`
+		`), "cortex_query_frontend_busy_queriers"))
+	}
+
+	_, err = f.RoundTripGRPC(user.InjectOrgID(context.Background(), "1"), &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query?query=up"})
+	require.NoError(t, err)
+	expectBusyQueriers("1")
+
+	// The querier is no longer busy as soon as it responds without signaling it.
+	busy = false
+	_, err = f.RoundTripGRPC(user.InjectOrgID(context.Background(), "1"), &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query?query=up"})
+	require.NoError(t, err)
+	expectBusyQueriers("0")
+
+	// Nor once it disconnects.
+	busy = true
+	_, err = f.RoundTripGRPC(user.InjectOrgID(context.Background(), "1"), &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query?query=up"})
+	require.NoError(t, err)
+	expectBusyQueriers("1")
+	cancel()
+	<-done
+	expectBusyQueriers("0")
+}
@@ -0,0 +1,217 @@
+package frontend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// processServer is the server side of the stream of a querier, answering the requests with the response
+// function.
+type processServer struct {
+	grpc.ServerStream
+
+	ctx      context.Context
+	id       string
+	respond  func(*httpgrpc.HTTPRequest) (*ClientToFrontend, error)
+	received *FrontendToClient
+}
+
+func (s *processServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *processServer) Send(m *FrontendToClient) error {
+	s.received = m
+	return nil
+}
+
+func (s *processServer) Recv() (*ClientToFrontend, error) {
+	if s.received.Type == GET_ID {
+		return &ClientToFrontend{ClientID: s.id}, nil
+	}
+	return s.respond(s.received.HttpRequest)
+}
+
+func TestFrontend_QuerierDisconnect(t *testing.T) {
+	errDisconnected := errors.New("querier disconnected")
+
+	for name, tc := range map[string]struct {
+		retries       int
+		method        string
+		url           string
+		expectedRetry bool
+	}{
+		"retries disabled": {
+			method: "GET",
+			url:    "/api/v1/query_range?query=up",
+		},
+		"GET request retried": {
+			retries:       1,
+			method:        "GET",
+			url:           "/api/v1/query_range?query=up",
+			expectedRetry: true,
+		},
+		"POST query retried": {
+			retries:       1,
+			method:        "POST",
+			url:           "/prometheus/api/v1/query",
+			expectedRetry: true,
+		},
+		"POST request with side effects not retried": {
+			retries: 1,
+			method:  "POST",
+			url:     "/api/v1/rules/namespace",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cfg Config
+			flagext.DefaultValues(&cfg)
+			cfg.QuerierDisconnectRetries = tc.retries
+			reg := prometheus.NewPedanticRegistry()
+			f, err := New(cfg, limits{}, log.NewNopLogger(), reg)
+			require.NoError(t, err)
+
+			ctx := user.InjectOrgID(context.Background(), "1")
+			type result struct {
+				resp *httpgrpc.HTTPResponse
+				err  error
+			}
+			results := make(chan result, 1)
+			go func() {
+				resp, err := f.RoundTripGRPC(ctx, &httpgrpc.HTTPRequest{Method: tc.method, Url: tc.url})
+				results <- result{resp, err}
+			}()
+
+			// The first querier disconnects while executing the query.
+			err = f.Process(&processServer{
+				ctx: context.Background(),
+				id:  "querier-1",
+				respond: func(*httpgrpc.HTTPRequest) (*ClientToFrontend, error) {
+					return nil, errDisconnected
+				},
+			})
+			require.Equal(t, errDisconnected, err)
+
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+				# HELP cortex_query_frontend_querier_disconnects_total Total number of queriers which disconnected while executing a query.
+				# TYPE cortex_query_frontend_querier_disconnects_total counter
+				cortex_query_frontend_querier_disconnects_total 1
+			`), "cortex_query_frontend_querier_disconnects_total"))
+
+			if !tc.expectedRetry {
+				res := <-results
+				require.Equal(t, errDisconnected, res.err)
+				return
+			}
+
+			// The query is executed by another querier.
+			processCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				_ = f.Process(&processServer{
+					ctx: processCtx,
+					id:  "querier-2",
+					respond: func(req *httpgrpc.HTTPRequest) (*ClientToFrontend, error) {
+						assert.Equal(t, tc.url, req.Url)
+						return &ClientToFrontend{HttpResponse: &httpgrpc.HTTPResponse{Code: http.StatusOK}}, nil
+					},
+				})
+			}()
+
+			res := <-results
+			require.NoError(t, res.err)
+			assert.Equal(t, int32(http.StatusOK), res.resp.Code)
+		})
+	}
+}
+
+func TestFrontend_QuerierDisconnectRetriesLimit(t *testing.T) {
+	errDisconnected := errors.New("querier disconnected")
+
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.QuerierDisconnectRetries = 1
+	reg := prometheus.NewPedanticRegistry()
+	f, err := New(cfg, limits{}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := f.RoundTripGRPC(user.InjectOrgID(context.Background(), "1"), &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query?query=up"})
+		errs <- err
+	}()
+
+	// The query crashes all the queriers executing it.
+	for _, querierID := range []string{"querier-1", "querier-2"} {
+		err := f.Process(&processServer{
+			ctx: context.Background(),
+			id:  querierID,
+			respond: func(*httpgrpc.HTTPRequest) (*ClientToFrontend, error) {
+				return nil, errDisconnected
+			},
+		})
+		require.Equal(t, errDisconnected, err)
+	}
+	require.Equal(t, errDisconnected, <-errs)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_query_frontend_querier_disconnects_total Total number of queriers which disconnected while executing a query.
+		# TYPE cortex_query_frontend_querier_disconnects_total counter
+		cortex_query_frontend_querier_disconnects_total 2
+		# HELP cortex_query_frontend_requeued_requests_total Total number of queries queued again after the querier executing them disconnected.
+		# TYPE cortex_query_frontend_requeued_requests_total counter
+		cortex_query_frontend_requeued_requests_total 1
+	`), "cortex_query_frontend_querier_disconnects_total", "cortex_query_frontend_requeued_requests_total"))
+}
+
+func TestFrontend_QuerierDisconnectCancelledRequest(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	reg := prometheus.NewPedanticRegistry()
+	f, err := New(cfg, limits{}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "1"))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := f.RoundTripGRPC(ctx, &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query?query=up"})
+		errs <- err
+	}()
+
+	// The stream is closed as the client cancels the query.
+	_ = f.Process(&processServer{
+		ctx: context.Background(),
+		id:  "querier-1",
+		respond: func(*httpgrpc.HTTPRequest) (*ClientToFrontend, error) {
+			cancel()
+			return nil, context.Canceled
+		},
+	})
+	require.Equal(t, context.Canceled, <-errs)
+
+	f.mtx.Lock()
+	assert.Equal(t, 0, f.queues.len())
+	f.mtx.Unlock()
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_query_frontend_querier_disconnects_total Total number of queriers which disconnected while executing a query.
+		# TYPE cortex_query_frontend_querier_disconnects_total counter
+		cortex_query_frontend_querier_disconnects_total 0
+		# HELP cortex_query_frontend_requeued_requests_total Total number of queries queued again after the querier executing them disconnected.
+		# TYPE cortex_query_frontend_requeued_requests_total counter
+		cortex_query_frontend_requeued_requests_total 0
+	`), "cortex_query_frontend_querier_disconnects_total", "cortex_query_frontend_requeued_requests_total"))
+}
@@ -0,0 +1,71 @@
+package frontend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestFrontend_QuerierInflightRequests(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	reg := prometheus.NewPedanticRegistry()
+	f, err := New(cfg, limits{}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+
+	expectInflight := func(expected string) {
+		assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP cortex_query_frontend_querier_inflight_requests Number of requests dispatched to the connected querier and not completed yet.
+			# TYPE cortex_query_frontend_querier_inflight_requests gauge
+		`+expected), "cortex_query_frontend_querier_inflight_requests"))
+	}
+
+	// The connected queriers are exposed even when idle.
+	f.registerQuerierConnection("querier-1", 1)
+	f.registerQuerierConnection("querier-2", 1)
+	expectInflight(`
+		cortex_query_frontend_querier_inflight_requests{querier="querier-1"} 0
+		cortex_query_frontend_querier_inflight_requests{querier="querier-2"} 0
+	`)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+	}
+	var dispatched []*request
+	for _, querierID := range []string{"querier-1", "querier-1", "querier-2"} {
+		req, err := f.getNextRequestForQuerier(context.Background(), querierID)
+		require.NoError(t, err)
+		dispatched = append(dispatched, req)
+	}
+	expectInflight(`
+		cortex_query_frontend_querier_inflight_requests{querier="querier-1"} 2
+		cortex_query_frontend_querier_inflight_requests{querier="querier-2"} 1
+	`)
+
+	f.releaseRequest(dispatched[0])
+	expectInflight(`
+		cortex_query_frontend_querier_inflight_requests{querier="querier-1"} 1
+		cortex_query_frontend_querier_inflight_requests{querier="querier-2"} 1
+	`)
+
+	// A disconnected querier is removed once its requests are completed.
+	f.unregisterQuerierConnection("querier-2")
+	expectInflight(`
+		cortex_query_frontend_querier_inflight_requests{querier="querier-1"} 1
+		cortex_query_frontend_querier_inflight_requests{querier="querier-2"} 1
+	`)
+	f.releaseRequest(dispatched[2])
+	expectInflight(`
+		cortex_query_frontend_querier_inflight_requests{querier="querier-1"} 1
+	`)
+}
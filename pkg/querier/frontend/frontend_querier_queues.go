@@ -1,12 +1,54 @@
 package frontend
 
 import (
+	"math"
 	"math/rand"
 	"sort"
+	"time"
 
 	"github.com/cortexproject/cortex/pkg/util"
 )
 
+// Lowest weight of a user, so that finding the next queue doesn't take too many rounds.
+const minUserWeight = 0.01
+
+// requestQueues holds the queues of the pending requests of the users, and decides which queue each
+// querier is served from. It's implemented by queues, and replaced by the tests to control the order
+// the requests are dispatched in. The frontend calls it with its mtx held.
+type requestQueues interface {
+	// len returns the number of queues.
+	len() int
+	// getOrAddQueue returns the queue of the user, added if it doesn't exist yet, or nil if the user is empty.
+	getOrAddQueue(userID string, maxQueriers float64, minQueriers int, weight float64) chan *request
+	// getQueue returns the queue of the user, or nil if it doesn't exist.
+	getQueue(userID string) chan *request
+	// queueIDs returns the users with a queue, in no particular order.
+	queueIDs() []string
+	deleteQueue(userID string)
+
+	// getNextQueueForQuerier returns the queue the querier is served from, and its user, or nil if
+	// none, skipping the users for which skip returns true. dispatched is called after a request of
+	// the user has been dispatched.
+	getNextQueueForQuerier(querier string, skip func(userID string) bool) (chan *request, string)
+	dispatched(userID string)
+
+	addQuerierConnection(querier string, weight int)
+	removeQuerierConnection(querier string)
+	// connectedQueriers returns the connected queriers, sorted.
+	connectedQueriers() []string
+	// connections returns the number of connections of the querier.
+	connections(querier string) int
+
+	startQuerierRequest(querier string)
+	finishQuerierRequest(querier string)
+	// inflightRequests returns the number of requests executed by the querier.
+	inflightRequests(querier string) int
+	addWaitingQuerier(querier string)
+	removeWaitingQuerier(querier string)
+	setQuerierBusy(querier string, until time.Time)
+	busyQueriersCount(now time.Time) int
+}
+
 // This struct holds user queues for pending requests. It also keeps track of connected queriers,
 // and mapping between users and queriers.
 type queues struct {
@@ -17,12 +59,27 @@ type queues struct {
 	// this list when there are ""'s at the end of it.
 	users []string
 
+	// Index in users of the user currently served by the deficit round-robin, shared by all the queriers.
+	next int
+
 	maxUserQueueSize int
 
 	// Number of connections per querier.
 	querierConnections map[string]int
 	// Sorted list of querier names, used when creating per-user shard.
 	sortedQueriers []string
+
+	// Weight of the connected queriers, number of requests they're executing and number of their
+	// connections waiting for a request. When the weights differ, a request is left to the waiting
+	// querier with the fewest in-flight requests relative to its weight.
+	querierWeights  map[string]int
+	querierInflight map[string]int
+	waitingQueriers map[string]int
+	weighted        bool
+
+	// Queriers which signaled they're busy, until the given time. Their connections only get a request
+	// when no other querier handling the user is waiting for one.
+	busyQueriers map[string]time.Time
 }
 
 type userQueue struct {
@@ -31,7 +88,13 @@ type userQueue struct {
 	// If not nil, only these queriers can handle user requests. If nil, all queriers can.
 	// We set this to nil if number of available queriers <= maxQueriers.
 	queriers    map[string]struct{}
-	maxQueriers int
+	maxQueriers float64
+	minQueriers int
+
+	// Share of the queriers the user gets relative to the other users, and the number of requests it
+	// can have dispatched before the deficit round-robin moves on to the next user.
+	weight  float64
+	deficit float64
 
 	// Seed for shuffle sharding of queriers. This seed is based on userID only and is therefore consistent
 	// between different frontends.
@@ -48,6 +111,10 @@ func newUserQueues(maxUserQueueSize int) *queues {
 		maxUserQueueSize:   maxUserQueueSize,
 		querierConnections: map[string]int{},
 		sortedQueriers:     nil,
+		querierWeights:     map[string]int{},
+		querierInflight:    map[string]int{},
+		waitingQueriers:    map[string]int{},
+		busyQueriers:       map[string]time.Time{},
 	}
 }
 
@@ -55,6 +122,21 @@ func (q *queues) len() int {
 	return len(q.userQueues)
 }
 
+func (q *queues) getQueue(userID string) chan *request {
+	if uq := q.userQueues[userID]; uq != nil {
+		return uq.ch
+	}
+	return nil
+}
+
+func (q *queues) queueIDs() []string {
+	ids := make([]string, 0, len(q.userQueues))
+	for userID := range q.userQueues {
+		ids = append(ids, userID)
+	}
+	return ids
+}
+
 func (q *queues) deleteQueue(userID string) {
 	uq := q.userQueues[userID]
 	if uq == nil {
@@ -73,8 +155,11 @@ func (q *queues) deleteQueue(userID string) {
 // Returns existing or new queue for user.
 // MaxQueriers is used to compute which queriers should handle requests for this user.
 // If maxQueriers is <= 0, all queriers can handle this user's requests.
-// If maxQueriers has changed since the last call, queriers for this are recomputed.
-func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan *request {
+// If maxQueriers is < 1, it's the fraction of the connected queriers which can handle this user's requests,
+// and at least minQueriers of them do.
+// If maxQueriers or minQueriers have changed since the last call, queriers for this are recomputed.
+// Weight is the share of the queriers the user gets relative to the other users. If it's <= 0, it's 1.
+func (q *queues) getOrAddQueue(userID string, maxQueriers float64, minQueriers int, weight float64) chan *request {
 	// Empty user is not allowed, as that would break our users list ("" is used for free spot).
 	if userID == "" {
 		return nil
@@ -83,6 +168,11 @@ func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan *request {
 	if maxQueriers < 0 {
 		maxQueriers = 0
 	}
+	if weight <= 0 {
+		weight = 1
+	} else if weight < minUserWeight {
+		weight = minUserWeight
+	}
 
 	uq := q.userQueues[userID]
 
@@ -110,53 +200,99 @@ func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan *request {
 		}
 	}
 
-	if uq.maxQueriers != maxQueriers {
+	uq.weight = weight
+
+	if uq.maxQueriers != maxQueriers || uq.minQueriers != minQueriers {
 		uq.maxQueriers = maxQueriers
-		uq.queriers = shuffleQueriersForUser(uq.seed, maxQueriers, q.sortedQueriers, nil)
+		uq.minQueriers = minQueriers
+		uq.queriers = shuffleQueriersForUser(uq.seed, queriersToSelect(maxQueriers, minQueriers, len(q.sortedQueriers)), q.sortedQueriers, nil)
 	}
 
 	return uq.ch
 }
 
-// Finds next queue for the querier. To support fair scheduling between users, client is expected
-// to pass last user index returned by this function as argument. Is there was no previous
-// last user index, use -1.
-func (q *queues) getNextQueueForQuerier(lastUserIndex int, querier string) (chan *request, string, int) {
-	uid := lastUserIndex
+// Finds next queue for the querier, following a deficit round-robin across the users: when the round
+// reaches a user, it's credited with its weight, and it's served as long as it has at least one credit
+// left, each dispatched request costing one. The users thus get a share of the queriers proportional to
+// their weight, however many requests they queued, and the users with a weight lower than 1 are only
+// served every few rounds. The users the querier can't handle, or skipped by the function, aren't
+// credited. The caller is expected to call dispatched once it's taken a request off the queue.
+func (q *queues) getNextQueueForQuerier(querier string, skip func(userID string) bool) (chan *request, string) {
+	for {
+		credited := false
+		uid := q.next
+
+		for iters := 0; iters < len(q.users); iters++ {
+			// Don't use "mod len(q.users)", as that could skip users at the beginning of the list
+			// for example when q.users has shrunk since last call.
+			if uid >= len(q.users) {
+				uid = 0
+			}
 
-	for iters := 0; iters < len(q.users); iters++ {
-		uid = uid + 1
+			u := q.users[uid]
+			if u == "" || !q.handles(q.userQueues[u], querier) || (skip != nil && skip(u)) {
+				uid++
+				continue
+			}
 
-		// Don't use "mod len(q.users)", as that could skip users at the beginning of the list
-		// for example when q.users has shrunk since last call.
-		if uid >= len(q.users) {
-			uid = 0
+			uq := q.userQueues[u]
+			if uq.deficit < 1 {
+				uq.deficit += uq.weight
+				credited = true
+			}
+			if uq.deficit >= 1 {
+				q.next = uid
+				return uq.ch, u
+			}
+			uid++
 		}
 
-		u := q.users[uid]
-		if u == "" {
-			continue
+		// Go for another round if some users have been credited but not enough to be served yet.
+		if !credited {
+			return nil, ""
 		}
+	}
+}
 
-		q := q.userQueues[u]
-
-		if q.queriers != nil {
-			if _, ok := q.queriers[querier]; !ok {
-				// This querier is not handling the user.
-				continue
-			}
+// handles returns whether the querier should take the next request of the user.
+func (q *queues) handles(uq *userQueue, querier string) bool {
+	if uq.queriers != nil {
+		if _, ok := uq.queriers[querier]; !ok {
+			return false
 		}
+	}
+	return !q.preferredQuerierWaiting(uq, querier)
+}
 
-		return q.ch, u, uid
+// dispatched charges the user for a request dispatched to a querier, and moves the deficit round-robin
+// on to the next user once the user has no credit left.
+func (q *queues) dispatched(userID string) {
+	uq := q.userQueues[userID]
+	if uq == nil {
+		return
+	}
+
+	uq.deficit--
+	if uq.deficit < 1 {
+		q.next = uq.index + 1
 	}
-	return nil, "", uid
 }
 
-func (q *queues) addQuerierConnection(querier string) {
+// addQuerierConnection registers a connection of the querier, with the weight it advertised. Old queriers
+// don't advertise any weight, and have the default weight of 1.
+func (q *queues) addQuerierConnection(querier string, weight int) {
 	conns := q.querierConnections[querier]
 
 	q.querierConnections[querier] = conns + 1
 
+	if weight < 1 {
+		weight = 1
+	}
+	if q.querierWeights[querier] != weight {
+		q.querierWeights[querier] = weight
+		q.recomputeWeighted()
+	}
+
 	// First connection from this querier.
 	if conns == 0 {
 		q.sortedQueriers = append(q.sortedQueriers, querier)
@@ -177,6 +313,9 @@ func (q *queues) removeQuerierConnection(querier string) {
 		q.querierConnections[querier] = conns
 	} else {
 		delete(q.querierConnections, querier)
+		delete(q.querierWeights, querier)
+		delete(q.busyQueriers, querier)
+		q.recomputeWeighted()
 
 		ix := sort.SearchStrings(q.sortedQueriers, querier)
 		if ix >= len(q.sortedQueriers) || q.sortedQueriers[ix] != querier {
@@ -189,12 +328,149 @@ func (q *queues) removeQuerierConnection(querier string) {
 	}
 }
 
+func (q *queues) connectedQueriers() []string {
+	return q.sortedQueriers
+}
+
+func (q *queues) connections(querier string) int {
+	return q.querierConnections[querier]
+}
+
+func (q *queues) recomputeWeighted() {
+	q.weighted = false
+	for _, weight := range q.querierWeights {
+		for _, other := range q.querierWeights {
+			if weight != other {
+				q.weighted = true
+				return
+			}
+		}
+	}
+}
+
+// startQuerierRequest and finishQuerierRequest track the requests executed by the querier.
+func (q *queues) startQuerierRequest(querier string) {
+	q.querierInflight[querier]++
+}
+
+func (q *queues) finishQuerierRequest(querier string) {
+	if q.querierInflight[querier]--; q.querierInflight[querier] <= 0 {
+		delete(q.querierInflight, querier)
+	}
+}
+
+func (q *queues) inflightRequests(querier string) int {
+	return q.querierInflight[querier]
+}
+
+// addWaitingQuerier and removeWaitingQuerier track the connections of the querier waiting for a request.
+func (q *queues) addWaitingQuerier(querier string) {
+	q.waitingQueriers[querier]++
+}
+
+func (q *queues) removeWaitingQuerier(querier string) {
+	if q.waitingQueriers[querier]--; q.waitingQueriers[querier] <= 0 {
+		delete(q.waitingQueriers, querier)
+	}
+}
+
+// setQuerierBusy flags the querier as busy until the given time, or clears the flag if the time is zero.
+func (q *queues) setQuerierBusy(querier string, until time.Time) {
+	if until.IsZero() {
+		delete(q.busyQueriers, querier)
+		return
+	}
+	q.busyQueriers[querier] = until
+}
+
+func (q *queues) querierBusy(querier string, now time.Time) bool {
+	until, ok := q.busyQueriers[querier]
+	return ok && now.Before(until)
+}
+
+// busyQueriersCount returns the number of connected queriers which are currently busy.
+func (q *queues) busyQueriersCount(now time.Time) int {
+	count := 0
+	for querier := range q.busyQueriers {
+		if q.querierBusy(querier, now) {
+			count++
+		}
+	}
+	return count
+}
+
+// preferredQuerierWaiting returns whether another querier handling the user's requests is waiting for a
+// request, and should get it rather than the querier: the busy queriers leave the requests to the other
+// queriers, and otherwise a request is left to the querier which would have fewer in-flight requests
+// relative to its weight once it's dispatched the request. The waiting queriers are woken up when a
+// request is queued, so the request is left to it.
+func (q *queues) preferredQuerierWaiting(uq *userQueue, querier string) bool {
+	if !q.weighted && len(q.busyQueriers) == 0 {
+		return false
+	}
+
+	now := time.Now()
+	busy := q.querierBusy(querier, now)
+	load := q.querierLoad(querier)
+	for other := range q.waitingQueriers {
+		if other == querier {
+			continue
+		}
+		if uq.queriers != nil {
+			if _, ok := uq.queriers[other]; !ok {
+				continue
+			}
+		}
+
+		otherBusy := q.querierBusy(other, now)
+		if busy && !otherBusy {
+			return true
+		}
+		if q.weighted && busy == otherBusy && q.querierLoad(other) < load {
+			return true
+		}
+	}
+	return false
+}
+
+// querierLoad returns the in-flight requests of the querier relative to its weight, once it's dispatched
+// one more request.
+func (q *queues) querierLoad(querier string) float64 {
+	weight := q.querierWeights[querier]
+	if weight < 1 {
+		weight = 1
+	}
+	return float64(q.querierInflight[querier]+1) / float64(weight)
+}
+
 func (q *queues) recomputeUserQueriers() {
 	scratchpad := make([]string, 0, len(q.sortedQueriers))
 
 	for _, uq := range q.userQueues {
-		uq.queriers = shuffleQueriersForUser(uq.seed, uq.maxQueriers, q.sortedQueriers, scratchpad)
+		uq.queriers = shuffleQueriersForUser(uq.seed, queriersToSelect(uq.maxQueriers, uq.minQueriers, len(q.sortedQueriers)), q.sortedQueriers, scratchpad)
+	}
+}
+
+// queriersToSelect returns the number of queriers which should handle the requests of a user, given the
+// configured max and min queriers and the number of connected queriers. 0 means all queriers.
+func queriersToSelect(maxQueriers float64, minQueriers, connectedQueriers int) int {
+	if maxQueriers <= 0 {
+		return 0
+	}
+	if maxQueriers >= 1 {
+		return int(maxQueriers)
+	}
+
+	// A fraction of the connected queriers, rounded up so that at least one querier is selected, and
+	// floored at the min queriers as long as enough queriers are connected.
+	selected := int(math.Ceil(maxQueriers * float64(connectedQueriers)))
+	if selected < minQueriers {
+		selected = minQueriers
+	}
+	if selected > connectedQueriers {
+		selected = connectedQueriers
 	}
+	return selected
 }
 
 // Scratchpad is used for shuffling, to avoid new allocations. If nil, new slice is allocated.
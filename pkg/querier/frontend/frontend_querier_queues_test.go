@@ -17,43 +17,44 @@ func TestQueues(t *testing.T) {
 	assert.NotNil(t, uq)
 	assert.NoError(t, isConsistent(uq))
 
-	q, u, lastUserIndex := uq.getNextQueueForQuerier(-1, "querier-1")
+	q, u := uq.getNextQueueForQuerier("querier-1", nil)
 	assert.Nil(t, q)
 	assert.Equal(t, "", u)
 
 	// Add queues: [one]
 	qOne := getOrAdd(t, uq, "one", 0)
-	lastUserIndex = confirmOrderForQuerier(t, uq, "querier-1", lastUserIndex, qOne, qOne)
+	confirmOrderForQuerier(t, uq, "querier-1", qOne, qOne)
 
 	// [one two]
 	qTwo := getOrAdd(t, uq, "two", 0)
 	assert.NotEqual(t, qOne, qTwo)
 
-	lastUserIndex = confirmOrderForQuerier(t, uq, "querier-1", lastUserIndex, qTwo, qOne, qTwo, qOne)
-	confirmOrderForQuerier(t, uq, "querier-2", -1, qOne, qTwo, qOne)
+	// The round-robin is shared by the queriers.
+	confirmOrderForQuerier(t, uq, "querier-1", qTwo, qOne, qTwo, qOne)
+	confirmOrderForQuerier(t, uq, "querier-2", qTwo, qOne, qTwo)
 
 	// [one two three]
 	// confirm fifo by adding a third queue and iterating to it
 	qThree := getOrAdd(t, uq, "three", 0)
 
-	lastUserIndex = confirmOrderForQuerier(t, uq, "querier-1", lastUserIndex, qTwo, qThree, qOne)
+	confirmOrderForQuerier(t, uq, "querier-1", qThree, qOne, qTwo)
 
 	// Remove one: ["" two three]
 	uq.deleteQueue("one")
 	assert.NoError(t, isConsistent(uq))
 
-	lastUserIndex = confirmOrderForQuerier(t, uq, "querier-1", lastUserIndex, qTwo, qThree, qTwo)
+	confirmOrderForQuerier(t, uq, "querier-1", qThree, qTwo, qThree)
 
 	// "four" is added at the beginning of the list: [four two three]
 	qFour := getOrAdd(t, uq, "four", 0)
 
-	lastUserIndex = confirmOrderForQuerier(t, uq, "querier-1", lastUserIndex, qThree, qFour, qTwo, qThree)
+	confirmOrderForQuerier(t, uq, "querier-1", qFour, qTwo, qThree, qFour)
 
 	// Remove two: [four "" three]
 	uq.deleteQueue("two")
 	assert.NoError(t, isConsistent(uq))
 
-	lastUserIndex = confirmOrderForQuerier(t, uq, "querier-1", lastUserIndex, qFour, qThree, qFour)
+	confirmOrderForQuerier(t, uq, "querier-1", qThree, qFour, qThree)
 
 	// Remove three: [four]
 	uq.deleteQueue("three")
@@ -63,10 +64,41 @@ func TestQueues(t *testing.T) {
 	uq.deleteQueue("four")
 	assert.NoError(t, isConsistent(uq))
 
-	q, _, _ = uq.getNextQueueForQuerier(lastUserIndex, "querier-1")
+	q, _ = uq.getNextQueueForQuerier("querier-1", nil)
 	assert.Nil(t, q)
 }
 
+func TestQueuesWithWeights(t *testing.T) {
+	uq := newUserQueues(0)
+	qHeavy := uq.getOrAddQueue("heavy", 0, 0, 2)
+	qDefault := uq.getOrAddQueue("default", 0, 0, 0)
+	qLight := uq.getOrAddQueue("light", 0, 0, 0.5)
+
+	// Each round, the users get as many requests dispatched as their weight, the light user every
+	// other round.
+	confirmOrderForQuerier(t, uq, "querier-1", qHeavy, qHeavy, qDefault, qHeavy, qHeavy, qDefault, qLight, qHeavy)
+
+	// The skipped users aren't credited, but they keep their credit.
+	skipHeavy := func(userID string) bool { return userID == "heavy" }
+	for _, expected := range []chan *request{qDefault, qDefault, qLight, qDefault} {
+		q, u := uq.getNextQueueForQuerier("querier-1", skipHeavy)
+		require.Equal(t, expected, q)
+		uq.dispatched(u)
+	}
+	assert.Equal(t, float64(1), uq.userQueues["heavy"].deficit)
+	confirmOrderForQuerier(t, uq, "querier-1", qHeavy, qDefault)
+
+	q, _ := uq.getNextQueueForQuerier("querier-1", func(string) bool { return true })
+	assert.Nil(t, q)
+
+	// The weight follows the limits.
+	uq.getOrAddQueue("light", 0, 0, 1)
+	assert.Equal(t, float64(1), uq.userQueues["light"].weight)
+	uq.getOrAddQueue("light", 0, 0, 0.0001)
+	assert.Equal(t, minUserWeight, uq.userQueues["light"].weight)
+	assert.NoError(t, isConsistent(uq))
+}
+
 func TestQueuesWithQueriers(t *testing.T) {
 	uq := newUserQueues(0)
 	assert.NotNil(t, uq)
@@ -79,10 +111,10 @@ func TestQueuesWithQueriers(t *testing.T) {
 	// Add some queriers.
 	for ix := 0; ix < queriers; ix++ {
 		qid := fmt.Sprintf("querier-%d", ix)
-		uq.addQuerierConnection(qid)
+		uq.addQuerierConnection(qid, 1)
 
 		// No querier has any queues yet.
-		q, u, _ := uq.getNextQueueForQuerier(-1, qid)
+		q, u := uq.getNextQueueForQuerier(qid, nil)
 		assert.Nil(t, q)
 		assert.Equal(t, "", u)
 	}
@@ -92,7 +124,7 @@ func TestQueuesWithQueriers(t *testing.T) {
 	// Add user queues.
 	for u := 0; u < users; u++ {
 		uid := fmt.Sprintf("user-%d", u)
-		getOrAdd(t, uq, uid, maxQueriersPerUser)
+		getOrAdd(t, uq, uid, float64(maxQueriersPerUser))
 
 		// Verify it has maxQueriersPerUser queriers assigned now.
 		qs := uq.userQueues[uid].queriers
@@ -103,16 +135,8 @@ func TestQueuesWithQueriers(t *testing.T) {
 	// and compute mean and stdDev.
 	queriersMap := make(map[string]int)
 
-	for q := 0; q < queriers; q++ {
-		qid := fmt.Sprintf("querier-%d", q)
-
-		lastUserIndex := -1
-		for {
-			_, _, newIx := uq.getNextQueueForQuerier(lastUserIndex, qid)
-			if newIx < lastUserIndex {
-				break
-			}
-			lastUserIndex = newIx
+	for _, userQueue := range uq.userQueues {
+		for qid := range userQueue.queriers {
 			queriersMap[qid]++
 		}
 	}
@@ -135,6 +159,124 @@ func TestQueuesWithQueriers(t *testing.T) {
 	assert.InDelta(t, stdDev, 0, mean*0.2)
 }
 
+func TestQueuesWithFractionalMaxQueriers(t *testing.T) {
+	uq := newUserQueues(0)
+	for i := 0; i < 10; i++ {
+		uq.addQuerierConnection(fmt.Sprintf("querier-%d", i), 1)
+	}
+
+	getOrAdd(t, uq, "user", 0.3)
+	assert.Len(t, uq.userQueues["user"].queriers, 3)
+
+	// The number of queriers follows the connected queriers.
+	for i := 10; i < 20; i++ {
+		uq.addQuerierConnection(fmt.Sprintf("querier-%d", i), 1)
+	}
+	assert.Len(t, uq.userQueues["user"].queriers, 6)
+	assert.NoError(t, isConsistent(uq))
+
+	for i := 10; i < 15; i++ {
+		uq.removeQuerierConnection(fmt.Sprintf("querier-%d", i))
+	}
+	// 30% of 15 queriers, rounded up.
+	assert.Len(t, uq.userQueues["user"].queriers, 5)
+	assert.NoError(t, isConsistent(uq))
+}
+
+func TestQueuesWithWeightedQueriers(t *testing.T) {
+	uq := newUserQueues(0)
+	uq.addQuerierConnection("small", 1)
+	uq.addQuerierConnection("big", 3)
+	uq.addWaitingQuerier("small")
+	uq.addWaitingQuerier("big")
+	queue := getOrAdd(t, uq, "user", 0)
+
+	// The request is left to the waiting querier with fewer in-flight requests relative to its weight.
+	q, _ := uq.getNextQueueForQuerier("small", nil)
+	assert.Nil(t, q)
+	q, _ = uq.getNextQueueForQuerier("big", nil)
+	assert.Equal(t, queue, q)
+
+	for i := 0; i < 3; i++ {
+		uq.startQuerierRequest("big")
+	}
+	q, _ = uq.getNextQueueForQuerier("small", nil)
+	assert.Equal(t, queue, q)
+	q, _ = uq.getNextQueueForQuerier("big", nil)
+	assert.Nil(t, q)
+
+	// The queriers not waiting for a request don't get it.
+	uq.removeWaitingQuerier("small")
+	q, _ = uq.getNextQueueForQuerier("big", nil)
+	assert.Equal(t, queue, q)
+	uq.addWaitingQuerier("small")
+
+	for i := 0; i < 3; i++ {
+		uq.finishQuerierRequest("big")
+	}
+	q, _ = uq.getNextQueueForQuerier("small", nil)
+	assert.Nil(t, q)
+
+	// Old queriers don't advertise any weight: with the same weights, the requests go to any querier.
+	uq.removeQuerierConnection("big")
+	uq.addQuerierConnection("big", 0)
+	assert.False(t, uq.weighted)
+	q, _ = uq.getNextQueueForQuerier("small", nil)
+	assert.Equal(t, queue, q)
+	assert.NoError(t, isConsistent(uq))
+}
+
+func TestQueuesWithMinQueriers(t *testing.T) {
+	uq := newUserQueues(0)
+	for i := 0; i < 3; i++ {
+		uq.addQuerierConnection(fmt.Sprintf("querier-%d", i), 1)
+	}
+
+	// 30% of 3 queriers is 1 querier, floored at 2.
+	assert.NotNil(t, uq.getOrAddQueue("user", 0.3, 2, 1))
+	assert.Len(t, uq.userQueues["user"].queriers, 2)
+	assert.NoError(t, isConsistent(uq))
+
+	// The queriers are recomputed when the min queriers changes.
+	assert.NotNil(t, uq.getOrAddQueue("user", 0.3, 0, 1))
+	assert.Len(t, uq.userQueues["user"].queriers, 1)
+	assert.NoError(t, isConsistent(uq))
+
+	// All the queriers handle the requests when fewer than the min queriers are connected.
+	assert.NotNil(t, uq.getOrAddQueue("user", 0.3, 5, 1))
+	assert.Nil(t, uq.userQueues["user"].queriers)
+	assert.NoError(t, isConsistent(uq))
+}
+
+func TestQueriersToSelect(t *testing.T) {
+	for _, tc := range []struct {
+		maxQueriers       float64
+		minQueriers       int
+		connectedQueriers int
+		expected          int
+	}{
+		{maxQueriers: 0, connectedQueriers: 10, expected: 0},
+		{maxQueriers: -1, connectedQueriers: 10, expected: 0},
+		{maxQueriers: 3, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 1, connectedQueriers: 10, expected: 1},
+		{maxQueriers: 0.3, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 0.25, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 0.01, connectedQueriers: 10, expected: 1},
+		{maxQueriers: 0.5, connectedQueriers: 0, expected: 0},
+		// The fraction is floored at the min queriers, capped at the connected queriers.
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 4, expected: 2},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 3, expected: 2},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 1, expected: 1},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 0, expected: 0},
+		// The min queriers only applies to a fraction.
+		{maxQueriers: 1, minQueriers: 2, connectedQueriers: 10, expected: 1},
+		{maxQueriers: 0, minQueriers: 2, connectedQueriers: 10, expected: 0},
+	} {
+		assert.Equal(t, tc.expected, queriersToSelect(tc.maxQueriers, tc.minQueriers, tc.connectedQueriers), "max queriers: %v, min queriers: %d, connected queriers: %d", tc.maxQueriers, tc.minQueriers, tc.connectedQueriers)
+	}
+}
+
 func TestQueuesConsistency(t *testing.T) {
 	uq := newUserQueues(0)
 	assert.NotNil(t, uq)
@@ -142,23 +284,21 @@ func TestQueuesConsistency(t *testing.T) {
 
 	r := rand.New(rand.NewSource(time.Now().Unix()))
 
-	lastUserIndexes := map[string]int{}
-
 	conns := map[string]int{}
 
 	for i := 0; i < 1000; i++ {
 		switch r.Int() % 6 {
 		case 0:
-			assert.NotNil(t, uq.getOrAddQueue(generateTenant(r), 3))
+			assert.NotNil(t, uq.getOrAddQueue(generateTenant(r), 3, 0, 1))
 		case 1:
-			qid := generateQuerier(r)
-			_, _, luid := uq.getNextQueueForQuerier(lastUserIndexes[qid], qid)
-			lastUserIndexes[qid] = luid
+			if _, u := uq.getNextQueueForQuerier(generateQuerier(r), nil); u != "" {
+				uq.dispatched(u)
+			}
 		case 2:
 			uq.deleteQueue(generateTenant(r))
 		case 3:
 			q := generateQuerier(r)
-			uq.addQuerierConnection(q)
+			uq.addQuerierConnection(q, 1)
 			conns[q]++
 		case 4:
 			q := generateQuerier(r)
@@ -166,6 +306,8 @@ func TestQueuesConsistency(t *testing.T) {
 				uq.removeQuerierConnection(q)
 				conns[q]--
 			}
+		case 5:
+			assert.NotNil(t, uq.getOrAddQueue(generateTenant(r), 0.5, 0, 2))
 		}
 
 		assert.NoErrorf(t, isConsistent(uq), "last action %d", i)
@@ -180,28 +322,31 @@ func generateQuerier(r *rand.Rand) string {
 	return fmt.Sprint("querier-", r.Int()%5)
 }
 
-func getOrAdd(t *testing.T, uq *queues, tenant string, maxQueriers int) chan *request {
-	q := uq.getOrAddQueue(tenant, maxQueriers)
+func getOrAdd(t *testing.T, uq *queues, tenant string, maxQueriers float64) chan *request {
+	q := uq.getOrAddQueue(tenant, maxQueriers, 0, 1)
 	assert.NotNil(t, q)
 	assert.NoError(t, isConsistent(uq))
-	assert.Equal(t, q, uq.getOrAddQueue(tenant, maxQueriers))
+	assert.Equal(t, q, uq.getOrAddQueue(tenant, maxQueriers, 0, 1))
 	return q
 }
 
-func confirmOrderForQuerier(t *testing.T, uq *queues, querier string, lastUserIndex int, qs ...chan *request) int {
-	var n chan *request
+// confirmOrderForQuerier checks the order the queues are picked in, as a request is dispatched from each.
+func confirmOrderForQuerier(t *testing.T, uq *queues, querier string, qs ...chan *request) {
 	for _, q := range qs {
-		n, _, lastUserIndex = uq.getNextQueueForQuerier(lastUserIndex, querier)
+		n, u := uq.getNextQueueForQuerier(querier, nil)
 		assert.Equal(t, q, n)
+		uq.dispatched(u)
 		assert.NoError(t, isConsistent(uq))
 	}
-	return lastUserIndex
 }
 
 func isConsistent(uq *queues) error {
 	if len(uq.sortedQueriers) != len(uq.querierConnections) {
 		return fmt.Errorf("inconsistent number of sorted queriers and querier connections")
 	}
+	if len(uq.querierWeights) != len(uq.querierConnections) {
+		return fmt.Errorf("inconsistent number of querier weights and querier connections")
+	}
 
 	uc := 0
 	for ix, u := range uq.users {
@@ -222,16 +367,18 @@ func isConsistent(uq *queues) error {
 			return fmt.Errorf("invalid user's index, expected=%d, got=%d", ix, q.index)
 		}
 
-		if q.maxQueriers == 0 && q.queriers != nil {
+		maxQueriers := queriersToSelect(q.maxQueriers, q.minQueriers, len(uq.sortedQueriers))
+
+		if maxQueriers == 0 && q.queriers != nil {
 			return fmt.Errorf("user %s has queriers, but maxQueriers=0", u)
 		}
 
-		if q.maxQueriers > 0 && len(uq.sortedQueriers) <= q.maxQueriers && q.queriers != nil {
+		if maxQueriers > 0 && len(uq.sortedQueriers) <= maxQueriers && q.queriers != nil {
 			return fmt.Errorf("user %s has queriers set despite not enough queriers available", u)
 		}
 
-		if q.maxQueriers > 0 && len(uq.sortedQueriers) > q.maxQueriers && len(q.queriers) != q.maxQueriers {
-			return fmt.Errorf("user %s has incorrect number of queriers, expected=%d, got=%d", u, len(q.queriers), q.maxQueriers)
+		if maxQueriers > 0 && len(uq.sortedQueriers) > maxQueriers && len(q.queriers) != maxQueriers {
+			return fmt.Errorf("user %s has incorrect number of queriers, expected=%d, got=%d", u, len(q.queriers), maxQueriers)
 		}
 	}
 
@@ -289,3 +436,35 @@ func TestShuffleQueriersCorrectness(t *testing.T) {
 		}
 	}
 }
+
+func TestQueuesWithBusyQueriers(t *testing.T) {
+	uq := newUserQueues(0)
+	uq.addQuerierConnection("querier-1", 1)
+	uq.addQuerierConnection("querier-2", 1)
+	uq.addWaitingQuerier("querier-1")
+	queue := getOrAdd(t, uq, "user", 0)
+	uq.setQuerierBusy("querier-1", time.Now().Add(time.Hour))
+	assert.Equal(t, 1, uq.busyQueriersCount(time.Now()))
+
+	// The busy querier gets the requests while no other querier is waiting for one.
+	q, _ := uq.getNextQueueForQuerier("querier-1", nil)
+	assert.Equal(t, queue, q)
+
+	uq.addWaitingQuerier("querier-2")
+	q, _ = uq.getNextQueueForQuerier("querier-1", nil)
+	assert.Nil(t, q)
+	q, _ = uq.getNextQueueForQuerier("querier-2", nil)
+	assert.Equal(t, queue, q)
+
+	// The busy state expires.
+	uq.setQuerierBusy("querier-1", time.Now().Add(-time.Second))
+	assert.Equal(t, 0, uq.busyQueriersCount(time.Now()))
+	q, _ = uq.getNextQueueForQuerier("querier-1", nil)
+	assert.Equal(t, queue, q)
+
+	// The busy state is cleared when the querier disconnects.
+	uq.setQuerierBusy("querier-1", time.Now().Add(time.Hour))
+	uq.removeQuerierConnection("querier-1")
+	assert.Equal(t, 0, uq.busyQueriersCount(time.Now()))
+	assert.NoError(t, isConsistent(uq))
+}
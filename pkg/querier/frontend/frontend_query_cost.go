@@ -0,0 +1,104 @@
+package frontend
+
+import (
+	"context"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+)
+
+// Querier-seconds used by a tenant in the current budget window.
+type tenantCost struct {
+	windowStart time.Time
+	used        float64
+}
+
+// recordQueryCost accounts the querier-seconds used by the request, and the series and samples it
+// fetched, to each of its tenants. Queriers not reporting the stats of the requests are ignored.
+func (f *Frontend) recordQueryCost(req *request, stats *QueryStats) {
+	if stats == nil {
+		return
+	}
+
+	tenantIDs, err := tenant.TenantIDsFromOrgID(req.userID)
+	if err != nil {
+		return
+	}
+
+	cost := stats.WallTime.Seconds()
+	now := time.Now()
+
+	f.costsMtx.Lock()
+	defer f.costsMtx.Unlock()
+
+	// Each tenant of a query spanning multiple tenants is charged its full cost.
+	for _, tenantID := range tenantIDs {
+		label := f.tenantLabeler.label(tenantID)
+		f.querierSeconds.WithLabelValues(label).Add(cost)
+		f.fetchedSeries.WithLabelValues(label).Add(float64(stats.FetchedSeries))
+		f.fetchedSamples.WithLabelValues(label).Add(float64(stats.FetchedSamples))
+
+		c := f.currentTenantCost(tenantID, now)
+		c.used += cost
+	}
+}
+
+// currentTenantCost returns the cost of the tenant in the current window, which is started
+// again once the configured window elapsed. Must be called with costsMtx held.
+func (f *Frontend) currentTenantCost(tenantID string, now time.Time) *tenantCost {
+	c := f.tenantCosts[tenantID]
+	if c == nil {
+		c = &tenantCost{windowStart: now}
+		f.tenantCosts[tenantID] = c
+	} else if now.Sub(c.windowStart) >= f.cfg.QueryBudgetWindow {
+		c.windowStart = now
+		c.used = 0
+	}
+	return c
+}
+
+// overBudget returns the first of the tenants which used its query budget in the current window,
+// or an empty string if none did.
+func (f *Frontend) overBudget(tenantIDs []string) string {
+	now := time.Now()
+
+	f.costsMtx.Lock()
+	defer f.costsMtx.Unlock()
+
+	for _, tenantID := range tenantIDs {
+		budget := f.limits.QueryBudget(tenantID)
+		if budget <= 0 {
+			continue
+		}
+		if c := f.currentTenantCost(tenantID, now); c.used >= budget {
+			return tenantID
+		}
+	}
+	return ""
+}
+
+// throttleOverBudget delays the query if any of its tenants used its query budget, so that
+// the queries of the other tenants are favoured. The query isn't rejected.
+func (f *Frontend) throttleOverBudget(ctx context.Context, tenantIDs []string) error {
+	if f.cfg.QueryBudgetThrottleDelay <= 0 {
+		return nil
+	}
+
+	tenantID := f.overBudget(tenantIDs)
+	if tenantID == "" {
+		return nil
+	}
+
+	f.throttledQueries.WithLabelValues(f.tenantLabeler.label(tenantID)).Inc()
+	span, _ := opentracing.StartSpanFromContext(ctx, "throttled")
+	defer span.Finish()
+
+	select {
+	case <-time.After(f.cfg.QueryBudgetThrottleDelay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+package frontend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestFrontend_QueryBudget(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+	config.QueryBudgetThrottleDelay = 50 * time.Millisecond
+
+	f, err := New(config, limits{queryBudget: 3}, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+	require.NoError(t, err)
+
+	// Requests without stats, from old queriers, aren't accounted.
+	f.recordQueryCost(&request{userID: "1"}, nil)
+	f.recordQueryCost(&request{userID: "1"}, &QueryStats{WallTime: 2 * time.Second})
+	f.recordQueryCost(&request{userID: "1|2"}, &QueryStats{WallTime: time.Second, FetchedSeries: 2, FetchedSamples: 20})
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(f.querierSeconds.WithLabelValues("1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(f.querierSeconds.WithLabelValues("2")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(f.fetchedSeries.WithLabelValues("1")))
+	assert.Equal(t, float64(20), testutil.ToFloat64(f.fetchedSamples.WithLabelValues("2")))
+	assert.Equal(t, "1", f.overBudget([]string{"2", "1"}))
+	assert.Equal(t, "", f.overBudget([]string{"2"}))
+
+	// The queries of the tenant over budget are delayed.
+	start := time.Now()
+	require.NoError(t, f.throttleOverBudget(context.Background(), []string{"1"}))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(config.QueryBudgetThrottleDelay))
+	assert.Equal(t, float64(1), testutil.ToFloat64(f.throttledQueries.WithLabelValues("1")))
+
+	// Unless they're cancelled meanwhile.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, f.throttleOverBudget(ctx, []string{"1"}))
+
+	// The budget is available again in the next window.
+	f.tenantCosts["1"].windowStart = time.Now().Add(-2 * config.QueryBudgetWindow)
+	assert.Equal(t, "", f.overBudget([]string{"1"}))
+}
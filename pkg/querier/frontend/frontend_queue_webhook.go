@@ -0,0 +1,214 @@
+package frontend
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Max number of events waiting to be sent, the newer ones are dropped.
+	queueWebhookBufferSize = 100
+
+	// Min period between the logs of the failures to send the events.
+	queueWebhookErrorLogPeriod = time.Minute
+)
+
+// Types of the queue webhook events.
+const (
+	queueEventSaturated = "queue_saturated"
+	queueEventRecovered = "queue_recovered"
+)
+
+// QueueWebhookConfig configures the webhook notified when the queue crosses its high-watermark,
+// and when it recovers.
+type QueueWebhookConfig struct {
+	URL                 string        `yaml:"queue_webhook_url"`
+	HighWatermark       int           `yaml:"queue_webhook_high_watermark"`
+	TenantHighWatermark int           `yaml:"queue_webhook_tenant_high_watermark"`
+	Timeout             time.Duration `yaml:"queue_webhook_timeout"`
+}
+
+func (cfg *QueueWebhookConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.URL, "frontend.queue-webhook.url", "", "URL a JSON event is POSTed to when the number of queued requests reaches a high-watermark, and again when it recovers, ie. drops to half of it. Events which can't be sent right away are dropped, so that the queries are never slowed down. Empty to disable.")
+	f.IntVar(&cfg.HighWatermark, "frontend.queue-webhook.high-watermark", 0, "Number of queued requests, all tenants included, at which the queue webhook is notified. 0 to disable.")
+	f.IntVar(&cfg.TenantHighWatermark, "frontend.queue-webhook.tenant-high-watermark", 0, "Number of queued requests of a single tenant at which the queue webhook is notified. 0 to disable.")
+	f.DurationVar(&cfg.Timeout, "frontend.queue-webhook.timeout", 5*time.Second, "Timeout of the requests sent to the queue webhook.")
+}
+
+func (cfg *QueueWebhookConfig) Validate() error {
+	if cfg.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -frontend.queue-webhook.url %q: must be an http or https URL", cfg.URL)
+	}
+	if cfg.HighWatermark < 0 || cfg.TenantHighWatermark < 0 {
+		return fmt.Errorf("invalid -frontend.queue-webhook.high-watermark %d and -frontend.queue-webhook.tenant-high-watermark %d: must not be negative", cfg.HighWatermark, cfg.TenantHighWatermark)
+	}
+	if cfg.HighWatermark == 0 && cfg.TenantHighWatermark == 0 {
+		return fmt.Errorf("-frontend.queue-webhook.high-watermark or -frontend.queue-webhook.tenant-high-watermark must be configured along with -frontend.queue-webhook.url")
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("invalid -frontend.queue-webhook.timeout %s: must be positive", cfg.Timeout)
+	}
+	return nil
+}
+
+// queueEvent is the body of the requests sent to the queue webhook. The tenant is only set
+// for the events of the queue of a tenant.
+type queueEvent struct {
+	Event         string    `json:"event"`
+	Tenant        string    `json:"tenant,omitempty"`
+	QueueLength   int       `json:"queue_length"`
+	HighWatermark int       `json:"high_watermark"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// queueWebhook notifies the webhook of the saturation of the queue, overall and per tenant. The
+// events are sent in the background, one at a time, so that the queries are never slowed down.
+type queueWebhook struct {
+	cfg    QueueWebhookConfig
+	log    log.Logger
+	client *http.Client
+
+	// Saturation of the queue and of the queues of the tenants. Protected by the frontend mtx.
+	saturated        bool
+	saturatedTenants map[string]struct{}
+
+	events  chan queueEvent
+	stopped chan struct{}
+
+	errorLogLimiter *rate.Limiter
+	eventsTotal     *prometheus.CounterVec
+}
+
+func newQueueWebhook(cfg QueueWebhookConfig, log log.Logger, reg prometheus.Registerer) *queueWebhook {
+	w := &queueWebhook{
+		cfg:              cfg,
+		log:              log,
+		client:           &http.Client{Timeout: cfg.Timeout},
+		saturatedTenants: map[string]struct{}{},
+		events:           make(chan queueEvent, queueWebhookBufferSize),
+		stopped:          make(chan struct{}),
+		errorLogLimiter:  rate.NewLimiter(rate.Every(queueWebhookErrorLogPeriod), 1),
+		eventsTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_queue_webhook_events_total",
+			Help:      "Total number of queue saturation events of the query-frontend, by outcome: sent, failed or dropped.",
+		}, []string{"outcome"}),
+	}
+	go w.run()
+	return w
+}
+
+// observe notifies the webhook if the queue of the tenant, or the whole queue, crossed its
+// high-watermark or recovered. Must be called with the frontend mtx held.
+func (w *queueWebhook) observe(userID string, queueLength, totalLength int) {
+	if w.cfg.HighWatermark > 0 {
+		if saturated, changed := crossed(w.saturated, totalLength, w.cfg.HighWatermark); changed {
+			w.saturated = saturated
+			w.notify(saturated, "", totalLength, w.cfg.HighWatermark)
+		}
+	}
+
+	if w.cfg.TenantHighWatermark > 0 {
+		_, wasSaturated := w.saturatedTenants[userID]
+		if saturated, changed := crossed(wasSaturated, queueLength, w.cfg.TenantHighWatermark); changed {
+			if saturated {
+				w.saturatedTenants[userID] = struct{}{}
+			} else {
+				delete(w.saturatedTenants, userID)
+			}
+			w.notify(saturated, userID, queueLength, w.cfg.TenantHighWatermark)
+		}
+	}
+}
+
+// crossed returns whether the queue is saturated, and whether it changed. A saturated queue only
+// recovers once it's down to half of the high-watermark, so that the webhook isn't flooded with
+// events while the queue length oscillates around it.
+func crossed(saturated bool, length, highWatermark int) (bool, bool) {
+	if !saturated && length >= highWatermark {
+		return true, true
+	}
+	if saturated && length <= highWatermark/2 {
+		return false, true
+	}
+	return saturated, false
+}
+
+func (w *queueWebhook) notify(saturated bool, userID string, length, highWatermark int) {
+	event := queueEvent{
+		Event:         queueEventRecovered,
+		Tenant:        userID,
+		QueueLength:   length,
+		HighWatermark: highWatermark,
+		Timestamp:     time.Now(),
+	}
+	if saturated {
+		event.Event = queueEventSaturated
+	}
+
+	select {
+	case w.events <- event:
+	default:
+		w.eventsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (w *queueWebhook) run() {
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case event := <-w.events:
+			if err := w.send(event); err != nil {
+				w.eventsTotal.WithLabelValues("failed").Inc()
+				if w.errorLogLimiter.Allow() {
+					level.Warn(w.log).Log("msg", "failed to send the queue event to the webhook", "event", event.Event, "tenant", event.Tenant, "err", err)
+				}
+				continue
+			}
+			w.eventsTotal.WithLabelValues("sent").Inc()
+		}
+	}
+}
+
+func (w *queueWebhook) send(event queueEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// stop stops sending the events, the pending ones are dropped.
+func (w *queueWebhook) stop() {
+	close(w.stopped)
+}
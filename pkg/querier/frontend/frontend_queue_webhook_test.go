@@ -0,0 +1,137 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestFrontend_QueueWebhook(t *testing.T) {
+	events := make(chan queueEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var event queueEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer webhook.Close()
+
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.QueueWebhook = QueueWebhookConfig{URL: webhook.URL, HighWatermark: 3, TenantHighWatermark: 2, Timeout: time.Second}
+	require.NoError(t, cfg.Validate())
+	reg := prometheus.NewPedanticRegistry()
+	f, err := New(cfg, limits{}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+	defer f.queueWebhook.stop()
+
+	queue := func(userID string) {
+		ctx := user.InjectOrgID(context.Background(), userID)
+		require.NoError(t, f.queueRequest(ctx, &request{
+			originalCtx: ctx,
+			request:     &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query?query=up"},
+			err:         make(chan error, 1),
+			response:    make(chan *httpgrpc.HTTPResponse, 1),
+		}))
+	}
+	queue("user-1")
+	queue("user-1")
+	queue("user-2")
+
+	// The queued requests of the tenant are dequeued one at a time.
+	queued, _ := f.CancelTenant("user-1")
+	require.Equal(t, 2, queued)
+
+	for _, expected := range []queueEvent{
+		{Event: queueEventSaturated, Tenant: "user-1", QueueLength: 2, HighWatermark: 2},
+		{Event: queueEventSaturated, QueueLength: 3, HighWatermark: 3},
+		{Event: queueEventRecovered, Tenant: "user-1", QueueLength: 1, HighWatermark: 2},
+		{Event: queueEventRecovered, QueueLength: 1, HighWatermark: 3},
+	} {
+		select {
+		case event := <-events:
+			assert.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+			event.Timestamp = time.Time{}
+			assert.Equal(t, expected, event)
+		case <-time.After(time.Second):
+			require.Fail(t, "event not received", "expected %v", expected)
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP cortex_query_frontend_queue_webhook_events_total Total number of queue saturation events of the query-frontend, by outcome: sent, failed or dropped.
+			# TYPE cortex_query_frontend_queue_webhook_events_total counter
+			cortex_query_frontend_queue_webhook_events_total{outcome="sent"} 4
+		`), "cortex_query_frontend_queue_webhook_events_total") == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestQueueWebhook_FailuresAndDroppedEvents(t *testing.T) {
+	unblock := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer webhook.Close()
+	defer close(unblock)
+
+	reg := prometheus.NewPedanticRegistry()
+	w := newQueueWebhook(QueueWebhookConfig{URL: webhook.URL, TenantHighWatermark: 2, Timeout: time.Minute}, log.NewNopLogger(), reg)
+	defer w.stop()
+
+	// The events beyond the buffer are dropped while the webhook is slow, without blocking.
+	w.observe("user-1", 2, 2)
+	require.Eventually(t, func() bool { return len(w.events) == 0 }, time.Second, 10*time.Millisecond)
+	for i := 0; i < queueWebhookBufferSize+10; i++ {
+		w.observe("user-1", 0, 0)
+		w.observe("user-1", 2, 2)
+	}
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_query_frontend_queue_webhook_events_total Total number of queue saturation events of the query-frontend, by outcome: sent, failed or dropped.
+		# TYPE cortex_query_frontend_queue_webhook_events_total counter
+		cortex_query_frontend_queue_webhook_events_total{outcome="dropped"} 120
+	`), "cortex_query_frontend_queue_webhook_events_total"))
+
+	// The events the webhook fails to handle are counted as failed.
+	unblock <- struct{}{}
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(w.eventsTotal.WithLabelValues("failed")) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestQueueWebhook_Crossed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		saturated         bool
+		length            int
+		expectedSaturated bool
+		expectedChanged   bool
+	}{
+		"below the high-watermark":                  {length: 9},
+		"at the high-watermark":                     {length: 10, expectedSaturated: true, expectedChanged: true},
+		"saturated above half of the watermark":     {saturated: true, length: 6, expectedSaturated: true},
+		"saturated at half of the watermark":        {saturated: true, length: 5, expectedChanged: true},
+		"saturated above the watermark":             {saturated: true, length: 20, expectedSaturated: true},
+		"not saturated above half of the watermark": {length: 6},
+	} {
+		t.Run(name, func(t *testing.T) {
+			saturated, changed := crossed(tc.saturated, tc.length, 10)
+			assert.Equal(t, tc.expectedSaturated, saturated)
+			assert.Equal(t, tc.expectedChanged, changed)
+		})
+	}
+}
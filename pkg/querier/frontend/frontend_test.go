@@ -3,6 +3,7 @@ package frontend
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -17,6 +18,7 @@ import (
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	otgrpc "github.com/opentracing-contrib/go-grpc"
 	"github.com/opentracing-contrib/go-stdlib/nethttp"
@@ -26,10 +28,10 @@ import (
 	jaeger "github.com/uber/jaeger-client-go"
 	"github.com/uber/jaeger-client-go/config"
 	"github.com/weaveworks/common/httpgrpc"
-	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
 	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/user"
 	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 
 	"github.com/cortexproject/cortex/pkg/querier"
@@ -118,59 +120,124 @@ func TestFrontendPropagateTrace(t *testing.T) {
 }
 
 func TestFrontend_RequestHostHeaderWhenDownstreamURLIsConfigured(t *testing.T) {
-	// Create an HTTP server listening locally. This server mocks the downstream
-	// Prometheus API-compatible server.
-	downstreamListen, err := net.Listen("tcp", "localhost:0")
-	require.NoError(t, err)
+	for _, preserveHost := range []bool{false, true} {
+		t.Run(fmt.Sprintf("preserve host header: %t", preserveHost), func(t *testing.T) {
+			// Create an HTTP server listening locally. This server mocks the downstream
+			// Prometheus API-compatible server.
+			downstreamListen, err := net.Listen("tcp", "localhost:0")
+			require.NoError(t, err)
 
-	observedHost := make(chan string, 2)
-	downstreamServer := http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			observedHost <- r.Host
+			observedHost := make(chan string, 2)
+			downstreamServer := http.Server{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					observedHost <- r.Host
 
-			_, err := w.Write([]byte(responseBody))
-			require.NoError(t, err)
-		}),
+					_, err := w.Write([]byte(responseBody))
+					require.NoError(t, err)
+				}),
+			}
+
+			defer downstreamServer.Shutdown(context.Background()) //nolint:errcheck
+			go downstreamServer.Serve(downstreamListen)           //nolint:errcheck
+
+			// Configure the query-frontend with the mocked downstream server.
+			config := defaultFrontendConfig()
+			config.DownstreamURL = fmt.Sprintf("http://%s", downstreamListen.Addr())
+			config.Handler.PreserveHostHeader = preserveHost
+
+			// Configure the test to send a request to the query-frontend and assert on the
+			// Host HTTP header received by the downstream server.
+			test := func(addr string) {
+				req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/%s", addr, query), nil)
+				require.NoError(t, err)
+
+				ctx := context.Background()
+				req = req.WithContext(ctx)
+				err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(ctx, "1"), req)
+				require.NoError(t, err)
+
+				client := http.Client{
+					Transport: &nethttp.Transport{},
+				}
+				resp, err := client.Do(req)
+				require.NoError(t, err)
+				require.Equal(t, 200, resp.StatusCode)
+
+				defer resp.Body.Close()
+				_, err = ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				downstreamReqHost := <-observedHost
+				if preserveHost {
+					// We expect the Host received by the downstream is the query-frontend host
+					// the client sent the request to.
+					assert.Equal(t, addr, downstreamReqHost)
+				} else {
+					// We expect the Host received by the downstream is the downstream host itself
+					// and not the query-frontend host.
+					assert.Equal(t, downstreamListen.Addr().String(), downstreamReqHost)
+					assert.NotEqual(t, downstreamReqHost, addr)
+				}
+			}
+
+			testFrontend(t, config, nil, test, false, nil)
+			testFrontend(t, config, nil, test, true, nil)
+		})
 	}
+}
 
-	defer downstreamServer.Shutdown(context.Background()) //nolint:errcheck
-	go downstreamServer.Serve(downstreamListen)           //nolint:errcheck
+func TestFrontend_TenantDownstreamURL(t *testing.T) {
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte("downstream"))
+	}))
+	defer downstream.Close()
 
-	// Configure the query-frontend with the mocked downstream server.
-	config := defaultFrontendConfig()
-	config.DownstreamURL = fmt.Sprintf("http://%s", downstreamListen.Addr())
+	querierHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("querier"))
+	})
+
+	lim := limits{
+		queryTimeout:   100 * time.Millisecond,
+		downstreamURLs: map[string]string{"heavy": downstream.URL, "heavy-2": downstream.URL},
+	}
 
-	// Configure the test to send a request to the query-frontend and assert on the
-	// Host HTTP header received by the downstream server.
 	test := func(addr string) {
-		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/%s", addr, query), nil)
-		require.NoError(t, err)
+		for _, tc := range []struct {
+			orgID          string
+			path           string
+			expectedStatus int
+			expectedBody   string
+		}{
+			{orgID: "1", path: "/", expectedStatus: http.StatusOK, expectedBody: "querier"},
+			{orgID: "heavy", path: "/", expectedStatus: http.StatusOK, expectedBody: "downstream"},
+			{orgID: "heavy|heavy-2", path: "/", expectedStatus: http.StatusOK, expectedBody: "downstream"},
+			// Tenants with different downstream URLs are handled by the queriers.
+			{orgID: "1|heavy", path: "/", expectedStatus: http.StatusOK, expectedBody: "querier"},
+			// The query timeout applies downstream.
+			{orgID: "heavy", path: "/slow", expectedStatus: http.StatusGatewayTimeout},
+		} {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://%s%s", addr, tc.path), nil)
+			require.NoError(t, err)
+			require.NoError(t, user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), tc.orgID), req))
 
-		ctx := context.Background()
-		req = req.WithContext(ctx)
-		err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(ctx, "1"), req)
-		require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
 
-		client := http.Client{
-			Transport: &nethttp.Transport{},
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "org ID: %s", tc.orgID)
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, string(body), "org ID: %s", tc.orgID)
+			}
 		}
-		resp, err := client.Do(req)
-		require.NoError(t, err)
-		require.Equal(t, 200, resp.StatusCode)
-
-		defer resp.Body.Close()
-		_, err = ioutil.ReadAll(resp.Body)
-		require.NoError(t, err)
-
-		// We expect the Host received by the downstream is the downstream host itself
-		// and not the query-frontend host.
-		downstreamReqHost := <-observedHost
-		assert.Equal(t, downstreamListen.Addr().String(), downstreamReqHost)
-		assert.NotEqual(t, downstreamReqHost, addr)
 	}
 
-	testFrontend(t, config, nil, test, false, nil)
-	testFrontend(t, config, nil, test, true, nil)
+	testFrontendWithLimits(t, defaultFrontendConfig(), lim, querierHandler, test, false, nil)
 }
 
 // TestFrontendCancel ensures that when client requests are cancelled,
@@ -206,36 +273,229 @@ func TestFrontendCancel(t *testing.T) {
 	testFrontend(t, defaultFrontendConfig(), handler, test, true, nil)
 }
 
+// TestFrontendNoRetryAfterPartialWrite ensures a request is not sent downstream again once the
+// response has started being written to the client.
+func TestFrontendNoRetryAfterPartialWrite(t *testing.T) {
+	var tries atomic.Int32
+	downstreamListen, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	downstreamServer := http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tries.Inc()
+
+			// Fail after part of the declared body, longer than the prefix buffered by the
+			// query-frontend, has been sent.
+			body := strings.Repeat("x", responsePrefixSize) + responseBody
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			_, _ = w.Write([]byte(body[:responsePrefixSize+5]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}),
+	}
+
+	defer downstreamServer.Shutdown(context.Background()) //nolint:errcheck
+	go downstreamServer.Serve(downstreamListen)           //nolint:errcheck
+
+	config := defaultFrontendConfig()
+	config.DownstreamURL = fmt.Sprintf("http://%s", downstreamListen.Addr())
+
+	test := func(addr string) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", addr), nil)
+		require.NoError(t, err)
+		err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		// The client gets the status code and a truncated body, as the error happened while streaming.
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Error(t, err)
+		assert.Equal(t, int32(1), tries.Load())
+	}
+	testFrontend(t, config, nil, test, false, nil)
+}
+
 func TestFrontendCancelStatusCode(t *testing.T) {
 	for _, test := range []struct {
-		status int
-		err    error
+		status    int
+		outcome   string
+		errorType string
+		err       error
 	}{
-		{http.StatusInternalServerError, errors.New("unknown")},
-		{http.StatusGatewayTimeout, context.DeadlineExceeded},
-		{StatusClientClosedRequest, context.Canceled},
-		{http.StatusBadRequest, httpgrpc.Errorf(http.StatusBadRequest, "")},
+		{http.StatusInternalServerError, outcomeDownstreamError, errorInternal, errors.New("unknown")},
+		{http.StatusGatewayTimeout, outcomeDeadlineExceeded, errorTimeout, context.DeadlineExceeded},
+		{StatusClientClosedRequest, outcomeCanceled, errorCanceled, context.Canceled},
+		{http.StatusBadRequest, outcomeDownstreamError, errorBadData, httpgrpc.Errorf(http.StatusBadRequest, "")},
 	} {
 		t.Run(test.err.Error(), func(t *testing.T) {
 			w := httptest.NewRecorder()
-			writeError(w, test.err)
+			h := &Handler{cfg: defaultFrontendConfig().Handler}
+			require.Equal(t, test.outcome, h.writeError(w, httptest.NewRequest("GET", "/", nil), test.err))
+			require.Equal(t, test.status, w.Result().StatusCode)
+
+			// The errors of the query API are returned in the Prometheus format.
+			w = httptest.NewRecorder()
+			require.Equal(t, test.outcome, h.writeError(w, httptest.NewRequest("GET", query, nil), test.err))
 			require.Equal(t, test.status, w.Result().StatusCode)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var body prometheusError
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, "error", body.Status)
+			assert.Equal(t, test.errorType, body.ErrorType)
+		})
+	}
+}
+
+func TestPrometheusErrorResponse_KeepsPrometheusErrors(t *testing.T) {
+	resp := &httpgrpc.HTTPResponse{
+		Code: http.StatusUnprocessableEntity,
+		Body: []byte(`{"status":"error","errorType":"execution","error":"expanding series: too many samples"}`),
+	}
+	assert.Equal(t, resp, prometheusErrorResponse(resp, outcomeDownstreamError))
+}
+
+func TestFrontendDeadlineExceededStatusCode(t *testing.T) {
+	cfg := defaultFrontendConfig().Handler
+	cfg.DeadlineExceededStatusCode = http.StatusServiceUnavailable
+	h := &Handler{cfg: cfg}
+
+	w := httptest.NewRecorder()
+	h.writeError(w, httptest.NewRequest("GET", query, nil), context.DeadlineExceeded)
+	require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+
+	// Cancellation is not affected by the custom mapping.
+	w = httptest.NewRecorder()
+	h.writeError(w, httptest.NewRequest("GET", query, nil), context.Canceled)
+	require.Equal(t, StatusClientClosedRequest, w.Result().StatusCode)
+}
+
+// TestFrontendQueryTimeout ensures the per-tenant query timeout is enforced
+// by the frontend and propagated to the querier.
+func TestFrontendQueryTimeout(t *testing.T) {
+	var querierHasDeadline, querierCancelled atomic.Bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Context().Deadline()
+		querierHasDeadline.Store(ok)
+		<-r.Context().Done()
+		querierCancelled.Store(true)
+	})
+	test := func(addr string) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s%s", addr, query), nil)
+		require.NoError(t, err)
+		err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+		require.NoError(t, err)
+
+		// The client waits for as long as it takes: the query is cancelled by the frontend.
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+		var apiErr prometheusError
+		require.NoError(t, json.Unmarshal(body, &apiErr))
+		assert.Equal(t, errorTimeout, apiErr.ErrorType)
+
+		assert.True(t, querierHasDeadline.Load())
+		assert.Eventually(t, querierCancelled.Load, time.Second, 10*time.Millisecond)
+	}
+	testFrontendWithLimits(t, defaultFrontendConfig(), limits{queryTimeout: 100 * time.Millisecond}, handler, test, false, nil)
+}
+
+func TestFrontendQueryTimeoutClosesStream(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	f, err := New(cfg, limits{queryTimeout: 100 * time.Millisecond}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := f.RoundTripGRPC(user.InjectOrgID(context.Background(), "1"), &httpgrpc.HTTPRequest{Method: "GET", Url: query})
+		errs <- err
+	}()
+
+	// The querier never answers, nor honours the deadline it's sent: the frontend still ends the
+	// stream once the query timeout elapses, which cancels the query on the querier.
+	received, unblock := make(chan struct{}), make(chan struct{})
+	defer close(unblock)
+	server := &processServer{
+		ctx: context.Background(),
+		id:  "querier-1",
+		respond: func(*httpgrpc.HTTPRequest) (*ClientToFrontend, error) {
+			close(received)
+			<-unblock
+			return nil, context.Canceled
+		},
+	}
+	require.Equal(t, context.DeadlineExceeded, f.Process(server))
+	require.Equal(t, context.DeadlineExceeded, <-errs)
+
+	<-received
+	assert.Greater(t, int64(server.received.Timeout), int64(0))
+}
+
+func TestFrontendPropagateQueryID(t *testing.T) {
+	for name, tc := range map[string]struct {
+		queryID string
+	}{
+		"query ID sent by the client": {queryID: "grafana-panel-1"},
+		"query ID generated":          {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var querierQueryID atomic.String
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				querierQueryID.Store(r.Header.Get(QueryIDHeader))
+				_, err := w.Write([]byte(responseBody))
+				require.NoError(t, err)
+			})
+			test := func(addr string) {
+				req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", addr), nil)
+				require.NoError(t, err)
+				err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+				require.NoError(t, err)
+				if tc.queryID != "" {
+					req.Header.Set(QueryIDHeader, tc.queryID)
+				}
+
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				if tc.queryID != "" {
+					assert.Equal(t, tc.queryID, querierQueryID.Load())
+				} else {
+					_, err := uuid.Parse(querierQueryID.Load())
+					assert.NoError(t, err)
+				}
+			}
+			testFrontend(t, defaultFrontendConfig(), handler, test, false, nil)
 		})
 	}
 }
 
 func TestFrontendCheckReady(t *testing.T) {
 	for _, tt := range []struct {
-		name             string
-		connectedClients int32
-		msg              string
-		readyForRequests bool
+		name                string
+		connectedClients    int32
+		minConnectedClients int
+		msg                 string
+		readyForRequests    bool
 	}{
-		{"connected clients are ready", 3, "", true},
-		{"no url, no clients is not ready", 0, "not ready: number of queriers connected to query-frontend is 0", false},
+		{"connected clients are ready", 3, 0, "", true},
+		{"no url, no clients is not ready", 0, 0, "not ready: number of queriers connected to query-frontend is 0, minimum required is 1", false},
+		{"enough connected clients are ready", 3, 3, "", true},
+		{"not enough connected clients is not ready", 2, 3, "not ready: number of queriers connected to query-frontend is 2, minimum required is 3", false},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			f := &Frontend{
+				cfg:              Config{MinConnectedClients: tt.minConnectedClients},
 				connectedClients: atomic.NewInt32(tt.connectedClients),
 				log:              log.NewNopLogger(),
 			}
@@ -378,12 +638,17 @@ func TestFrontend_ReturnsRequestBodyTooLargeError(t *testing.T) {
 		assert.NoError(t, err)
 
 		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, string(b))
+		assert.Contains(t, string(b), "the limit is 1 bytes (-frontend.max-body-size)")
 	}
 
 	testFrontend(t, config, nil, test, false, nil)
 }
 
 func testFrontend(t *testing.T, config CombinedFrontendConfig, handler http.Handler, test func(addr string), matchMaxConcurrency bool, l log.Logger) {
+	testFrontendWithLimits(t, config, limits{}, handler, test, matchMaxConcurrency, l)
+}
+
+func testFrontendWithLimits(t *testing.T, config CombinedFrontendConfig, lim Limits, handler http.Handler, test func(addr string), matchMaxConcurrency bool, l log.Logger) {
 	logger := log.NewNopLogger()
 	if l != nil {
 		logger = l
@@ -406,7 +671,7 @@ func testFrontend(t *testing.T, config CombinedFrontendConfig, handler http.Hand
 	httpListen, err := net.Listen("tcp", "localhost:0")
 	require.NoError(t, err)
 
-	rt, v1, v2, err := InitFrontend(config, limits{}, 0, logger, nil)
+	rt, v1, v2, err := InitFrontend(config, lim, 0, logger, nil)
 	require.NoError(t, err)
 	require.NotNil(t, rt)
 	// v1 will be nil if DownstreamURL is defined.
@@ -428,18 +693,19 @@ func testFrontend(t *testing.T, config CombinedFrontendConfig, handler http.Hand
 	r.PathPrefix("/").Handler(middleware.Merge(
 		middleware.AuthenticateUser,
 		middleware.Tracer{},
-	).Wrap(NewHandler(config.Handler, rt, logger)))
+	).Wrap(NewHandler(config.Handler, lim, rt, logger, nil)))
 
 	httpServer := http.Server{
 		Handler: r,
 	}
+	config.Handler.HTTPServer.Apply(&httpServer, nil)
 	defer httpServer.Shutdown(context.Background()) //nolint:errcheck
 
 	go httpServer.Serve(httpListen) //nolint:errcheck
 	go grpcServer.Serve(grpcListen) //nolint:errcheck
 
 	var worker services.Service
-	worker, err = NewWorker(workerConfig, querierConfig, httpgrpc_server.NewServer(handler), logger)
+	worker, err = NewWorker(workerConfig, querierConfig, handler, logger)
 	require.NoError(t, err)
 	require.NoError(t, services.StartAndAwaitRunning(context.Background(), worker))
 
@@ -458,9 +724,52 @@ func defaultFrontendConfig() CombinedFrontendConfig {
 }
 
 type limits struct {
-	queriers int
+	queriers     float64
+	minQueriers  int
+	queryTimeout time.Duration
+	queryRate    rate.Limit
+	queryBurst   int
+	concurrency  int
+
+	queryBudget float64
+
+	// Downstream URL and weight of each tenant.
+	downstreamURLs map[string]string
+	weights        map[string]float64
 }
 
-func (l limits) MaxQueriersPerUser(_ string) int {
+func (l limits) MaxQueriersPerUser(_ string) float64 {
 	return l.queriers
 }
+
+func (l limits) MinQueriersPerUser(_ string) int {
+	return l.minQueriers
+}
+
+func (l limits) QueryTimeout(_ string) time.Duration {
+	return l.queryTimeout
+}
+
+func (l limits) QueryRate(_ string) rate.Limit {
+	return l.queryRate
+}
+
+func (l limits) QueryBurst(_ string) int {
+	return l.queryBurst
+}
+
+func (l limits) MaxConcurrentQueries(_ string) int {
+	return l.concurrency
+}
+
+func (l limits) DownstreamURL(user string) string {
+	return l.downstreamURLs[user]
+}
+
+func (l limits) QueryBudget(_ string) float64 {
+	return l.queryBudget
+}
+
+func (l limits) QueryWeight(user string) float64 {
+	return l.weights[user]
+}
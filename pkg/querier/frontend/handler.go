@@ -1,44 +1,187 @@
 package frontend
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/httpgrpc/server"
+	"github.com/weaveworks/common/user"
 
+	"github.com/cortexproject/cortex/pkg/tenant"
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/limiter"
 )
 
 const (
 	// StatusClientClosedRequest is the status code for when a client request cancellation of an http request
 	StatusClientClosedRequest = 499
+
+	retryAfterHeader = "Retry-After"
+
+	// Outcomes of the queries forwarded downstream.
+	outcomeServed           = "served"
+	outcomeCanceled         = "canceled"
+	outcomeDeadlineExceeded = "deadline_exceeded"
+	outcomeDownstreamError  = "downstream_error"
+
+	// Below this max body size, legit queries sent with POST are likely to be rejected.
+	minRecommendedMaxBodySize = 4 * 1024
 )
 
 var (
-	errCanceled              = httpgrpc.Errorf(StatusClientClosedRequest, context.Canceled.Error())
-	errDeadlineExceeded      = httpgrpc.Errorf(http.StatusGatewayTimeout, context.DeadlineExceeded.Error())
-	errRequestEntityTooLarge = httpgrpc.Errorf(http.StatusRequestEntityTooLarge, "http: request body too large")
+	errCanceled            = httpgrpc.Errorf(StatusClientClosedRequest, context.Canceled.Error())
+	errCancelledByOperator = httpgrpc.Errorf(http.StatusServiceUnavailable, "query cancelled by an operator")
+	errShuttingDown        = httpgrpc.Errorf(http.StatusServiceUnavailable, "the query-frontend is shutting down")
 )
 
 // Config for a Handler.
 type HandlerConfig struct {
 	LogQueriesLongerThan time.Duration `yaml:"log_queries_longer_than"`
 	MaxBodySize          int64         `yaml:"max_body_size"`
+	MaxBodySizeStrict    bool          `yaml:"max_body_size_strict"`
+	MaxResponseSize      int64         `yaml:"max_response_size"`
+	DefaultRetryAfter    time.Duration `yaml:"default_retry_after"`
+	CoalesceInFlight     bool          `yaml:"coalesce_in_flight"`
+	MetricsByTenant      bool          `yaml:"metrics_by_tenant"`
+	PreserveHostHeader   bool          `yaml:"preserve_host_header"`
+	PathPrefix           string        `yaml:"path_prefix"`
+
+	DownstreamHeaders         map[string]string `yaml:"downstream_headers" doc:"nocli|description=Static headers added to the requests sent to the downstream URL or to the queriers, eg. for authentication or routing. Header values are not included in the slow queries log."`
+	OverrideDownstreamHeaders bool              `yaml:"override_downstream_headers"`
+
+	StripRequestHeaders  flagext.StringSliceCSV `yaml:"strip_request_headers"`
+	ForwardHeaders       flagext.StringSliceCSV `yaml:"forward_headers"`
+	StripUnlistedHeaders bool                   `yaml:"strip_unlisted_headers"`
+	PassthroughPaths     flagext.StringSliceCSV `yaml:"passthrough_paths"`
+
+	DeadlineExceededStatusCode int  `yaml:"deadline_exceeded_status_code"`
+	QueryTimeoutParam          bool `yaml:"query_timeout_param_enabled"`
+
+	ConvertLongGETsToPOST bool `yaml:"convert_long_gets_to_post"`
+	LongGETQueryLength    int  `yaml:"long_get_query_length"`
+
+	OrgIDValidation     OrgIDValidationConfig      `yaml:",inline"`
+	CORS                CORSConfig                 `yaml:",inline"`
+	Auth                AuthConfig                 `yaml:",inline"`
+	TenantResolver      TenantResolverConfig       `yaml:",inline"`
+	TenantLabels        TenantLabelsConfig         `yaml:",inline"`
+	DownstreamTransport DownstreamTransportConfig  `yaml:",inline"`
+	DownstreamHTTP2     DownstreamHTTP2Config      `yaml:",inline"`
+	BuildInfo           BuildInfoConfig            `yaml:",inline"`
+	ETag                ETagConfig                 `yaml:",inline"`
+	QueryID             QueryIDConfig              `yaml:",inline"`
+	HTTPServer          HTTPServerConfig           `yaml:",inline"`
+	Historical          HistoricalDownstreamConfig `yaml:",inline"`
+	Timeouts            EndpointTimeoutsConfig     `yaml:"timeouts"`
 }
 
 func (cfg *HandlerConfig) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.LogQueriesLongerThan, "frontend.log-queries-longer-than", 0, "Log queries that are slower than the specified duration. Set to 0 to disable. Set to < 0 to enable on all queries.")
 	f.Int64Var(&cfg.MaxBodySize, "frontend.max-body-size", 10*1024*1024, "Max body size for downstream prometheus.")
+	f.BoolVar(&cfg.MaxBodySizeStrict, "frontend.max-body-size-strict", false, fmt.Sprintf("Refuse to start if -frontend.max-body-size is lower than %d bytes, instead of logging a warning.", minRecommendedMaxBodySize))
+	f.Int64Var(&cfg.MaxResponseSize, "frontend.max-response-size", 0, "Max size, in bytes, of a response returned by the downstream. Responses larger than this are rejected with HTTP 413 if their size is known upfront, otherwise they're truncated. 0 to disable.")
+	f.DurationVar(&cfg.DefaultRetryAfter, "frontend.default-retry-after", 5*time.Second, "Value of the Retry-After header set on HTTP 429 and 503 responses, unless a more accurate value is known. 0 to disable.")
+	f.BoolVar(&cfg.CoalesceInFlight, "frontend.coalesce-in-flight", false, "Coalesce concurrent identical queries of the same tenant, sent with GET or a POST form, so that only one of them is forwarded downstream and all of them get the same response or error. Requests with Cache-Control: no-store aren't coalesced. Responses of coalesced requests are buffered in memory.")
+	f.BoolVar(&cfg.MetricsByTenant, "frontend.metrics-by-tenant", true, "Label the query-frontend requests metrics by tenant. Disable it to reduce the metrics cardinality when running with many tenants.")
+	f.BoolVar(&cfg.PreserveHostHeader, "frontend.preserve-host-header", false, "When the downstream URL is configured, forward the Host header of the client request to the downstream, instead of setting it to the downstream host.")
+	f.StringVar(&cfg.PathPrefix, "frontend.path-prefix", "", "Path prefix the query-frontend is hosted under, eg. /prometheus when served at https://host/prometheus/ behind a proxy which may or may not strip it. The prefix is stripped from the requests arriving with it, before they're routed and forwarded.")
+	f.BoolVar(&cfg.OverrideDownstreamHeaders, "frontend.override-downstream-headers", false, "Whether the configured downstream headers replace the headers with the same name in the client request. By default the headers of the client request take precedence.")
+	f.Var(&cfg.StripRequestHeaders, "frontend.strip-request-headers", "Comma-separated list of headers of the client request which are not forwarded to the downstream URL or to the queriers. A trailing * matches all the headers with the given prefix, eg. X-Internal-*. The tenant ID, query ID and Content-Type headers are always forwarded.")
+	f.Var(&cfg.ForwardHeaders, "frontend.forward-headers", "Comma-separated list of headers of the client request which are always forwarded to the downstream URL or to the queriers, including by the sub-queries which don't keep the headers of the original request, eg. Accept-Language. A trailing * matches all the headers with the given prefix. They take precedence over -frontend.strip-request-headers.")
+	f.BoolVar(&cfg.StripUnlistedHeaders, "frontend.strip-unlisted-headers", false, "Strip all the headers of the client request not listed in -frontend.forward-headers, except the tenant ID, query ID and Content-Type headers.")
+	f.Var(&cfg.PassthroughPaths, "frontend.passthrough-paths", "Comma-separated list of the path prefixes, or regular expressions if starting with ^, of the requests forwarded as is to the queriers or to the downstream URL, without splitting, caching nor parsing them, eg. /api/v1/admin/tsdb/. The requests are still authenticated, logged and limited.")
+	f.IntVar(&cfg.DeadlineExceededStatusCode, "frontend.deadline-exceeded-status-code", http.StatusGatewayTimeout, "HTTP status code returned when a query times out.")
+	f.BoolVar(&cfg.QueryTimeoutParam, "frontend.query-timeout-param-enabled", false, "Apply the deadline requested by the timeout parameter of the queries, clamped to -frontend.query-timeout, to the queries forwarded downstream. The queries with an invalid timeout parameter are rejected with HTTP 400.")
+	f.BoolVar(&cfg.ConvertLongGETsToPOST, "frontend.convert-long-gets-to-post", false, "Send the GET requests of the query, query_range, series and labels endpoints whose query string is longer than -frontend.long-get-query-length to the downstream or to the queriers as POST requests, with the parameters in the form body, as they may reject long URLs. The client isn't affected.")
+	f.IntVar(&cfg.LongGETQueryLength, "frontend.long-get-query-length", 4096, "Length, in bytes, of the query string from which the GET requests are converted to POST, when -frontend.convert-long-gets-to-post is enabled.")
+	cfg.OrgIDValidation.RegisterFlags(f)
+	cfg.CORS.RegisterFlags(f)
+	cfg.Auth.RegisterFlags(f)
+	cfg.TenantResolver.RegisterFlags(f)
+	cfg.TenantLabels.RegisterFlags(f)
+	cfg.DownstreamTransport.RegisterFlags(f)
+	cfg.DownstreamHTTP2.RegisterFlags(f)
+	cfg.BuildInfo.RegisterFlags(f)
+	cfg.ETag.RegisterFlags(f)
+	cfg.QueryID.RegisterFlags(f)
+	cfg.HTTPServer.RegisterFlags(f)
+	cfg.Historical.RegisterFlags(f)
+	cfg.Timeouts.RegisterFlags(f)
+}
+
+func (cfg *HandlerConfig) Validate() error {
+	if cfg.DeadlineExceededStatusCode < 100 || cfg.DeadlineExceededStatusCode > 599 {
+		return fmt.Errorf("invalid -frontend.deadline-exceeded-status-code %d: must be a valid HTTP status code", cfg.DeadlineExceededStatusCode)
+	}
+	if cfg.MaxBodySize <= 0 {
+		return fmt.Errorf("invalid -frontend.max-body-size %d: must be positive, otherwise all the requests with a body are rejected", cfg.MaxBodySize)
+	}
+	if cfg.MaxBodySizeStrict && cfg.MaxBodySize < minRecommendedMaxBodySize {
+		return fmt.Errorf("invalid -frontend.max-body-size %d: must be at least %d bytes when -frontend.max-body-size-strict is enabled", cfg.MaxBodySize, minRecommendedMaxBodySize)
+	}
+	if cfg.PathPrefix != "" && (!strings.HasPrefix(cfg.PathPrefix, "/") || strings.HasSuffix(cfg.PathPrefix, "/")) {
+		return fmt.Errorf("invalid -frontend.path-prefix %q: must start with a / and not end with one", cfg.PathPrefix)
+	}
+	if _, err := NewPassthroughPaths(*cfg); err != nil {
+		return err
+	}
+	if cfg.MaxResponseSize < 0 {
+		return fmt.Errorf("invalid -frontend.max-response-size %d: must not be negative, 0 to disable", cfg.MaxResponseSize)
+	}
+	if cfg.ConvertLongGETsToPOST && cfg.LongGETQueryLength < 1 {
+		return fmt.Errorf("invalid -frontend.long-get-query-length %d: must be positive when -frontend.convert-long-gets-to-post is enabled", cfg.LongGETQueryLength)
+	}
+	if cfg.DefaultRetryAfter < 0 {
+		return fmt.Errorf("invalid -frontend.default-retry-after %s: must not be negative, 0 to disable", cfg.DefaultRetryAfter)
+	}
+	if err := cfg.DownstreamTransport.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.DownstreamHTTP2.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Auth.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.TenantResolver.Validate(); err != nil {
+		return err
+	}
+	if cfg.TenantResolver.JWKSURL != "" && cfg.Auth.enabled() {
+		return fmt.Errorf("-frontend.jwt.jwks-url can't be configured along with the -frontend.auth credentials, as both are read from the Authorization header")
+	}
+	if err := cfg.TenantLabels.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.QueryID.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.HTTPServer.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Historical.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Timeouts.Validate(); err != nil {
+		return err
+	}
+	return cfg.OrgIDValidation.Validate()
 }
 
 // Handler accepts queries and forwards them to RoundTripper. It can log slow queries,
@@ -47,14 +190,96 @@ type Handler struct {
 	cfg          HandlerConfig
 	log          log.Logger
 	roundTripper http.RoundTripper
+
+	// Nil if the per-tenant limits aren't enforced.
+	limits Limits
+
+	// Per-tenant query rate limiter, local to this replica. Nil if limits aren't enforced.
+	queryLimiter *limiter.RateLimiter
+
+	// Lowercase names, or prefixes if ending with *, of the request headers not forwarded.
+	stripHeaders   []string
+	forwardHeaders []string
+
+	// Nil if the tenant IDs aren't validated.
+	orgIDValidator *orgIDValidator
+
+	// Nil if authentication is disabled.
+	authenticator *authenticator
+
+	tenantLabeler *tenantLabeler
+
+	// Nil if the shutdown grace period is disabled.
+	drainer *drainer
+
+	// Metrics.
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryOutcomes   *prometheus.CounterVec
+	queryRange      *prometheus.HistogramVec
+	queryStep       *prometheus.HistogramVec
 }
 
-// New creates a new frontend handler.
-func NewHandler(cfg HandlerConfig, roundTripper http.RoundTripper, log log.Logger) http.Handler {
+// New creates a new frontend handler. The per-tenant query rate limits aren't enforced if limits is nil.
+func NewHandler(cfg HandlerConfig, limits Limits, roundTripper http.RoundTripper, log log.Logger, reg prometheus.Registerer) *Handler {
+	if cfg.MaxBodySize < minRecommendedMaxBodySize {
+		level.Warn(log).Log("msg", "the max body size is low and legit queries sent with POST may be rejected", "max_body_size", cfg.MaxBodySize, "recommended_min", minRecommendedMaxBodySize)
+	}
+
+	if cfg.CoalesceInFlight {
+		roundTripper = newCoalescingRoundTripper(roundTripper)
+	}
+
+	var queryLimiter *limiter.RateLimiter
+	if limits != nil {
+		queryLimiter = limiter.NewRateLimiter(newQueryRateStrategy(limits), queryRateRecheckPeriod)
+	}
+
+	var drainer *drainer
+	if cfg.HTTPServer.ShutdownGracePeriod > 0 {
+		drainer = newDrainer()
+	}
+
 	return &Handler{
-		cfg:          cfg,
-		log:          log,
-		roundTripper: roundTripper,
+		cfg:            cfg,
+		log:            log,
+		roundTripper:   roundTripper,
+		limits:         limits,
+		queryLimiter:   queryLimiter,
+		stripHeaders:   lowerAll(cfg.StripRequestHeaders),
+		forwardHeaders: lowerAll(cfg.ForwardHeaders),
+		orgIDValidator: newOrgIDValidator(cfg.OrgIDValidation),
+		authenticator:  newAuthenticator(cfg.Auth, log),
+		tenantLabeler:  newTenantLabeler(cfg.TenantLabels),
+		drainer:        drainer,
+		requestsTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_requests_total",
+			Help:      "Total number of requests received by the query-frontend.",
+		}, []string{"user", "method", "status"}),
+		requestDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_request_duration_seconds",
+			Help:      "Time spent by the query-frontend serving requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"user", "method", "status"}),
+		queryOutcomes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_query_outcomes_total",
+			Help:      "Total number of queries forwarded downstream by the query-frontend, by outcome: served, canceled by the client, deadline exceeded or downstream error.",
+		}, []string{"user", "outcome"}),
+		queryRange: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_query_range_seconds",
+			Help:      "Time range, end minus start, of the range queries received by the query-frontend.",
+			Buckets:   queryRangeBuckets,
+		}, []string{"user"}),
+		queryStep: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_query_step_seconds",
+			Help:      "Step of the range queries received by the query-frontend.",
+			Buckets:   queryStepBuckets,
+		}, []string{"user"}),
 	}
 }
 
@@ -63,17 +288,148 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		_ = r.Body.Close()
 	}()
 
+	// Measured before the path prefix is stripped from the request URI.
+	headerSize := requestHeaderSize(r)
+
+	// The proxy in front of the query-frontend may or may not have stripped the prefix.
+	if f.cfg.PathPrefix != "" {
+		r = stripPathPrefix(r, f.cfg.PathPrefix)
+	}
+
+	// Preflight requests are answered before the request is tracked, limited or forwarded,
+	// and they don't carry the tenant ID.
+	if f.cfg.CORS.handleCORS(w, r) {
+		return
+	}
+
+	if f.authenticator != nil && !f.authenticator.allows(r) {
+		writeUnauthorized(w)
+		return
+	}
+
+	sw := &statusRecordingWriter{ResponseWriter: w, status: http.StatusOK}
+	defer f.observeRequest(r, sw, time.Now())
+	w = sw
+
+	if limit := f.cfg.HTTPServer.MaxRequestHeaderBytes; limit > 0 && headerSize > limit {
+		f.writeError(w, r, httpgrpc.Errorf(http.StatusRequestHeaderFieldsTooLarge, "request headers too large: %d bytes, the limit is %d bytes (-frontend.max-request-header-bytes), send the long queries with POST instead", headerSize, limit))
+		return
+	}
+
+	if f.drainer != nil {
+		if !f.drainer.acquire() {
+			w.Header().Set("Connection", "close")
+			f.writeError(w, r, errShuttingDown)
+			return
+		}
+		defer f.drainer.release()
+	}
+
+	queryID := r.Header.Get(f.cfg.QueryID.Header)
+	if queryID == "" {
+		queryID = newQueryID(f.cfg.QueryID.Format)
+		r.Header.Set(f.cfg.QueryID.Header, queryID)
+	}
+	r.Header.Set(QueryIDHeader, queryID)
+	w.Header().Set(f.cfg.QueryID.Header, queryID)
+	if span := opentracing.SpanFromContext(r.Context()); span != nil {
+		span.SetTag("query_id", queryID)
+	}
+	r = r.WithContext(injectQueryID(r.Context(), queryID))
+
+	normalized, err := normalizeOrgID(r, f.orgIDValidator, f.log)
+	if err != nil {
+		f.writeError(w, r, err)
+		return
+	}
+	r = normalized
+
+	if err := f.checkQueryRate(r); err != nil {
+		f.writeError(w, r, err)
+		return
+	}
+
 	// Buffer the body for later use to track slow queries.
 	var buf bytes.Buffer
 	r.Body = http.MaxBytesReader(w, r.Body, f.cfg.MaxBodySize)
 	r.Body = ioutil.NopCloser(io.TeeReader(r.Body, &buf))
 
+	f.observeQueryRange(r)
+
+	if timeout := f.cfg.Timeouts.timeout(r); timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	if f.cfg.QueryTimeoutParam {
+		withTimeout, cancel, err := withQueryTimeout(r, f.limits)
+		if err != nil {
+			f.writeError(w, r, err)
+			return
+		}
+		defer cancel()
+		r = withTimeout
+	}
+
+	f.stripRequestHeaders(r.Header)
+	if forwarded := f.forwardedHeaders(r.Header); len(forwarded) > 0 {
+		r = r.WithContext(injectForwardedHeaders(r.Context(), forwarded))
+	}
+
 	startTime := time.Now()
 	resp, err := f.roundTripper.RoundTrip(r)
 	queryResponseTime := time.Since(startTime)
 
 	if err != nil {
-		writeError(w, err)
+		f.observeOutcome(r, f.writeError(w, r, err))
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if f.cfg.MaxResponseSize > 0 && resp.ContentLength > f.cfg.MaxResponseSize {
+		f.observeOutcome(r, f.writeError(w, r, httpgrpc.Errorf(http.StatusRequestEntityTooLarge, "response size (%d bytes) exceeds the max response size (%d bytes)", resp.ContentLength, f.cfg.MaxResponseSize)))
+		return
+	}
+
+	// Stream the response body to the client as it arrives, counting the bytes written
+	// instead of buffering the whole response.
+	cw := &countingWriter{w: w}
+	var src io.Reader = resp.Body
+	if f.cfg.MaxResponseSize > 0 {
+		// The size of the response may not be known upfront, so enforce the limit while streaming too.
+		src = &maxBytesReader{r: resp.Body, remaining: f.cfg.MaxResponseSize, limit: f.cfg.MaxResponseSize}
+	}
+
+	// The label values responses are buffered to compute their ETag, and not sent at all when
+	// the client already has them.
+	if f.cfg.ETag.LabelValuesETag && resp.StatusCode == http.StatusOK && isLabelValuesRequest(r) {
+		payload, err := ioutil.ReadAll(src)
+		if err != nil {
+			f.observeOutcome(r, f.writeError(w, r, err))
+			return
+		}
+
+		etag := weakETag(payload)
+		resp.Header.Set("ETag", etag)
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			f.observeOutcome(r, outcomeServed)
+			f.reportSlowQuery(queryResponseTime, 0, r, buf)
+			return
+		}
+		src = bytes.NewReader(payload)
+	}
+	body := bufio.NewReaderSize(src, responsePrefixSize)
+
+	// Wait for the beginning of the body before writing the status code, so that errors
+	// occurring before anything has been sent can still be mapped to the proper status code.
+	prefix, err := body.Peek(responsePrefixSize)
+	if err != nil && err != io.EOF {
+		f.observeOutcome(r, f.writeError(w, r, err))
 		return
 	}
 
@@ -81,16 +437,30 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	for h, vs := range resp.Header {
 		hs[h] = vs
 	}
+	// The downstream may not send the length of the response, eg. with a chunked transfer
+	// encoding. It's known when the whole response fits in the prefix, and the compression
+	// of the responses depends on it.
+	if err == io.EOF && hs.Get("Content-Length") == "" {
+		hs.Set("Content-Length", strconv.Itoa(len(prefix)))
+	}
 
 	w.WriteHeader(resp.StatusCode)
-	// we don't check for copy error as there is no much we can do at this point
-	_, _ = io.Copy(w, resp.Body)
 
-	f.reportSlowQuery(queryResponseTime, r, buf)
+	// Once streaming has begun there's no way to report an error to the client anymore, nor
+	// to send the request downstream again, as the client would get a corrupted response.
+	if _, err := io.Copy(cw, body); err != nil {
+		level.Warn(util.WithContext(r.Context(), f.log)).Log("msg", "response body truncated while streaming to the client", "bytes_written", cw.count, "err", err)
+		outcome, _ := f.classifyError(err)
+		f.observeOutcome(r, outcome)
+	} else {
+		f.observeOutcome(r, outcomeServed)
+	}
+
+	f.reportSlowQuery(queryResponseTime, cw.count, r, buf)
 }
 
 // reportSlowQuery reports slow queries if LogQueriesLongerThan is set to <0, where 0 disables logging
-func (f *Handler) reportSlowQuery(queryResponseTime time.Duration, r *http.Request, bodyBuf bytes.Buffer) {
+func (f *Handler) reportSlowQuery(queryResponseTime time.Duration, responseSize int64, r *http.Request, bodyBuf bytes.Buffer) {
 	if f.cfg.LogQueriesLongerThan == 0 || queryResponseTime <= f.cfg.LogQueriesLongerThan {
 		return
 	}
@@ -100,7 +470,9 @@ func (f *Handler) reportSlowQuery(queryResponseTime time.Duration, r *http.Reque
 		"method", r.Method,
 		"host", r.Host,
 		"path", r.URL.Path,
+		"query_id", extractQueryID(r.Context()),
 		"time_taken", queryResponseTime.String(),
+		"response_size_bytes", responseSize,
 	}
 
 	// use previously buffered body
@@ -120,18 +492,271 @@ func (f *Handler) reportSlowQuery(queryResponseTime time.Duration, r *http.Reque
 	level.Info(util.WithContext(r.Context(), f.log)).Log(logMessage...)
 }
 
-func writeError(w http.ResponseWriter, err error) {
+// stripPathPrefix returns the request without the prefix of its path, if it has it, so that the
+// path is forwarded as if the request was sent to the query-frontend directly.
+func stripPathPrefix(r *http.Request, prefix string) *http.Request {
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	if path == r.URL.Path || (path != "" && path[0] != '/') {
+		return r
+	}
+	if path == "" {
+		path = "/"
+	}
+	stripped := r.Clone(r.Context())
+	stripped.URL.Path = path
+	stripped.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	stripped.RequestURI = stripped.URL.RequestURI()
+	return stripped
+}
+
+// checkQueryRate returns an error if the tenant exceeded its query rate limit. A query spanning
+// multiple tenants counts against the query rate limit of each of them.
+func (f *Handler) checkQueryRate(r *http.Request) error {
+	if f.queryLimiter == nil {
+		return nil
+	}
+
+	tenantIDs, err := tenant.TenantIDs(r.Context())
+	if err != nil {
+		// Requests without a tenant are rejected downstream.
+		return nil
+	}
+
+	now := time.Now()
+	for _, tenantID := range tenantIDs {
+		if f.queryLimiter.AllowN(now, tenantID, 1) {
+			continue
+		}
+
+		// A new query is allowed as soon as the bucket is refilled with one token.
+		limit := f.queryLimiter.Limit(now, tenantID)
+		msg := fmt.Sprintf("query rate limit (%v queries/s) exceeded", limit)
+		if len(tenantIDs) > 1 {
+			msg = fmt.Sprintf("query rate limit (%v queries/s) of tenant %s exceeded", limit, tenantID)
+		}
+		return httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+			Code:    http.StatusTooManyRequests,
+			Body:    []byte(msg),
+			Headers: []*httpgrpc.Header{{Key: retryAfterHeader, Values: []string{formatRetryAfter(time.Duration(float64(time.Second) / limit))}}},
+		})
+	}
+	return nil
+}
+
+// observeRequest tracks the request in the metrics, once the response has been written.
+func (f *Handler) observeRequest(r *http.Request, w *statusRecordingWriter, startTime time.Time) {
+	userID := f.userLabel(r)
+	status := fmt.Sprintf("%dxx", w.status/100)
+	f.requestsTotal.WithLabelValues(userID, r.Method, status).Inc()
+	f.requestDuration.WithLabelValues(userID, r.Method, status).Observe(time.Since(startTime).Seconds())
+}
+
+// observeOutcome tracks the outcome of a query forwarded downstream.
+func (f *Handler) observeOutcome(r *http.Request, outcome string) {
+	f.queryOutcomes.WithLabelValues(f.userLabel(r), outcome).Inc()
+}
+
+// userLabel returns the value of the user label of the metrics tracking the request.
+func (f *Handler) userLabel(r *http.Request) string {
+	if !f.cfg.MetricsByTenant {
+		return ""
+	}
+
+	// The tenant is left empty if missing, so that the request is tracked anyway.
+	userID, _ := user.ExtractOrgID(r.Context())
+	if normalized, err := tenant.NormalizeOrgID(userID); err == nil {
+		userID = normalized
+	}
+	return f.tenantLabeler.label(userID)
+}
+
+// classifyError returns the outcome of a query which failed with the given error, and the
+// error mapped to the HTTP status code returned to the client.
+func (f *Handler) classifyError(err error) (string, error) {
 	switch err {
 	case context.Canceled:
-		err = errCanceled
+		return outcomeCanceled, errCanceled
 	case context.DeadlineExceeded:
-		err = errDeadlineExceeded
-	default:
-		if strings.Contains(err.Error(), "http: request body too large") {
-			err = errRequestEntityTooLarge
+		return outcomeDeadlineExceeded, httpgrpc.Errorf(f.cfg.DeadlineExceededStatusCode, context.DeadlineExceeded.Error())
+	}
+
+	if strings.Contains(err.Error(), "http: request body too large") {
+		return outcomeDownstreamError, httpgrpc.Errorf(http.StatusRequestEntityTooLarge, "http: request body too large, the limit is %d bytes (-frontend.max-body-size)", f.cfg.MaxBodySize)
+	}
+	return outcomeDownstreamError, err
+}
+
+// writeError writes the error to the client, and returns the outcome of the query.
+func (f *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) string {
+	outcome, err := f.classifyError(err)
+
+	// Writing the error once the response has started would corrupt it.
+	if sw, ok := w.(*statusRecordingWriter); ok && sw.started {
+		level.Warn(f.log).Log("msg", "error not reported to the client, as the response has already started", "err", err)
+		return outcome
+	}
+
+	apiRequest := isQueryAPIRequest(r)
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	if !ok {
+		if !apiRequest {
+			server.WriteError(w, err)
+			return outcome
+		}
+		resp = &httpgrpc.HTTPResponse{Code: http.StatusInternalServerError, Body: []byte(err.Error())}
+	}
+	// The Prometheus clients expect the errors of the query API in the Prometheus format.
+	if apiRequest {
+		resp = prometheusErrorResponse(resp, outcome)
+	}
+
+	// Hint well-behaved clients to back off, unless the error already carries a more accurate value.
+	if (resp.Code == http.StatusTooManyRequests || resp.Code == http.StatusServiceUnavailable) && f.cfg.DefaultRetryAfter > 0 && !hasHeader(resp.Headers, retryAfterHeader) {
+		resp.Headers = append(resp.Headers, &httpgrpc.Header{Key: retryAfterHeader, Values: []string{formatRetryAfter(f.cfg.DefaultRetryAfter)}})
+	}
+	server.WriteResponse(w, resp)
+	return outcome
+}
+
+// stripRequestHeaders removes the configured headers, or all the headers not forwarded, except the
+// ones needed to route the request to the tenant, to track it and to read its body.
+func (f *Handler) stripRequestHeaders(headers http.Header) {
+	if len(f.stripHeaders) == 0 && !f.cfg.StripUnlistedHeaders {
+		return
+	}
+
+	for name := range headers {
+		canonical := http.CanonicalHeaderKey(name)
+		if canonical == http.CanonicalHeaderKey(user.OrgIDHeaderName) || canonical == http.CanonicalHeaderKey(QueryIDHeader) || canonical == http.CanonicalHeaderKey(f.cfg.QueryID.Header) || canonical == "Content-Type" {
+			continue
 		}
+
+		lower := strings.ToLower(name)
+		if matchesHeader(lower, f.forwardHeaders) {
+			continue
+		}
+		if f.cfg.StripUnlistedHeaders || matchesHeader(lower, f.stripHeaders) {
+			delete(headers, name)
+		}
+	}
+}
+
+// forwardedHeaders returns the headers of the request which are always forwarded.
+func (f *Handler) forwardedHeaders(headers http.Header) http.Header {
+	if len(f.forwardHeaders) == 0 {
+		return nil
+	}
+
+	forwarded := http.Header{}
+	for name, values := range headers {
+		if matchesHeader(strings.ToLower(name), f.forwardHeaders) {
+			forwarded[name] = values
+		}
+	}
+	return forwarded
+}
+
+// matchesHeader returns whether the lowercase header name matches one of the lowercase names, a
+// trailing * matching all the headers with the given prefix.
+func matchesHeader(lower string, names []string) bool {
+	for _, name := range names {
+		if lower == name || (strings.HasSuffix(name, "*") && strings.HasPrefix(lower, strings.TrimSuffix(name, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerAll(values []string) []string {
+	lower := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			lower = append(lower, strings.ToLower(v))
+		}
+	}
+	return lower
+}
+
+func hasHeader(headers []*httpgrpc.Header, name string) bool {
+	for _, h := range headers {
+		if http.CanonicalHeaderKey(h.Key) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// formatRetryAfter formats the duration as a number of seconds, as expected by the Retry-After header.
+func formatRetryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// statusRecordingWriter records the status code written to the wrapped writer, and whether
+// the response has started being written.
+type statusRecordingWriter struct {
+	http.ResponseWriter
+	status  int
+	started bool
+}
+
+func (s *statusRecordingWriter) WriteHeader(statusCode int) {
+	s.status = statusCode
+	s.started = true
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (s *statusRecordingWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.ResponseWriter.Write(p)
+}
+
+// Size of the prefix of the response bodies buffered before writing the response, larger than the
+// minimum size of the compressed responses.
+const responsePrefixSize = 4096
+
+// countingWriter counts the bytes successfully written to the wrapped writer.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// maxBytesReader is similar to http.MaxBytesReader, but for response bodies: it fails
+// once more than limit bytes have been read from the wrapped reader.
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, m.tooLarge()
+	}
+
+	// Read one byte more than remaining, to detect whether the response exceeds the limit.
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
 	}
-	server.WriteError(w, err)
+
+	n, err := m.r.Read(p)
+	if int64(n) <= m.remaining {
+		m.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(m.remaining)
+	m.remaining = -1
+	return n, m.tooLarge()
+}
+
+func (m *maxBytesReader) tooLarge() error {
+	return httpgrpc.Errorf(http.StatusRequestEntityTooLarge, "response exceeds the max response size (%d bytes)", m.limit)
 }
 
 // GrpcRoundTripper is similar to http.RoundTripper, but works with HTTP requests converted to protobuf messages.
@@ -160,9 +785,10 @@ func (a *grpcRoundTripperAdapter) RoundTrip(r *http.Request) (*http.Response, er
 	}
 
 	httpResp := &http.Response{
-		StatusCode: int(resp.Code),
-		Body:       ioutil.NopCloser(bytes.NewReader(resp.Body)),
-		Header:     http.Header{},
+		StatusCode:    int(resp.Code),
+		Body:          ioutil.NopCloser(bytes.NewReader(resp.Body)),
+		Header:        http.Header{},
+		ContentLength: int64(len(resp.Body)),
 	}
 	for _, h := range resp.Headers {
 		httpResp.Header[h.Key] = h.Values
@@ -0,0 +1,851 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// errReader returns the configured data first, and then fails with the configured error.
+type errReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func defaultHandlerConfig() HandlerConfig {
+	cfg := defaultFrontendConfig()
+	return cfg.Handler
+}
+
+func TestHandler_StreamsResponseBody(t *testing.T) {
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+
+	var buf syncBuf
+	cfg := defaultHandlerConfig()
+	cfg.LogQueriesLongerThan = -1
+	h := NewHandler(cfg, nil, rt, log.NewLogfmtLogger(&buf), nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, responseBody, w.Body.String())
+	assert.Contains(t, buf.String(), fmt.Sprintf("response_size_bytes=%d", len(responseBody)))
+}
+
+func TestHandler_ChunkedResponseBody(t *testing.T) {
+	largeBody := strings.Repeat(responseBody, 2*responsePrefixSize/len(responseBody))
+
+	for name, body := range map[string]string{
+		"small body": responseBody,
+		"large body": largeBody,
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The downstream flushes the body in chunks, without sending its length.
+			downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < len(body); i += len(responseBody) {
+					_, _ = io.WriteString(w, body[i:i+len(responseBody)])
+					w.(http.Flusher).Flush()
+				}
+			}))
+			defer downstream.Close()
+
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				r.URL.Scheme, r.URL.Host = "http", downstream.Listener.Addr().String()
+				r.RequestURI = ""
+				return http.DefaultTransport.RoundTrip(r)
+			})
+
+			var buf syncBuf
+			cfg := defaultHandlerConfig()
+			cfg.LogQueriesLongerThan = -1
+			w := httptest.NewRecorder()
+			NewHandler(cfg, nil, rt, log.NewLogfmtLogger(&buf), nil).ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, body, w.Body.String())
+			assert.Contains(t, buf.String(), fmt.Sprintf("response_size_bytes=%d", len(body)))
+			if len(body) < responsePrefixSize {
+				assert.Equal(t, strconv.Itoa(len(body)), w.Header().Get("Content-Length"))
+			} else {
+				assert.Empty(t, w.Header().Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestHandler_ErrorBeforeFirstByteIsMapped(t *testing.T) {
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(&errReader{data: &bytes.Buffer{}, err: errors.New("connection reset")}),
+		}, nil
+	})
+
+	w := httptest.NewRecorder()
+	NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "connection reset")
+}
+
+func TestHandler_ErrorWhileStreamingIsLogged(t *testing.T) {
+	// The error occurs after the buffered prefix of the response has been written.
+	partial := strings.Repeat("x", responsePrefixSize) + "partial"
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(&errReader{data: strings.NewReader(partial), err: errors.New("connection reset")}),
+		}, nil
+	})
+
+	var buf syncBuf
+	w := httptest.NewRecorder()
+	NewHandler(defaultHandlerConfig(), nil, rt, log.NewLogfmtLogger(&buf), nil).ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, partial, w.Body.String())
+	assert.Contains(t, buf.String(), "response body truncated")
+	assert.Contains(t, buf.String(), fmt.Sprintf("bytes_written=%d", len(partial)))
+}
+
+func TestHandler_ErrorInBufferedPrefixIsMapped(t *testing.T) {
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(&errReader{data: strings.NewReader("partial"), err: errors.New("connection reset")}),
+		}, nil
+	})
+
+	w := httptest.NewRecorder()
+	NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "connection reset")
+}
+
+func TestHandler_ErrorNotWrittenOnceResponseStarted(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &statusRecordingWriter{ResponseWriter: rec, status: http.StatusOK}
+	h := &Handler{cfg: defaultHandlerConfig(), log: log.NewNopLogger()}
+
+	_, err := w.Write([]byte("partial"))
+	require.NoError(t, err)
+	assert.True(t, w.started)
+
+	assert.Equal(t, outcomeDownstreamError, h.writeError(w, httptest.NewRequest("GET", query, nil), errors.New("connection reset")))
+	assert.Equal(t, http.StatusOK, w.status)
+	assert.Equal(t, "partial", rec.Body.String())
+}
+
+func TestNewHandler_WarnsOnSmallMaxBodySize(t *testing.T) {
+	var buf syncBuf
+	cfg := defaultHandlerConfig()
+	NewHandler(cfg, nil, okRoundTripper(), log.NewLogfmtLogger(&buf), nil)
+	assert.Empty(t, buf.String())
+
+	cfg.MaxBodySize = 1
+	NewHandler(cfg, nil, okRoundTripper(), log.NewLogfmtLogger(&buf), nil)
+	assert.Contains(t, buf.String(), "max_body_size=1")
+}
+
+func TestHandler_MaxResponseSize(t *testing.T) {
+	largeBody := strings.Repeat(responseBody, 2*responsePrefixSize/len(responseBody))
+
+	for name, tc := range map[string]struct {
+		body            string
+		maxResponseSize int64
+		contentLength   int64
+		expectedStatus  int
+		expectedBody    string
+		expectedLog     string
+	}{
+		"unlimited": {
+			maxResponseSize: 0,
+			contentLength:   int64(len(responseBody)),
+			expectedStatus:  http.StatusOK,
+			expectedBody:    responseBody,
+		},
+		"below the limit": {
+			maxResponseSize: int64(len(responseBody)),
+			contentLength:   int64(len(responseBody)),
+			expectedStatus:  http.StatusOK,
+			expectedBody:    responseBody,
+		},
+		"known size above the limit": {
+			maxResponseSize: 10,
+			contentLength:   int64(len(responseBody)),
+			expectedStatus:  http.StatusRequestEntityTooLarge,
+			expectedBody:    fmt.Sprintf(`{"status":"error","errorType":"bad_data","error":"response size (%d bytes) exceeds the max response size (10 bytes)"}`, len(responseBody)),
+		},
+		"unknown size above the limit": {
+			maxResponseSize: 10,
+			contentLength:   -1,
+			expectedStatus:  http.StatusRequestEntityTooLarge,
+			expectedBody:    `{"status":"error","errorType":"bad_data","error":"response exceeds the max response size (10 bytes)"}`,
+		},
+		"unknown size above the limit after the buffered prefix": {
+			body:            largeBody,
+			maxResponseSize: responsePrefixSize + 10,
+			contentLength:   -1,
+			expectedStatus:  http.StatusOK,
+			expectedBody:    largeBody[:responsePrefixSize+10],
+			expectedLog:     fmt.Sprintf("response exceeds the max response size (%d bytes)", responsePrefixSize+10),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := tc.body
+			if body == "" {
+				body = responseBody
+			}
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode:    http.StatusOK,
+					Header:        http.Header{},
+					Body:          ioutil.NopCloser(strings.NewReader(body)),
+					ContentLength: tc.contentLength,
+				}, nil
+			})
+
+			cfg := defaultHandlerConfig()
+			cfg.MaxResponseSize = tc.maxResponseSize
+
+			var buf syncBuf
+			w := httptest.NewRecorder()
+			NewHandler(cfg, nil, rt, log.NewLogfmtLogger(&buf), nil).ServeHTTP(w, httptest.NewRequest("GET", query, nil))
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedBody, w.Body.String())
+			if tc.expectedLog != "" {
+				assert.Contains(t, buf.String(), tc.expectedLog)
+			}
+		})
+	}
+}
+
+func TestHandler_RetryAfter(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err                error
+		defaultRetryAfter  time.Duration
+		expectedRetryAfter string
+	}{
+		"429 gets the default": {
+			err:                httpgrpc.Errorf(http.StatusTooManyRequests, "too many outstanding requests"),
+			defaultRetryAfter:  5 * time.Second,
+			expectedRetryAfter: "5",
+		},
+		"503 gets the default": {
+			err:                httpgrpc.Errorf(http.StatusServiceUnavailable, "unavailable"),
+			defaultRetryAfter:  1500 * time.Millisecond,
+			expectedRetryAfter: "2",
+		},
+		"the value carried by the error is preserved": {
+			err: httpgrpc.ErrorFromHTTPResponse(&httpgrpc.HTTPResponse{
+				Code:    http.StatusTooManyRequests,
+				Headers: []*httpgrpc.Header{{Key: "Retry-After", Values: []string{"30"}}},
+			}),
+			defaultRetryAfter:  5 * time.Second,
+			expectedRetryAfter: "30",
+		},
+		"disabled": {
+			err:                httpgrpc.Errorf(http.StatusTooManyRequests, "too many outstanding requests"),
+			defaultRetryAfter:  0,
+			expectedRetryAfter: "",
+		},
+		"other status codes are not affected": {
+			err:                httpgrpc.Errorf(http.StatusBadRequest, "bad request"),
+			defaultRetryAfter:  5 * time.Second,
+			expectedRetryAfter: "",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultHandlerConfig()
+			cfg.DefaultRetryAfter = tc.defaultRetryAfter
+
+			w := httptest.NewRecorder()
+			h := &Handler{cfg: cfg, log: log.NewNopLogger()}
+			h.writeError(w, httptest.NewRequest("GET", query, nil), tc.err)
+
+			assert.Equal(t, tc.expectedRetryAfter, w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestHandler_RequestMetrics(t *testing.T) {
+	for name, tc := range map[string]struct {
+		metricsByTenant bool
+		tenantsOverflow bool
+		downstreamErr   error
+		expectedUser    string
+		expectedStatus  string
+	}{
+		"successful request": {
+			metricsByTenant: true,
+			expectedUser:    "1",
+			expectedStatus:  "2xx",
+		},
+		"failed request": {
+			metricsByTenant: true,
+			downstreamErr:   httpgrpc.Errorf(http.StatusBadRequest, "bad request"),
+			expectedUser:    "1",
+			expectedStatus:  "4xx",
+		},
+		"tenant label disabled": {
+			metricsByTenant: false,
+			expectedUser:    "",
+			expectedStatus:  "2xx",
+		},
+		"tenants beyond the max tenants": {
+			metricsByTenant: true,
+			tenantsOverflow: true,
+			expectedUser:    "other",
+			expectedStatus:  "2xx",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if tc.downstreamErr != nil {
+					return nil, tc.downstreamErr
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+
+			cfg := defaultHandlerConfig()
+			cfg.MetricsByTenant = tc.metricsByTenant
+			h := NewHandler(cfg, nil, rt, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+			if tc.tenantsOverflow {
+				// Another tenant already reached the max tenants.
+				h.tenantLabeler = newTenantLabeler(TenantLabelsConfig{MaxTenants: 1, Overflow: TenantLabelsOverflowOther})
+				h.tenantLabeler.label("0")
+			}
+
+			req := httptest.NewRequest("GET", query, nil)
+			h.ServeHTTP(httptest.NewRecorder(), req.WithContext(user.InjectOrgID(req.Context(), "1")))
+
+			assert.Equal(t, float64(1), testutil.ToFloat64(h.requestsTotal.WithLabelValues(tc.expectedUser, "GET", tc.expectedStatus)))
+			assert.Equal(t, 1, testutil.CollectAndCount(h.requestDuration))
+		})
+	}
+}
+
+func TestHandler_QueryOutcomes(t *testing.T) {
+	for name, tc := range map[string]struct {
+		downstreamErr   error
+		expectedOutcome string
+	}{
+		"served": {
+			expectedOutcome: outcomeServed,
+		},
+		"canceled by the client": {
+			downstreamErr:   context.Canceled,
+			expectedOutcome: outcomeCanceled,
+		},
+		"deadline exceeded": {
+			downstreamErr:   context.DeadlineExceeded,
+			expectedOutcome: outcomeDeadlineExceeded,
+		},
+		"downstream error": {
+			downstreamErr:   httpgrpc.Errorf(http.StatusInternalServerError, "failed"),
+			expectedOutcome: outcomeDownstreamError,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if tc.downstreamErr != nil {
+					return nil, tc.downstreamErr
+				}
+				return okRoundTripper().RoundTrip(r)
+			})
+
+			h := NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+
+			req := httptest.NewRequest("GET", query, nil)
+			h.ServeHTTP(httptest.NewRecorder(), req.WithContext(user.InjectOrgID(req.Context(), "1")))
+
+			assert.Equal(t, float64(1), testutil.ToFloat64(h.queryOutcomes.WithLabelValues("1", tc.expectedOutcome)))
+			assert.Equal(t, 1, testutil.CollectAndCount(h.queryOutcomes))
+		})
+	}
+}
+
+func TestHandler_QueryID(t *testing.T) {
+	for name, tc := range map[string]struct {
+		header        string
+		format        string
+		queryID       string
+		expectedRegex string
+	}{
+		"query ID sent by the client": {queryID: "grafana-panel-1"},
+		"query ID generated":          {expectedRegex: "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$"},
+		"base62 query ID generated": {
+			format:        QueryIDFormatBase62,
+			expectedRegex: "^[0-9A-Za-z]{16}$",
+		},
+		"query ID sent by the client in a custom header": {
+			header:  "X-Trace-Id",
+			queryID: "trace-1",
+		},
+		"query ID generated with a custom header": {
+			header:        "X-Trace-Id",
+			expectedRegex: "^[0-9a-f-]{36}$",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultHandlerConfig()
+			if tc.header != "" {
+				cfg.QueryID.Header = tc.header
+			}
+			if tc.format != "" {
+				cfg.QueryID.Format = tc.format
+			}
+
+			var headerQueryID, ctxQueryID string
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				headerQueryID = r.Header.Get(QueryIDHeader)
+				ctxQueryID = extractQueryID(r.Context())
+				assert.Equal(t, headerQueryID, r.Header.Get(cfg.QueryID.Header))
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+
+			req := httptest.NewRequest("GET", query, nil)
+			if tc.queryID != "" {
+				req.Header.Set(cfg.QueryID.Header, tc.queryID)
+			}
+			w := httptest.NewRecorder()
+			NewHandler(cfg, nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, req)
+
+			assert.NotEmpty(t, headerQueryID)
+			assert.Equal(t, headerQueryID, ctxQueryID)
+			assert.Equal(t, headerQueryID, w.Header().Get(cfg.QueryID.Header))
+			if tc.queryID != "" {
+				assert.Equal(t, tc.queryID, headerQueryID)
+			} else {
+				assert.Regexp(t, tc.expectedRegex, headerQueryID)
+			}
+		})
+	}
+}
+
+func TestQueryIDConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg         QueryIDConfig
+		expectedErr string
+	}{
+		"uuid":   {cfg: QueryIDConfig{Header: QueryIDHeader, Format: QueryIDFormatUUID}},
+		"base62": {cfg: QueryIDConfig{Header: "X-Trace-Id", Format: QueryIDFormatBase62}},
+		"empty header": {
+			cfg:         QueryIDConfig{Format: QueryIDFormatUUID},
+			expectedErr: "invalid -frontend.query-id-header: must not be empty",
+		},
+		"unknown format": {
+			cfg:         QueryIDConfig{Header: QueryIDHeader, Format: "ulid"},
+			expectedErr: `invalid -frontend.query-id-format "ulid": supported values are uuid, base62`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestHandler_QueryRateLimit(t *testing.T) {
+	for name, tc := range map[string]struct {
+		limits           Limits
+		expectedStatuses []int
+	}{
+		"limits not enforced": {
+			limits:           nil,
+			expectedStatuses: []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		"unlimited rate": {
+			limits:           limits{queryRate: 0, queryBurst: 1},
+			expectedStatuses: []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		"rate exceeded after the burst": {
+			limits:           limits{queryRate: 0.5, queryBurst: 2},
+			expectedStatuses: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+			h := NewHandler(defaultHandlerConfig(), tc.limits, rt, log.NewNopLogger(), nil)
+
+			for i, expected := range tc.expectedStatuses {
+				req := httptest.NewRequest("GET", query, nil)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req.WithContext(user.InjectOrgID(req.Context(), "1")))
+
+				require.Equal(t, expected, w.Code, "request %d", i)
+				if expected == http.StatusTooManyRequests {
+					assert.Equal(t, "2", w.Header().Get(retryAfterHeader))
+					assert.Contains(t, w.Body.String(), "query rate limit (0.5 queries/s) exceeded")
+				}
+			}
+
+			// Tenants are limited independently.
+			req := httptest.NewRequest("GET", query, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req.WithContext(user.InjectOrgID(req.Context(), "2")))
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestHandler_PathPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		url            string
+		expectedPath   string
+		expectedReqURI string
+		noPathPrefix   bool
+	}{
+		{url: "/prefix/api/v1/query_range?query=up", expectedPath: "/api/v1/query_range", expectedReqURI: "/api/v1/query_range?query=up"},
+		{url: "/api/v1/query_range?query=up", expectedPath: "/api/v1/query_range", expectedReqURI: "/api/v1/query_range?query=up"},
+		{url: "/prefixed/api/v1/query_range", expectedPath: "/prefixed/api/v1/query_range", expectedReqURI: "/prefixed/api/v1/query_range"},
+		{url: "/prefix/api/v1/query_range", expectedPath: "/prefix/api/v1/query_range", expectedReqURI: "/prefix/api/v1/query_range", noPathPrefix: true},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			var path, requestURI string
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				path, requestURI = r.URL.Path, r.RequestURI
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+
+			cfg := defaultHandlerConfig()
+			if !tc.noPathPrefix {
+				cfg.PathPrefix = "/prefix"
+			}
+			w := httptest.NewRecorder()
+			NewHandler(cfg, nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.expectedPath, path)
+			assert.Equal(t, tc.expectedReqURI, requestURI)
+		})
+	}
+}
+
+func TestHandler_StripRequestHeaders(t *testing.T) {
+	var observed http.Header
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		observed = r.Header.Clone()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+
+	cfg := defaultHandlerConfig()
+	require.NoError(t, cfg.StripRequestHeaders.Set("cookie,X-Internal-*,X-*"))
+
+	req := httptest.NewRequest("GET", query, nil)
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Internal-Token", "secret")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(QueryIDHeader, "query-1")
+	require.NoError(t, user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(req.Context(), "1"), req))
+
+	w := httptest.NewRecorder()
+	NewHandler(cfg, nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Empty(t, observed.Get("Cookie"))
+	assert.Empty(t, observed.Get("X-Internal-Token"))
+	assert.Empty(t, observed.Get("X-Forwarded-For"))
+	assert.Equal(t, "application/json", observed.Get("Accept"))
+
+	// The tenant and query IDs are never stripped.
+	assert.Equal(t, "1", observed.Get(user.OrgIDHeaderName))
+	assert.Equal(t, "query-1", observed.Get(QueryIDHeader))
+}
+
+func TestHandler_ForwardHeaders(t *testing.T) {
+	for name, tc := range map[string]struct {
+		stripUnlisted bool
+		expected      http.Header
+	}{
+		"forwarded headers take precedence over stripped ones": {
+			expected: http.Header{
+				"Accept-Language": {"fr"},
+				"X-Plugin-Mode":   {"compact"},
+				"Accept":          {"application/json"},
+				"Content-Type":    {"application/x-www-form-urlencoded"},
+				"X-Scope-Orgid":   {"1"},
+				"X-Query-Id":      {"query-1"},
+			},
+		},
+		"unlisted headers stripped": {
+			stripUnlisted: true,
+			expected: http.Header{
+				"Accept-Language": {"fr"},
+				"X-Plugin-Mode":   {"compact"},
+				"Content-Type":    {"application/x-www-form-urlencoded"},
+				"X-Scope-Orgid":   {"1"},
+				"X-Query-Id":      {"query-1"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var observed http.Header
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				observed = r.Header
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+
+			cfg := defaultHandlerConfig()
+			require.NoError(t, cfg.StripRequestHeaders.Set("X-*"))
+			require.NoError(t, cfg.ForwardHeaders.Set("accept-language,X-Plugin-*"))
+			cfg.StripUnlistedHeaders = tc.stripUnlisted
+
+			req := httptest.NewRequest("POST", "/api/v1/query", strings.NewReader("query=up"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept-Language", "fr")
+			req.Header.Set("X-Plugin-Mode", "compact")
+			req.Header.Set("X-Forwarded-For", "10.0.0.1")
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set(QueryIDHeader, "query-1")
+			require.NoError(t, user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(req.Context(), "1"), req))
+
+			w := httptest.NewRecorder()
+			NewHandler(cfg, nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.expected, observed)
+		})
+	}
+}
+
+func TestHandler_MultiTenantOrgID(t *testing.T) {
+	for name, tc := range map[string]struct {
+		orgID          string
+		expectedOrgID  string
+		expectedStatus int
+	}{
+		"single tenant":               {orgID: "1", expectedOrgID: "1", expectedStatus: http.StatusOK},
+		"multiple tenants are sorted": {orgID: "2|1|2", expectedOrgID: "1|2", expectedStatus: http.StatusOK},
+		"empty tenant ID is rejected": {orgID: "1||2", expectedStatus: http.StatusBadRequest},
+		"trailing separator rejected": {orgID: "1|", expectedStatus: http.StatusBadRequest},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var headerOrgID, ctxOrgID string
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				headerOrgID = r.Header.Get(user.OrgIDHeaderName)
+				ctxOrgID, _ = user.ExtractOrgID(r.Context())
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+
+			req := httptest.NewRequest("GET", query, nil)
+			require.NoError(t, user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(req.Context(), tc.orgID), req))
+			req = req.WithContext(user.InjectOrgID(req.Context(), tc.orgID))
+
+			w := httptest.NewRecorder()
+			NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedOrgID, headerOrgID)
+			assert.Equal(t, tc.expectedOrgID, ctxOrgID)
+		})
+	}
+}
+
+func TestHandler_OrgIDValidation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		mode           string
+		orgID          string
+		expectedOrgID  string
+		expectedStatus int
+	}{
+		"disabled accepts any org ID": {mode: OrgIDValidationDisabled, orgID: "a/b", expectedOrgID: "a/b", expectedStatus: http.StatusOK},
+		"strict accepts valid org ID": {mode: OrgIDValidationStrict, orgID: "team-1", expectedOrgID: "team-1", expectedStatus: http.StatusOK},
+		"strict rejects invalid char": {mode: OrgIDValidationStrict, orgID: "team-1|a/b", expectedStatus: http.StatusBadRequest},
+		"strict rejects long org ID":  {mode: OrgIDValidationStrict, orgID: "team-12345", expectedStatus: http.StatusBadRequest},
+		"lenient sanitizes org ID":    {mode: OrgIDValidationLenient, orgID: "b|a/b c", expectedOrgID: "a_b_c|b", expectedStatus: http.StatusOK},
+		"lenient truncates org ID":    {mode: OrgIDValidationLenient, orgID: "team-12345", expectedOrgID: "team-123", expectedStatus: http.StatusOK},
+		"lenient de-duplicates":       {mode: OrgIDValidationLenient, orgID: "a/b|a_b", expectedOrgID: "a_b", expectedStatus: http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var headerOrgID, ctxOrgID string
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				headerOrgID = r.Header.Get(user.OrgIDHeaderName)
+				ctxOrgID, _ = user.ExtractOrgID(r.Context())
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+
+			cfg := defaultHandlerConfig()
+			cfg.OrgIDValidation.Mode = tc.mode
+			cfg.OrgIDValidation.MaxLength = 8
+			require.NoError(t, cfg.Validate())
+
+			req := httptest.NewRequest("GET", query, nil)
+			require.NoError(t, user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(req.Context(), tc.orgID), req))
+			req = req.WithContext(user.InjectOrgID(req.Context(), tc.orgID))
+
+			w := httptest.NewRecorder()
+			NewHandler(cfg, nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedOrgID, headerOrgID)
+			assert.Equal(t, tc.expectedOrgID, ctxOrgID)
+		})
+	}
+}
+
+func TestOrgIDValidationConfig_Validate(t *testing.T) {
+	cfg := OrgIDValidationConfig{Mode: "unknown"}
+	assert.EqualError(t, cfg.Validate(), "unsupported org ID validation: unknown")
+
+	cfg = OrgIDValidationConfig{Mode: OrgIDValidationStrict, AllowedCharacters: "z-a"}
+	assert.Error(t, cfg.Validate())
+
+	cfg = OrgIDValidationConfig{Mode: OrgIDValidationStrict, AllowedCharacters: "a-z"}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestHandler_OrgIDInjectedWithoutHeader(t *testing.T) {
+	var headerOrgID string
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		headerOrgID = r.Header.Get(user.OrgIDHeaderName)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+
+	// The org ID is injected in the context only, as done by the auth middleware when auth is disabled.
+	req := httptest.NewRequest("GET", query, nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "fake"))
+
+	w := httptest.NewRecorder()
+	NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), nil).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "fake", headerOrgID)
+}
+
+func TestHandler_CORS(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.com"}
+
+	forwarded := 0
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		forwarded++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+	handler := NewHandler(cfg, nil, rt, log.NewNopLogger(), nil)
+
+	// The preflight request is answered without the tenant ID, and isn't forwarded.
+	req := httptest.NewRequest(http.MethodOptions, query, nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type, X-Scope-OrgID", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, 0, forwarded)
+
+	// The preflight request of a disallowed origin is rejected.
+	req = httptest.NewRequest(http.MethodOptions, query, nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, 0, forwarded)
+
+	// The actual request is forwarded, and allowed to be read by the origin.
+	req = httptest.NewRequest(http.MethodGet, query, nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	assert.Equal(t, 1, forwarded)
+}
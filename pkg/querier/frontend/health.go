@@ -0,0 +1,21 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+)
+
+// HealthHandler returns the handler responding HTTP 200 if all the checks pass, or HTTP 503 with
+// the error of the first failing one otherwise. Without checks, it only reports the query-frontend
+// is able to serve HTTP requests.
+func HealthHandler(checks ...func(context.Context) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, check := range checks {
+			if err := check(r.Context()); err != nil {
+				http.Error(w, "Query Frontend "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		http.Error(w, "ok", http.StatusOK)
+	})
+}
@@ -0,0 +1,44 @@
+package frontend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	for name, tc := range map[string]struct {
+		checks         []func(context.Context) error
+		expectedStatus int
+		expectedBody   string
+	}{
+		"no checks": {
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok\n",
+		},
+		"passing checks": {
+			checks:         []func(context.Context) error{func(context.Context) error { return nil }},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok\n",
+		},
+		"failing check": {
+			checks: []func(context.Context) error{
+				func(context.Context) error { return nil },
+				func(context.Context) error { return errors.New("not ready: no querier") },
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "Query Frontend not ready: no querier\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HealthHandler(tc.checks...).ServeHTTP(w, httptest.NewRequest("GET", "/frontend/ready", nil))
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package frontend
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// HistoricalDownstreamConfig configures the routing of the queries of old time ranges to a
+// different downstream, eg. a read replica on slower storage.
+type HistoricalDownstreamConfig struct {
+	URL       string        `yaml:"historical_downstream_url"`
+	Threshold time.Duration `yaml:"historical_query_threshold"`
+}
+
+func (cfg *HistoricalDownstreamConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.URL, "frontend.historical-downstream-url", "", "URL of the downstream Prometheus the queries of time ranges older than -frontend.historical-query-threshold are forwarded to. The range queries spanning the threshold are split at it, and the results of both parts are merged. The other queries are handled as if it wasn't configured.")
+	f.DurationVar(&cfg.Threshold, "frontend.historical-query-threshold", 24*time.Hour, "Age beyond which the queries are forwarded to -frontend.historical-downstream-url.")
+}
+
+func (cfg *HistoricalDownstreamConfig) Validate() error {
+	if cfg.URL == "" {
+		return nil
+	}
+	if err := validateDownstreamURL(cfg.URL); err != nil {
+		return errors.Wrapf(err, "invalid -frontend.historical-downstream-url %q", cfg.URL)
+	}
+	if cfg.Threshold <= 0 {
+		return fmt.Errorf("invalid -frontend.historical-query-threshold %s: must be positive", cfg.Threshold)
+	}
+	return nil
+}
+
+// RoundTripper that forwards the requests of historical time ranges to the historical downstream,
+// and the other requests to next. The range queries are routed as decided when they're split at
+// the threshold, so that both agree on the boundary. The other requests are historical if the end
+// of their time range, or their evaluation time, is older than the threshold.
+type historicalDownstreamRoundTripper struct {
+	threshold  time.Duration
+	historical http.RoundTripper
+	next       http.RoundTripper
+}
+
+func newHistoricalDownstreamRoundTripper(threshold time.Duration, historical, next http.RoundTripper) http.RoundTripper {
+	return &historicalDownstreamRoundTripper{
+		threshold:  threshold,
+		historical: historical,
+		next:       next,
+	}
+}
+
+func (h *historicalDownstreamRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	historical, ok := queryrange.IsHistoricalRequest(r.Context())
+	if !ok {
+		historical = h.isHistorical(r)
+	}
+	if historical {
+		return h.historical.RoundTrip(r)
+	}
+	return h.next.RoundTrip(r)
+}
+
+func (h *historicalDownstreamRoundTripper) isHistorical(r *http.Request) bool {
+	// Parse the form on a copy of the request, so that the body can still be forwarded downstream.
+	clone := r.Clone(r.Context())
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return false
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err := clone.ParseForm(); err != nil {
+		return false
+	}
+
+	// The requests without time range are evaluated at the current time.
+	param := clone.Form.Get("end")
+	if param == "" {
+		param = clone.Form.Get("time")
+	}
+	if param == "" {
+		return false
+	}
+	end, err := util.ParseTime(param)
+	if err != nil {
+		return false
+	}
+	return end < util.TimeToMillis(time.Now().Add(-h.threshold))
+}
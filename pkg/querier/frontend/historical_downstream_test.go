@@ -0,0 +1,76 @@
+package frontend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoricalDownstreamRoundTripper(t *testing.T) {
+	historical := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return textResponse("historical"), nil
+	})
+	primary := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		// The body is still forwarded after the routing.
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		return textResponse("primary " + string(body)), nil
+	})
+	rt := newHistoricalDownstreamRoundTripper(24*time.Hour, historical, primary)
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	for name, tc := range map[string]struct {
+		method, path, body string
+		expectedBody       string
+	}{
+		"recent range": {
+			method: "GET", path: fmt.Sprintf("/api/v1/series?match[]=up&start=%d&end=%d", old.Unix(), now.Unix()),
+			expectedBody: "primary ",
+		},
+		"historical range": {
+			method: "GET", path: fmt.Sprintf("/api/v1/series?match[]=up&start=%d&end=%d", old.Unix(), old.Add(time.Hour).Unix()),
+			expectedBody: "historical",
+		},
+		"historical instant query": {
+			method: "GET", path: fmt.Sprintf("/api/v1/query?query=up&time=%d", old.Unix()),
+			expectedBody: "historical",
+		},
+		"instant query without time": {
+			method: "GET", path: "/api/v1/query?query=up",
+			expectedBody: "primary ",
+		},
+		"recent instant query in the body": {
+			method: "POST", path: "/api/v1/query", body: fmt.Sprintf("query=up&time=%d", now.Unix()),
+			expectedBody: fmt.Sprintf("primary query=up&time=%d", now.Unix()),
+		},
+		"historical instant query in the body": {
+			method: "POST", path: "/api/v1/query", body: fmt.Sprintf("query=up&time=%d", old.Unix()),
+			expectedBody: "historical",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBody, string(body))
+		})
+	}
+}
+
+func textResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}
+}
@@ -0,0 +1,153 @@
+package frontend
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/atomic"
+)
+
+// HTTPServerConfig configures the timeouts and the connections limit of the HTTP server wrapping
+// the query-frontend handler, to defend against slow clients holding the connections open and
+// connection floods, the max size of the request headers, and how the requests in flight are
+// drained on shutdown.
+type HTTPServerConfig struct {
+	HTTPReadTimeout          time.Duration `yaml:"http_read_timeout"`
+	ReadHeaderTimeout        time.Duration `yaml:"http_read_header_timeout"`
+	WriteTimeout             time.Duration `yaml:"http_write_timeout"`
+	IdleTimeout              time.Duration `yaml:"http_idle_timeout"`
+	MaxConcurrentConnections int           `yaml:"max_concurrent_connections"`
+	ShutdownGracePeriod      time.Duration `yaml:"shutdown_grace_period"`
+	MaxRequestHeaderBytes    int           `yaml:"max_request_header_bytes"`
+}
+
+func (cfg *HTTPServerConfig) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&cfg.HTTPReadTimeout, "frontend.http-read-timeout", 0, "Maximum time to read a request, including its body. 0 to keep the -server.http-read-timeout value.")
+	f.DurationVar(&cfg.ReadHeaderTimeout, "frontend.http-read-header-timeout", 10*time.Second, "Maximum time to read the headers of a request. 0 to keep the read timeout.")
+	f.DurationVar(&cfg.WriteTimeout, "frontend.http-write-timeout", 0, "Maximum time to write a response, from the end of the request headers. It must be longer than the slowest queries. 0 to keep the -server.http-write-timeout value.")
+	f.DurationVar(&cfg.IdleTimeout, "frontend.http-idle-timeout", 0, "Maximum time to wait for the next request on a keep-alive connection. 0 to keep the -server.http-idle-timeout value.")
+	f.IntVar(&cfg.MaxConcurrentConnections, "frontend.max-concurrent-connections", 0, "Maximum number of concurrent HTTP connections. The connections accepted beyond the limit are closed right away. When the query-frontend runs along other modules, the limit applies to all the HTTP connections. 0 to disable.")
+	f.IntVar(&cfg.MaxRequestHeaderBytes, "frontend.max-request-header-bytes", 0, fmt.Sprintf("Max size, in bytes, of the request line and headers of a request, including the query of the GET requests. The requests exceeding it are rejected with HTTP 431. When the query-frontend runs along other modules, the limit applies to all the HTTP requests. 0 to keep the server default of %d bytes.", http.DefaultMaxHeaderBytes))
+	f.DurationVar(&cfg.ShutdownGracePeriod, "frontend.shutdown-grace-period", 0, "Maximum time to wait on shutdown for the requests in flight to complete, before the query-frontend stops. The new requests received meanwhile are rejected with HTTP 503 and the keep-alive connections are closed, so that load balancers steer the traffic to the other replicas. 0 to disable.")
+}
+
+func (cfg *HTTPServerConfig) Validate() error {
+	for _, timeout := range []struct {
+		flag  string
+		value time.Duration
+	}{
+		{"frontend.http-read-timeout", cfg.HTTPReadTimeout},
+		{"frontend.http-read-header-timeout", cfg.ReadHeaderTimeout},
+		{"frontend.http-write-timeout", cfg.WriteTimeout},
+		{"frontend.http-idle-timeout", cfg.IdleTimeout},
+	} {
+		if timeout.value < 0 {
+			return fmt.Errorf("invalid -%s %s: must not be negative, 0 to keep the server default", timeout.flag, timeout.value)
+		}
+	}
+	if cfg.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("invalid -frontend.shutdown-grace-period %s: must not be negative, 0 to disable", cfg.ShutdownGracePeriod)
+	}
+	if cfg.MaxRequestHeaderBytes < 0 {
+		return fmt.Errorf("invalid -frontend.max-request-header-bytes %d: must not be negative, 0 to keep the server default", cfg.MaxRequestHeaderBytes)
+	}
+	if cfg.MaxConcurrentConnections < 0 {
+		return fmt.Errorf("invalid -frontend.max-concurrent-connections %d: must not be negative, 0 to disable", cfg.MaxConcurrentConnections)
+	}
+	return nil
+}
+
+// Apply overrides the timeouts of the HTTP server with the configured ones, and limits its
+// connections. It must be called before the server starts serving.
+func (cfg HTTPServerConfig) Apply(s *http.Server, reg prometheus.Registerer) {
+	if cfg.HTTPReadTimeout > 0 {
+		s.ReadTimeout = cfg.HTTPReadTimeout
+	}
+	if cfg.ReadHeaderTimeout > 0 {
+		s.ReadHeaderTimeout = cfg.ReadHeaderTimeout
+	}
+	if cfg.WriteTimeout > 0 {
+		s.WriteTimeout = cfg.WriteTimeout
+	}
+	if cfg.IdleTimeout > 0 {
+		s.IdleTimeout = cfg.IdleTimeout
+	}
+	if cfg.MaxRequestHeaderBytes > 0 {
+		// The server only answers the requests exceeding its own limit with a generic error, so it
+		// reads up to twice the limit and the handler rejects the requests exceeding the limit with
+		// an error explaining it.
+		s.MaxHeaderBytes = 2 * cfg.MaxRequestHeaderBytes
+	}
+
+	limiter := newConnectionLimiter(cfg.MaxConcurrentConnections, reg)
+	if next := s.ConnState; next != nil {
+		s.ConnState = func(conn net.Conn, state http.ConnState) {
+			limiter.connState(conn, state)
+			next(conn, state)
+		}
+	} else {
+		s.ConnState = limiter.connState
+	}
+}
+
+// connectionLimiter tracks the open connections of an HTTP server, and closes the new ones
+// beyond the limit.
+type connectionLimiter struct {
+	limit int64
+	open  atomic.Int64
+
+	rejected prometheus.Counter
+}
+
+func newConnectionLimiter(limit int, reg prometheus.Registerer) *connectionLimiter {
+	l := &connectionLimiter{
+		limit: int64(limit),
+		rejected: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_rejected_connections_total",
+			Help:      "Total number of HTTP connections closed because of the max concurrent connections limit.",
+		}),
+	}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "cortex",
+		Name:      "query_frontend_open_connections",
+		Help:      "Number of open HTTP connections.",
+	}, func() float64 {
+		return float64(l.open.Load())
+	})
+	return l
+}
+
+func (l *connectionLimiter) connState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		if open := l.open.Inc(); l.limit > 0 && open > l.limit {
+			// The server still reports the connection as closed.
+			l.rejected.Inc()
+			_ = conn.Close()
+		}
+	case http.StateHijacked, http.StateClosed:
+		l.open.Dec()
+	}
+}
+
+// requestHeaderSize returns the size of the request line and headers of the request, as read by the
+// server.
+func requestHeaderSize(r *http.Request) int {
+	// The request line, and the Host header the server moved out of the headers.
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + len("  \r\n")
+	if r.Host != "" {
+		size += len("Host: \r\n") + len(r.Host)
+	}
+	for key, values := range r.Header {
+		for _, value := range values {
+			size += len(key) + len(value) + len(": \r\n")
+		}
+	}
+	return size
+}
@@ -0,0 +1,180 @@
+package frontend
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestHTTPServerConfig_Apply(t *testing.T) {
+	s := &http.Server{ReadTimeout: 30 * time.Second, WriteTimeout: 30 * time.Second, IdleTimeout: 2 * time.Minute}
+
+	// The timeouts not configured keep the server values.
+	HTTPServerConfig{ReadHeaderTimeout: 5 * time.Second, WriteTimeout: 5 * time.Minute}.Apply(s, nil)
+	assert.Equal(t, 30*time.Second, s.ReadTimeout)
+	assert.Equal(t, 5*time.Second, s.ReadHeaderTimeout)
+	assert.Equal(t, 5*time.Minute, s.WriteTimeout)
+	assert.Equal(t, 2*time.Minute, s.IdleTimeout)
+	assert.Equal(t, 0, s.MaxHeaderBytes)
+
+	// The server reads up to twice the max header size, so that the handler rejects the requests exceeding it.
+	HTTPServerConfig{MaxRequestHeaderBytes: 1024}.Apply(s, nil)
+	assert.Equal(t, 2048, s.MaxHeaderBytes)
+}
+
+func TestHTTPServerConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&HTTPServerConfig{ReadHeaderTimeout: time.Second}).Validate())
+	assert.EqualError(t, (&HTTPServerConfig{IdleTimeout: -time.Second}).Validate(), "invalid -frontend.http-idle-timeout -1s: must not be negative, 0 to keep the server default")
+	assert.EqualError(t, (&HTTPServerConfig{MaxRequestHeaderBytes: -1}).Validate(), "invalid -frontend.max-request-header-bytes -1: must not be negative, 0 to keep the server default")
+}
+
+func TestRequestHeaderSize(t *testing.T) {
+	raw := "GET /api/v1/query?query=up HTTP/1.1\r\nHost: localhost:8080\r\nX-Scope-Orgid: user-1\r\nAccept: a\r\nAccept: b\r\n\r\n"
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	require.NoError(t, err)
+
+	// The size doesn't include the empty line ending the headers.
+	assert.Equal(t, len(raw)-len("\r\n"), requestHeaderSize(r))
+}
+
+func TestHTTPServerConfig_MaxRequestHeaderBytes(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+		}, nil
+	})
+	cfg := defaultHandlerConfig()
+	cfg.HTTPServer.MaxRequestHeaderBytes = 1024
+
+	s := &http.Server{Handler: NewHandler(cfg, nil, rt, log.NewNopLogger(), nil)}
+	cfg.HTTPServer.Apply(s, nil)
+	go s.Serve(listener) //nolint:errcheck
+	defer s.Close()
+
+	get := func(querySize int) (int, string) {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/api/v1/query?query=" + strings.Repeat("a", querySize))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, _ := get(512)
+	assert.Equal(t, http.StatusOK, status)
+
+	// The requests exceeding the limit are rejected with an error explaining it.
+	status, body := get(1024)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, status)
+	assert.Contains(t, body, "the limit is 1024 bytes (-frontend.max-request-header-bytes)")
+
+	// The ones exceeding the limit of the server only get its generic error.
+	status, _ = get(16 * 1024)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, status)
+}
+
+func TestHTTPServerConfig_ClosesSlowClients(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	s := &http.Server{Handler: okHandler()}
+	HTTPServerConfig{ReadHeaderTimeout: 100 * time.Millisecond}.Apply(s, nil)
+	go s.Serve(listener) //nolint:errcheck
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The client never finishes sending the headers.
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+
+	// The server gives up on the request and closes the connection.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = ioutil.ReadAll(conn)
+	require.NoError(t, err)
+}
+
+func TestHTTPServerConfig_MaxConcurrentConnections(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	s := &http.Server{Handler: okHandler()}
+	HTTPServerConfig{MaxConcurrentConnections: 1}.Apply(s, reg)
+	go s.Serve(listener) //nolint:errcheck
+	defer s.Close()
+
+	// The first connection is kept open.
+	first, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	_, err = first.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(first), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The second one is closed right away.
+	second, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	require.NoError(t, second.SetReadDeadline(time.Now().Add(5*time.Second)))
+	body, err := ioutil.ReadAll(second)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// The rejected connection is reported as closed asynchronously.
+	test.Poll(t, time.Second, nil, func() interface{} {
+		return testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP cortex_query_frontend_open_connections Number of open HTTP connections.
+			# TYPE cortex_query_frontend_open_connections gauge
+			cortex_query_frontend_open_connections 1
+			# HELP cortex_query_frontend_rejected_connections_total Total number of HTTP connections closed because of the max concurrent connections limit.
+			# TYPE cortex_query_frontend_rejected_connections_total counter
+			cortex_query_frontend_rejected_connections_total 1
+		`))
+	})
+
+	// Once the first connection is closed, new connections are accepted again.
+	require.NoError(t, first.Close())
+	test.Poll(t, time.Second, nil, func() interface{} {
+		return testutil.GatherAndCompare(reg, strings.NewReader(`
+			# HELP cortex_query_frontend_open_connections Number of open HTTP connections.
+			# TYPE cortex_query_frontend_open_connections gauge
+			cortex_query_frontend_open_connections 0
+		`), "cortex_query_frontend_open_connections")
+	})
+
+	third, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer third.Close()
+	_, err = third.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(bufio.NewReader(third), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
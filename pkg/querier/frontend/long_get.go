@@ -0,0 +1,50 @@
+package frontend
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// postFormPath matches the endpoints of the Prometheus API accepting their parameters in a POST form.
+var postFormPath = regexp.MustCompile(`/api/v1/(query|query_range|series|labels)$`)
+
+// RoundTripper that sends the GET requests with a long query string as POST requests, with the
+// parameters in the form body, as the downstream may reject long URLs.
+type longGETRoundTripper struct {
+	maxQueryLength int
+	next           http.RoundTripper
+}
+
+// withLongGETsAsPOST wraps the RoundTripper to convert the long GET requests to POST, if enabled.
+func withLongGETsAsPOST(cfg HandlerConfig, next http.RoundTripper) http.RoundTripper {
+	if !cfg.ConvertLongGETsToPOST || next == nil {
+		return next
+	}
+	return &longGETRoundTripper{maxQueryLength: cfg.LongGETQueryLength, next: next}
+}
+
+func (l *longGETRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet || len(r.URL.RawQuery) <= l.maxQueryLength || !postFormPath.MatchString(r.URL.Path) {
+		return l.next.RoundTrip(r)
+	}
+
+	// Don't modify the caller's request. The query string is already form-encoded.
+	form := r.URL.RawQuery
+	post := r.Clone(r.Context())
+	post.Method = http.MethodPost
+	post.URL.RawQuery = ""
+	if r.RequestURI != "" {
+		post.RequestURI = post.URL.RequestURI()
+	}
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	post.Body = ioutil.NopCloser(strings.NewReader(form))
+	post.ContentLength = int64(len(form))
+	post.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(form)), nil
+	}
+
+	return l.next.RoundTrip(post)
+}
@@ -0,0 +1,90 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongGETRoundTripper(t *testing.T) {
+	longQuery := strings.Repeat("a", 100)
+
+	for name, tc := range map[string]struct {
+		disabled       bool
+		method         string
+		url            string
+		expectedMethod string
+	}{
+		"long GET converted": {
+			method:         "GET",
+			url:            "/api/v1/query_range?query=" + longQuery + "&start=1&end=2&step=1",
+			expectedMethod: "POST",
+		},
+		"short GET kept": {
+			method:         "GET",
+			url:            "/api/v1/query_range?query=up&start=1&end=2&step=1",
+			expectedMethod: "GET",
+		},
+		"long GET of an endpoint without POST kept": {
+			method:         "GET",
+			url:            "/api/v1/label/job/values?match[]=" + longQuery,
+			expectedMethod: "GET",
+		},
+		"long GET kept when disabled": {
+			disabled:       true,
+			method:         "GET",
+			url:            "/api/v1/query?query=" + longQuery,
+			expectedMethod: "GET",
+		},
+		"POST kept": {
+			method:         "POST",
+			url:            "/api/v1/series?match[]=" + longQuery,
+			expectedMethod: "POST",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultHandlerConfig()
+			cfg.ConvertLongGETsToPOST = !tc.disabled
+			cfg.LongGETQueryLength = 50
+
+			var (
+				observed *http.Request
+				form     url.Values
+			)
+			rt := withLongGETsAsPOST(cfg, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				observed = r
+				require.NoError(t, r.ParseForm())
+				form = r.Form
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			}))
+
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			req.Header.Set("X-Scope-OrgID", "user-1")
+			_, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+
+			// All the parameters and headers are preserved.
+			expected, err := url.ParseQuery(req.URL.RawQuery)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedMethod, observed.Method)
+			assert.Equal(t, expected, form)
+			assert.Equal(t, "user-1", observed.Header.Get("X-Scope-OrgID"))
+
+			if tc.method == "GET" && tc.expectedMethod == "POST" {
+				assert.Empty(t, observed.URL.RawQuery)
+				assert.Equal(t, req.URL.Path, observed.RequestURI)
+				assert.Equal(t, "application/x-www-form-urlencoded", observed.Header.Get("Content-Type"))
+
+				// The caller's request is unchanged.
+				assert.Equal(t, "GET", req.Method)
+				assert.Equal(t, tc.url, req.RequestURI)
+			}
+		})
+	}
+}
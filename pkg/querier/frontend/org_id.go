@@ -0,0 +1,145 @@
+package frontend
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+)
+
+const (
+	OrgIDValidationDisabled = "disabled"
+	OrgIDValidationStrict   = "strict"
+	OrgIDValidationLenient  = "lenient"
+
+	// Invalid characters of the tenant IDs are replaced with this in the lenient mode.
+	orgIDReplacementChar = "_"
+)
+
+// OrgIDValidationConfig configures the validation of the tenant IDs of the incoming requests.
+type OrgIDValidationConfig struct {
+	Mode              string `yaml:"org_id_validation"`
+	AllowedCharacters string `yaml:"org_id_allowed_characters"`
+	MaxLength         int    `yaml:"org_id_max_length"`
+}
+
+func (cfg *OrgIDValidationConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Mode, "frontend.org-id-validation", OrgIDValidationDisabled, fmt.Sprintf("Validation of the tenant IDs of the incoming requests. Supported values are: %s (the tenant IDs aren't validated), %s (requests with invalid tenant IDs are rejected with HTTP 400), %s (invalid characters are replaced with %s, too long tenant IDs are truncated, and the request is logged).", OrgIDValidationDisabled, OrgIDValidationStrict, OrgIDValidationLenient, orgIDReplacementChar))
+	f.StringVar(&cfg.AllowedCharacters, "frontend.org-id-allowed-characters", `a-zA-Z0-9!._*'()-`, "Characters allowed in tenant IDs, in the format of a regular expression character class. The '|' separating the tenant IDs of a query spanning multiple tenants is always allowed.")
+	f.IntVar(&cfg.MaxLength, "frontend.org-id-max-length", 150, "Maximum length of tenant IDs, in characters. 0 to disable.")
+}
+
+func (cfg *OrgIDValidationConfig) Validate() error {
+	switch cfg.Mode {
+	case OrgIDValidationDisabled, OrgIDValidationStrict, OrgIDValidationLenient:
+	default:
+		return fmt.Errorf("unsupported org ID validation: %s", cfg.Mode)
+	}
+
+	if cfg.Mode != OrgIDValidationDisabled {
+		if _, err := compileInvalidCharacters(cfg.AllowedCharacters); err != nil {
+			return fmt.Errorf("invalid org ID allowed characters: %v", err)
+		}
+	}
+	return nil
+}
+
+// compileInvalidCharacters returns the regular expression matching the characters not allowed.
+func compileInvalidCharacters(allowed string) (*regexp.Regexp, error) {
+	return regexp.Compile("[^" + allowed + "]")
+}
+
+// orgIDValidator validates, or sanitizes, the tenant IDs.
+type orgIDValidator struct {
+	lenient   bool
+	invalid   *regexp.Regexp
+	maxLength int
+}
+
+// newOrgIDValidator returns the validator of the tenant IDs, or nil if they aren't validated.
+func newOrgIDValidator(cfg OrgIDValidationConfig) *orgIDValidator {
+	if cfg.Mode == "" || cfg.Mode == OrgIDValidationDisabled {
+		return nil
+	}
+
+	// The config has been validated already.
+	invalid, err := compileInvalidCharacters(cfg.AllowedCharacters)
+	if err != nil {
+		panic(err)
+	}
+
+	return &orgIDValidator{
+		lenient:   cfg.Mode == OrgIDValidationLenient,
+		invalid:   invalid,
+		maxLength: cfg.MaxLength,
+	}
+}
+
+// validate returns the tenant ID, sanitized in the lenient mode, or an error if it's invalid.
+func (v *orgIDValidator) validate(tenantID string) (string, error) {
+	if v.lenient {
+		tenantID = v.invalid.ReplaceAllLiteralString(tenantID, orgIDReplacementChar)
+		if runes := []rune(tenantID); v.maxLength > 0 && len(runes) > v.maxLength {
+			tenantID = string(runes[:v.maxLength])
+		}
+		return tenantID, nil
+	}
+
+	if v.invalid.MatchString(tenantID) {
+		return "", fmt.Errorf("tenant ID %q contains invalid characters", tenantID)
+	}
+	if length := len([]rune(tenantID)); v.maxLength > 0 && length > v.maxLength {
+		return "", fmt.Errorf("tenant ID %q is too long (length: %d, max length: %d)", tenantID, length, v.maxLength)
+	}
+	return tenantID, nil
+}
+
+// normalizeOrgID validates the tenant IDs of the request, and sorts and de-duplicates the ones of
+// a query spanning multiple tenants, so that it's queued, limited and cached the same way regardless
+// of the order of its tenants. The org ID is updated both in the context and in the header, which
+// is checked for consistency when the org ID is injected into the downstream request.
+func normalizeOrgID(r *http.Request, v *orgIDValidator, logger log.Logger) (*http.Request, error) {
+	orgID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		// Requests without a tenant are rejected downstream.
+		return r, nil
+	}
+
+	tenantIDs, err := tenant.TenantIDsFromOrgID(orgID)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid org ID %q: %v", orgID, err)
+	}
+
+	if v != nil {
+		for i, tenantID := range tenantIDs {
+			if tenantIDs[i], err = v.validate(tenantID); err != nil {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid org ID %q: %v", orgID, err)
+			}
+		}
+	}
+
+	// Sanitized tenant IDs may need to be sorted and de-duplicated again.
+	normalized, err := tenant.NormalizeOrgID(tenant.JoinTenantIDs(tenantIDs))
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid org ID %q: %v", orgID, err)
+	}
+	// The header may be missing when the org ID has been injected by the auth middleware,
+	// e.g. when auth is disabled, but it's still needed by the queriers and the downstream.
+	if normalized == orgID && r.Header.Get(user.OrgIDHeaderName) == orgID {
+		return r, nil
+	}
+
+	if v != nil && v.lenient {
+		level.Warn(logger).Log("msg", "sanitized invalid org ID", "org_id", orgID, "sanitized_org_id", normalized)
+	}
+
+	r.Header.Set(user.OrgIDHeaderName, normalized)
+	return r.WithContext(user.InjectOrgID(r.Context(), normalized)), nil
+}
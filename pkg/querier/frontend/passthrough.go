@@ -0,0 +1,85 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// PassthroughPaths matches the paths of the requests forwarded as is, without going through the
+// query middlewares splitting, caching and parsing the queries. The requests still go through the
+// authentication, the logging and the limits of the handler.
+type PassthroughPaths struct {
+	pathPrefix string
+	prefixes   []string
+	regexps    []*regexp.Regexp
+}
+
+// NewPassthroughPaths returns the passthrough paths of the config, or nil if there are none. The
+// paths starting with ^ are regular expressions, the other ones are prefixes.
+func NewPassthroughPaths(cfg HandlerConfig) (*PassthroughPaths, error) {
+	if len(cfg.PassthroughPaths) == 0 {
+		return nil, nil
+	}
+
+	p := &PassthroughPaths{pathPrefix: cfg.PathPrefix}
+	for _, path := range cfg.PassthroughPaths {
+		if !strings.HasPrefix(path, "^") {
+			p.prefixes = append(p.prefixes, path)
+			continue
+		}
+		re, err := regexp.Compile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -frontend.passthrough-paths regular expression %q: %v", path, err)
+		}
+		p.regexps = append(p.regexps, re)
+	}
+	return p, nil
+}
+
+// Matches returns whether the request received by the server is forwarded as is. The path prefix
+// the query-frontend is hosted under is ignored, as the handler strips it.
+func (p *PassthroughPaths) Matches(r *http.Request) bool {
+	path := r.URL.Path
+	if p.pathPrefix != "" && strings.HasPrefix(path, p.pathPrefix+"/") {
+		path = strings.TrimPrefix(path, p.pathPrefix)
+	}
+	return p.matches(path)
+}
+
+func (p *PassthroughPaths) matches(path string) bool {
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	for _, re := range p.regexps {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap returns the round tripper forwarding the requests matching the passthrough paths to next,
+// and the other ones to the query middlewares.
+func (p *PassthroughPaths) Wrap(next, middlewares http.RoundTripper) http.RoundTripper {
+	if p == nil {
+		return middlewares
+	}
+	return passthroughRoundTripper{paths: p, next: next, middlewares: middlewares}
+}
+
+type passthroughRoundTripper struct {
+	paths       *PassthroughPaths
+	next        http.RoundTripper
+	middlewares http.RoundTripper
+}
+
+func (rt passthroughRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if rt.paths.matches(r.URL.Path) {
+		return rt.next.RoundTrip(r)
+	}
+	return rt.middlewares.RoundTrip(r)
+}
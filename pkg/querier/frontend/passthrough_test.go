@@ -0,0 +1,66 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassthroughPaths(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.PassthroughPaths = []string{"/api/v1/admin/tsdb/", `^/api/v1/custom/[a-z]+$`}
+	cfg.PathPrefix = "/prefix"
+	p, err := NewPassthroughPaths(cfg)
+	require.NoError(t, err)
+
+	for path, expected := range map[string]bool{
+		"/api/v1/admin/tsdb/delete_series":        true,
+		"/prefix/api/v1/admin/tsdb/delete_series": true,
+		"/api/v1/custom/endpoint":                 true,
+		"/api/v1/custom/endpoint/sub":             false,
+		"/api/v1/query_range":                     false,
+		"/prefix/api/v1/query_range":              false,
+	} {
+		assert.Equal(t, expected, p.Matches(httptest.NewRequest("GET", path, nil)), path)
+	}
+}
+
+func TestPassthroughPaths_Wrap(t *testing.T) {
+	cfg := defaultHandlerConfig()
+	cfg.PassthroughPaths = []string{"/api/v1/admin/tsdb/"}
+	p, err := NewPassthroughPaths(cfg)
+	require.NoError(t, err)
+
+	respond := func(body string) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+		})
+	}
+	rt := p.Wrap(respond("next"), respond("middlewares"))
+
+	for path, expected := range map[string]string{
+		"/api/v1/admin/tsdb/snapshot": "next",
+		"/api/v1/query_range":         "middlewares",
+	} {
+		resp, err := rt.RoundTrip(httptest.NewRequest("POST", path, nil))
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(body), path)
+	}
+
+	// Without passthrough paths, all the requests go through the middlewares.
+	p, err = NewPassthroughPaths(defaultHandlerConfig())
+	require.NoError(t, err)
+	assert.Nil(t, p)
+	resp, err := p.Wrap(respond("next"), respond("middlewares")).RoundTrip(httptest.NewRequest("POST", "/api/v1/admin/tsdb/snapshot", nil))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "middlewares", string(body))
+}
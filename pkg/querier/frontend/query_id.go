@@ -0,0 +1,106 @@
+package frontend
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/weaveworks/common/httpgrpc"
+)
+
+// QueryIDHeader is the header carrying the ID which correlates the logs and traces
+// of a query in the query-frontend and the querier. If the client doesn't send it,
+// the query-frontend generates one.
+const QueryIDHeader = "X-Query-ID"
+
+// Formats of the generated query IDs.
+const (
+	QueryIDFormatUUID   = "uuid"
+	QueryIDFormatBase62 = "base62"
+)
+
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// Length of the base62 query IDs, carrying about 95 bits of randomness.
+	base62QueryIDLength = 16
+)
+
+var queryIDFormats = []string{QueryIDFormatUUID, QueryIDFormatBase62}
+
+// QueryIDConfig configures the header of the client requests carrying the query ID, and
+// the format of the query IDs generated when the client doesn't send one.
+type QueryIDConfig struct {
+	Header string `yaml:"query_id_header"`
+	Format string `yaml:"query_id_format"`
+}
+
+func (cfg *QueryIDConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Header, "frontend.query-id-header", QueryIDHeader, "Header of the client requests carrying the query ID, reused if present and otherwise generated. The query ID is set in this header of the response, and logged with the slow queries. It's always forwarded to the queriers in the "+QueryIDHeader+" header.")
+	f.StringVar(&cfg.Format, "frontend.query-id-format", QueryIDFormatUUID, fmt.Sprintf("Format of the generated query IDs. Supported values: %s.", strings.Join(queryIDFormats, ", ")))
+}
+
+func (cfg *QueryIDConfig) Validate() error {
+	if cfg.Header == "" {
+		return fmt.Errorf("invalid -frontend.query-id-header: must not be empty")
+	}
+	if cfg.Format != QueryIDFormatUUID && cfg.Format != QueryIDFormatBase62 {
+		return fmt.Errorf("invalid -frontend.query-id-format %q: supported values are %s", cfg.Format, strings.Join(queryIDFormats, ", "))
+	}
+	return nil
+}
+
+type queryIDContextKey int
+
+const queryIDKey queryIDContextKey = 0
+
+func newQueryID(format string) string {
+	if format == QueryIDFormatBase62 {
+		return newBase62ID(base62QueryIDLength)
+	}
+	return uuid.New().String()
+}
+
+func newBase62ID(length int) string {
+	max := big.NewInt(int64(len(base62Alphabet)))
+	id := make([]byte, length)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			// The system random source never fails on the supported platforms.
+			panic(err)
+		}
+		id[i] = base62Alphabet[n.Int64()]
+	}
+	return string(id)
+}
+
+// injectQueryID returns a derived context carrying the query ID, so that it's propagated
+// to the sub-queries which don't keep the headers of the original request.
+func injectQueryID(ctx context.Context, queryID string) context.Context {
+	return context.WithValue(ctx, queryIDKey, queryID)
+}
+
+// extractQueryID returns the query ID carried by the context, or an empty string if none.
+func extractQueryID(ctx context.Context) string {
+	queryID, _ := ctx.Value(queryIDKey).(string)
+	return queryID
+}
+
+// setQueryIDHeader sets the query ID header on the request, replacing any previous value.
+func setQueryIDHeader(req *httpgrpc.HTTPRequest, queryID string) {
+	// The headers are converted back to an http.Header as they are, so the key must be canonical.
+	key := http.CanonicalHeaderKey(QueryIDHeader)
+	for _, h := range req.Headers {
+		if http.CanonicalHeaderKey(h.Key) == key {
+			h.Values = []string{queryID}
+			return
+		}
+	}
+	req.Headers = append(req.Headers, &httpgrpc.Header{Key: key, Values: []string{queryID}})
+}
@@ -0,0 +1,45 @@
+package frontend
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// Buckets of the time range and of the step of the range queries, in seconds: from a few minutes
+// to 90 days, and from 1 second to 1 day.
+var (
+	queryRangeBuckets = []float64{300, 900, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 86400, 2 * 86400, 7 * 86400, 14 * 86400, 30 * 86400, 90 * 86400}
+	queryStepBuckets  = []float64{1, 5, 15, 30, 60, 120, 300, 900, 3600, 3 * 3600, 86400}
+)
+
+// observeQueryRange tracks the time range and the step of the range queries, to know the shape of
+// the workload, eg. to tune the split interval. The queries whose parameters can't be parsed are
+// skipped, they're rejected downstream anyway.
+func (f *Handler) observeQueryRange(r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/api/v1/query_range") {
+		return
+	}
+
+	form, err := requestForm(r)
+	if err != nil {
+		return
+	}
+	start, err := util.ParseTime(form.Get("start"))
+	if err != nil {
+		return
+	}
+	end, err := util.ParseTime(form.Get("end"))
+	if err != nil || end < start {
+		return
+	}
+	step, err := parseDuration(form.Get("step"))
+	if err != nil || step <= 0 {
+		return
+	}
+
+	userID := f.userLabel(r)
+	f.queryRange.WithLabelValues(userID).Observe(float64(end-start) / 1000)
+	f.queryStep.WithLabelValues(userID).Observe(step.Seconds())
+}
@@ -0,0 +1,121 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestHandler_QueryRangeStats(t *testing.T) {
+	params := func(start, end, step string) url.Values {
+		return url.Values{"query": []string{"up"}, "start": []string{start}, "end": []string{end}, "step": []string{step}}
+	}
+
+	for name, tc := range map[string]struct {
+		path          string
+		params        url.Values
+		form          bool
+		expectedRange float64
+		expectedStep  float64
+		skipped       bool
+	}{
+		"range query": {
+			path:          "/api/v1/query_range",
+			params:        params("1536673680", "1536716880", "120"),
+			expectedRange: 43200,
+			expectedStep:  120,
+		},
+		"range query with RFC3339 times and a duration step": {
+			path:          "/prometheus/api/v1/query_range",
+			params:        params("2020-10-01T00:00:00Z", "2020-10-08T00:00:00Z", "1h"),
+			expectedRange: 7 * 86400,
+			expectedStep:  3600,
+		},
+		"range query sent with POST": {
+			path:          "/api/v1/query_range",
+			params:        params("1536673680", "1536673980.5", "15s"),
+			form:          true,
+			expectedRange: 300.5,
+			expectedStep:  15,
+		},
+		"instant query": {
+			path:    "/api/v1/query",
+			params:  url.Values{"query": []string{"up"}, "time": []string{"1536673680"}},
+			skipped: true,
+		},
+		"invalid start": {
+			path:    "/api/v1/query_range",
+			params:  params("yesterday", "1536716880", "120"),
+			skipped: true,
+		},
+		"end before start": {
+			path:    "/api/v1/query_range",
+			params:  params("1536716880", "1536673680", "120"),
+			skipped: true,
+		},
+		"missing step": {
+			path:    "/api/v1/query_range",
+			params:  params("1536673680", "1536716880", ""),
+			skipped: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var forwardedBody string
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				if r.Body != nil {
+					b, err := ioutil.ReadAll(r.Body)
+					require.NoError(t, err)
+					forwardedBody = string(b)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(responseBody))}, nil
+			})
+			h := NewHandler(defaultHandlerConfig(), nil, rt, log.NewNopLogger(), prometheus.NewPedanticRegistry())
+
+			var req *http.Request
+			if tc.form {
+				req = httptest.NewRequest("POST", tc.path, strings.NewReader(tc.params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest("GET", tc.path+"?"+tc.params.Encode(), nil)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req.WithContext(user.InjectOrgID(req.Context(), "1")))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			if tc.form {
+				// The body is still forwarded downstream.
+				assert.Equal(t, tc.params.Encode(), forwardedBody)
+			}
+
+			if tc.skipped {
+				assert.Equal(t, 0, testutil.CollectAndCount(h.queryRange))
+				assert.Equal(t, 0, testutil.CollectAndCount(h.queryStep))
+				return
+			}
+			assert.Equal(t, tc.expectedRange, histogramSum(t, h.queryRange))
+			assert.Equal(t, tc.expectedStep, histogramSum(t, h.queryStep))
+		})
+	}
+}
+
+// histogramSum returns the sum of the observations of the histogram of the only tenant.
+func histogramSum(t *testing.T, h *prometheus.HistogramVec) float64 {
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(h))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	require.Len(t, families[0].Metric, 1)
+	assert.Equal(t, uint64(1), families[0].Metric[0].GetHistogram().GetSampleCount())
+	return families[0].Metric[0].GetHistogram().GetSampleSum()
+}
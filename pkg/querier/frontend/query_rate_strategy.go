@@ -0,0 +1,38 @@
+package frontend
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/util/limiter"
+)
+
+// Period after which the per-tenant rate limiters pick up changes to the limits.
+const queryRateRecheckPeriod = 10 * time.Second
+
+type queryRateStrategy struct {
+	limits Limits
+}
+
+func newQueryRateStrategy(limits Limits) limiter.RateLimiterStrategy {
+	return &queryRateStrategy{
+		limits: limits,
+	}
+}
+
+func (s *queryRateStrategy) Limit(tenantID string) float64 {
+	if limit := s.limits.QueryRate(tenantID); limit > 0 {
+		return float64(limit)
+	}
+	return float64(rate.Inf)
+}
+
+func (s *queryRateStrategy) Burst(tenantID string) int {
+	// Burst is ignored when limit = rate.Inf. Otherwise at least one query must be allowed,
+	// or the tenant would be rejected whatever the rate.
+	if burst := s.limits.QueryBurst(tenantID); burst > 0 {
+		return burst
+	}
+	return 1
+}
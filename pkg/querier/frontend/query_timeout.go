@@ -0,0 +1,86 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// withQueryTimeout returns the request with the deadline requested by its timeout parameter, clamped
+// to the query timeout of the tenants, and the function cancelling it. The request is returned as is
+// if it has no timeout parameter, and an error is returned if the parameter is invalid.
+func withQueryTimeout(r *http.Request, limits Limits) (*http.Request, context.CancelFunc, error) {
+	form, err := requestForm(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	param := form.Get("timeout")
+	if param == "" {
+		return r, func() {}, nil
+	}
+	timeout, err := parseDuration(param)
+	if err != nil {
+		return nil, nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid parameter \"timeout\": %v", err)
+	}
+
+	if limits != nil {
+		if tenantIDs, err := tenant.TenantIDs(r.Context()); err == nil {
+			if max := validation.SmallestPositiveDurationPerTenant(tenantIDs, limits.QueryTimeout); max > 0 && max < timeout {
+				timeout = max
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return r.WithContext(ctx), cancel, nil
+}
+
+// requestForm returns the parameters of the request, from its URL and its body. The form is parsed
+// on a copy of the request, so that the body can still be forwarded downstream.
+func requestForm(r *http.Request) (url.Values, error) {
+	clone := r.Clone(r.Context())
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			// Forward what's been read, the rest of the body fails the same way.
+			r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			return nil, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err := clone.ParseForm(); err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	return clone.Form, nil
+}
+
+// parseDuration parses a duration parameter, like the timeout or the step, as Prometheus does,
+// either as a number of seconds or as a duration like 30s.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration. It overflows int64", s)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
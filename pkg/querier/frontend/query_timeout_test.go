@@ -0,0 +1,119 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestHandler_QueryTimeoutParam(t *testing.T) {
+	for name, tc := range map[string]struct {
+		disabled         bool
+		params           url.Values
+		form             bool
+		limits           Limits
+		expectedStatus   int
+		expectedBody     string
+		expectedDeadline time.Duration
+	}{
+		"no timeout parameter": {
+			params:         url.Values{"query": []string{"up"}},
+			expectedStatus: http.StatusOK,
+		},
+		"timeout as a duration": {
+			params:           url.Values{"query": []string{"up"}, "timeout": []string{"30s"}},
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: 30 * time.Second,
+		},
+		"timeout as seconds": {
+			params:           url.Values{"query": []string{"up"}, "timeout": []string{"1.5"}},
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: 1500 * time.Millisecond,
+		},
+		"timeout in the form body": {
+			params:           url.Values{"query": []string{"up"}, "timeout": []string{"30s"}},
+			form:             true,
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: 30 * time.Second,
+		},
+		"timeout below the query timeout": {
+			params:           url.Values{"query": []string{"up"}, "timeout": []string{"30s"}},
+			limits:           limits{queryTimeout: time.Minute},
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: 30 * time.Second,
+		},
+		"timeout clamped to the query timeout": {
+			params:           url.Values{"query": []string{"up"}, "timeout": []string{"5m"}},
+			limits:           limits{queryTimeout: time.Minute},
+			expectedStatus:   http.StatusOK,
+			expectedDeadline: time.Minute,
+		},
+		"invalid timeout": {
+			params:         url.Values{"query": []string{"up"}, "timeout": []string{"soon"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"status":"error","errorType":"bad_data","error":"invalid parameter \"timeout\": cannot parse \"soon\" to a valid duration"}`,
+		},
+		"disabled": {
+			disabled:       true,
+			params:         url.Values{"query": []string{"up"}, "timeout": []string{"soon"}},
+			expectedStatus: http.StatusOK,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				deadline    time.Time
+				hasDeadline bool
+				body        string
+			)
+			rt := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				deadline, hasDeadline = r.Context().Deadline()
+				b, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				body = string(b)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{},
+					Body:       ioutil.NopCloser(strings.NewReader(responseBody)),
+				}, nil
+			})
+
+			cfg := defaultHandlerConfig()
+			cfg.QueryTimeoutParam = !tc.disabled
+			h := NewHandler(cfg, tc.limits, rt, log.NewNopLogger(), nil)
+
+			req := httptest.NewRequest("GET", "/api/v1/query?"+tc.params.Encode(), nil)
+			if tc.form {
+				req = httptest.NewRequest("POST", "/api/v1/query", strings.NewReader(tc.params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			w := httptest.NewRecorder()
+			start := time.Now()
+			h.ServeHTTP(w, req.WithContext(user.InjectOrgID(req.Context(), "1")))
+
+			require.Equal(t, tc.expectedStatus, w.Code)
+			if tc.expectedStatus != http.StatusOK {
+				assert.Equal(t, tc.expectedBody, w.Body.String())
+				return
+			}
+			if tc.form {
+				// The body is still forwarded downstream.
+				assert.Equal(t, tc.params.Encode(), body)
+			}
+			if tc.expectedDeadline == 0 {
+				assert.False(t, hasDeadline)
+				return
+			}
+			require.True(t, hasDeadline)
+			assert.WithinDuration(t, start.Add(tc.expectedDeadline), deadline, time.Second)
+		})
+	}
+}
@@ -3,15 +3,19 @@ package frontend
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
 )
 
 func setupFrontend(config Config) (*Frontend, error) {
@@ -60,16 +64,16 @@ func TestDequeuesExpiredRequests(t *testing.T) {
 	}
 
 	// the first request shouldn't be expired
-	req, idx, err := f.getNextRequestForQuerier(ctx, -1, "")
+	req, err := f.getNextRequestForQuerier(ctx, "")
 	require.Nil(t, err)
 	require.NotNil(t, req)
-	require.Equal(t, 9, len(f.queues.getOrAddQueue(userID, 0)))
+	require.Equal(t, 9, len(f.queues.getOrAddQueue(userID, 0, 0, 1)))
 
 	// the next unexpired request should be the 5th index
-	req, idx, err = f.getNextRequestForQuerier(ctx, idx, "")
+	req, err = f.getNextRequestForQuerier(ctx, "")
 	require.Nil(t, err)
 	require.NotNil(t, req)
-	require.Equal(t, 4, len(f.queues.getOrAddQueue(userID, 0)))
+	require.Equal(t, 4, len(f.queues.getOrAddQueue(userID, 0, 0, 1)))
 
 	// add one request to a second tenant queue
 	ctx2 := user.InjectOrgID(context.Background(), userID2)
@@ -77,19 +81,17 @@ func TestDequeuesExpiredRequests(t *testing.T) {
 	require.Nil(t, err)
 
 	// there should be no more unexpired requests in queue until the second tenant enqueues one.
-	req, _, err = f.getNextRequestForQuerier(ctx, idx, "")
+	req, err = f.getNextRequestForQuerier(ctx, "")
 	require.Nil(t, err)
 	require.NotNil(t, req)
 
 	// ensure either one or two queues are fully drained, depending on which was requested first
-	_, ok := f.queues.userQueues[userID]
-	if ok {
+	if f.queues.getQueue(userID) != nil {
 		// if the second user's queue was chosen for the last request,
 		// the first queue should still contain 4 (expired) requests.
-		require.Equal(t, 4, len(f.queues.getOrAddQueue(userID, 0)))
+		require.Equal(t, 4, len(f.queues.getOrAddQueue(userID, 0, 0, 1)))
 	}
-	_, ok = f.queues.userQueues[userID2]
-	require.Equal(t, false, ok)
+	require.Nil(t, f.queues.getQueue(userID2))
 }
 
 func TestRoundRobinQueues(t *testing.T) {
@@ -109,12 +111,10 @@ func TestRoundRobinQueues(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	idx := -1
 	for i := 0; i < 100; i++ {
-		req, nidx, err := f.getNextRequestForQuerier(ctx, idx, "")
+		req, err := f.getNextRequestForQuerier(ctx, "")
 		require.NoError(t, err)
 		require.NotNil(t, req)
-		idx = nidx
 
 		userID, err := user.ExtractOrgID(req.originalCtx)
 		require.NoError(t, err)
@@ -125,6 +125,78 @@ func TestRoundRobinQueues(t *testing.T) {
 	}
 }
 
+func TestWeightedRoundRobinQueues(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+	config.MaxOutstandingPerTenant = 100
+
+	f, err := New(config, limits{weights: map[string]float64{"heavy": 3}}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	// The tenant with the most queued requests doesn't get more than its share of the queriers.
+	for _, tc := range []struct {
+		userID string
+		count  int
+	}{{"heavy", 20}, {"default", 90}} {
+		ctx := user.InjectOrgID(context.Background(), tc.userID)
+		for i := 0; i < tc.count; i++ {
+			require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+		}
+	}
+
+	dispatched := map[string]int{}
+	for i := 0; i < 20; i++ {
+		req, err := f.getNextRequestForQuerier(context.Background(), "")
+		require.NoError(t, err)
+		dispatched[req.userID]++
+	}
+	require.Equal(t, map[string]int{"heavy": 15, "default": 5}, dispatched)
+}
+
+func TestFrontendDispatchesFromInjectedQueues(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	queues := &priorityQueues{queues: newUserQueues(config.MaxOutstandingPerTenant), priority: []string{"c", "a", "b"}}
+	f, err := newFrontend(config, queues, limits{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	for _, userID := range []string{"a", "b", "c", "a", "b", "c"} {
+		ctx := user.InjectOrgID(context.Background(), userID)
+		require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+	}
+
+	// The requests are dispatched in the order decided by the queues.
+	var order []string
+	for i := 0; i < 6; i++ {
+		req, err := f.getNextRequestForQuerier(context.Background(), "querier")
+		require.NoError(t, err)
+		order = append(order, req.userID)
+	}
+	require.Equal(t, []string{"c", "c", "a", "a", "b", "b"}, order)
+	require.Equal(t, order, queues.dispatchedUsers)
+}
+
+// priorityQueues always serves the first user of the priority list with queued requests.
+type priorityQueues struct {
+	*queues
+	priority        []string
+	dispatchedUsers []string
+}
+
+func (q *priorityQueues) getNextQueueForQuerier(_ string, skip func(userID string) bool) (chan *request, string) {
+	for _, userID := range q.priority {
+		if queue := q.getQueue(userID); queue != nil && !skip(userID) {
+			return queue, userID
+		}
+	}
+	return nil, ""
+}
+
+func (q *priorityQueues) dispatched(userID string) {
+	q.dispatchedUsers = append(q.dispatchedUsers, userID)
+}
+
 func BenchmarkGetNextRequest(b *testing.B) {
 	var config Config
 	flagext.DefaultValues(&config)
@@ -142,7 +214,7 @@ func BenchmarkGetNextRequest(b *testing.B) {
 		}
 
 		for ix := 0; ix < queriers; ix++ {
-			f.registerQuerierConnection(fmt.Sprintf("querier-%d", ix))
+			f.registerQuerierConnection(fmt.Sprintf("querier-%d", ix), 1)
 		}
 
 		for i := 0; i < config.MaxOutstandingPerTenant; i++ {
@@ -163,24 +235,22 @@ func BenchmarkGetNextRequest(b *testing.B) {
 	ctx := context.Background()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		idx := -1
-		for j := 0; j < config.MaxOutstandingPerTenant*numTenants; j++ {
+			for j := 0; j < config.MaxOutstandingPerTenant*numTenants; j++ {
 			querier := ""
 		b:
 			// Find querier with at least one request to avoid blocking in getNextRequestForQuerier.
-			for _, q := range frontends[i].queues.userQueues {
+			for _, q := range frontends[i].queues.(*queues).userQueues {
 				for qid := range q.queriers {
 					querier = qid
 					break b
 				}
 			}
 
-			_, nidx, err := frontends[i].getNextRequestForQuerier(ctx, idx, querier)
+			_, err := frontends[i].getNextRequestForQuerier(ctx, querier)
 			if err != nil {
 				b.Fatal(err)
 			}
-			idx = nidx
-		}
+			}
 	}
 }
 
@@ -203,7 +273,7 @@ func BenchmarkQueueRequest(b *testing.B) {
 		}
 
 		for ix := 0; ix < queriers; ix++ {
-			f.registerQuerierConnection(fmt.Sprintf("querier-%d", ix))
+			f.registerQuerierConnection(fmt.Sprintf("querier-%d", ix), 1)
 		}
 
 		frontends = append(frontends, f)
@@ -230,3 +300,179 @@ func BenchmarkQueueRequest(b *testing.B) {
 		}
 	}
 }
+
+func TestQueueFullHintsRetryAfterFromQueueDuration(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+	config.MaxOutstandingPerTenant = 1
+
+	f, err := setupFrontend(config)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// No request has been dequeued yet, so there's no hint about the queue pressure.
+	require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+	err = f.queueRequest(ctx, testReq(ctx))
+	require.Equal(t, errTooManyRequest, err)
+
+	// Once requests have been dequeued, the hint is based on the time they spent in the queue.
+	_, err = f.getNextRequestForQuerier(ctx, "")
+	require.NoError(t, err)
+	f.avgQueueDuration = 2500 * time.Millisecond
+
+	require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+	err = f.queueRequest(ctx, testReq(ctx))
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	require.Equal(t, int32(http.StatusTooManyRequests), resp.Code)
+	require.Equal(t, []*httpgrpc.Header{{Key: "Retry-After", Values: []string{"3"}}}, resp.Headers)
+}
+
+func TestQueueDurationIsObservedOnDispatch(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	reg := prometheus.NewPedanticRegistry()
+	f, err := New(config, limits{queriers: 3}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+	require.Equal(t, uint64(0), queueDurationSampleCount(t, reg))
+
+	_, err = f.getNextRequestForQuerier(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), queueDurationSampleCount(t, reg))
+}
+
+func TestMaxConcurrentQueriesPerTenant(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	f, err := New(config, limits{queriers: 3, concurrency: 1}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	ctx1 := user.InjectOrgID(context.Background(), "1")
+	ctx2 := user.InjectOrgID(context.Background(), "2")
+	require.NoError(t, f.queueRequest(ctx1, testReq(ctx1)))
+	require.NoError(t, f.queueRequest(ctx1, testReq(ctx1)))
+	require.NoError(t, f.queueRequest(ctx2, testReq(ctx2)))
+
+	first, err := f.getNextRequestForQuerier(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, "1", first.userID)
+
+	// Tenant 1 is executing as many queries as allowed, so the request of tenant 2 comes next.
+	second, err := f.getNextRequestForQuerier(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, "2", second.userID)
+
+	// The remaining request of tenant 1 waits in the queue.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		f.cond.Broadcast()
+	}()
+	_, err = f.getNextRequestForQuerier(ctx, "")
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 1, f.queues.len())
+
+	// Once the running query of tenant 1 completes, its next request is dispatched.
+	f.releaseRequest(first)
+	third, err := f.getNextRequestForQuerier(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, "1", third.userID)
+}
+
+func TestCancelTenant(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+	config.CancelledTenantBlockDuration = time.Minute
+
+	f, err := New(config, limits{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	ctx1 := user.InjectOrgID(context.Background(), "1")
+	ctx2 := user.InjectOrgID(context.Background(), "2")
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := f.RoundTripGRPC(ctx1, &httpgrpc.HTTPRequest{})
+			errs <- err
+		}()
+	}
+	test.Poll(t, time.Second, 2, func() interface{} {
+		return queuedRequests(f, "1")
+	})
+
+	// Dispatch one request of tenant 1 to a querier, leaving the other one in the queue.
+	inflight, err := f.getNextRequestForQuerier(context.Background(), "")
+	require.NoError(t, err)
+
+	go f.RoundTripGRPC(ctx2, &httpgrpc.HTTPRequest{}) //nolint:errcheck
+	test.Poll(t, time.Second, 1, func() interface{} {
+		return queuedRequests(f, "2")
+	})
+
+	queued, inflightCount := f.CancelTenant("1")
+	require.Equal(t, 1, queued)
+	require.Equal(t, 1, inflightCount)
+
+	// Both requests of tenant 1 fail, and the querier executing one of them sees its context cancelled.
+	require.Equal(t, errCancelledByOperator, <-errs)
+	require.Equal(t, errCancelledByOperator, <-errs)
+	require.Error(t, inflight.originalCtx.Err())
+	f.releaseRequest(inflight)
+
+	// New requests of tenant 1 are refused, while tenant 2 isn't affected.
+	_, err = f.RoundTripGRPC(ctx1, &httpgrpc.HTTPRequest{})
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	require.Equal(t, int32(http.StatusServiceUnavailable), resp.Code)
+	require.Equal(t, "60", resp.Headers[0].Values[0])
+	require.Equal(t, 1, queuedRequests(f, "2"))
+}
+
+func TestCancelTenant_MultipleTenants(t *testing.T) {
+	var config Config
+	flagext.DefaultValues(&config)
+
+	f, err := New(config, limits{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	// Queries spanning multiple tenants are queued separately.
+	ctx := user.InjectOrgID(context.Background(), "2|1")
+	require.NoError(t, f.queueRequest(ctx, testReq(ctx)))
+	ctx3 := user.InjectOrgID(context.Background(), "3")
+	require.NoError(t, f.queueRequest(ctx3, testReq(ctx3)))
+	require.Equal(t, 1, queuedRequests(f, "1|2"))
+
+	// Cancelling one of the tenants cancels the queries spanning it, and refuses new ones.
+	queued, _ := f.CancelTenant("2")
+	require.Equal(t, 1, queued)
+	require.Equal(t, 0, queuedRequests(f, "1|2"))
+	require.Equal(t, 1, queuedRequests(f, "3"))
+	require.Error(t, f.queueRequest(ctx, testReq(ctx)))
+}
+
+func queuedRequests(f *Frontend, userID string) int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return len(f.queues.getQueue(userID))
+}
+
+func queueDurationSampleCount(t *testing.T, reg prometheus.Gatherer) uint64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() == "cortex_query_frontend_queue_duration_seconds" {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package frontend
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	TenantLabelsOverflowOther = "other"
+	TenantLabelsOverflowHash  = "hash"
+
+	// Label value of the tenants beyond the max tenants, in the "other" overflow mode.
+	otherTenantsLabel = "other"
+)
+
+// TenantLabelsConfig limits the number of distinct tenant label values of the query-frontend
+// metrics, to protect Prometheus from an unbounded growth of series.
+type TenantLabelsConfig struct {
+	MaxTenants  int    `yaml:"metrics_max_tenants"`
+	Overflow    string `yaml:"metrics_tenants_overflow"`
+	HashBuckets int    `yaml:"metrics_tenants_hash_buckets"`
+}
+
+func (cfg *TenantLabelsConfig) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxTenants, "frontend.metrics-max-tenants", 0, "Maximum number of distinct tenants labelling the query-frontend metrics. The tenants seen after the limit is reached are labelled according to -frontend.metrics-tenants-overflow. 0 to disable.")
+	f.StringVar(&cfg.Overflow, "frontend.metrics-tenants-overflow", TenantLabelsOverflowOther, fmt.Sprintf("How the tenants beyond -frontend.metrics-max-tenants are labelled in the query-frontend metrics. Supported values are: %s (all labelled as %s), %s (labelled with a bucket of the hash of the tenant ID).", TenantLabelsOverflowOther, otherTenantsLabel, TenantLabelsOverflowHash))
+	f.IntVar(&cfg.HashBuckets, "frontend.metrics-tenants-hash-buckets", 16, "Number of buckets the tenants beyond -frontend.metrics-max-tenants are hashed to, when -frontend.metrics-tenants-overflow is hash.")
+}
+
+func (cfg *TenantLabelsConfig) Validate() error {
+	switch cfg.Overflow {
+	case TenantLabelsOverflowOther:
+	case TenantLabelsOverflowHash:
+		if cfg.HashBuckets <= 0 {
+			return fmt.Errorf("invalid metrics tenants hash buckets: %d", cfg.HashBuckets)
+		}
+	default:
+		return fmt.Errorf("unsupported metrics tenants overflow: %s", cfg.Overflow)
+	}
+	return nil
+}
+
+// tenantLabeler returns the label values of the tenants in the metrics. The first tenants are
+// labelled with their ID, up to the max tenants, and the following ones with the overflow label.
+// The tenants are never forgotten, so that the label value of a tenant doesn't change, and the
+// gauges incremented and decremented with it stay consistent.
+type tenantLabeler struct {
+	cfg TenantLabelsConfig
+
+	mtx     sync.Mutex
+	tenants map[string]struct{}
+}
+
+func newTenantLabeler(cfg TenantLabelsConfig) *tenantLabeler {
+	return &tenantLabeler{cfg: cfg, tenants: map[string]struct{}{}}
+}
+
+func (l *tenantLabeler) label(tenantID string) string {
+	if l == nil || l.cfg.MaxTenants <= 0 {
+		return tenantID
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if _, ok := l.tenants[tenantID]; ok {
+		return tenantID
+	}
+	if len(l.tenants) < l.cfg.MaxTenants {
+		l.tenants[tenantID] = struct{}{}
+		return tenantID
+	}
+
+	if l.cfg.Overflow == TenantLabelsOverflowHash && l.cfg.HashBuckets > 0 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(tenantID))
+		return fmt.Sprintf("hash-%d", h.Sum32()%uint32(l.cfg.HashBuckets))
+	}
+	return otherTenantsLabel
+}
@@ -0,0 +1,48 @@
+package frontend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantLabeler(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg      TenantLabelsConfig
+		expected []string
+	}{
+		"disabled": {
+			cfg:      TenantLabelsConfig{Overflow: TenantLabelsOverflowOther},
+			expected: []string{"a", "b", "c", "a", "d"},
+		},
+		"other": {
+			cfg:      TenantLabelsConfig{MaxTenants: 2, Overflow: TenantLabelsOverflowOther},
+			expected: []string{"a", "b", "other", "a", "other"},
+		},
+		"hash": {
+			cfg:      TenantLabelsConfig{MaxTenants: 2, Overflow: TenantLabelsOverflowHash, HashBuckets: 4},
+			expected: []string{"a", "b", "hash-2", "a", "hash-3"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			l := newTenantLabeler(tc.cfg)
+
+			var actual []string
+			for _, tenantID := range []string{"a", "b", "c", "a", "d"} {
+				actual = append(actual, l.label(tenantID))
+			}
+			assert.Equal(t, tc.expected, actual)
+
+			// The label of a tenant never changes.
+			assert.Equal(t, actual[2], l.label("c"))
+		})
+	}
+}
+
+func TestTenantLabelsConfig_Validate(t *testing.T) {
+	cfg := TenantLabelsConfig{Overflow: "unknown"}
+	assert.EqualError(t, cfg.Validate(), "unsupported metrics tenants overflow: unknown")
+
+	cfg = TenantLabelsConfig{Overflow: TenantLabelsOverflowHash}
+	assert.EqualError(t, cfg.Validate(), "invalid metrics tenants hash buckets: 0")
+}
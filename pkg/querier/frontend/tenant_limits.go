@@ -0,0 +1,88 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// NewTenantLimitsHandler returns the handler exposing the limits of the tenant of the request, as resolved
+// from the overrides and the defaults. The limits are keyed by their names in the configuration file.
+func NewTenantLimitsHandler(cfg HandlerConfig, overrides *validation.Overrides, log log.Logger) http.Handler {
+	authenticator := newAuthenticator(cfg.Auth, log)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authenticator != nil && !authenticator.allows(r) {
+			writeUnauthorized(w)
+			return
+		}
+
+		tenantIDs, err := tenant.TenantIDs(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(tenantIDs) != 1 {
+			http.Error(w, "the limits of a single tenant can be requested", http.StatusBadRequest)
+			return
+		}
+
+		limits, err := limitsValues(overrides.UserLimits(tenantIDs[0]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		util.WriteJSONResponse(w, limits)
+	})
+}
+
+// limitsValues returns the limits as they're written in the configuration file, eg. with durations like
+// "1m0s", so that they can be encoded in JSON.
+func limitsValues(limits validation.Limits) (interface{}, error) {
+	b, err := yaml.Marshal(limits)
+	if err != nil {
+		return nil, err
+	}
+	var values interface{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+	return stringKeys(values)
+}
+
+// stringKeys converts the maps decoded from YAML, keyed by interface{}, into maps keyed by string.
+func stringKeys(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			s, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected key %v of type %T", key, key)
+			}
+			converted, err := stringKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			m[s] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, value := range v {
+			converted, err := stringKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,76 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestTenantLimitsHandler(t *testing.T) {
+	var defaults validation.Limits
+	flagext.DefaultValues(&defaults)
+
+	// The overrides of the tenant, which are reloaded.
+	tenantLimits := defaults
+	tenantLimits.MaxQueryParallelism = 42
+	tenantLimits.MaxQueryLength = 7 * 24 * time.Hour
+	tenantLimits.BlockedQueryFunctions = []string{"holt_winters"}
+	overrides, err := validation.NewOverrides(defaults, func(userID string) *validation.Limits {
+		if userID == "overridden" {
+			limits := tenantLimits
+			return &limits
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	cfg := defaultHandlerConfig()
+	cfg.Auth.BearerToken = flagext.Secret{Value: "token"}
+	handler := NewTenantLimitsHandler(cfg, overrides, log.NewNopLogger())
+
+	get := func(orgID, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest("GET", "/frontend/tenant/limits", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = req.WithContext(user.InjectOrgID(context.Background(), orgID))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var limits map[string]interface{}
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &limits))
+		}
+		return rec, limits
+	}
+
+	rec, limits := get("overridden", "token")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(42), limits["max_query_parallelism"])
+	assert.Equal(t, "168h0m0s", limits["max_query_length"])
+	assert.Equal(t, []interface{}{"holt_winters"}, limits["blocked_query_functions"])
+
+	rec, limits = get("other", "token")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(defaults.MaxQueryParallelism), limits["max_query_parallelism"])
+
+	// The reloaded overrides are returned.
+	tenantLimits.MaxQueryParallelism = 7
+	_, limits = get("overridden", "token")
+	assert.Equal(t, float64(7), limits["max_query_parallelism"])
+
+	rec, _ = get("overridden", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, _ = get("a|b", "token")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
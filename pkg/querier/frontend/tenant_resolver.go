@@ -0,0 +1,488 @@
+package frontend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+const (
+	// Min period between two fetches of the JWKS, when a token is signed by an unknown key.
+	jwksMinRefreshInterval = 10 * time.Second
+
+	// Timeout of the requests fetching the JWKS.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// Source of the tenant ID, in -frontend.tenant-sources, reading it from the JWT bearer token.
+const jwtTenantSource = "jwt"
+
+var errConflictingTenantIDs = errors.New("conflicting tenant IDs")
+
+// TenantResolverConfig configures where the tenant ID is read from, when the clients don't send
+// it in the X-Scope-OrgID header. The tenant ID is then handled as if it was sent in this header.
+type TenantResolverConfig struct {
+	TenantSources       flagext.StringSliceCSV `yaml:"tenant_sources"`
+	TenantSourcesStrict bool                   `yaml:"tenant_sources_strict"`
+	OrgIDHeader         string                 `yaml:"org_id_header"`
+	JWKSURL             string                 `yaml:"jwt_jwks_url"`
+	JWKSRefreshPeriod   time.Duration          `yaml:"jwt_jwks_refresh_period"`
+	JWTIssuer           string                 `yaml:"jwt_issuer"`
+	JWTAudience         string                 `yaml:"jwt_audience"`
+	JWTTenantClaim      string                 `yaml:"jwt_tenant_claim"`
+	JWTAllowedSkew      time.Duration          `yaml:"jwt_allowed_clock_skew"`
+	JWTRequireExpiry    bool                   `yaml:"jwt_require_expiry"`
+}
+
+func (cfg *TenantResolverConfig) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.TenantSources, "frontend.tenant-sources", "Comma separated list of the sources the tenant ID is read from, by order of precedence: the names of the headers, eg. X-Scope-OrgID, or jwt for the claim of the JWT bearer token configured in -frontend.jwt.tenant-claim. The first source with a tenant ID wins, and the headers not in the list are ignored. Empty to only read the tenant ID from -frontend.org-id-header or -frontend.jwt.jwks-url, if configured.")
+	f.BoolVar(&cfg.TenantSourcesStrict, "frontend.tenant-sources-strict", false, "Reject with HTTP 401 the requests whose sources in -frontend.tenant-sources have different tenant IDs, instead of using the first one.")
+	f.StringVar(&cfg.OrgIDHeader, "frontend.org-id-header", "", "Header the tenant ID is read from, instead of X-Scope-OrgID. The X-Scope-OrgID header sent by the clients is ignored. Empty to disable.")
+	f.StringVar(&cfg.JWKSURL, "frontend.jwt.jwks-url", "", "URL of the JSON Web Key Set used to validate the JWT bearer token of the requests, the tenant ID is read from one of its claims instead of the X-Scope-OrgID header. The requests with an invalid token are rejected with HTTP 401. Empty to disable.")
+	f.DurationVar(&cfg.JWKSRefreshPeriod, "frontend.jwt.jwks-refresh-period", time.Hour, "How often the JSON Web Key Set is fetched again. It's also fetched again when a token is signed by an unknown key.")
+	f.StringVar(&cfg.JWTIssuer, "frontend.jwt.issuer", "", "Issuer the JWT bearer tokens must have, in their iss claim. Empty to accept any issuer.")
+	f.StringVar(&cfg.JWTAudience, "frontend.jwt.audience", "", "Audience the JWT bearer tokens must have, in their aud claim. Empty to accept any audience.")
+	f.StringVar(&cfg.JWTTenantClaim, "frontend.jwt.tenant-claim", "", "Claim of the JWT bearer tokens holding the tenant ID. Required along with -frontend.jwt.jwks-url.")
+	f.DurationVar(&cfg.JWTAllowedSkew, "frontend.jwt.allowed-clock-skew", 0, "Clock skew tolerated when checking the exp, nbf and iat claims of the JWT bearer tokens.")
+	f.BoolVar(&cfg.JWTRequireExpiry, "frontend.jwt.require-expiry", true, "Reject the JWT bearer tokens without an exp claim.")
+}
+
+func (cfg *TenantResolverConfig) Validate() error {
+	if len(cfg.TenantSources) > 0 && cfg.OrgIDHeader != "" {
+		return fmt.Errorf("-frontend.tenant-sources and -frontend.org-id-header can't be both configured, add the header to -frontend.tenant-sources instead")
+	}
+	if cfg.OrgIDHeader != "" && cfg.JWKSURL != "" {
+		return fmt.Errorf("-frontend.org-id-header and -frontend.jwt.jwks-url can't be both configured, use -frontend.tenant-sources instead")
+	}
+	if strings.EqualFold(cfg.OrgIDHeader, user.OrgIDHeaderName) {
+		return fmt.Errorf("invalid -frontend.org-id-header %q: the tenant ID is already read from this header", cfg.OrgIDHeader)
+	}
+
+	seen := map[string]bool{}
+	for _, source := range cfg.TenantSources {
+		key := http.CanonicalHeaderKey(source)
+		if source == "" || seen[key] {
+			return fmt.Errorf("invalid -frontend.tenant-sources %q: the sources must be unique and not empty", cfg.TenantSources.String())
+		}
+		seen[key] = true
+	}
+	if len(cfg.TenantSources) > 0 && seen[http.CanonicalHeaderKey(jwtTenantSource)] != (cfg.JWKSURL != "") {
+		return fmt.Errorf("%s must be in -frontend.tenant-sources if and only if -frontend.jwt.jwks-url is configured", jwtTenantSource)
+	}
+
+	if cfg.JWKSURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(cfg.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -frontend.jwt.jwks-url %q: must be an http or https URL", cfg.JWKSURL)
+	}
+	if cfg.JWTTenantClaim == "" {
+		return fmt.Errorf("-frontend.jwt.tenant-claim must be configured along with -frontend.jwt.jwks-url")
+	}
+	if cfg.JWKSRefreshPeriod <= 0 {
+		return fmt.Errorf("invalid -frontend.jwt.jwks-refresh-period %s: must be positive", cfg.JWKSRefreshPeriod)
+	}
+	if cfg.JWTAllowedSkew < 0 {
+		return fmt.Errorf("invalid -frontend.jwt.allowed-clock-skew %s: must not be negative", cfg.JWTAllowedSkew)
+	}
+	return nil
+}
+
+// sources returns the sources of the tenant ID, by order of precedence.
+func (cfg *TenantResolverConfig) sources() []string {
+	switch {
+	case len(cfg.TenantSources) > 0:
+		return cfg.TenantSources
+	case cfg.OrgIDHeader != "":
+		return []string{cfg.OrgIDHeader}
+	case cfg.JWKSURL != "":
+		return []string{jwtTenantSource}
+	default:
+		return nil
+	}
+}
+
+// TenantResolver sets the X-Scope-OrgID header of the requests from the sources the tenant ID is
+// read from, so that it's injected in their context by the authentication middleware as usual, and
+// the limits, the caching and the logging work unchanged.
+type TenantResolver struct {
+	cfg     TenantResolverConfig
+	log     log.Logger
+	sources []string
+	jwks    *jwks
+}
+
+// NewTenantResolver returns the tenant resolver of the config, or nil if the tenant ID is read
+// from the X-Scope-OrgID header.
+func NewTenantResolver(cfg TenantResolverConfig, log log.Logger) *TenantResolver {
+	sources := cfg.sources()
+	if len(sources) == 0 {
+		return nil
+	}
+
+	t := &TenantResolver{cfg: cfg, log: log, sources: sources}
+	if cfg.JWKSURL != "" {
+		t.jwks = &jwks{
+			url:           cfg.JWKSURL,
+			refreshPeriod: cfg.JWKSRefreshPeriod,
+			client:        &http.Client{Timeout: jwksFetchTimeout},
+			log:           log,
+		}
+	}
+	return t
+}
+
+// Wrap implements middleware.Interface. It must wrap the authentication middleware, which reads
+// the X-Scope-OrgID header.
+func (t *TenantResolver) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID, err := t.resolve(r, time.Now())
+		if errors.Is(err, errConflictingTenantIDs) {
+			level.Debug(t.log).Log("msg", "rejected request with conflicting tenant IDs", "path", r.URL.Path, "err", err)
+			http.Error(w, errConflictingTenantIDs.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			level.Debug(t.log).Log("msg", "rejected request with an invalid JWT bearer token", "path", r.URL.Path, "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid JWT bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		// Don't trust the X-Scope-OrgID header sent by the client, unless it's one of the sources.
+		r = r.Clone(r.Context())
+		r.Header.Del(user.OrgIDHeaderName)
+		if tenantID != "" {
+			r.Header.Set(user.OrgIDHeaderName, tenantID)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolve returns the tenant ID of the first source which has one, or an empty string if none
+// has. In strict mode, all the sources are checked and they must have the same tenant ID. An
+// invalid JWT bearer token is an error once it's checked.
+func (t *TenantResolver) resolve(r *http.Request, now time.Time) (string, error) {
+	tenantID, tenantSource := "", ""
+	for _, source := range t.sources {
+		var id string
+		if strings.EqualFold(source, jwtTenantSource) {
+			token, ok := bearerToken(r)
+			if !ok {
+				continue
+			}
+			var err error
+			if id, err = t.tenantFromToken(token, now); err != nil {
+				return "", err
+			}
+		} else {
+			id = r.Header.Get(source)
+		}
+
+		switch {
+		case id == "":
+			continue
+		case tenantID == "":
+			tenantID, tenantSource = id, source
+			if !t.cfg.TenantSourcesStrict {
+				return tenantID, nil
+			}
+		case id != tenantID:
+			return "", fmt.Errorf("%w: %s from %s and %s from %s", errConflictingTenantIDs, tenantID, tenantSource, id, source)
+		}
+	}
+	return tenantID, nil
+}
+
+// bearerToken returns the bearer token of the Authorization header of the request, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return header[len(prefix):], true
+}
+
+// tenantFromToken returns the tenant ID of the JWT bearer token, once the token is validated.
+func (t *TenantResolver) tenantFromToken(token string, now time.Time) (string, error) {
+	// The claims are validated below, taking the allowed clock skew into account.
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(token, claims, t.jwks.keyFunc); err != nil {
+		return "", err
+	}
+	if err := t.validateClaims(claims, now); err != nil {
+		return "", err
+	}
+
+	tenantID, ok := claims[t.cfg.JWTTenantClaim].(string)
+	if !ok || tenantID == "" {
+		return "", fmt.Errorf("no %s claim", t.cfg.JWTTenantClaim)
+	}
+	return tenantID, nil
+}
+
+func (t *TenantResolver) validateClaims(claims jwt.MapClaims, now time.Time) error {
+	skew := t.cfg.JWTAllowedSkew
+	if _, ok := claims["exp"]; !ok && t.cfg.JWTRequireExpiry {
+		return fmt.Errorf("no exp claim")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-skew).Unix(), false) {
+		return fmt.Errorf("token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(skew).Unix(), false) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(skew).Unix(), false) {
+		return fmt.Errorf("token is used before it was issued")
+	}
+	if t.cfg.JWTIssuer != "" && !claims.VerifyIssuer(t.cfg.JWTIssuer, true) {
+		return fmt.Errorf("unexpected issuer")
+	}
+	if t.cfg.JWTAudience != "" && !hasAudience(claims, t.cfg.JWTAudience) {
+		return fmt.Errorf("unexpected audience")
+	}
+	return nil
+}
+
+// hasAudience returns whether the aud claim, either a string or an array of strings, contains
+// the audience.
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwks fetches and caches the keys of a JSON Web Key Set, by key ID.
+type jwks struct {
+	url           string
+	refreshPeriod time.Duration
+	client        *http.Client
+	log           log.Logger
+
+	mtx         sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+
+	// Closed once the fetch in progress is done, nil if none is.
+	fetching chan struct{}
+}
+
+// keyFunc implements jwt.Keyfunc. Only the RSA and ECDSA signing methods are allowed, and they
+// must match the type of the key.
+func (k *jwks) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := k.key(kid, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+}
+
+// key returns the key with the ID, fetching the JWKS again if it's due to be refreshed or if the
+// key is unknown, at most every jwksMinRefreshInterval. The known keys are returned without waiting
+// for the JWKS to be fetched again, and are kept if it can't be fetched.
+func (k *jwks) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	k.mtx.Lock()
+	key, ok := k.keys[kid]
+	if ok && now.Sub(k.fetchedAt) < k.refreshPeriod {
+		k.mtx.Unlock()
+		return key, nil
+	}
+	fetching := k.refresh(now)
+	k.mtx.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	// The key may be in the JWKS being fetched.
+	if fetching != nil {
+		<-fetching
+		k.mtx.Lock()
+		key, ok = k.keys[kid]
+		k.mtx.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// refresh fetches the JWKS in the background, unless it was attempted less than
+// jwksMinRefreshInterval ago, and returns the channel closed once the fetch in progress is done,
+// or nil if none is. It must be called with mtx held.
+func (k *jwks) refresh(now time.Time) chan struct{} {
+	if k.fetching != nil || now.Sub(k.lastAttempt) < jwksMinRefreshInterval {
+		return k.fetching
+	}
+	k.lastAttempt = now
+
+	fetching := make(chan struct{})
+	k.fetching = fetching
+	go func() {
+		defer close(fetching)
+
+		// The lock isn't held while fetching, so that the requests can still be served.
+		keys, err := k.fetch()
+
+		k.mtx.Lock()
+		defer k.mtx.Unlock()
+		k.fetching = nil
+		if err != nil {
+			level.Warn(k.log).Log("msg", "failed to fetch the JWKS", "url", k.url, "err", err)
+			return
+		}
+		k.keys = keys
+		k.fetchedAt = now
+	}()
+	return fetching
+}
+
+func (k *jwks) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		// The encryption keys and the unsupported types of keys are ignored.
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA or EC signing key")
+	}
+	return keys, nil
+}
+
+// jsonWebKey is a public key of a JWKS, as defined by RFC 7517.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or ECDSA public key, or nil if the type of the key is unsupported.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64URLInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBase64URLInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if e.BitLen() > 31 {
+			return nil, fmt.Errorf("exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBase64URLInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBase64URLInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on the curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBase64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,403 @@
+package frontend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestTenantResolver_Disabled(t *testing.T) {
+	assert.Nil(t, NewTenantResolver(TenantResolverConfig{}, log.NewNopLogger()))
+}
+
+func TestTenantResolver_OrgIDHeader(t *testing.T) {
+	resolver := NewTenantResolver(TenantResolverConfig{OrgIDHeader: "X-Tenant"}, log.NewNopLogger())
+
+	for name, tc := range map[string]struct {
+		headers       map[string]string
+		expectedOrgID string
+	}{
+		"tenant header": {
+			headers:       map[string]string{"X-Tenant": "team-a"},
+			expectedOrgID: "team-a",
+		},
+		"X-Scope-OrgID is ignored": {
+			headers:       map[string]string{"X-Tenant": "team-a", "X-Scope-OrgID": "team-b"},
+			expectedOrgID: "team-a",
+		},
+		"no tenant header": {
+			headers: map[string]string{"X-Scope-OrgID": "team-b"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			assert.Equal(t, tc.expectedOrgID, resolvedOrgID(t, resolver, req))
+		})
+	}
+}
+
+func TestTenantResolver_JWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := newTestJWKS(t, map[string]interface{}{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey})
+	defer jwks.Close()
+
+	resolver := NewTenantResolver(TenantResolverConfig{
+		JWKSURL:           jwks.URL,
+		JWKSRefreshPeriod: time.Hour,
+		JWTIssuer:         "https://auth.example.com",
+		JWTAudience:       "cortex",
+		JWTTenantClaim:    "tenant_id",
+		JWTRequireExpiry:  true,
+	}, log.NewNopLogger())
+
+	now := time.Now()
+	valid := jwt.MapClaims{
+		"iss":       "https://auth.example.com",
+		"aud":       "cortex",
+		"exp":       now.Add(time.Hour).Unix(),
+		"tenant_id": "team-a",
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	for name, tc := range map[string]struct {
+		token         string
+		expectedOrgID string
+	}{
+		"RSA signed token": {
+			token:         signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, valid),
+			expectedOrgID: "team-a",
+		},
+		"ECDSA signed token": {
+			token:         signToken(t, jwt.SigningMethodES256, "ec", ecKey, valid),
+			expectedOrgID: "team-a",
+		},
+		"audience in an array": {
+			token:         signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("aud", []string{"other", "cortex"})),
+			expectedOrgID: "team-a",
+		},
+		"expired token": {
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("exp", now.Add(-time.Minute).Unix())),
+		},
+		"token without expiry": {
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("exp", nil)),
+		},
+		"token not valid yet": {
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("nbf", now.Add(time.Minute).Unix())),
+		},
+		"unexpected issuer": {
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("iss", "https://other.example.com")),
+		},
+		"unexpected audience": {
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("aud", "other")),
+		},
+		"no tenant claim": {
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, with("tenant_id", nil)),
+		},
+		"signed by another key": {
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, valid),
+		},
+		"unknown key": {
+			token: signToken(t, jwt.SigningMethodRS256, "other", otherKey, valid),
+		},
+		"signing method not matching the key": {
+			token: signToken(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), valid),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+			req.Header.Set("X-Scope-OrgID", "team-b")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			if tc.expectedOrgID == "" {
+				w := httptest.NewRecorder()
+				resolver.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Fatal("the request should have been rejected")
+				})).ServeHTTP(w, req)
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+				assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+				return
+			}
+			assert.Equal(t, tc.expectedOrgID, resolvedOrgID(t, resolver, req))
+		})
+	}
+
+	// The requests without a token are left to the authentication middleware, which rejects them.
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+	req.Header.Set("X-Scope-OrgID", "team-b")
+	assert.Equal(t, "", resolvedOrgID(t, resolver, req))
+}
+
+func TestTenantResolver_Sources(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := newTestJWKS(t, map[string]interface{}{"rsa": &key.PublicKey})
+	defer jwks.Close()
+
+	token := func(tenantID string) string {
+		return "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa", key, jwt.MapClaims{
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"tenant_id": tenantID,
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		strict           bool
+		headers          map[string]string
+		expectedOrgID    string
+		expectedRejected bool
+	}{
+		"first source wins": {
+			headers:       map[string]string{"X-Scope-OrgID": "team-a", "X-Tenant": "team-b", "Authorization": token("team-c")},
+			expectedOrgID: "team-a",
+		},
+		"empty sources are skipped": {
+			headers:       map[string]string{"Authorization": token("team-c")},
+			expectedOrgID: "team-c",
+		},
+		"headers not in the sources are ignored": {
+			headers: map[string]string{"X-Other-Tenant": "team-a"},
+		},
+		"invalid token": {
+			headers:          map[string]string{"Authorization": "Bearer invalid"},
+			expectedRejected: true,
+		},
+		"strict with an invalid token": {
+			strict:           true,
+			headers:          map[string]string{"X-Scope-OrgID": "team-a", "Authorization": "Bearer invalid"},
+			expectedRejected: true,
+		},
+		"strict with matching sources": {
+			strict:        true,
+			headers:       map[string]string{"X-Scope-OrgID": "team-a", "Authorization": token("team-a")},
+			expectedOrgID: "team-a",
+		},
+		"strict with a single source": {
+			strict:        true,
+			headers:       map[string]string{"X-Tenant": "team-b"},
+			expectedOrgID: "team-b",
+		},
+		"strict with conflicting headers": {
+			strict:           true,
+			headers:          map[string]string{"X-Scope-OrgID": "team-a", "X-Tenant": "team-b"},
+			expectedRejected: true,
+		},
+		"strict with conflicting token": {
+			strict:           true,
+			headers:          map[string]string{"X-Tenant": "team-b", "Authorization": token("team-c")},
+			expectedRejected: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resolver := NewTenantResolver(TenantResolverConfig{
+				TenantSources:       []string{"X-Scope-OrgID", "X-Tenant", "jwt"},
+				TenantSourcesStrict: tc.strict,
+				JWKSURL:             jwks.URL,
+				JWKSRefreshPeriod:   time.Hour,
+				JWTTenantClaim:      "tenant_id",
+			}, log.NewNopLogger())
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			if tc.expectedRejected {
+				w := httptest.NewRecorder()
+				resolver.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					t.Fatal("the request should have been rejected")
+				})).ServeHTTP(w, req)
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+				return
+			}
+			assert.Equal(t, tc.expectedOrgID, resolvedOrgID(t, resolver, req))
+		})
+	}
+}
+
+func TestJWKS_KeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keys := map[string]interface{}{"old": &oldKey.PublicKey}
+	fetches := atomic.NewInt32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Inc()
+		writeJWKS(t, w, keys)
+	}))
+	defer server.Close()
+
+	k := &jwks{url: server.URL, refreshPeriod: time.Hour, client: http.DefaultClient, log: log.NewNopLogger()}
+	now := time.Now()
+
+	_, err = k.key("old", now)
+	require.NoError(t, err)
+	_, err = k.key("old", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// An unknown key is fetched again, at most every jwksMinRefreshInterval.
+	keys["new"] = &newKey.PublicKey
+	_, err = k.key("new", now.Add(time.Second))
+	require.Error(t, err)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	_, err = k.key("new", now.Add(jwksMinRefreshInterval))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// The keys fetched previously are kept if the JWKS can't be fetched.
+	server.Close()
+	_, err = k.key("old", now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestJWKS_SlowServer(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var (
+		mtx     sync.Mutex
+		keys    = map[string]interface{}{"old": &oldKey.PublicKey}
+		release chan struct{}
+	)
+	fetches := atomic.NewInt32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Inc()
+		mtx.Lock()
+		wait := release
+		mtx.Unlock()
+		if wait != nil {
+			<-wait
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		writeJWKS(t, w, keys)
+	}))
+	defer server.Close()
+
+	k := &jwks{url: server.URL, refreshPeriod: time.Hour, client: http.DefaultClient, log: log.NewNopLogger()}
+	now := time.Now()
+	_, err = k.key("old", now)
+	require.NoError(t, err)
+
+	// The server becomes slow, the known keys are still served while the JWKS is fetched again.
+	mtx.Lock()
+	release = make(chan struct{})
+	keys["new"] = &newKey.PublicKey
+	mtx.Unlock()
+
+	_, err = k.key("old", now.Add(2*time.Hour))
+	require.NoError(t, err)
+	test.Poll(t, time.Second, int32(2), func() interface{} {
+		return fetches.Load()
+	})
+	_, err = k.key("old", now.Add(2*time.Hour))
+	require.NoError(t, err)
+
+	// An unknown key waits for the fetch in progress, without starting another one.
+	newErr := make(chan error)
+	go func() {
+		_, err := k.key("new", now.Add(2*time.Hour))
+		newErr <- err
+	}()
+	select {
+	case err := <-newErr:
+		t.Fatalf("the unknown key didn't wait for the JWKS to be fetched: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-newErr)
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+// resolvedOrgID returns the X-Scope-OrgID header of the request forwarded by the resolver.
+func resolvedOrgID(t *testing.T, resolver *TenantResolver, req *http.Request) string {
+	var orgID string
+	w := httptest.NewRecorder()
+	resolver.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID = r.Header.Get("X-Scope-OrgID")
+	})).ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	return orgID
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func newTestJWKS(t *testing.T, keys map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJWKS(t, w, keys)
+	}))
+}
+
+func writeJWKS(t *testing.T, w http.ResponseWriter, keys map[string]interface{}) {
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: encode(key.N), E: encode(big.NewInt(int64(key.E)))})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jsonWebKey{Kty: "EC", Kid: kid, Crv: "P-256", X: encode(key.X), Y: encode(key.Y)})
+		}
+	}
+	// An encryption key, which is ignored.
+	set.Keys = append(set.Keys, jsonWebKey{Kty: "RSA", Kid: "enc", Use: "enc", N: "invalid", E: "invalid"})
+	require.NoError(t, json.NewEncoder(w).Encode(set))
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math"
 	"math/rand"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -13,6 +16,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/weaveworks/common/httpgrpc/server"
 	"github.com/weaveworks/common/middleware"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/naming"
 
@@ -26,8 +30,12 @@ type WorkerConfig struct {
 	FrontendAddress     string        `yaml:"frontend_address"`
 	Parallelism         int           `yaml:"parallelism"`
 	MatchMaxConcurrency bool          `yaml:"match_max_concurrent"`
+	MaxConcurrentPerCPU float64       `yaml:"match_max_concurrent_per_cpu"`
 	DNSLookupDuration   time.Duration `yaml:"dns_lookup_duration"`
 	QuerierID           string        `yaml:"id"`
+	Weight              int           `yaml:"weight"`
+	BusyThreshold       int           `yaml:"busy_threshold"`
+	MaxResponseSize     int64         `yaml:"max_response_size"`
 
 	GRPCClientConfig grpcclient.ConfigWithTLS `yaml:"grpc_client_config"`
 }
@@ -37,13 +45,35 @@ func (cfg *WorkerConfig) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.FrontendAddress, "querier.frontend-address", "", "Address of query frontend service, in host:port format. If -querier.scheduler-address is set as well, querier will use scheduler instead. If neither -querier.frontend-address or -querier.scheduler-address is set, queries must arrive via HTTP endpoint.")
 	f.IntVar(&cfg.Parallelism, "querier.worker-parallelism", 10, "Number of simultaneous queries to process per query frontend.")
 	f.BoolVar(&cfg.MatchMaxConcurrency, "querier.worker-match-max-concurrent", false, "Force worker concurrency to match the -querier.max-concurrent option.  Overrides querier.worker-parallelism.")
+	f.Float64Var(&cfg.MaxConcurrentPerCPU, "querier.worker-match-max-concurrent-per-cpu", 0, "When -querier.worker-match-max-concurrent is enabled, match the number of CPUs available to the querier (its cgroup CPU quota, or GOMAXPROCS if the CPUs are not limited) multiplied by this factor instead of -querier.max-concurrent, and update the worker concurrency when it changes, eg. as the querier is resized. -querier.max-concurrent is then the maximum concurrency of the PromQL engine. 0 to match -querier.max-concurrent.")
 	f.DurationVar(&cfg.DNSLookupDuration, "querier.dns-lookup-period", 10*time.Second, "How often to query DNS.")
 	f.StringVar(&cfg.QuerierID, "querier.id", "", "Querier ID, sent to frontend service to identify requests from the same querier. Defaults to hostname.")
+	f.IntVar(&cfg.Weight, "querier.worker-weight", 1, "Weight of the querier, sent to the query frontend when connecting to it. When the connected queriers have different weights, the query frontend dispatches the queries to the querier with the fewest in-flight queries relative to its weight, so that the queriers with a higher weight execute proportionally more queries. The parallelism of the queriers with a higher weight should be increased accordingly. Not supported by the query-scheduler.")
+	f.IntVar(&cfg.BusyThreshold, "querier.worker-busy-threshold", 0, "Number of queries executed by the querier at the same time, for all the query frontends, from which the querier signals the query frontends that it's busy, along its responses. The query frontends then dispatch the queries to the other queriers when possible, for -frontend.querier-busy-period. Usually set to -querier.max-concurrent, when the parallelism of the workers exceeds it. 0 to disable. Not supported by the query-scheduler.")
+	f.Int64Var(&cfg.MaxResponseSize, "querier.worker-max-response-size", 0, "Max size, in bytes, of a response of the querier to a query received from a query frontend. The responses are buffered in memory before being sent to the query frontend, the larger ones are dropped as soon as they exceed this size, and replaced by an HTTP 413 error, so that they can't run the querier out of memory. 0 to disable. Not supported by the query-scheduler.")
 
 	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("querier.frontend-client", f)
 }
 
 func (cfg *WorkerConfig) Validate(log log.Logger) error {
+	if cfg.Parallelism < 1 && !cfg.MatchMaxConcurrency {
+		return fmt.Errorf("invalid -querier.worker-parallelism %d: must be at least 1, unless -querier.worker-match-max-concurrent is enabled", cfg.Parallelism)
+	}
+	if cfg.MaxConcurrentPerCPU < 0 {
+		return fmt.Errorf("invalid -querier.worker-match-max-concurrent-per-cpu %v: must not be negative, 0 to disable", cfg.MaxConcurrentPerCPU)
+	}
+	if cfg.Weight < 1 {
+		return fmt.Errorf("invalid -querier.worker-weight %d: must be at least 1", cfg.Weight)
+	}
+	if cfg.BusyThreshold < 0 {
+		return fmt.Errorf("invalid -querier.worker-busy-threshold %d: must not be negative, 0 to disable", cfg.BusyThreshold)
+	}
+	if cfg.MaxResponseSize < 0 {
+		return fmt.Errorf("invalid -querier.worker-max-response-size %d: must not be negative, 0 to disable", cfg.MaxResponseSize)
+	}
+	if cfg.DNSLookupDuration <= 0 {
+		return fmt.Errorf("invalid -querier.dns-lookup-period %s: must be positive", cfg.DNSLookupDuration)
+	}
 	return cfg.GRPCClientConfig.Validate(log)
 }
 
@@ -54,17 +84,52 @@ type worker struct {
 	log        log.Logger
 	server     *server.Server
 
-	watcher  naming.Watcher //nolint:staticcheck //Skipping for now. If you still see this more than likely issue https://github.com/cortexproject/cortex/issues/2015 has not yet been addressed.
-	managers map[string]*frontendManager
+	watcher naming.Watcher //nolint:staticcheck //Skipping for now. If you still see this more than likely issue https://github.com/cortexproject/cortex/issues/2015 has not yet been addressed.
+	load    *querierLoad
+
+	// Protects the managers, updated by the DNS watcher and when the max concurrency changes.
+	mtx           sync.Mutex
+	managers      map[string]*frontendManager
+	maxConcurrent int
+}
+
+// How often the max concurrency derived from the available CPUs is checked.
+const maxConcurrentCheckPeriod = 10 * time.Second
+
+
+// querierLoad tracks the queries executed by the querier for all the frontends, to signal them
+// when the querier is busy.
+type querierLoad struct {
+	inflight      atomic.Int32
+	busyThreshold int
+}
+
+func (l *querierLoad) start() {
+	if l != nil {
+		l.inflight.Inc()
+	}
+}
+
+// finish returns whether the querier is still busy once the query is finished.
+func (l *querierLoad) finish() bool {
+	if l == nil {
+		return false
+	}
+	inflight := l.inflight.Dec()
+	return l.busyThreshold > 0 && int(inflight) >= l.busyThreshold
 }
 
-// NewWorker creates a new worker and returns a service that is wrapping it.
-// If no address is specified, it returns error.
-func NewWorker(cfg WorkerConfig, querierCfg querier.Config, server *server.Server, log log.Logger) (services.Service, error) {
+// NewWorker creates a new worker executing the queries with the handler, and returns a service that
+// is wrapping it. If no address is specified, it returns error.
+func NewWorker(cfg WorkerConfig, querierCfg querier.Config, handler http.Handler, log log.Logger) (services.Service, error) {
 	if cfg.FrontendAddress == "" {
 		return nil, errors.New("frontend address not configured")
 	}
 
+	if err := cfg.Validate(log); err != nil {
+		return nil, errors.Wrap(err, "invalid querier worker config")
+	}
+
 	if cfg.QuerierID == "" {
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -87,14 +152,18 @@ func NewWorker(cfg WorkerConfig, querierCfg querier.Config, server *server.Serve
 		cfg:        cfg,
 		querierCfg: querierCfg,
 		log:        log,
-		server:     server,
+		server:     server.NewServer(maxResponseSizeHandler(handler, cfg.MaxResponseSize)),
 		watcher:    watcher,
 		managers:   map[string]*frontendManager{},
+		load:       &querierLoad{busyThreshold: cfg.BusyThreshold},
 	}
 	return services.NewBasicService(nil, w.watchDNSLoop, w.stopping), nil
 }
 
 func (w *worker) stopping(_ error) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
 	// wait until all per-address workers are done. This is only called after watchDNSLoop exits.
 	for _, mgr := range w.managers {
 		mgr.stop()
@@ -113,6 +182,10 @@ func (w *worker) watchDNSLoop(servCtx context.Context) error {
 		w.watcher.Close()
 	}()
 
+	if w.cfg.MatchMaxConcurrency && w.cfg.MaxConcurrentPerCPU > 0 {
+		go w.watchMaxConcurrent(servCtx)
+	}
+
 	for {
 		updates, err := w.watcher.Next()
 		if err != nil {
@@ -124,6 +197,7 @@ func (w *worker) watchDNSLoop(servCtx context.Context) error {
 			return errors.Wrapf(err, "error from DNS watcher")
 		}
 
+		w.mtx.Lock()
 		for _, update := range updates {
 			switch update.Op {
 			case naming.Add:
@@ -134,7 +208,7 @@ func (w *worker) watchDNSLoop(servCtx context.Context) error {
 					continue
 				}
 
-				w.managers[update.Addr] = newFrontendManager(servCtx, w.log, w.server, conn, NewFrontendClient(conn), w.cfg.GRPCClientConfig, w.cfg.QuerierID)
+				w.managers[update.Addr] = newFrontendManager(servCtx, w.log, w.server, conn, NewFrontendClient(conn), w.cfg.GRPCClientConfig, w.cfg.QuerierID, w.cfg.Weight, w.load)
 
 			case naming.Delete:
 				level.Debug(w.log).Log("msg", "removing connection", "addr", update.Addr)
@@ -144,12 +218,48 @@ func (w *worker) watchDNSLoop(servCtx context.Context) error {
 				}
 
 			default:
+				w.mtx.Unlock()
 				return fmt.Errorf("unknown op: %v", update.Op)
 			}
 		}
 
 		w.resetConcurrency()
+		w.mtx.Unlock()
+	}
+}
+
+// watchMaxConcurrent periodically updates the concurrency of the workers when the CPUs available
+// to the querier change.
+func (w *worker) watchMaxConcurrent(servCtx context.Context) {
+	ticker := time.NewTicker(maxConcurrentCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-servCtx.Done():
+			return
+		case <-ticker.C:
+			w.mtx.Lock()
+			if len(w.managers) > 0 && w.getMaxConcurrent() != w.maxConcurrent {
+				level.Info(w.log).Log("msg", "updating the worker concurrency, as the available CPUs changed", "cpus", availableCPUs())
+				w.resetConcurrency()
+			}
+			w.mtx.Unlock()
+		}
+	}
+}
+
+// getMaxConcurrent returns the total concurrency matched by the workers: derived from the CPUs currently
+// available to the querier if configured, otherwise the max concurrency of the PromQL engine.
+func (w *worker) getMaxConcurrent() int {
+	if w.cfg.MaxConcurrentPerCPU <= 0 {
+		return w.querierCfg.MaxConcurrent
+	}
+	maxConcurrent := int(math.Round(availableCPUs() * w.cfg.MaxConcurrentPerCPU))
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
 	}
+	return maxConcurrent
 }
 
 func (w *worker) connect(ctx context.Context, address string) (*grpc.ClientConn, error) {
@@ -171,6 +281,7 @@ func (w *worker) resetConcurrency() {
 		addresses = append(addresses, addr)
 	}
 	rand.Shuffle(len(addresses), func(i, j int) { addresses[i], addresses[j] = addresses[j], addresses[i] })
+	w.maxConcurrent = w.getMaxConcurrent()
 
 	totalConcurrency := 0
 	for i, addr := range addresses {
@@ -193,12 +304,12 @@ func (w *worker) concurrency(index int, addr string) int {
 	concurrentRequests := 0
 
 	if w.cfg.MatchMaxConcurrency {
-		concurrentRequests = w.querierCfg.MaxConcurrent / len(w.managers)
+		concurrentRequests = w.maxConcurrent / len(w.managers)
 
 		// If max concurrency does not evenly divide into our frontends a subset will be chosen
 		// to receive an extra connection.  Frontend addresses were shuffled above so this will be a
 		// random selection of frontends.
-		if index < w.querierCfg.MaxConcurrent%len(w.managers) {
+		if index < w.maxConcurrent%len(w.managers) {
 			level.Warn(w.log).Log("msg", "max concurrency is not evenly divisible across query frontends. adding an extra connection", "addr", addr)
 			concurrentRequests++
 		}
@@ -0,0 +1,61 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Root of the cgroup filesystem the CPU quota of the querier is read from. Replaced by the tests.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// availableCPUs returns the number of CPUs available to the querier: its cgroup CPU quota if any, so
+// that it follows the resizes of its container, or else GOMAXPROCS. Replaced by the tests.
+var availableCPUs = func() float64 {
+	if cpus, ok := cgroupCPUQuota(cgroupRoot); ok {
+		return cpus
+	}
+	return float64(runtime.GOMAXPROCS(0))
+}
+
+// cgroupCPUQuota returns the CPU quota of the cgroup, in CPUs, read from the cgroup v2 cpu.max file or
+// from the cgroup v1 CFS quota and period. It returns false if the CPUs aren't limited or if the quota
+// can't be read.
+func cgroupCPUQuota(root string) (float64, bool) {
+	// cgroup v2: "<quota> <period>", or "max <period>" if unlimited.
+	if content, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(content))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return cpuQuota(fields[0], fields[1])
+	}
+
+	// cgroup v1: the quota is -1 if unlimited.
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		quota, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		period, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_period_us"))
+		if err != nil {
+			continue
+		}
+		return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0, false
+}
+
+func cpuQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
@@ -0,0 +1,57 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupCPUQuota(t *testing.T) {
+	for name, tc := range map[string]struct {
+		files         map[string]string
+		expectedCPUs  float64
+		expectedFound bool
+	}{
+		"cgroup v2": {
+			files:         map[string]string{"cpu.max": "250000 100000\n"},
+			expectedCPUs:  2.5,
+			expectedFound: true,
+		},
+		"cgroup v2 unlimited": {
+			files: map[string]string{"cpu.max": "max 100000\n"},
+		},
+		"cgroup v1": {
+			files:         map[string]string{"cpu/cpu.cfs_quota_us": "400000\n", "cpu/cpu.cfs_period_us": "100000\n"},
+			expectedCPUs:  4,
+			expectedFound: true,
+		},
+		"cgroup v1 combined controllers": {
+			files:         map[string]string{"cpu,cpuacct/cpu.cfs_quota_us": "50000\n", "cpu,cpuacct/cpu.cfs_period_us": "100000\n"},
+			expectedCPUs:  0.5,
+			expectedFound: true,
+		},
+		"cgroup v1 unlimited": {
+			files: map[string]string{"cpu/cpu.cfs_quota_us": "-1\n", "cpu/cpu.cfs_period_us": "100000\n"},
+		},
+		"no cgroup": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "cgroup")
+			require.NoError(t, err)
+			defer os.RemoveAll(root)
+
+			for path, content := range tc.files {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755))
+				require.NoError(t, ioutil.WriteFile(filepath.Join(root, path), []byte(content), 0644))
+			}
+
+			cpus, ok := cgroupCPUQuota(root)
+			assert.Equal(t, tc.expectedFound, ok)
+			assert.Equal(t, tc.expectedCPUs, cpus)
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/weaveworks/common/httpgrpc/server"
 	"go.uber.org/atomic"
 
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/grpcclient"
 )
@@ -31,6 +32,8 @@ type frontendManager struct {
 	client     FrontendClient
 	clientCfg  grpcclient.ConfigWithTLS
 	querierID  string
+	weight     int
+	load       *querierLoad
 
 	log log.Logger
 
@@ -40,7 +43,7 @@ type frontendManager struct {
 	currentProcessors *atomic.Int32
 }
 
-func newFrontendManager(serverCtx context.Context, log log.Logger, server *server.Server, connection io.Closer, client FrontendClient, clientCfg grpcclient.ConfigWithTLS, querierID string) *frontendManager {
+func newFrontendManager(serverCtx context.Context, log log.Logger, server *server.Server, connection io.Closer, client FrontendClient, clientCfg grpcclient.ConfigWithTLS, querierID string, weight int, load *querierLoad) *frontendManager {
 	f := &frontendManager{
 		log:               log,
 		connection:        connection,
@@ -50,6 +53,8 @@ func newFrontendManager(serverCtx context.Context, log log.Logger, server *serve
 		serverCtx:         serverCtx,
 		currentProcessors: atomic.NewInt32(0),
 		querierID:         querierID,
+		weight:            weight,
+		load:              load,
 	}
 
 	return f
@@ -127,12 +132,12 @@ func (f *frontendManager) process(c Frontend_ProcessClient) error {
 			// and cancel the query.  We don't actually handle queries in parallel
 			// here, as we're running in lock step with the server - each Recv is
 			// paired with a Send.
-			go f.runRequest(ctx, request.HttpRequest, func(response *httpgrpc.HTTPResponse) error {
-				return c.Send(&ClientToFrontend{HttpResponse: response})
+			go f.runRequest(ctx, request.HttpRequest, request.Timeout, request.QueryID, func(response *httpgrpc.HTTPResponse, stats *QueryStats, busy bool) error {
+				return c.Send(&ClientToFrontend{HttpResponse: response, Stats: stats, Busy: busy})
 			})
 
 		case GET_ID:
-			err := c.Send(&ClientToFrontend{ClientID: f.querierID})
+			err := c.Send(&ClientToFrontend{ClientID: f.querierID, Weight: int32(f.weight)})
 			if err != nil {
 				return err
 			}
@@ -143,8 +148,35 @@ func (f *frontendManager) process(c Frontend_ProcessClient) error {
 	}
 }
 
-func (f *frontendManager) runRequest(ctx context.Context, request *httpgrpc.HTTPRequest, sendHTTPResponse func(response *httpgrpc.HTTPResponse) error) {
+func (f *frontendManager) runRequest(ctx context.Context, request *httpgrpc.HTTPRequest, timeout time.Duration, queryID string, sendHTTPResponse func(response *httpgrpc.HTTPResponse, stats *QueryStats, busy bool) error) {
+	logger := f.log
+	if queryID != "" {
+		// Expose the query ID to the querier handlers too, since sub-queries
+		// don't keep the headers of the original request.
+		setQueryIDHeader(request, queryID)
+		logger = log.With(logger, "query_id", queryID)
+	}
+
+	// Honour the deadline set by the frontend, so that the query is cancelled
+	// even if the frontend doesn't close the stream in time.
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Collect the series and samples fetched by the query, to report them along the response.
+	fetched, ctx := querier_stats.ContextWithEmptyStats(ctx)
+
+	f.load.start()
+	start := time.Now()
 	response, err := f.server.Handle(ctx, request)
+	busy := f.load.finish()
+	stats := &QueryStats{
+		WallTime:       time.Since(start),
+		FetchedSeries:  fetched.FetchedSeries(),
+		FetchedSamples: fetched.FetchedSamples(),
+	}
 	if err != nil {
 		var ok bool
 		response, ok = httpgrpc.HTTPResponseFromError(err)
@@ -163,10 +195,10 @@ func (f *frontendManager) runRequest(ctx context.Context, request *httpgrpc.HTTP
 			Code: http.StatusRequestEntityTooLarge,
 			Body: []byte(errMsg),
 		}
-		level.Error(f.log).Log("msg", "error processing query", "err", errMsg)
+		level.Error(logger).Log("msg", "error processing query", "err", errMsg)
 	}
 
-	if err := sendHTTPResponse(response); err != nil {
-		level.Error(f.log).Log("msg", "error processing requests", "err", err)
+	if err := sendHTTPResponse(response, stats, busy); err != nil {
+		level.Error(logger).Log("msg", "error processing requests", "err", err)
 	}
 }
@@ -10,11 +10,13 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/httpgrpc"
 	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
 	"go.uber.org/atomic"
 	grpc "google.golang.org/grpc"
 
+	querier_stats "github.com/cortexproject/cortex/pkg/querier/stats"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/grpcclient"
 )
@@ -97,7 +99,7 @@ func TestConcurrency(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("Testing concurrency %v", tt.concurrency), func(t *testing.T) {
-			mgr := newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, &mockFrontendClient{}, grpcclient.ConfigWithTLS{}, "querier")
+			mgr := newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, &mockFrontendClient{}, grpcclient.ConfigWithTLS{}, "querier", 1, nil)
 
 			for _, c := range tt.concurrency {
 				calls.Store(0)
@@ -133,7 +135,7 @@ func TestRecvFailDoesntCancelProcess(t *testing.T) {
 		failRecv: true,
 	}
 
-	mgr := newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, client, grpcclient.ConfigWithTLS{}, "querier")
+	mgr := newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, client, grpcclient.ConfigWithTLS{}, "querier", 1, nil)
 
 	mgr.concurrentRequests(1)
 	time.Sleep(50 * time.Millisecond)
@@ -157,7 +159,7 @@ func TestServeCancelStopsProcess(t *testing.T) {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	mgr := newFrontendManager(ctx, util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, client, grpcclient.ConfigWithTLS{GRPC: grpcclient.Config{MaxSendMsgSize: 100000}}, "querier")
+	mgr := newFrontendManager(ctx, util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, client, grpcclient.ConfigWithTLS{GRPC: grpcclient.Config{MaxSendMsgSize: 100000}}, "querier", 1, nil)
 
 	mgr.concurrentRequests(1)
 	time.Sleep(50 * time.Millisecond)
@@ -170,3 +172,77 @@ func TestServeCancelStopsProcess(t *testing.T) {
 	mgr.stop()
 	assert.Equal(t, int32(0), mgr.currentProcessors.Load())
 }
+
+func TestRunRequestSetsQueryIDHeader(t *testing.T) {
+	// The request of a sub-query doesn't carry the header of the original request.
+	var queryID string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queryID = r.Header.Get(QueryIDHeader)
+	})
+
+	clientCfg := grpcclient.ConfigWithTLS{}
+	clientCfg.GRPC.MaxSendMsgSize = 1024
+	mgr := newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, &mockFrontendClient{}, clientCfg, "querier", 1, nil)
+
+	request := &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query_range"}
+	mgr.runRequest(context.Background(), request, 0, "grafana-panel-1", func(response *httpgrpc.HTTPResponse, _ *QueryStats, _ bool) error {
+		assert.Equal(t, int32(http.StatusOK), response.Code)
+		return nil
+	})
+
+	assert.Equal(t, "grafana-panel-1", queryID)
+}
+
+func TestRunRequestReportsStats(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+
+		fetched := querier_stats.FromContext(r.Context())
+		fetched.AddFetchedSeries(3)
+		fetched.AddFetchedSamples(30)
+	})
+
+	clientCfg := grpcclient.ConfigWithTLS{}
+	clientCfg.GRPC.MaxSendMsgSize = 1024
+	mgr := newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, &mockFrontendClient{}, clientCfg, "querier", 1, nil)
+
+	var stats *QueryStats
+	request := &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query_range"}
+	mgr.runRequest(context.Background(), request, 0, "", func(_ *httpgrpc.HTTPResponse, s *QueryStats, _ bool) error {
+		stats = s
+		return nil
+	})
+
+	require.NotNil(t, stats)
+	assert.GreaterOrEqual(t, int64(stats.WallTime), int64(20*time.Millisecond))
+	assert.Equal(t, uint64(3), stats.FetchedSeries)
+	assert.Equal(t, uint64(30), stats.FetchedSamples)
+}
+
+func TestRunRequestReportsBusy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	clientCfg := grpcclient.ConfigWithTLS{}
+	clientCfg.GRPC.MaxSendMsgSize = 1024
+	load := &querierLoad{busyThreshold: 2}
+	mgr := newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, &mockFrontendClient{}, clientCfg, "querier", 1, load)
+
+	run := func() bool {
+		var busy bool
+		request := &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query_range"}
+		mgr.runRequest(context.Background(), request, 0, "", func(_ *httpgrpc.HTTPResponse, _ *QueryStats, b bool) error {
+			busy = b
+			return nil
+		})
+		return busy
+	}
+
+	// The querier is busy while it executes as many queries as the threshold, once the query is finished.
+	assert.False(t, run())
+	load.start()
+	assert.False(t, run())
+	load.start()
+	assert.True(t, run())
+	assert.False(t, load.finish())
+	assert.False(t, run())
+}
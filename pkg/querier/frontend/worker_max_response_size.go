@@ -0,0 +1,93 @@
+package frontend
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+)
+
+var errWorkerResponseTooLarge = errors.New("response exceeds the max response size")
+
+// maxResponseSizeHandler returns the handler replacing the responses of the querier larger than the
+// max size with an HTTP 413 error, or the handler as is if the size is not limited. The responses
+// are written through to the recorder of the httpgrpc server, which already holds the whole response
+// in memory before sending it to the query frontend, and the recorder is reset as soon as the
+// response exceeds the limit.
+func maxResponseSizeHandler(handler http.Handler, limit int64) http.Handler {
+	if limit <= 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &maxResponseSizeWriter{ResponseWriter: w, limit: limit}
+		handler.ServeHTTP(lw, r)
+
+		if !lw.exceeded {
+			lw.writeHeader()
+			return
+		}
+
+		msg := fmt.Sprintf("%s (%d bytes)", errWorkerResponseTooLarge, limit)
+		rec, ok := w.(*httptest.ResponseRecorder)
+		if !ok || !lw.wroteHeader {
+			for k := range w.Header() {
+				delete(w.Header(), k)
+			}
+			http.Error(w, msg, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Part of the response was already written, it's replaced by the error.
+		for k := range rec.Header() {
+			delete(rec.Header(), k)
+		}
+		rec.Body.Reset()
+		rec.Code = http.StatusRequestEntityTooLarge
+		http.Error(rec, msg, http.StatusRequestEntityTooLarge)
+	})
+}
+
+// maxResponseSizeWriter writes the response through until it exceeds the limit. The status code is
+// only written along with the first bytes of the body, as it doesn't apply to the error.
+type maxResponseSizeWriter struct {
+	http.ResponseWriter
+
+	code        int
+	wroteHeader bool
+	written     int64
+	limit       int64
+	exceeded    bool
+}
+
+func (w *maxResponseSizeWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *maxResponseSizeWriter) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// Write fails once the response exceeds the limit, so that the handler can stop encoding it.
+func (w *maxResponseSizeWriter) Write(p []byte) (int, error) {
+	if w.exceeded {
+		return 0, errWorkerResponseTooLarge
+	}
+	if w.written+int64(len(p)) > w.limit {
+		w.exceeded = true
+		return 0, errWorkerResponseTooLarge
+	}
+
+	w.writeHeader()
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
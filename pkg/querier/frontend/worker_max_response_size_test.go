@@ -0,0 +1,120 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
+)
+
+func TestMaxResponseSizeHandler(t *testing.T) {
+	for name, tc := range map[string]struct {
+		limit        int64
+		writes       []string
+		code         int
+		expectedCode int32
+		expectedBody string
+		expectedType string
+	}{
+		"disabled": {
+			writes:       []string{strings.Repeat("a", 100)},
+			expectedCode: http.StatusOK,
+			expectedBody: strings.Repeat("a", 100),
+			expectedType: "application/json",
+		},
+		"below the limit": {
+			limit:        100,
+			writes:       []string{strings.Repeat("a", 50), strings.Repeat("b", 50)},
+			expectedCode: http.StatusOK,
+			expectedBody: strings.Repeat("a", 50) + strings.Repeat("b", 50),
+			expectedType: "application/json",
+		},
+		"status code kept": {
+			limit:        100,
+			writes:       []string{"bad request"},
+			code:         http.StatusBadRequest,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "bad request",
+			expectedType: "application/json",
+		},
+		"empty response": {
+			limit:        100,
+			expectedCode: http.StatusOK,
+			expectedType: "application/json",
+		},
+		"above the limit": {
+			limit:        100,
+			writes:       []string{strings.Repeat("a", 50), strings.Repeat("b", 51)},
+			expectedCode: http.StatusRequestEntityTooLarge,
+			expectedBody: "response exceeds the max response size (100 bytes)\n",
+			expectedType: "text/plain; charset=utf-8",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var writeErr error
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if tc.code != 0 {
+					w.WriteHeader(tc.code)
+				}
+				for _, s := range tc.writes {
+					if _, writeErr = w.Write([]byte(s)); writeErr != nil {
+						return
+					}
+				}
+			})
+
+			// The handler is exercised through the httpgrpc server used by the worker, which buffers the response.
+			server := httpgrpc_server.NewServer(maxResponseSizeHandler(handler, tc.limit))
+			resp, err := server.Handle(context.Background(), &httpgrpc.HTTPRequest{Method: "GET", Url: "/api/v1/query"})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedCode, resp.Code)
+			assert.Equal(t, tc.expectedBody, string(resp.Body))
+			assert.Equal(t, []*httpgrpc.Header{{Key: "Content-Type", Values: []string{tc.expectedType}}}, filterHeaders(resp.Headers, "Content-Type"))
+			if tc.expectedCode == http.StatusRequestEntityTooLarge {
+				assert.Equal(t, errWorkerResponseTooLarge, writeErr)
+			} else {
+				assert.NoError(t, writeErr)
+			}
+		})
+	}
+}
+
+func TestMaxResponseSizeHandler_WritesThrough(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(strings.Repeat("a", 60)))
+		require.NoError(t, err)
+
+		// The response isn't buffered on top of the recorder.
+		assert.Equal(t, 60, rec.Body.Len())
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		_, err = w.Write([]byte(strings.Repeat("b", 60)))
+		assert.Equal(t, errWorkerResponseTooLarge, err)
+	})
+
+	// Once exceeded, the part of the response already written is replaced by the error.
+	maxResponseSizeHandler(handler, 100).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/query", nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, "response exceeds the max response size (100 bytes)\n", rec.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+}
+
+func filterHeaders(headers []*httpgrpc.Header, key string) []*httpgrpc.Header {
+	var filtered []*httpgrpc.Header
+	for _, h := range headers {
+		if h.Key == key {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
@@ -7,11 +7,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
 
 	"github.com/cortexproject/cortex/pkg/querier"
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/cortexproject/cortex/pkg/util/grpcclient"
 )
 
@@ -83,7 +85,7 @@ func TestResetConcurrency(t *testing.T) {
 			}
 
 			for i := 0; i < tt.numManagers; i++ {
-				w.managers[strconv.Itoa(i)] = newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, &mockFrontendClient{}, grpcclient.ConfigWithTLS{}, "querier")
+				w.managers[strconv.Itoa(i)] = newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(handler), mockCloser{}, &mockFrontendClient{}, grpcclient.ConfigWithTLS{}, "querier", 1, nil)
 			}
 
 			w.resetConcurrency()
@@ -106,3 +108,110 @@ func TestResetConcurrency(t *testing.T) {
 		})
 	}
 }
+
+func TestResetConcurrencyMatchingCPUs(t *testing.T) {
+	cpus := 4.0
+	defer func(original func() float64) { availableCPUs = original }(availableCPUs)
+	availableCPUs = func() float64 { return cpus }
+
+	w := &worker{
+		cfg:        WorkerConfig{MatchMaxConcurrency: true, MaxConcurrentPerCPU: 1.5},
+		querierCfg: querier.Config{MaxConcurrent: 20},
+		log:        util.Logger,
+		managers:   map[string]*frontendManager{},
+	}
+	for i := 0; i < 2; i++ {
+		w.managers[strconv.Itoa(i)] = newFrontendManager(context.Background(), util.Logger, httpgrpc_server.NewServer(http.NotFoundHandler()), mockCloser{}, &mockFrontendClient{}, grpcclient.ConfigWithTLS{}, "querier", 1, nil)
+	}
+	concurrency := func() int {
+		total := 0
+		for _, mgr := range w.managers {
+			total += len(mgr.workerCancels)
+		}
+		return total
+	}
+
+	w.resetConcurrency()
+	assert.Equal(t, 6, concurrency())
+
+	// The concurrency follows the available CPUs, rounded.
+	cpus = 3
+	assert.Equal(t, 5, w.getMaxConcurrent())
+	cpus = 2.5
+	assert.Equal(t, 4, w.getMaxConcurrent())
+	cpus = 3
+	w.resetConcurrency()
+	assert.Equal(t, 5, concurrency())
+
+	// At least one query is executed at a time.
+	w.cfg.MaxConcurrentPerCPU = 0.1
+	assert.Equal(t, 1, w.getMaxConcurrent())
+
+	assert.NoError(t, w.stopping(nil))
+}
+
+func TestWorkerConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		setup       func(cfg *WorkerConfig)
+		expectedErr string
+	}{
+		"defaults": {
+			setup: func(cfg *WorkerConfig) {},
+		},
+		"no parallelism": {
+			setup: func(cfg *WorkerConfig) {
+				cfg.Parallelism = 0
+			},
+			expectedErr: "invalid -querier.worker-parallelism 0: must be at least 1, unless -querier.worker-match-max-concurrent is enabled",
+		},
+		"no parallelism matching the max concurrency": {
+			setup: func(cfg *WorkerConfig) {
+				cfg.Parallelism = 0
+				cfg.MatchMaxConcurrency = true
+			},
+		},
+		"negative max concurrent per CPU": {
+			setup: func(cfg *WorkerConfig) {
+				cfg.MaxConcurrentPerCPU = -1
+			},
+			expectedErr: "invalid -querier.worker-match-max-concurrent-per-cpu -1: must not be negative, 0 to disable",
+		},
+		"no weight": {
+			setup: func(cfg *WorkerConfig) {
+				cfg.Weight = 0
+			},
+			expectedErr: "invalid -querier.worker-weight 0: must be at least 1",
+		},
+		"negative busy threshold": {
+			setup: func(cfg *WorkerConfig) {
+				cfg.BusyThreshold = -1
+			},
+			expectedErr: "invalid -querier.worker-busy-threshold -1: must not be negative, 0 to disable",
+		},
+		"negative max response size": {
+			setup: func(cfg *WorkerConfig) {
+				cfg.MaxResponseSize = -1
+			},
+			expectedErr: "invalid -querier.worker-max-response-size -1: must not be negative, 0 to disable",
+		},
+		"no DNS lookup period": {
+			setup: func(cfg *WorkerConfig) {
+				cfg.DNSLookupDuration = 0
+			},
+			expectedErr: "invalid -querier.dns-lookup-period 0s: must be positive",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := WorkerConfig{}
+			flagext.DefaultValues(&cfg)
+			tc.setup(&cfg)
+
+			err := cfg.Validate(log.NewNopLogger())
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package queryrange
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+)
+
+const errBlockedFunction = "the function %s is blocked for the tenant"
+
+// BlockedFunctionsMiddleware rejects the queries calling any of the functions blocked for the tenant.
+func BlockedFunctionsMiddleware(limits Limits) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return blockedFunctions{
+			next:   next,
+			limits: limits,
+		}
+	})
+}
+
+type blockedFunctions struct {
+	next   Handler
+	limits Limits
+}
+
+func (b blockedFunctions) Do(ctx context.Context, r Request) (Response, error) {
+	if err := validateQueryFunctions(ctx, r.GetQuery(), b.limits); err != nil {
+		return nil, err
+	}
+	return b.next.Do(ctx, r)
+}
+
+// validateQueryFunctions returns an error naming the first function of the query blocked for any of
+// the tenants. The function calls are looked up in the parsed query, so that the strings of the query
+// matching a blocked function name are not mistaken for calls.
+func validateQueryFunctions(ctx context.Context, query string, limits Limits) error {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	blocked := map[string]struct{}{}
+	for _, name := range blockedFunctionsPerTenant(tenantIDs, limits) {
+		blocked[name] = struct{}{}
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	var found error
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		call, ok := node.(*parser.Call)
+		if !ok || found != nil {
+			return nil
+		}
+		if _, ok := blocked[call.Func.Name]; ok {
+			found = httpgrpc.Errorf(http.StatusBadRequest, errBlockedFunction, call.Func.Name)
+			return found
+		}
+		return nil
+	})
+	return found
+}
+
+// blockedFunctionsPerTenant returns the functions blocked for any of the tenants.
+func blockedFunctionsPerTenant(tenantIDs []string, limits Limits) []string {
+	var blocked []string
+	for _, tenantID := range tenantIDs {
+		blocked = append(blocked, limits.BlockedQueryFunctions(tenantID)...)
+	}
+	return blocked
+}
+
+// validateQueryFunctionsHTTP is validateQueryFunctions for the query requests passed through the
+// middlewares, whose query is in the URL or in the form body.
+func validateQueryFunctionsHTTP(r *http.Request, limits Limits) error {
+	form, err := peekForm(r)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	if form.Get("query") == "" {
+		return nil
+	}
+	return validateQueryFunctions(r.Context(), form.Get("query"), limits)
+}
@@ -0,0 +1,83 @@
+package queryrange
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+func TestBlockedFunctionsMiddleware(t *testing.T) {
+	for name, tc := range map[string]struct {
+		query            string
+		blockedFunctions []string
+		expectedErr      string
+	}{
+		"no blocked functions": {
+			query: `holt_winters(up[1h], 0.5, 0.5)`,
+		},
+		"blocked function": {
+			query:            `holt_winters(up[1h], 0.5, 0.5)`,
+			blockedFunctions: []string{"holt_winters"},
+			expectedErr:      "rpc error: code = Code(400) desc = the function holt_winters is blocked for the tenant",
+		},
+		"blocked function in a subquery": {
+			query:            `max_over_time(label_replace(up, "a", "$1", "b", "(.*)")[1h:1m])`,
+			blockedFunctions: []string{"holt_winters", "label_replace"},
+			expectedErr:      "rpc error: code = Code(400) desc = the function label_replace is blocked for the tenant",
+		},
+		"blocked function name in a string literal": {
+			query:            `up{job="holt_winters"}`,
+			blockedFunctions: []string{"holt_winters"},
+		},
+		"blocked function name as a metric name": {
+			query:            `sum(label_replace)`,
+			blockedFunctions: []string{"label_replace"},
+		},
+		"other functions": {
+			query:            `sum(rate(http_requests_total[5m]))`,
+			blockedFunctions: []string{"holt_winters"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			next := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				return &PrometheusResponse{Status: StatusSuccess}, nil
+			})
+
+			req := &PrometheusRequest{Start: 0, End: 3600 * 1e3, Step: 15 * 1e3, Query: tc.query}
+			_, err := BlockedFunctionsMiddleware(fakeLimits{blockedFunctions: tc.blockedFunctions}).Wrap(next).Do(user.InjectOrgID(context.Background(), "1"), req)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expectedErr)
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+		})
+	}
+}
+
+func TestValidateQueryFunctionsHTTP(t *testing.T) {
+	limits := fakeLimits{blockedFunctions: []string{"holt_winters"}}
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	r, err := http.NewRequest("GET", "/api/v1/query?query="+url.QueryEscape(`holt_winters(up[1h], 0.5, 0.5)`), nil)
+	require.NoError(t, err)
+	assert.EqualError(t, validateQueryFunctionsHTTP(r.WithContext(ctx), limits), "rpc error: code = Code(400) desc = the function holt_winters is blocked for the tenant")
+
+	r, err = http.NewRequest("POST", "/api/v1/query", strings.NewReader("query="+url.QueryEscape(`holt_winters(up[1h], 0.5, 0.5)`)))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.EqualError(t, validateQueryFunctionsHTTP(r.WithContext(ctx), limits), "rpc error: code = Code(400) desc = the function holt_winters is blocked for the tenant")
+
+	r, err = http.NewRequest("GET", "/api/v1/query?query=up", nil)
+	require.NoError(t, err)
+	assert.NoError(t, validateQueryFunctionsHTTP(r.WithContext(ctx), limits))
+}
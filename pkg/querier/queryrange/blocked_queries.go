@@ -0,0 +1,96 @@
+package queryrange
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+)
+
+const errBlockedQuery = "the query is blocked for the tenant, as it matches the blocked query pattern %q"
+
+// queryBlocker rejects the queries matching any of the patterns blocked for their tenant. The patterns
+// are read from the limits of each query, so they can be updated through the runtime config.
+type queryBlocker struct {
+	limits         Limits
+	blockedQueries *prometheus.CounterVec
+}
+
+func newQueryBlocker(limits Limits, registerer prometheus.Registerer) *queryBlocker {
+	return &queryBlocker{
+		limits: limits,
+		blockedQueries: promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "query_frontend_blocked_queries_total",
+			Help:      "Total number of queries rejected because they match a blocked query pattern of the tenant.",
+		}, []string{"user"}),
+	}
+}
+
+// Wrap implements Middleware.
+func (b *queryBlocker) Wrap(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, r Request) (Response, error) {
+		if err := b.validate(ctx, r.GetQuery()); err != nil {
+			return nil, err
+		}
+		return next.Do(ctx, r)
+	})
+}
+
+func (b *queryBlocker) validate(ctx context.Context, query string) error {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	tenantID, pattern := matchBlockedQuery(tenantIDs, query, b.limits)
+	if pattern == "" {
+		return nil
+	}
+	b.blockedQueries.WithLabelValues(tenantID).Inc()
+	return httpgrpc.Errorf(http.StatusForbidden, errBlockedQuery, pattern)
+}
+
+// validateHTTP is validate for the query requests passed through the middlewares, whose query is in
+// the URL or in the form body.
+func (b *queryBlocker) validateHTTP(r *http.Request) error {
+	form, err := peekForm(r)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	if form.Get("query") == "" {
+		return nil
+	}
+	return b.validate(r.Context(), form.Get("query"))
+}
+
+// blockedQueriesPerTenant returns the blocked query patterns of all the tenants.
+func blockedQueriesPerTenant(tenantIDs []string, limits Limits) []string {
+	var blocked []string
+	for _, tenantID := range tenantIDs {
+		blocked = append(blocked, limits.BlockedQueries(tenantID)...)
+	}
+	return blocked
+}
+
+// matchBlockedQuery returns the first tenant with a blocked query pattern matching any part of the
+// query and the pattern, or empty strings if the query isn't blocked. The invalid patterns, rejected
+// by the limits validation, never match.
+func matchBlockedQuery(tenantIDs []string, query string, limits Limits) (string, string) {
+	for _, tenantID := range tenantIDs {
+		for _, pattern := range limits.BlockedQueries(tenantID) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				continue
+			}
+			if re.MatchString(query) {
+				return tenantID, pattern
+			}
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,83 @@
+package queryrange
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+func TestQueryBlocker(t *testing.T) {
+	for name, tc := range map[string]struct {
+		query          string
+		blockedQueries []string
+		expectedErr    string
+	}{
+		"no blocked queries": {
+			query: `sum(rate(http_requests_total[5m]))`,
+		},
+		"query not matching": {
+			query:          `sum(rate(http_requests_total[5m]))`,
+			blockedQueries: []string{`job:.*:rate5m`},
+		},
+		"query matching": {
+			query:          `sum(job:http_requests:rate5m)`,
+			blockedQueries: []string{`up`, `job:.*:rate5m`},
+			expectedErr:    `rpc error: code = Code(403) desc = the query is blocked for the tenant, as it matches the blocked query pattern "job:.*:rate5m"`,
+		},
+		"invalid pattern": {
+			query:          `sum(rate(http_requests_total[5m]))`,
+			blockedQueries: []string{`rate(`},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reg := prometheus.NewPedanticRegistry()
+			next := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				return &PrometheusResponse{Status: StatusSuccess}, nil
+			})
+
+			req := &PrometheusRequest{Start: 0, End: 3600 * 1e3, Step: 15 * 1e3, Query: tc.query}
+			_, err := newQueryBlocker(fakeLimits{blockedQueries: tc.blockedQueries}, reg).Wrap(next).Do(user.InjectOrgID(context.Background(), "1"), req)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader("")))
+				return
+			}
+			require.EqualError(t, err, tc.expectedErr)
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			assert.Equal(t, int32(http.StatusForbidden), resp.Code)
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+				# HELP cortex_query_frontend_blocked_queries_total Total number of queries rejected because they match a blocked query pattern of the tenant.
+				# TYPE cortex_query_frontend_blocked_queries_total counter
+				cortex_query_frontend_blocked_queries_total{user="1"} 1
+			`)))
+		})
+	}
+}
+
+func TestQueryBlocker_ValidateHTTP(t *testing.T) {
+	blocker := newQueryBlocker(fakeLimits{blockedQueries: []string{`job:.*:rate5m`}}, nil)
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	r, err := http.NewRequest("GET", "/api/v1/query?query="+url.QueryEscape(`sum(job:http_requests:rate5m)`), nil)
+	require.NoError(t, err)
+	assert.Error(t, blocker.validateHTTP(r.WithContext(ctx)))
+
+	r, err = http.NewRequest("POST", "/api/v1/query", strings.NewReader("query="+url.QueryEscape(`sum(job:http_requests:rate5m)`)))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.Error(t, blocker.validateHTTP(r.WithContext(ctx)))
+
+	r, err = http.NewRequest("GET", "/api/v1/query?query=up", nil)
+	require.NoError(t, err)
+	assert.NoError(t, blocker.validateHTTP(r.WithContext(ctx)))
+}
@@ -0,0 +1,81 @@
+package queryrange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+const warnDownsampled = "the query step was increased from %s to %s, as the query would have returned more than %d points per series"
+
+// DownsampleMiddleware increases the step of the queries returning more points per series than
+// allowed, for the tenants which enabled it.
+func DownsampleMiddleware(limits Limits) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return downsample{
+			next:   next,
+			limits: limits,
+		}
+	})
+}
+
+type downsample struct {
+	next   Handler
+	limits Limits
+}
+
+func (d downsample) Do(ctx context.Context, r Request) (Response, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	step, maxPoints := downsampledStep(r, tenantIDs, d.limits)
+	if step == r.GetStep() {
+		return d.next.Do(ctx, r)
+	}
+
+	resp, err := d.next.Do(ctx, r.WithStep(step))
+	if err != nil {
+		return nil, err
+	}
+	if promResponse, ok := resp.(*PrometheusResponse); ok {
+		promResponse.Warnings = append(promResponse.Warnings, fmt.Sprintf(warnDownsampled, stepDuration(r.GetStep()), stepDuration(step), maxPoints))
+	}
+	return resp, nil
+}
+
+// downsampledStep returns the step of the query, increased if the query would return more points per
+// series than allowed and the downsampling is enabled for all the tenants, and the max number of points.
+// The increased step is a multiple of the original one.
+func downsampledStep(r Request, tenantIDs []string, limits Limits) (int64, int) {
+	if !validation.AllTrueBooleansPerTenant(tenantIDs, limits.QueryDownsampling) {
+		return r.GetStep(), 0
+	}
+	maxPoints := validation.SmallestPositiveIntPerTenant(tenantIDs, limits.MaxSamplesPerQuery)
+	if maxPoints <= 0 || r.GetStep() <= 0 || (r.GetEnd()-r.GetStart())/r.GetStep()+1 <= int64(maxPoints) {
+		return r.GetStep(), maxPoints
+	}
+
+	// The points are at both ends of the intervals between them.
+	intervals := int64(maxPoints - 1)
+	if intervals < 1 {
+		intervals = 1
+	}
+	step := ceilDiv(r.GetEnd()-r.GetStart(), intervals)
+	return ceilDiv(step, r.GetStep()) * r.GetStep(), maxPoints
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+func stepDuration(step int64) time.Duration {
+	return time.Duration(step) * time.Millisecond
+}
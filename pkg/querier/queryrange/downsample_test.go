@@ -0,0 +1,59 @@
+package queryrange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestDownsampleMiddleware(t *testing.T) {
+	// 1h with a 15s step: 241 points per series.
+	req := &PrometheusRequest{Start: 0, End: 3600 * 1e3, Step: 15 * 1e3, Query: "up"}
+
+	for name, tc := range map[string]struct {
+		limits           fakeLimits
+		expectedStep     int64
+		expectedWarnings []string
+	}{
+		"disabled": {
+			limits:       fakeLimits{maxSamplesPerQuery: 100},
+			expectedStep: 15 * 1e3,
+		},
+		"enabled and below the limit": {
+			limits:       fakeLimits{queryDownsampling: true, maxSamplesPerQuery: 241},
+			expectedStep: 15 * 1e3,
+		},
+		"enabled without limit": {
+			limits:       fakeLimits{queryDownsampling: true},
+			expectedStep: 15 * 1e3,
+		},
+		"enabled and above the limit": {
+			limits:           fakeLimits{queryDownsampling: true, maxSamplesPerQuery: 100},
+			expectedStep:     45 * 1e3,
+			expectedWarnings: []string{"the query step was increased from 15s to 45s, as the query would have returned more than 100 points per series"},
+		},
+		"enabled with a limit of a single point": {
+			limits:           fakeLimits{queryDownsampling: true, maxSamplesPerQuery: 1},
+			expectedStep:     3600 * 1e3,
+			expectedWarnings: []string{"the query step was increased from 15s to 1h0m0s, as the query would have returned more than 1 points per series"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var step int64
+			next := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				step = r.GetStep()
+				assert.Equal(t, req.GetStart(), r.GetStart())
+				assert.Equal(t, req.GetEnd(), r.GetEnd())
+				return &PrometheusResponse{Status: StatusSuccess}, nil
+			})
+
+			resp, err := DownsampleMiddleware(tc.limits).Wrap(next).Do(user.InjectOrgID(context.Background(), "1"), req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStep, step)
+			assert.Equal(t, tc.expectedWarnings, resp.(*PrometheusResponse).Warnings)
+		})
+	}
+}
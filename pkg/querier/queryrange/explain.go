@@ -0,0 +1,322 @@
+package queryrange
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// ExplainHeader is the header of the requests asking for a description of how the query-frontend
+// would handle the query, instead of its results.
+const ExplainHeader = "X-Cortex-Explain"
+
+// isExplainRequest returns whether the request asks for an explanation instead of the results.
+func isExplainRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(ExplainHeader), "true")
+}
+
+type explanation struct {
+	Path            string           `json:"path"`
+	Query           string           `json:"query,omitempty"`
+	InjectedQuery   string           `json:"injectedQuery,omitempty"`
+	Start           string           `json:"start,omitempty"`
+	End             string           `json:"end,omitempty"`
+	Step            string           `json:"step,omitempty"`
+	ClampedStep     string           `json:"clampedStep,omitempty"`
+	DownsampledStep string           `json:"downsampledStep,omitempty"`
+	Tenants         []string         `json:"tenants"`
+	Downstream      string           `json:"downstream"`
+	Limits          explainedLimits  `json:"limits"`
+	Splits          []explainedSplit `json:"splits,omitempty"`
+	Rejected        string           `json:"rejected,omitempty"`
+	Passthrough     bool             `json:"passthrough,omitempty"`
+}
+
+type explainedLimits struct {
+	MaxQueryLength      string   `json:"maxQueryLength"`
+	MaxQueryParallelism int      `json:"maxQueryParallelism"`
+	MaxQuerySplits      int      `json:"maxQuerySplits"`
+	MaxCacheFreshness   string   `json:"maxCacheFreshness"`
+	AllowPartialResults bool     `json:"allowPartialResults"`
+	QueryDownsampling   bool     `json:"queryDownsampling"`
+	MinStep             string   `json:"minStep"`
+	MinStepClamp        bool     `json:"minStepClamp"`
+	RequiredMatchers    []string `json:"requiredMatchers,omitempty"`
+	BlockedFunctions    []string `json:"blockedFunctions,omitempty"`
+	BlockedQueries      []string `json:"blockedQueries,omitempty"`
+}
+
+type explainedSplit struct {
+	Tenant     string          `json:"tenant"`
+	Start      string          `json:"start"`
+	End        string          `json:"end"`
+	Historical bool            `json:"historical,omitempty"`
+	Cache      *explainedCache `json:"cache,omitempty"`
+}
+
+type explainedCache struct {
+	Key     string           `json:"key,omitempty"`
+	Skipped string           `json:"skipped,omitempty"`
+	Hits    []explainedRange `json:"hits,omitempty"`
+	Misses  []explainedRange `json:"misses,omitempty"`
+}
+
+type explainedRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// explainer describes how the middlewares would handle the requests, without executing them.
+type explainer struct {
+	cfg         Config
+	limits      Limits
+	codec       Codec
+	shouldCache ShouldCacheFn
+
+	// Nil if the results cache is disabled.
+	cache *resultsCache
+}
+
+func (e explainer) RoundTrip(r *http.Request) (*http.Response, error) {
+	tenantIDs, err := tenant.TenantIDs(r.Context())
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	exp := explanation{
+		Path:       r.URL.Path,
+		Tenants:    tenantIDs,
+		Downstream: e.downstream(tenantIDs),
+		Limits: explainedLimits{
+			MaxQueryLength:      validation.SmallestPositiveDurationPerTenant(tenantIDs, e.limits.MaxQueryLength).String(),
+			MaxQueryParallelism: validation.SmallestPositiveIntPerTenant(tenantIDs, e.limits.MaxQueryParallelism),
+			MaxQuerySplits:      validation.SmallestPositiveIntPerTenant(tenantIDs, e.limits.MaxQuerySplits),
+			MaxCacheFreshness:   validation.SmallestPositiveDurationPerTenant(tenantIDs, e.limits.MaxCacheFreshness).String(),
+			AllowPartialResults: validation.AllTrueBooleansPerTenant(tenantIDs, e.limits.AllowPartialResults),
+			QueryDownsampling:   validation.AllTrueBooleansPerTenant(tenantIDs, e.limits.QueryDownsampling),
+			MinStep:             validation.LargestDurationPerTenant(tenantIDs, e.limits.MinStep).String(),
+			MinStepClamp:        validation.AllTrueBooleansPerTenant(tenantIDs, e.limits.MinStepClamp),
+			RequiredMatchers:    requiredMatchersPerTenant(tenantIDs, e.limits),
+			BlockedFunctions:    blockedFunctionsPerTenant(tenantIDs, e.limits),
+			BlockedQueries:      blockedQueriesPerTenant(tenantIDs, e.limits),
+		},
+	}
+
+	// Mirrors the routing of the tripperware: only the range queries go through the middlewares.
+	if !strings.HasSuffix(r.URL.Path, "/query_range") || acceptsProtobuf(r) {
+		exp.Passthrough = true
+		if strings.HasSuffix(r.URL.Path, "/query_range") || strings.HasSuffix(r.URL.Path, "/series") {
+			if err := validateSeriesQueryLength(r, e.limits); err != nil {
+				exp.Rejected = errorMessage(err)
+			}
+		}
+		return explanationResponse(exp)
+	}
+
+	req, err := e.codec.DecodeRequest(r.Context(), r)
+	if err != nil {
+		return nil, err
+	}
+	exp.Query = req.GetQuery()
+	exp.Start = formatExplainTimestamp(req.GetStart())
+	exp.End = formatExplainTimestamp(req.GetEnd())
+	exp.Step = (time.Duration(req.GetStep()) * time.Millisecond).String()
+
+	if err := e.explainSplits(r.Context(), req, tenantIDs, &exp); err != nil {
+		exp.Rejected = errorMessage(err)
+		exp.Splits = nil
+	}
+	return explanationResponse(exp)
+}
+
+// explainSplits fills the sub-queries the request would be split into, and how they would be served
+// by the results cache. Returns the error the request would be rejected with, if any.
+func (e explainer) explainSplits(ctx context.Context, req Request, tenantIDs []string, exp *explanation) error {
+	maxQueryLen := validation.SmallestPositiveDurationPerTenant(tenantIDs, e.limits.MaxQueryLength)
+	queryLen := timestamp.Time(req.GetEnd()).Sub(timestamp.Time(req.GetStart()))
+	if maxQueryLen > 0 && queryLen > maxQueryLen {
+		return httpgrpc.Errorf(http.StatusBadRequest, validation.ErrQueryTooLong, queryLen, maxQueryLen)
+	}
+
+	// Each tenant is queried separately when splitting by tenant.
+	orgIDs := []string{tenant.JoinTenantIDs(tenantIDs)}
+	if e.cfg.SplitQueriesByTenant && len(tenantIDs) > 1 {
+		orgIDs = tenantIDs
+	}
+
+	if _, pattern := matchBlockedQuery(tenantIDs, req.GetQuery(), e.limits); pattern != "" {
+		return httpgrpc.Errorf(http.StatusForbidden, errBlockedQuery, pattern)
+	}
+
+	if err := validateQueryFunctions(ctx, req.GetQuery(), e.limits); err != nil {
+		return err
+	}
+
+	query, err := injectRequiredMatchers(ctx, req.GetQuery(), e.limits)
+	if err != nil {
+		return err
+	}
+	if query != req.GetQuery() {
+		req = req.WithQuery(query)
+		exp.InjectedQuery = query
+	}
+
+	step, err := minStepQuery(req, tenantIDs, e.limits)
+	if err != nil {
+		return err
+	}
+	if step != req.GetStep() {
+		req = req.WithStep(step)
+		exp.ClampedStep = stepDuration(step).String()
+	}
+
+	if step, _ := downsampledStep(req, tenantIDs, e.limits); step != req.GetStep() {
+		req = req.WithStep(step)
+		exp.DownsampledStep = stepDuration(step).String()
+	}
+
+	if e.cfg.AlignQueriesWithStep {
+		req = req.WithStartEnd((req.GetStart()/req.GetStep())*req.GetStep(), (req.GetEnd()/req.GetStep())*req.GetStep())
+	}
+
+	// The historical and the recent parts of the query are split by interval separately.
+	parts := []Request{req}
+	historical := req
+	if e.cfg.HistoricalQueryThreshold > 0 {
+		var recent Request
+		historical, recent = splitHistorical(req, util.TimeToMillis(time.Now().Add(-e.cfg.HistoricalQueryThreshold)))
+		parts = nil
+		for _, part := range []Request{historical, recent} {
+			if part != nil {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	for _, part := range parts {
+		reqs := []Request{part}
+		if e.cfg.SplitQueriesByInterval != 0 {
+			reqs = splitQuery(part, e.cfg.SplitQueriesByInterval)
+			if maxSplits := validation.SmallestPositiveIntPerTenant(tenantIDs, e.limits.MaxQuerySplits); maxSplits > 0 && len(reqs) > maxSplits {
+				return httpgrpc.Errorf(http.StatusBadRequest, errTooManySplits, len(reqs), maxSplits)
+			}
+		}
+
+		for _, orgID := range orgIDs {
+			for _, split := range reqs {
+				exp.Splits = append(exp.Splits, explainedSplit{
+					Tenant:     orgID,
+					Start:      formatExplainTimestamp(split.GetStart()),
+					End:        formatExplainTimestamp(split.GetEnd()),
+					Historical: e.cfg.HistoricalQueryThreshold > 0 && part == historical,
+					Cache:      e.explainCache(user.InjectOrgID(ctx, orgID), orgID, split),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// explainCache returns which parts of the sub-query would be served from the results cache, or nil if
+// the results cache is disabled. It mirrors the lookup of the results cache middleware.
+func (e explainer) explainCache(ctx context.Context, orgID string, r Request) *explainedCache {
+	if e.cache == nil {
+		return nil
+	}
+	if e.shouldCache != nil && !e.shouldCache(r) {
+		return &explainedCache{Skipped: "caching disabled for the request"}
+	}
+	if tenantIDs, err := tenant.TenantIDsFromOrgID(orgID); err != nil || len(tenantIDs) > 1 {
+		return &explainedCache{Skipped: "query spanning multiple tenants"}
+	}
+
+	maxCacheTime := int64(model.Now().Add(-e.limits.MaxCacheFreshness(orgID)))
+	if r.GetStart() > maxCacheTime {
+		return &explainedCache{Skipped: "query more recent than the max cache freshness"}
+	}
+
+	if e.cache.cacheGenNumberLoader != nil {
+		ctx = cache.InjectCacheGenNumber(ctx, e.cache.cacheGenNumberLoader.GetResultsCacheGenNumber(orgID))
+	}
+
+	key := e.cache.cacheKey(orgID, r)
+	result := &explainedCache{Key: key}
+
+	extents, _ := e.cache.get(ctx, key)
+	start := r.GetStart()
+	for _, extent := range extents {
+		if extent.GetEnd() < start || extent.Start > r.GetEnd() {
+			continue
+		}
+		if start < extent.Start {
+			result.Misses = append(result.Misses, explainRange(start, extent.Start))
+		}
+		end := extent.End
+		if end > r.GetEnd() {
+			end = r.GetEnd()
+		}
+		result.Hits = append(result.Hits, explainRange(start, end))
+		start = extent.End
+	}
+	if start < r.GetEnd() {
+		result.Misses = append(result.Misses, explainRange(start, r.GetEnd()))
+	}
+	return result
+}
+
+// downstream returns the downstream URL override of the tenants, or "default" if the query would be
+// sent to the default downstream URL or to the queriers, as they don't share the same override.
+func (e explainer) downstream(tenantIDs []string) string {
+	downstreamURL := e.limits.DownstreamURL(tenantIDs[0])
+	for _, tenantID := range tenantIDs[1:] {
+		if e.limits.DownstreamURL(tenantID) != downstreamURL {
+			return "default"
+		}
+	}
+	if downstreamURL == "" {
+		return "default"
+	}
+	return downstreamURL
+}
+
+func explanationResponse(exp explanation) (*http.Response, error) {
+	b, err := json.Marshal(exp)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+	}, nil
+}
+
+func explainRange(start, end int64) explainedRange {
+	return explainedRange{Start: formatExplainTimestamp(start), End: formatExplainTimestamp(end)}
+}
+
+func formatExplainTimestamp(t int64) string {
+	return timestamp.Time(t).UTC().Format(time.RFC3339Nano)
+}
+
+// errorMessage returns the body of HTTP errors, or the message of the other errors.
+func errorMessage(err error) string {
+	if resp, ok := httpgrpc.HTTPResponseFromError(err); ok {
+		return string(resp.Body)
+	}
+	return err.Error()
+}
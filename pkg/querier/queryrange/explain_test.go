@@ -0,0 +1,265 @@
+package queryrange
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const explainQuery = "/api/v1/query_range?end=7200&query=sum%28rate%28foo%5B1m%5D%29%29&start=0&step=60"
+
+var errExecuted = errors.New("executed")
+
+func TestTripperware_ExplainRequests(t *testing.T) {
+	for name, tc := range map[string]struct {
+		enabled           bool
+		header            string
+		expectedExplained bool
+	}{
+		"disabled": {
+			header: "true",
+		},
+		"enabled without the header": {
+			enabled: true,
+		},
+		"enabled with the header set to false": {
+			enabled: true,
+			header:  "false",
+		},
+		"enabled with the header": {
+			enabled:           true,
+			header:            "true",
+			expectedExplained: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			downstreamCalls := atomic.NewInt32(0)
+			downstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+				downstreamCalls.Inc()
+				return nil, errExecuted
+			})
+
+			tw, _, err := NewTripperware(
+				Config{SplitQueriesByInterval: time.Hour, QueryExplainEnabled: tc.enabled},
+				util.Logger,
+				fakeLimits{downstreamURL: "http://prometheus"},
+				PrometheusCodec,
+				nil,
+				chunk.SchemaConfig{},
+				promql.EngineOpts{},
+				0,
+				nil,
+				nil,
+			)
+			require.NoError(t, err)
+
+			req := newExplainRequest(t, explainQuery, "1", tc.header)
+			resp, err := tw(downstream).RoundTrip(req)
+			if !tc.expectedExplained {
+				require.Equal(t, errExecuted, err)
+				assert.NotZero(t, downstreamCalls.Load())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Zero(t, downstreamCalls.Load())
+
+			var exp explanation
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&exp))
+			assert.Equal(t, explanation{
+				Path:       "/api/v1/query_range",
+				Query:      "sum(rate(foo[1m]))",
+				Start:      "1970-01-01T00:00:00Z",
+				End:        "1970-01-01T02:00:00Z",
+				Step:       "1m0s",
+				Tenants:    []string{"1"},
+				Downstream: "http://prometheus",
+				Limits: explainedLimits{
+					MaxQueryLength:      "0s",
+					MaxQueryParallelism: 14,
+					MaxCacheFreshness:   "0s",
+					MinStep:             "0s",
+				},
+				Splits: []explainedSplit{
+					{Tenant: "1", Start: "1970-01-01T00:00:00Z", End: "1970-01-01T00:59:00Z"},
+					{Tenant: "1", Start: "1970-01-01T01:00:00Z", End: "1970-01-01T02:00:00Z"},
+				},
+			}, exp)
+		})
+	}
+}
+
+func TestExplainer(t *testing.T) {
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			Cache: cache.NewMockCache(),
+		},
+	}
+	rcm, _, err := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, constSplitter(time.Hour), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, nil, nil, nil)
+	require.NoError(t, err)
+	rc := rcm.Wrap(nil).(*resultsCache)
+
+	// The first half of the first split is cached.
+	firstSplit := &PrometheusRequest{Start: 0, End: 3540 * 1e3, Step: 60 * 1e3, Query: "sum(rate(foo[1m]))"}
+	rc.put(context.Background(), constSplitter(time.Hour).GenerateCacheKey("1", firstSplit), []Extent{mkExtent(0, 1800*1e3)})
+
+	for name, tc := range map[string]struct {
+		cfg            Config
+		limits         fakeLimits
+		path           string
+		orgID          string
+		expectedSplits []explainedSplit
+		expectedReject string
+		passthrough    bool
+	}{
+		"cached splits": {
+			cfg:  Config{SplitQueriesByInterval: time.Hour},
+			path: explainQuery,
+			expectedSplits: []explainedSplit{
+				{
+					Tenant: "1", Start: "1970-01-01T00:00:00Z", End: "1970-01-01T00:59:00Z",
+					Cache: &explainedCache{
+						Key:    "1:sum(rate(foo[1m])):60000:0",
+						Hits:   []explainedRange{{Start: "1970-01-01T00:00:00Z", End: "1970-01-01T00:30:00Z"}},
+						Misses: []explainedRange{{Start: "1970-01-01T00:30:00Z", End: "1970-01-01T00:59:00Z"}},
+					},
+				},
+				{
+					Tenant: "1", Start: "1970-01-01T01:00:00Z", End: "1970-01-01T02:00:00Z",
+					Cache: &explainedCache{
+						Key:    "1:sum(rate(foo[1m])):60000:1",
+						Misses: []explainedRange{{Start: "1970-01-01T01:00:00Z", End: "1970-01-01T02:00:00Z"}},
+					},
+				},
+			},
+		},
+		"queries spanning multiple tenants aren't cached": {
+			cfg:   Config{},
+			path:  explainQuery,
+			orgID: "1|2",
+			expectedSplits: []explainedSplit{
+				{
+					Tenant: "1|2", Start: "1970-01-01T00:00:00Z", End: "1970-01-01T02:00:00Z",
+					Cache: &explainedCache{Skipped: "query spanning multiple tenants"},
+				},
+			},
+		},
+		"split by tenant": {
+			cfg:   Config{SplitQueriesByTenant: true},
+			path:  explainQuery,
+			orgID: "1|2",
+			expectedSplits: []explainedSplit{
+				{
+					Tenant: "1", Start: "1970-01-01T00:00:00Z", End: "1970-01-01T02:00:00Z",
+					Cache: &explainedCache{
+						Key:    "1:sum(rate(foo[1m])):60000:0",
+						Hits:   []explainedRange{{Start: "1970-01-01T00:00:00Z", End: "1970-01-01T00:30:00Z"}},
+						Misses: []explainedRange{{Start: "1970-01-01T00:30:00Z", End: "1970-01-01T02:00:00Z"}},
+					},
+				},
+				{
+					Tenant: "2", Start: "1970-01-01T00:00:00Z", End: "1970-01-01T02:00:00Z",
+					Cache: &explainedCache{Key: "2:sum(rate(foo[1m])):60000:0", Misses: []explainedRange{{Start: "1970-01-01T00:00:00Z", End: "1970-01-01T02:00:00Z"}}},
+				},
+			},
+		},
+		"historical query": {
+			cfg:  Config{HistoricalQueryThreshold: 24 * time.Hour},
+			path: explainQuery,
+			expectedSplits: []explainedSplit{
+				{
+					Tenant: "1", Start: "1970-01-01T00:00:00Z", End: "1970-01-01T02:00:00Z", Historical: true,
+					Cache: &explainedCache{
+						Key:    "1:sum(rate(foo[1m])):60000:0",
+						Hits:   []explainedRange{{Start: "1970-01-01T00:00:00Z", End: "1970-01-01T00:30:00Z"}},
+						Misses: []explainedRange{{Start: "1970-01-01T00:30:00Z", End: "1970-01-01T02:00:00Z"}},
+					},
+				},
+			},
+		},
+		"too long": {
+			cfg:            Config{SplitQueriesByInterval: time.Hour},
+			limits:         fakeLimits{maxQueryLength: time.Hour},
+			path:           explainQuery,
+			expectedReject: "the query time range exceeds the limit (query length: 2h0m0s, limit: 1h0m0s)",
+		},
+		"too many splits": {
+			cfg:            Config{SplitQueriesByInterval: time.Hour},
+			limits:         fakeLimits{maxQuerySplits: 1},
+			path:           explainQuery,
+			expectedReject: "the query would be split into too many sub-queries (sub-queries: 2, limit: 1)",
+		},
+		"step below the minimum": {
+			cfg:            Config{SplitQueriesByInterval: time.Hour},
+			limits:         fakeLimits{minStep: 5 * time.Minute},
+			path:           explainQuery,
+			expectedReject: "the query step 1m0s is below the minimum step 5m0s (-frontend.min-step), increase the step of the query",
+		},
+		"conflicting required matcher": {
+			cfg:            Config{SplitQueriesByInterval: time.Hour},
+			limits:         fakeLimits{requiredMatchers: []string{`__name__="bar"`}},
+			path:           explainQuery,
+			expectedReject: `the query selector foo conflicts with the matcher __name__="bar" required for the tenant`,
+		},
+		"blocked function": {
+			cfg:            Config{SplitQueriesByInterval: time.Hour},
+			limits:         fakeLimits{blockedFunctions: []string{"rate"}},
+			path:           explainQuery,
+			expectedReject: "the function rate is blocked for the tenant",
+		},
+		"blocked query": {
+			cfg:            Config{SplitQueriesByInterval: time.Hour},
+			limits:         fakeLimits{blockedQueries: []string{`rate\(foo`}},
+			path:           explainQuery,
+			expectedReject: `the query is blocked for the tenant, as it matches the blocked query pattern "rate\\(foo"`,
+		},
+		"instant queries are passed through": {
+			cfg:         Config{SplitQueriesByInterval: time.Hour},
+			path:        "/api/v1/query?query=up",
+			passthrough: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			orgID := tc.orgID
+			if orgID == "" {
+				orgID = "1"
+			}
+
+			e := explainer{cfg: tc.cfg, limits: tc.limits, codec: PrometheusCodec, cache: rc}
+			resp, err := e.RoundTrip(newExplainRequest(t, tc.path, orgID, "true"))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var exp explanation
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&exp))
+			assert.Equal(t, tc.expectedSplits, exp.Splits)
+			assert.Equal(t, tc.expectedReject, exp.Rejected)
+			assert.Equal(t, tc.passthrough, exp.Passthrough)
+		})
+	}
+}
+
+func newExplainRequest(t *testing.T, path, orgID, header string) *http.Request {
+	req, err := http.NewRequest("GET", path, http.NoBody)
+	require.NoError(t, err)
+	if header != "" {
+		req.Header.Set(ExplainHeader, header)
+	}
+
+	ctx := user.InjectOrgID(context.Background(), orgID)
+	require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+	return req.WithContext(ctx)
+}
@@ -0,0 +1,85 @@
+package queryrange
+
+import (
+	"context"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+type historicalContextKey int
+
+const historicalKey historicalContextKey = 0
+
+// IsHistoricalRequest returns whether the request with the context covers a time range older than
+// the historical query threshold, and whether the request has been routed by HistoricalSplitMiddleware.
+func IsHistoricalRequest(ctx context.Context) (historical bool, ok bool) {
+	historical, ok = ctx.Value(historicalKey).(bool)
+	return historical, ok
+}
+
+func withHistorical(ctx context.Context, historical bool) context.Context {
+	return context.WithValue(ctx, historicalKey, historical)
+}
+
+// HistoricalSplitMiddleware splits the queries at the boundary between the historical time range,
+// older than the threshold, and the recent one, so that each part can be routed downstream
+// according to IsHistoricalRequest. The boundary is aligned with the step of the queries.
+func HistoricalSplitMiddleware(threshold time.Duration, limits Limits, merger Merger) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return historicalSplit{
+			next:      next,
+			limits:    limits,
+			merger:    merger,
+			threshold: threshold,
+		}
+	})
+}
+
+type historicalSplit struct {
+	next      Handler
+	limits    Limits
+	merger    Merger
+	threshold time.Duration
+}
+
+func (h historicalSplit) Do(ctx context.Context, r Request) (Response, error) {
+	historical, recent := splitHistorical(r, util.TimeToMillis(time.Now().Add(-h.threshold)))
+	switch {
+	case recent == nil:
+		return h.next.Do(withHistorical(ctx, true), historical)
+	case historical == nil:
+		return h.next.Do(withHistorical(ctx, false), recent)
+	}
+
+	reqs := []Request{historical, recent}
+	reqResps, _, err := doRequests(ctx, HandlerFunc(func(ctx context.Context, req Request) (Response, error) {
+		return h.next.Do(withHistorical(ctx, req == historical), req)
+	}), reqs, h.limits, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := make([]Response, 0, len(reqResps))
+	for _, reqResp := range reqResps {
+		resps = append(resps, reqResp.Response)
+	}
+	return h.merger.MergeResponse(resps...)
+}
+
+// splitHistorical returns the parts of the request whose points are before and after the boundary,
+// nil if there are none. The recent part starts at the first point of the request after the boundary.
+func splitHistorical(r Request, boundary int64) (Request, Request) {
+	if r.GetStart() >= boundary {
+		return nil, r
+	}
+	if r.GetEnd() < boundary {
+		return r, nil
+	}
+
+	first := r.GetStart() + ceilDiv(boundary-r.GetStart(), r.GetStep())*r.GetStep()
+	if first > r.GetEnd() {
+		return r, nil
+	}
+	return r.WithStartEnd(r.GetStart(), first-r.GetStep()), r.WithStartEnd(first, r.GetEnd())
+}
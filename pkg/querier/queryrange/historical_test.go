@@ -0,0 +1,109 @@
+package queryrange
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+func TestSplitHistorical(t *testing.T) {
+	for name, tc := range map[string]struct {
+		start, end, step   int64
+		boundary           int64
+		expectedHistorical Request
+		expectedRecent     Request
+	}{
+		"recent": {
+			start: 100, end: 200, step: 10, boundary: 100,
+			expectedRecent: &PrometheusRequest{Start: 100, End: 200, Step: 10},
+		},
+		"historical": {
+			start: 100, end: 200, step: 10, boundary: 201,
+			expectedHistorical: &PrometheusRequest{Start: 100, End: 200, Step: 10},
+		},
+		"spanning the boundary": {
+			start: 100, end: 200, step: 10, boundary: 150,
+			expectedHistorical: &PrometheusRequest{Start: 100, End: 140, Step: 10},
+			expectedRecent:     &PrometheusRequest{Start: 150, End: 200, Step: 10},
+		},
+		"boundary between two points": {
+			start: 100, end: 200, step: 10, boundary: 145,
+			expectedHistorical: &PrometheusRequest{Start: 100, End: 140, Step: 10},
+			expectedRecent:     &PrometheusRequest{Start: 150, End: 200, Step: 10},
+		},
+		"boundary after the last point": {
+			start: 100, end: 205, step: 10, boundary: 202,
+			expectedHistorical: &PrometheusRequest{Start: 100, End: 205, Step: 10},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			historical, recent := splitHistorical(&PrometheusRequest{Start: tc.start, End: tc.end, Step: tc.step}, tc.boundary)
+			assert.Equal(t, tc.expectedHistorical, historical)
+			assert.Equal(t, tc.expectedRecent, recent)
+		})
+	}
+}
+
+func TestHistoricalSplitMiddleware(t *testing.T) {
+	step := time.Minute.Milliseconds()
+	now := util.TimeToMillis(time.Now()) / step * step
+
+	for name, tc := range map[string]struct {
+		start, end         int64
+		expectedHistorical bool
+		expectedRecent     bool
+	}{
+		"recent": {
+			start: now - 10*step, end: now,
+			expectedRecent: true,
+		},
+		"historical": {
+			start: now - 48*time.Hour.Milliseconds(), end: now - 30*time.Hour.Milliseconds(),
+			expectedHistorical: true,
+		},
+		"spanning the threshold": {
+			start: now - 48*time.Hour.Milliseconds(), end: now,
+			expectedHistorical: true,
+			expectedRecent:     true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mtx  sync.Mutex
+				reqs = map[bool]Request{}
+			)
+			next := HandlerFunc(func(ctx context.Context, r Request) (Response, error) {
+				historical, ok := IsHistoricalRequest(ctx)
+				require.True(t, ok)
+
+				mtx.Lock()
+				defer mtx.Unlock()
+				reqs[historical] = r
+				return &PrometheusResponse{Status: StatusSuccess, Data: PrometheusData{ResultType: "matrix"}}, nil
+			})
+
+			req := &PrometheusRequest{Start: tc.start, End: tc.end, Step: step, Query: "up"}
+			_, err := HistoricalSplitMiddleware(24*time.Hour, fakeLimits{}, PrometheusCodec).Wrap(next).Do(user.InjectOrgID(context.Background(), "1"), req)
+			require.NoError(t, err)
+
+			historical, hasHistorical := reqs[true]
+			recent, hasRecent := reqs[false]
+			require.Equal(t, tc.expectedHistorical, hasHistorical)
+			require.Equal(t, tc.expectedRecent, hasRecent)
+			if hasHistorical && hasRecent {
+				// The parts are contiguous, without overlap.
+				assert.Equal(t, req.GetStart(), historical.GetStart())
+				assert.Equal(t, historical.GetEnd()+step, recent.GetStart())
+				assert.Equal(t, req.GetEnd(), recent.GetEnd())
+				assert.Less(t, historical.GetEnd(), util.TimeToMillis(time.Now().Add(-24*time.Hour)))
+			}
+		})
+	}
+}
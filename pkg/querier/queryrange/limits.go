@@ -1,23 +1,38 @@
 package queryrange
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/weaveworks/common/httpgrpc"
-	"github.com/weaveworks/common/user"
 
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 // Limits allows us to specify per-tenant runtime limits on the behavior of
-// the query handling code.
+// the query handling code. The limits of a query spanning multiple tenants
+// are the strictest of the limits of its tenants.
 type Limits interface {
 	MaxQueryLength(string) time.Duration
 	MaxQueryParallelism(string) int
+	MaxQuerySplits(string) int
+	AllowPartialResults(string) bool
+	QueryDownsampling(string) bool
+	MaxSamplesPerQuery(string) int
+	MinStep(string) time.Duration
+	MinStepClamp(string) bool
+	RequiredMatchers(string) []string
+	BlockedQueryFunctions(string) []string
+	BlockedQueries(string) []string
 	MaxCacheFreshness(string) time.Duration
+	DownstreamURL(string) string
 }
 
 type limits struct {
@@ -36,12 +51,12 @@ func LimitsMiddleware(l Limits) Middleware {
 }
 
 func (l limits) Do(ctx context.Context, r Request) (Response, error) {
-	userid, err := user.ExtractOrgID(ctx)
+	tenantIDs, err := tenant.TenantIDs(ctx)
 	if err != nil {
 		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
 	}
 
-	maxQueryLen := l.MaxQueryLength(userid)
+	maxQueryLen := validation.SmallestPositiveDurationPerTenant(tenantIDs, l.MaxQueryLength)
 	queryLen := timestamp.Time(r.GetEnd()).Sub(timestamp.Time(r.GetStart()))
 	if maxQueryLen > 0 && queryLen > maxQueryLen {
 		return nil, httpgrpc.Errorf(http.StatusBadRequest, validation.ErrQueryTooLong, queryLen, maxQueryLen)
@@ -49,17 +64,89 @@ func (l limits) Do(ctx context.Context, r Request) (Response, error) {
 	return l.next.Do(ctx, r)
 }
 
+// validateSeriesQueryLength rejects series requests whose time range exceeds the
+// tenant's max query length. Requests without an explicit start and end are left
+// to the querier, which enforces the limit on the actual range.
+func validateSeriesQueryLength(r *http.Request, limits Limits) error {
+	tenantIDs, err := tenant.TenantIDs(r.Context())
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	maxQueryLen := validation.SmallestPositiveDurationPerTenant(tenantIDs, limits.MaxQueryLength)
+	if maxQueryLen <= 0 {
+		return nil
+	}
+
+	form, err := peekForm(r)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	if form.Get("start") == "" || form.Get("end") == "" {
+		return nil
+	}
+
+	start, err := util.ParseTime(form.Get("start"))
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	end, err := util.ParseTime(form.Get("end"))
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	queryLen := timestamp.Time(end).Sub(timestamp.Time(start))
+	if queryLen > maxQueryLen {
+		return httpgrpc.Errorf(http.StatusBadRequest, validation.ErrQueryTooLong, queryLen, maxQueryLen)
+	}
+	return nil
+}
+
+// peekForm parses the URL query and the form body of the request, leaving the
+// body readable so that the request can still be forwarded downstream.
+func peekForm(r *http.Request) (url.Values, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r.URL.Query(), nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	clone := r.Clone(r.Context())
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := clone.ParseForm(); err != nil {
+		return nil, err
+	}
+	return clone.Form, nil
+}
+
 // RequestResponse contains a request response and the respective request that was used.
 type RequestResponse struct {
 	Request  Request
 	Response Response
 }
 
+// RequestError contains the error of a request and the respective request that was used.
+type RequestError struct {
+	Request Request
+	Err     error
+}
+
 // DoRequests executes a list of requests in parallel. The limits parameters is used to limit parallelism per single request.
 func DoRequests(ctx context.Context, downstream Handler, reqs []Request, limits Limits) ([]RequestResponse, error) {
-	userid, err := user.ExtractOrgID(ctx)
+	resps, _, err := doRequests(ctx, downstream, reqs, limits, false)
+	return resps, err
+}
+
+// doRequests is like DoRequests, but if allowFailures is true the requests failing with a server
+// error don't cancel the other ones, and are returned along with their error instead.
+func doRequests(ctx context.Context, downstream Handler, reqs []Request, limits Limits, allowFailures bool) ([]RequestResponse, []RequestError, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
 	if err != nil {
-		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+		return nil, nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
 	}
 
 	// If one of the requests fail, we want to be able to cancel the rest of them.
@@ -75,8 +162,8 @@ func DoRequests(ctx context.Context, downstream Handler, reqs []Request, limits
 		close(intermediate)
 	}()
 
-	respChan, errChan := make(chan RequestResponse), make(chan error)
-	parallelism := limits.MaxQueryParallelism(userid)
+	respChan, errChan := make(chan RequestResponse), make(chan RequestError)
+	parallelism := validation.SmallestPositiveIntPerTenant(tenantIDs, limits.MaxQueryParallelism)
 	if parallelism > len(reqs) {
 		parallelism = len(reqs)
 	}
@@ -85,7 +172,7 @@ func DoRequests(ctx context.Context, downstream Handler, reqs []Request, limits
 			for req := range intermediate {
 				resp, err := downstream.Do(ctx, req)
 				if err != nil {
-					errChan <- err
+					errChan <- RequestError{req, err}
 				} else {
 					respChan <- RequestResponse{req, resp}
 				}
@@ -93,19 +180,33 @@ func DoRequests(ctx context.Context, downstream Handler, reqs []Request, limits
 		}()
 	}
 
-	resps := make([]RequestResponse, 0, len(reqs))
-	var firstErr error
+	var (
+		resps    = make([]RequestResponse, 0, len(reqs))
+		failures []RequestError
+		firstErr error
+	)
 	for range reqs {
 		select {
 		case resp := <-respChan:
 			resps = append(resps, resp)
-		case err := <-errChan:
+		case reqErr := <-errChan:
+			if allowFailures && firstErr == nil && ctx.Err() == nil && isServerError(reqErr.Err) {
+				failures = append(failures, reqErr)
+				continue
+			}
 			if firstErr == nil {
 				cancel()
-				firstErr = err
+				firstErr = reqErr.Err
 			}
 		}
 	}
 
-	return resps, firstErr
+	return resps, failures, firstErr
+}
+
+// isServerError returns whether the error is a HTTP 5xx or a non-HTTP error, which
+// could succeed if the request is executed again.
+func isServerError(err error) bool {
+	httpResp, ok := httpgrpc.HTTPResponseFromError(err)
+	return !ok || httpResp.Code/100 == 5
 }
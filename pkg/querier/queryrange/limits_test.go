@@ -0,0 +1,136 @@
+package queryrange
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+func TestLimitsMiddleware_MaxQueryLength(t *testing.T) {
+	for name, tc := range map[string]struct {
+		maxQueryLength time.Duration
+		queryLength    time.Duration
+		expectedErr    string
+	}{
+		"disabled": {
+			maxQueryLength: 0,
+			queryLength:    365 * 24 * time.Hour,
+		},
+		"within the limit": {
+			maxQueryLength: 24 * time.Hour,
+			queryLength:    24 * time.Hour,
+		},
+		"exceeding the limit": {
+			maxQueryLength: 24 * time.Hour,
+			queryLength:    48 * time.Hour,
+			expectedErr:    "the query time range exceeds the limit (query length: 48h0m0s, limit: 24h0m0s)",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := &PrometheusRequest{
+				Start: 0,
+				End:   tc.queryLength.Milliseconds(),
+				Step:  60 * seconds,
+			}
+
+			calls := 0
+			next := HandlerFunc(func(_ context.Context, _ Request) (Response, error) {
+				calls++
+				return &PrometheusResponse{}, nil
+			})
+
+			ctx := user.InjectOrgID(context.Background(), "1")
+			_, err := LimitsMiddleware(fakeLimits{maxQueryLength: tc.maxQueryLength}).Wrap(next).Do(ctx, req)
+
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				assert.Equal(t, 1, calls)
+				return
+			}
+
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+			assert.Equal(t, tc.expectedErr, string(resp.Body))
+			assert.Equal(t, 0, calls)
+		})
+	}
+}
+
+func TestValidateSeriesQueryLength(t *testing.T) {
+	for name, tc := range map[string]struct {
+		method         string
+		url            string
+		body           string
+		maxQueryLength time.Duration
+		expectedErr    string
+	}{
+		"disabled": {
+			method: "GET",
+			url:    "/api/v1/series?match[]=up&start=0&end=31536000",
+		},
+		"GET within the limit": {
+			method:         "GET",
+			url:            "/api/v1/series?match[]=up&start=0&end=86400",
+			maxQueryLength: 24 * time.Hour,
+		},
+		"GET exceeding the limit": {
+			method:         "GET",
+			url:            "/api/v1/series?match[]=up&start=0&end=172800",
+			maxQueryLength: 24 * time.Hour,
+			expectedErr:    "the query time range exceeds the limit (query length: 48h0m0s, limit: 24h0m0s)",
+		},
+		"POST exceeding the limit": {
+			method:         "POST",
+			url:            "/api/v1/series",
+			body:           "match[]=up&start=0&end=172800",
+			maxQueryLength: 24 * time.Hour,
+			expectedErr:    "the query time range exceeds the limit (query length: 48h0m0s, limit: 24h0m0s)",
+		},
+		"POST within the limit": {
+			method:         "POST",
+			url:            "/api/v1/series",
+			body:           "match[]=up&start=0&end=86400",
+			maxQueryLength: 24 * time.Hour,
+		},
+		"unbounded range": {
+			method:         "GET",
+			url:            "/api/v1/series?match[]=up",
+			maxQueryLength: 24 * time.Hour,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			req = req.WithContext(user.InjectOrgID(context.Background(), "1"))
+
+			err := validateSeriesQueryLength(req, fakeLimits{maxQueryLength: tc.maxQueryLength})
+
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+
+				// The body must still be readable to forward the request downstream.
+				body, err := ioutil.ReadAll(req.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tc.body, string(body))
+				return
+			}
+
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+			assert.Equal(t, tc.expectedErr, string(resp.Body))
+		})
+	}
+}
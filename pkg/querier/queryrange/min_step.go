@@ -0,0 +1,71 @@
+package queryrange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+const (
+	errStepBelowMin = "the query step %s is below the minimum step %s (-frontend.min-step), increase the step of the query"
+	warnStepClamped = "the query step was increased from %s to the minimum step %s"
+)
+
+// MinStepMiddleware rejects the queries with a step below the minimum step of the tenant, or executes
+// them with the minimum step if the tenant enabled clamping. It must run before the step alignment, so
+// that the queries with a clamped step are aligned with it.
+func MinStepMiddleware(limits Limits) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return minStep{
+			next:   next,
+			limits: limits,
+		}
+	})
+}
+
+type minStep struct {
+	next   Handler
+	limits Limits
+}
+
+func (m minStep) Do(ctx context.Context, r Request) (Response, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	step, err := minStepQuery(r, tenantIDs, m.limits)
+	if err != nil {
+		return nil, err
+	}
+	if step == r.GetStep() {
+		return m.next.Do(ctx, r)
+	}
+
+	resp, err := m.next.Do(ctx, r.WithStep(step))
+	if err != nil {
+		return nil, err
+	}
+	if promResponse, ok := resp.(*PrometheusResponse); ok {
+		promResponse.Warnings = append(promResponse.Warnings, fmt.Sprintf(warnStepClamped, stepDuration(r.GetStep()), stepDuration(step)))
+	}
+	return resp, nil
+}
+
+// minStepQuery returns the step the query is executed with, or an error if the step is below the
+// minimum and it isn't clamped for all the tenants.
+func minStepQuery(r Request, tenantIDs []string, limits Limits) (int64, error) {
+	min := validation.LargestDurationPerTenant(tenantIDs, limits.MinStep).Milliseconds()
+	if min <= 0 || r.GetStep() >= min {
+		return r.GetStep(), nil
+	}
+	if !validation.AllTrueBooleansPerTenant(tenantIDs, limits.MinStepClamp) {
+		return 0, httpgrpc.Errorf(http.StatusBadRequest, errStepBelowMin, stepDuration(r.GetStep()), stepDuration(min))
+	}
+	return min, nil
+}
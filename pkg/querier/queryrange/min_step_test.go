@@ -0,0 +1,76 @@
+package queryrange
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+func TestMinStepMiddleware(t *testing.T) {
+	req := &PrometheusRequest{Start: 0, End: 3600 * 1e3, Step: 1e3, Query: "up"}
+
+	for name, tc := range map[string]struct {
+		limits           fakeLimits
+		expectedStep     int64
+		expectedErr      error
+		expectedWarnings []string
+	}{
+		"disabled": {
+			expectedStep: 1e3,
+		},
+		"step above the minimum": {
+			limits:       fakeLimits{minStep: time.Second},
+			expectedStep: 1e3,
+		},
+		"step below the minimum": {
+			limits:      fakeLimits{minStep: 15 * time.Second},
+			expectedErr: httpgrpc.Errorf(http.StatusBadRequest, "the query step 1s is below the minimum step 15s (-frontend.min-step), increase the step of the query"),
+		},
+		"step below the minimum clamped": {
+			limits:           fakeLimits{minStep: 15 * time.Second, minStepClamp: true},
+			expectedStep:     15 * 1e3,
+			expectedWarnings: []string{"the query step was increased from 1s to the minimum step 15s"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var step int64
+			next := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				step = r.GetStep()
+				return &PrometheusResponse{Status: StatusSuccess}, nil
+			})
+
+			resp, err := MinStepMiddleware(tc.limits).Wrap(next).Do(user.InjectOrgID(context.Background(), "1"), req)
+			if tc.expectedErr != nil {
+				require.Equal(t, tc.expectedErr, err)
+				assert.Zero(t, step)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStep, step)
+			assert.Equal(t, tc.expectedWarnings, resp.(*PrometheusResponse).Warnings)
+		})
+	}
+}
+
+func TestMinStepMiddleware_ClampedQueriesAreAligned(t *testing.T) {
+	req := &PrometheusRequest{Start: 7 * 1e3, End: 3607 * 1e3, Step: 1e3, Query: "up"}
+
+	var aligned Request
+	next := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+		aligned = r
+		return &PrometheusResponse{Status: StatusSuccess}, nil
+	})
+
+	h := MergeMiddlewares(MinStepMiddleware(fakeLimits{minStep: 15 * time.Second, minStepClamp: true}), StepAlignMiddleware).Wrap(next)
+	_, err := h.Do(user.InjectOrgID(context.Background(), "1"), req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(15*1e3), aligned.GetStep())
+	assert.Equal(t, int64(0), aligned.GetStart())
+	assert.Equal(t, int64(3600*1e3), aligned.GetEnd())
+}
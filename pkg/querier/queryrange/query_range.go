@@ -17,7 +17,9 @@ import (
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
 	"github.com/weaveworks/common/httpgrpc"
 
 	"github.com/cortexproject/cortex/pkg/ingester/client"
@@ -40,6 +42,9 @@ var (
 
 	// Name of the cache control header.
 	cacheControlHeader = "Cache-Control"
+
+	// Parameters decoded in the fields of the requests.
+	decodedParams = map[string]struct{}{"start": {}, "end": {}, "step": {}, "query": {}, "stats": {}}
 )
 
 // Codec is used to encode/decode query range requests and responses so they can be passed down to middlewares.
@@ -75,10 +80,16 @@ type Request interface {
 	GetQuery() string
 	// GetCachingOptions returns the caching options.
 	GetCachingOptions() CachingOptions
+	// GetStats returns the stats parameter of the request, asking for the query stats if not empty.
+	GetStats() string
+	// GetParams returns the other parameters of the request, forwarded as is.
+	GetParams() []PrometheusRequestParam
 	// WithStartEnd clone the current request with different start and end timestamp.
 	WithStartEnd(int64, int64) Request
 	// WithQuery clone the current request with a different query.
 	WithQuery(string) Request
+	// WithStep clone the current request with a different step.
+	WithStep(int64) Request
 	proto.Message
 	// LogToSpan writes information about this request to an OpenTracing span
 	LogToSpan(opentracing.Span)
@@ -108,6 +119,13 @@ func (q *PrometheusRequest) WithQuery(query string) Request {
 	return &new
 }
 
+// WithStep clones the current `PrometheusRequest` with a new step.
+func (q *PrometheusRequest) WithStep(step int64) Request {
+	new := *q
+	new.Step = step
+	return &new
+}
+
 // LogToSpan logs the current `PrometheusRequest` parameters to the specified span.
 func (q *PrometheusRequest) LogToSpan(sp opentracing.Span) {
 	sp.LogFields(
@@ -163,7 +181,9 @@ func (prometheusCodec) MergeResponse(responses ...Response) (Response, error) {
 		Data: PrometheusData{
 			ResultType: model.ValMatrix.String(),
 			Result:     matrixMerge(promResponses),
+			Stats:      statsMerge(promResponses),
 		},
+		Warnings: warningsMerge(promResponses),
 	}
 
 	if len(resultsCacheGenNumberHeaderValues) != 0 {
@@ -179,6 +199,13 @@ func (prometheusCodec) MergeResponse(responses ...Response) (Response, error) {
 func (prometheusCodec) DecodeRequest(_ context.Context, r *http.Request) (Request, error) {
 	var result PrometheusRequest
 	var err error
+
+	// Parameters can be sent in the URL or in the body. Parse them explicitly, because
+	// FormValue() ignores errors, like a body exceeding the max size.
+	if err := r.ParseForm(); err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
 	result.Start, err = util.ParseTime(r.FormValue("start"))
 	if err != nil {
 		return nil, err
@@ -209,7 +236,9 @@ func (prometheusCodec) DecodeRequest(_ context.Context, r *http.Request) (Reques
 	}
 
 	result.Query = r.FormValue("query")
+	result.Stats = r.FormValue("stats")
 	result.Path = r.URL.Path
+	result.Params = extraParams(r.Form)
 
 	for _, value := range r.Header.Values(cacheControlHeader) {
 		if strings.Contains(value, noStoreValue) {
@@ -221,6 +250,22 @@ func (prometheusCodec) DecodeRequest(_ context.Context, r *http.Request) (Reques
 	return &result, nil
 }
 
+// extraParams returns the parameters other than the ones decoded in the fields of the request,
+// sorted by name.
+func extraParams(form url.Values) []PrometheusRequestParam {
+	var params []PrometheusRequestParam
+	for name, values := range form {
+		if _, ok := decodedParams[name]; ok {
+			continue
+		}
+		params = append(params, PrometheusRequestParam{Name: name, Values: values})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].Name < params[j].Name
+	})
+	return params
+}
+
 func (prometheusCodec) EncodeRequest(ctx context.Context, r Request) (*http.Request, error) {
 	promReq, ok := r.(*PrometheusRequest)
 	if !ok {
@@ -232,6 +277,12 @@ func (prometheusCodec) EncodeRequest(ctx context.Context, r Request) (*http.Requ
 		"step":  []string{encodeDurationMs(promReq.Step)},
 		"query": []string{promReq.Query},
 	}
+	if promReq.Stats != "" {
+		params["stats"] = []string{promReq.Stats}
+	}
+	for _, param := range promReq.Params {
+		params[param.Name] = param.Values
+	}
 	u := &url.URL{
 		Path:     promReq.Path,
 		RawQuery: params.Encode(),
@@ -328,43 +379,111 @@ func (s *SampleStream) MarshalJSON() ([]byte, error) {
 	return json.Marshal(stream)
 }
 
+// matrixMerge merges the series of the responses, sorted by their labels as Prometheus sorts the series of
+// the range queries, so that the split queries return the same response as the queries executed at once.
 func matrixMerge(resps []*PrometheusResponse) []SampleStream {
 	output := map[string]*SampleStream{}
 	for _, resp := range resps {
 		for _, stream := range resp.Data.Result {
-			metric := client.FromLabelAdaptersToLabels(stream.Labels).String()
+			lbls := client.FromLabelAdaptersToLabels(stream.Labels)
+			if !sort.IsSorted(lbls) {
+				lbls = client.CopyLabels(lbls)
+				sort.Sort(lbls)
+			}
+			metric := lbls.String()
 			existing, ok := output[metric]
 			if !ok {
 				existing = &SampleStream{
-					Labels: stream.Labels,
-				}
-			}
-			// We need to make sure we don't repeat samples. This causes some visualisations to be broken in Grafana.
-			// The prometheus API is inclusive of start and end timestamps.
-			if len(existing.Samples) > 0 && len(stream.Samples) > 0 {
-				if existing.Samples[len(existing.Samples)-1].TimestampMs == stream.Samples[0].TimestampMs {
-					stream.Samples = stream.Samples[1:]
+					Labels: client.FromLabelsToLabelAdapters(lbls),
 				}
 			}
-			existing.Samples = append(existing.Samples, stream.Samples...)
+			existing.Samples = appendSamples(existing.Samples, stream.Samples)
 			output[metric] = existing
 		}
 	}
 
-	keys := make([]string, 0, len(output))
-	for key := range output {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
-
 	result := make([]SampleStream, 0, len(output))
-	for _, key := range keys {
-		result = append(result, *output[key])
+	for _, stream := range output {
+		result = append(result, *stream)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return labels.Compare(client.FromLabelAdaptersToLabels(result[i].Labels), client.FromLabelAdaptersToLabels(result[j].Labels)) < 0
+	})
 
 	return result
 }
 
+// appendSamples appends the samples after the existing ones, without repeating samples: the Prometheus API is
+// inclusive of start and end timestamps, so the responses of adjacent splits overlap at their boundary. Repeated
+// samples cause some visualisations to be broken in Grafana. At the same timestamp, a staleness marker isn't
+// overwritten by a real value of the adjacent split, so that the series doesn't reappear at the boundary.
+func appendSamples(existing, samples []client.Sample) []client.Sample {
+	if len(existing) == 0 {
+		return append(existing, samples...)
+	}
+
+	last := &existing[len(existing)-1]
+	i := 0
+	for ; i < len(samples) && samples[i].TimestampMs <= last.TimestampMs; i++ {
+		if samples[i].TimestampMs == last.TimestampMs && value.IsStaleNaN(samples[i].Value) {
+			last.Value = samples[i].Value
+		}
+	}
+	return append(existing, samples[i:]...)
+}
+
+// warningsMerge returns the distinct warnings of the responses, in order of appearance.
+func warningsMerge(resps []*PrometheusResponse) []string {
+	var (
+		warnings []string
+		seen     = map[string]struct{}{}
+	)
+	for _, resp := range resps {
+		for _, w := range resp.Warnings {
+			if _, ok := seen[w]; ok {
+				continue
+			}
+			seen[w] = struct{}{}
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+// statsMerge sums the stats of the responses, so that the merged response reports the stats of all
+// the sub-queries. The peak samples is the highest of the sub-queries, as they're executed separately.
+// Returns nil if none of the responses has stats.
+func statsMerge(resps []*PrometheusResponse) *PrometheusResponseStats {
+	var output *PrometheusResponseStats
+	for _, resp := range resps {
+		stats := resp.Data.Stats
+		if stats == nil {
+			continue
+		}
+		if output == nil {
+			output = &PrometheusResponseStats{}
+		}
+
+		output.Timings.EvalTotalTime += stats.Timings.EvalTotalTime
+		output.Timings.ResultSortTime += stats.Timings.ResultSortTime
+		output.Timings.QueryPreparationTime += stats.Timings.QueryPreparationTime
+		output.Timings.InnerEvalTime += stats.Timings.InnerEvalTime
+		output.Timings.ExecQueueTime += stats.Timings.ExecQueueTime
+		output.Timings.ExecTotalTime += stats.Timings.ExecTotalTime
+
+		if stats.Samples != nil {
+			if output.Samples == nil {
+				output.Samples = &PrometheusResponseSamples{}
+			}
+			output.Samples.TotalQueryableSamples += stats.Samples.TotalQueryableSamples
+			if stats.Samples.PeakSamples > output.Samples.PeakSamples {
+				output.Samples.PeakSamples = stats.Samples.PeakSamples
+			}
+		}
+	}
+	return output
+}
+
 func parseDurationMs(s string) (int64, error) {
 	if d, err := strconv.ParseFloat(s, 64); err == nil {
 		ts := d * float64(time.Second/time.Millisecond)
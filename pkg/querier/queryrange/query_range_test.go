@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
 	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/prometheus/pkg/value"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/httpgrpc"
@@ -27,6 +31,31 @@ func TestRequest(t *testing.T) {
 			url:      query,
 			expected: parsedRequest,
 		},
+		{
+			url: "/api/v1/query_range?end=1536716898&query=sum%28container_memory_rss%29+by+%28namespace%29&start=1536673680&stats=all&step=120",
+			expected: &PrometheusRequest{
+				Path:  "/api/v1/query_range",
+				Start: 1536673680 * 1e3,
+				End:   1536716898 * 1e3,
+				Step:  120 * 1e3,
+				Query: "sum(container_memory_rss) by (namespace)",
+				Stats: "all",
+			},
+		},
+		{
+			url: "/api/v1/query_range?dedup=false&end=1536716898&lookback_delta=1m&query=sum%28container_memory_rss%29+by+%28namespace%29&start=1536673680&step=120",
+			expected: &PrometheusRequest{
+				Path:  "/api/v1/query_range",
+				Start: 1536673680 * 1e3,
+				End:   1536716898 * 1e3,
+				Step:  120 * 1e3,
+				Query: "sum(container_memory_rss) by (namespace)",
+				Params: []PrometheusRequestParam{
+					{Name: "dedup", Values: []string{"false"}},
+					{Name: "lookback_delta", Values: []string{"1m"}},
+				},
+			},
+		},
 		{
 			url:         "api/v1/query_range?start=foo",
 			expectedErr: httpgrpc.Errorf(http.StatusBadRequest, "cannot parse \"foo\" to a valid timestamp"),
@@ -73,6 +102,38 @@ func TestRequest(t *testing.T) {
 	}
 }
 
+func TestRequest_FormBody(t *testing.T) {
+	body := "end=1536716898&query=sum%28container_memory_rss%29+by+%28namespace%29&start=1536673680&step=120"
+
+	r, err := http.NewRequest("POST", "/api/v1/query_range", strings.NewReader(body))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	req, err := PrometheusCodec.DecodeRequest(ctx, r.WithContext(ctx))
+	require.NoError(t, err)
+	require.EqualValues(t, parsedRequest, req)
+
+	// The request is forwarded downstream with all its parameters.
+	rdash, err := PrometheusCodec.EncodeRequest(context.Background(), req)
+	require.NoError(t, err)
+	require.EqualValues(t, query, rdash.RequestURI)
+}
+
+func TestRequest_FormBodyTooLarge(t *testing.T) {
+	body := "end=1536716898&query=sum%28container_memory_rss%29+by+%28namespace%29&start=1536673680&step=120"
+
+	r, err := http.NewRequest("POST", "/api/v1/query_range", strings.NewReader(body))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 10)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	_, err = PrometheusCodec.DecodeRequest(ctx, r.WithContext(ctx))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "http: request body too large")
+}
+
 func TestResponse(t *testing.T) {
 	r := *parsedResponse
 	r.Headers = respHeaders
@@ -266,6 +327,42 @@ func TestMergeAPIResponses(t *testing.T) {
 					},
 				},
 			},
+		},
+		// Merging of the stats, ignoring the responses without stats.
+		{
+			input: []Response{
+				mustParse(t, `{"status":"success","data":{"resultType":"matrix","result":[],"stats":{"timings":{"evalTotalTime":1,"execTotalTime":2}}}}`),
+				mustParse(t, `{"status":"success","data":{"resultType":"matrix","result":[],"stats":{"timings":{"evalTotalTime":0.5,"execTotalTime":1},"samples":{"totalQueryableSamples":10,"peakSamples":4}}}}`),
+				mustParse(t, `{"status":"success","data":{"resultType":"matrix","result":[],"stats":{"timings":{"evalTotalTime":0.25,"execTotalTime":0.5},"samples":{"totalQueryableSamples":5,"peakSamples":6}}}}`),
+				mustParse(t, `{"status":"success","data":{"resultType":"matrix","result":[]}}`),
+			},
+			expected: &PrometheusResponse{
+				Status: StatusSuccess,
+				Data: PrometheusData{
+					ResultType: matrix,
+					Result:     []SampleStream{},
+					Stats: &PrometheusResponseStats{
+						Timings: PrometheusResponseTimings{EvalTotalTime: 1.75, ExecTotalTime: 3.5},
+						Samples: &PrometheusResponseSamples{TotalQueryableSamples: 15, PeakSamples: 6},
+					},
+				},
+			},
+		},
+		// Merging of the warnings, without duplicates.
+		{
+			input: []Response{
+				mustParse(t, `{"status":"success","data":{"resultType":"matrix","result":[]},"warnings":["a","b"]}`),
+				mustParse(t, `{"status":"success","data":{"resultType":"matrix","result":[]}}`),
+				mustParse(t, `{"status":"success","data":{"resultType":"matrix","result":[]},"warnings":["b","c"]}`),
+			},
+			expected: &PrometheusResponse{
+				Status: StatusSuccess,
+				Data: PrometheusData{
+					ResultType: matrix,
+					Result:     []SampleStream{},
+				},
+				Warnings: []string{"a", "b", "c"},
+			},
 		}} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			output, err := PrometheusCodec.MergeResponse(tc.input...)
@@ -275,6 +372,116 @@ func TestMergeAPIResponses(t *testing.T) {
 	}
 }
 
+func TestMergeAPIResponses_SplitBoundary(t *testing.T) {
+	labels := []client.LabelAdapter{{Name: "a", Value: "b"}}
+	stale := math.Float64frombits(value.StaleNaN)
+	split := func(samples ...client.Sample) Response {
+		return &PrometheusResponse{
+			Status: StatusSuccess,
+			Data: PrometheusData{
+				ResultType: matrix,
+				Result:     []SampleStream{{Labels: labels, Samples: samples}},
+			},
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		input    []Response
+		expected []client.Sample
+	}{
+		"boundary sample in both splits": {
+			input: []Response{
+				split(client.Sample{Value: 1, TimestampMs: 0}, client.Sample{Value: 2, TimestampMs: 3600 * 1e3}),
+				split(client.Sample{Value: 2, TimestampMs: 3600 * 1e3}, client.Sample{Value: 3, TimestampMs: 7200 * 1e3}),
+			},
+			expected: []client.Sample{{Value: 1, TimestampMs: 0}, {Value: 2, TimestampMs: 3600 * 1e3}, {Value: 3, TimestampMs: 7200 * 1e3}},
+		},
+		"splits overlapping by several samples": {
+			input: []Response{
+				split(client.Sample{Value: 1, TimestampMs: 0}, client.Sample{Value: 2, TimestampMs: 1000}, client.Sample{Value: 3, TimestampMs: 2000}),
+				split(client.Sample{Value: 2, TimestampMs: 1000}, client.Sample{Value: 3, TimestampMs: 2000}, client.Sample{Value: 4, TimestampMs: 3000}),
+			},
+			expected: []client.Sample{{Value: 1, TimestampMs: 0}, {Value: 2, TimestampMs: 1000}, {Value: 3, TimestampMs: 2000}, {Value: 4, TimestampMs: 3000}},
+		},
+		"staleness marker at the end of the first split": {
+			input: []Response{
+				split(client.Sample{Value: 1, TimestampMs: 0}, client.Sample{Value: stale, TimestampMs: 1000}),
+				split(client.Sample{Value: 2, TimestampMs: 1000}, client.Sample{Value: 3, TimestampMs: 2000}),
+			},
+			expected: []client.Sample{{Value: 1, TimestampMs: 0}, {Value: stale, TimestampMs: 1000}, {Value: 3, TimestampMs: 2000}},
+		},
+		"staleness marker at the start of the second split": {
+			input: []Response{
+				split(client.Sample{Value: 1, TimestampMs: 0}, client.Sample{Value: 2, TimestampMs: 1000}),
+				split(client.Sample{Value: stale, TimestampMs: 1000}, client.Sample{Value: 3, TimestampMs: 2000}),
+			},
+			expected: []client.Sample{{Value: 1, TimestampMs: 0}, {Value: stale, TimestampMs: 1000}, {Value: 3, TimestampMs: 2000}},
+		},
+		"NaN value kept": {
+			input: []Response{
+				split(client.Sample{Value: math.NaN(), TimestampMs: 0}, client.Sample{Value: math.NaN(), TimestampMs: 1000}),
+				split(client.Sample{Value: 2, TimestampMs: 1000}, client.Sample{Value: 3, TimestampMs: 2000}),
+			},
+			expected: []client.Sample{{Value: math.NaN(), TimestampMs: 0}, {Value: math.NaN(), TimestampMs: 1000}, {Value: 3, TimestampMs: 2000}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			output, err := PrometheusCodec.MergeResponse(tc.input...)
+			require.NoError(t, err)
+
+			result := output.(*PrometheusResponse).Data.Result
+			require.Len(t, result, 1)
+			// The NaN values aren't equal to each other, so their bits are compared.
+			require.Len(t, result[0].Samples, len(tc.expected))
+			for i, sample := range result[0].Samples {
+				assert.Equal(t, tc.expected[i].TimestampMs, sample.TimestampMs)
+				assert.Equal(t, math.Float64bits(tc.expected[i].Value), math.Float64bits(sample.Value))
+			}
+		})
+	}
+}
+
+func TestMergeAPIResponses_SeriesOrder(t *testing.T) {
+	response := func(streams ...SampleStream) *PrometheusResponse {
+		return &PrometheusResponse{Status: StatusSuccess, Data: PrometheusData{ResultType: matrix, Result: streams}}
+	}
+	series := func(ts int64, lbls ...client.LabelAdapter) SampleStream {
+		return SampleStream{Labels: lbls, Samples: []client.Sample{{Value: 1, TimestampMs: ts}}}
+	}
+
+	// The series are sorted by their labels, as by Prometheus, rather than by their string form. The labels of
+	// the series are sorted too.
+	merged, err := PrometheusCodec.MergeResponse(
+		response(
+			series(0, client.LabelAdapter{Name: "c", Value: "d"}, client.LabelAdapter{Name: "a", Value: "b"}),
+			series(0, client.LabelAdapter{Name: "a", Value: "b"}),
+		),
+		response(
+			series(1000, client.LabelAdapter{Name: "a", Value: "b"}),
+			series(1000, client.LabelAdapter{Name: "a", Value: "b"}, client.LabelAdapter{Name: "c", Value: "d"}),
+		),
+	)
+	require.NoError(t, err)
+
+	expected := &PrometheusResponse{
+		Status: StatusSuccess,
+		Data: PrometheusData{
+			ResultType: matrix,
+			Result: []SampleStream{
+				{
+					Labels:  []client.LabelAdapter{{Name: "a", Value: "b"}},
+					Samples: []client.Sample{{Value: 1, TimestampMs: 0}, {Value: 1, TimestampMs: 1000}},
+				},
+				{
+					Labels:  []client.LabelAdapter{{Name: "a", Value: "b"}, {Name: "c", Value: "d"}},
+					Samples: []client.Sample{{Value: 1, TimestampMs: 0}, {Value: 1, TimestampMs: 1000}},
+				},
+			},
+		},
+	}
+	require.Equal(t, expected, merged)
+}
+
 func mustParse(t *testing.T, response string) Response {
 	var resp PrometheusResponse
 	// Needed as goimports automatically add a json import otherwise.
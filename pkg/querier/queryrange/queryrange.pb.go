@@ -4,6 +4,7 @@
 package queryrange
 
 import (
+	encoding_binary "encoding/binary"
 	fmt "fmt"
 	client "github.com/cortexproject/cortex/pkg/ingester/client"
 	github_com_cortexproject_cortex_pkg_ingester_client "github.com/cortexproject/cortex/pkg/ingester/client"
@@ -40,6 +41,9 @@ type PrometheusRequest struct {
 	Timeout        time.Duration  `protobuf:"bytes,5,opt,name=timeout,proto3,stdduration" json:"timeout"`
 	Query          string         `protobuf:"bytes,6,opt,name=query,proto3" json:"query,omitempty"`
 	CachingOptions CachingOptions `protobuf:"bytes,7,opt,name=cachingOptions,proto3" json:"cachingOptions"`
+	Stats          string         `protobuf:"bytes,8,opt,name=stats,proto3" json:"stats,omitempty"`
+	// Parameters of the request other than the ones above, eg. lookback_delta, forwarded as is.
+	Params []PrometheusRequestParam `protobuf:"bytes,9,rep,name=params,proto3" json:"params"`
 }
 
 func (m *PrometheusRequest) Reset()      { *m = PrometheusRequest{} }
@@ -123,6 +127,20 @@ func (m *PrometheusRequest) GetCachingOptions() CachingOptions {
 	return CachingOptions{}
 }
 
+func (m *PrometheusRequest) GetStats() string {
+	if m != nil {
+		return m.Stats
+	}
+	return ""
+}
+
+func (m *PrometheusRequest) GetParams() []PrometheusRequestParam {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
 type PrometheusResponseHeader struct {
 	Name   string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"-"`
 	Values []string `protobuf:"bytes,2,rep,name=Values,proto3" json:"-"`
@@ -180,6 +198,7 @@ type PrometheusResponse struct {
 	ErrorType string                      `protobuf:"bytes,3,opt,name=ErrorType,proto3" json:"errorType,omitempty"`
 	Error     string                      `protobuf:"bytes,4,opt,name=Error,proto3" json:"error,omitempty"`
 	Headers   []*PrometheusResponseHeader `protobuf:"bytes,5,rep,name=Headers,proto3" json:"-"`
+	Warnings  []string                    `protobuf:"bytes,6,rep,name=Warnings,proto3" json:"warnings,omitempty"`
 }
 
 func (m *PrometheusResponse) Reset()      { *m = PrometheusResponse{} }
@@ -249,9 +268,17 @@ func (m *PrometheusResponse) GetHeaders() []*PrometheusResponseHeader {
 	return nil
 }
 
+func (m *PrometheusResponse) GetWarnings() []string {
+	if m != nil {
+		return m.Warnings
+	}
+	return nil
+}
+
 type PrometheusData struct {
-	ResultType string         `protobuf:"bytes,1,opt,name=ResultType,proto3" json:"resultType"`
-	Result     []SampleStream `protobuf:"bytes,2,rep,name=Result,proto3" json:"result"`
+	ResultType string                   `protobuf:"bytes,1,opt,name=ResultType,proto3" json:"resultType"`
+	Result     []SampleStream           `protobuf:"bytes,2,rep,name=Result,proto3" json:"result"`
+	Stats      *PrometheusResponseStats `protobuf:"bytes,3,opt,name=Stats,proto3" json:"stats,omitempty"`
 }
 
 func (m *PrometheusData) Reset()      { *m = PrometheusData{} }
@@ -300,6 +327,198 @@ func (m *PrometheusData) GetResult() []SampleStream {
 	return nil
 }
 
+func (m *PrometheusData) GetStats() *PrometheusResponseStats {
+	if m != nil {
+		return m.Stats
+	}
+	return nil
+}
+
+type PrometheusResponseStats struct {
+	Timings PrometheusResponseTimings  `protobuf:"bytes,1,opt,name=Timings,proto3" json:"timings"`
+	Samples *PrometheusResponseSamples `protobuf:"bytes,2,opt,name=Samples,proto3" json:"samples,omitempty"`
+}
+
+func (m *PrometheusResponseStats) Reset()      { *m = PrometheusResponseStats{} }
+func (*PrometheusResponseStats) ProtoMessage() {}
+func (*PrometheusResponseStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_79b02382e213d0b2, []int{4}
+}
+func (m *PrometheusResponseStats) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *PrometheusResponseStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_PrometheusResponseStats.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *PrometheusResponseStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PrometheusResponseStats.Merge(m, src)
+}
+func (m *PrometheusResponseStats) XXX_Size() int {
+	return m.Size()
+}
+func (m *PrometheusResponseStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_PrometheusResponseStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PrometheusResponseStats proto.InternalMessageInfo
+
+func (m *PrometheusResponseStats) GetTimings() PrometheusResponseTimings {
+	if m != nil {
+		return m.Timings
+	}
+	return PrometheusResponseTimings{}
+}
+
+func (m *PrometheusResponseStats) GetSamples() *PrometheusResponseSamples {
+	if m != nil {
+		return m.Samples
+	}
+	return nil
+}
+
+type PrometheusResponseTimings struct {
+	EvalTotalTime        float64 `protobuf:"fixed64,1,opt,name=EvalTotalTime,proto3" json:"evalTotalTime"`
+	ResultSortTime       float64 `protobuf:"fixed64,2,opt,name=ResultSortTime,proto3" json:"resultSortTime"`
+	QueryPreparationTime float64 `protobuf:"fixed64,3,opt,name=QueryPreparationTime,proto3" json:"queryPreparationTime"`
+	InnerEvalTime        float64 `protobuf:"fixed64,4,opt,name=InnerEvalTime,proto3" json:"innerEvalTime"`
+	ExecQueueTime        float64 `protobuf:"fixed64,5,opt,name=ExecQueueTime,proto3" json:"execQueueTime"`
+	ExecTotalTime        float64 `protobuf:"fixed64,6,opt,name=ExecTotalTime,proto3" json:"execTotalTime"`
+}
+
+func (m *PrometheusResponseTimings) Reset()      { *m = PrometheusResponseTimings{} }
+func (*PrometheusResponseTimings) ProtoMessage() {}
+func (*PrometheusResponseTimings) Descriptor() ([]byte, []int) {
+	return fileDescriptor_79b02382e213d0b2, []int{5}
+}
+func (m *PrometheusResponseTimings) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *PrometheusResponseTimings) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_PrometheusResponseTimings.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *PrometheusResponseTimings) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PrometheusResponseTimings.Merge(m, src)
+}
+func (m *PrometheusResponseTimings) XXX_Size() int {
+	return m.Size()
+}
+func (m *PrometheusResponseTimings) XXX_DiscardUnknown() {
+	xxx_messageInfo_PrometheusResponseTimings.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PrometheusResponseTimings proto.InternalMessageInfo
+
+func (m *PrometheusResponseTimings) GetEvalTotalTime() float64 {
+	if m != nil {
+		return m.EvalTotalTime
+	}
+	return 0
+}
+
+func (m *PrometheusResponseTimings) GetResultSortTime() float64 {
+	if m != nil {
+		return m.ResultSortTime
+	}
+	return 0
+}
+
+func (m *PrometheusResponseTimings) GetQueryPreparationTime() float64 {
+	if m != nil {
+		return m.QueryPreparationTime
+	}
+	return 0
+}
+
+func (m *PrometheusResponseTimings) GetInnerEvalTime() float64 {
+	if m != nil {
+		return m.InnerEvalTime
+	}
+	return 0
+}
+
+func (m *PrometheusResponseTimings) GetExecQueueTime() float64 {
+	if m != nil {
+		return m.ExecQueueTime
+	}
+	return 0
+}
+
+func (m *PrometheusResponseTimings) GetExecTotalTime() float64 {
+	if m != nil {
+		return m.ExecTotalTime
+	}
+	return 0
+}
+
+type PrometheusResponseSamples struct {
+	TotalQueryableSamples int64 `protobuf:"varint,1,opt,name=TotalQueryableSamples,proto3" json:"totalQueryableSamples"`
+	PeakSamples           int64 `protobuf:"varint,2,opt,name=PeakSamples,proto3" json:"peakSamples"`
+}
+
+func (m *PrometheusResponseSamples) Reset()      { *m = PrometheusResponseSamples{} }
+func (*PrometheusResponseSamples) ProtoMessage() {}
+func (*PrometheusResponseSamples) Descriptor() ([]byte, []int) {
+	return fileDescriptor_79b02382e213d0b2, []int{6}
+}
+func (m *PrometheusResponseSamples) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *PrometheusResponseSamples) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_PrometheusResponseSamples.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *PrometheusResponseSamples) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PrometheusResponseSamples.Merge(m, src)
+}
+func (m *PrometheusResponseSamples) XXX_Size() int {
+	return m.Size()
+}
+func (m *PrometheusResponseSamples) XXX_DiscardUnknown() {
+	xxx_messageInfo_PrometheusResponseSamples.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PrometheusResponseSamples proto.InternalMessageInfo
+
+func (m *PrometheusResponseSamples) GetTotalQueryableSamples() int64 {
+	if m != nil {
+		return m.TotalQueryableSamples
+	}
+	return 0
+}
+
+func (m *PrometheusResponseSamples) GetPeakSamples() int64 {
+	if m != nil {
+		return m.PeakSamples
+	}
+	return 0
+}
+
 type SampleStream struct {
 	Labels  []github_com_cortexproject_cortex_pkg_ingester_client.LabelAdapter `protobuf:"bytes,1,rep,name=labels,proto3,customtype=github.com/cortexproject/cortex/pkg/ingester/client.LabelAdapter" json:"metric"`
 	Samples []client.Sample                                                    `protobuf:"bytes,2,rep,name=samples,proto3" json:"values"`
@@ -308,7 +527,7 @@ type SampleStream struct {
 func (m *SampleStream) Reset()      { *m = SampleStream{} }
 func (*SampleStream) ProtoMessage() {}
 func (*SampleStream) Descriptor() ([]byte, []int) {
-	return fileDescriptor_79b02382e213d0b2, []int{4}
+	return fileDescriptor_79b02382e213d0b2, []int{7}
 }
 func (m *SampleStream) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -345,15 +564,15 @@ func (m *SampleStream) GetSamples() []client.Sample {
 }
 
 type CachedResponse struct {
-	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key"`
-	// List of cached responses; non-overlapping and in order.
-	Extents []Extent `protobuf:"bytes,2,rep,name=extents,proto3" json:"extents"`
+	Key       string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key"`
+	Extents   []Extent `protobuf:"bytes,2,rep,name=extents,proto3" json:"extents"`
+	ExpiresAt int64    `protobuf:"varint,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at"`
 }
 
 func (m *CachedResponse) Reset()      { *m = CachedResponse{} }
 func (*CachedResponse) ProtoMessage() {}
 func (*CachedResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_79b02382e213d0b2, []int{5}
+	return fileDescriptor_79b02382e213d0b2, []int{8}
 }
 func (m *CachedResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -396,6 +615,13 @@ func (m *CachedResponse) GetExtents() []Extent {
 	return nil
 }
 
+func (m *CachedResponse) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
 type Extent struct {
 	Start    int64      `protobuf:"varint,1,opt,name=start,proto3" json:"start"`
 	End      int64      `protobuf:"varint,2,opt,name=end,proto3" json:"end"`
@@ -406,7 +632,7 @@ type Extent struct {
 func (m *Extent) Reset()      { *m = Extent{} }
 func (*Extent) ProtoMessage() {}
 func (*Extent) Descriptor() ([]byte, []int) {
-	return fileDescriptor_79b02382e213d0b2, []int{6}
+	return fileDescriptor_79b02382e213d0b2, []int{9}
 }
 func (m *Extent) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -470,7 +696,7 @@ type CachingOptions struct {
 func (m *CachingOptions) Reset()      { *m = CachingOptions{} }
 func (*CachingOptions) ProtoMessage() {}
 func (*CachingOptions) Descriptor() ([]byte, []int) {
-	return fileDescriptor_79b02382e213d0b2, []int{7}
+	return fileDescriptor_79b02382e213d0b2, []int{10}
 }
 func (m *CachingOptions) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -506,74 +732,151 @@ func (m *CachingOptions) GetDisabled() bool {
 	return false
 }
 
+type PrometheusRequestParam struct {
+	Name   string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Values []string `protobuf:"bytes,2,rep,name=values,proto3" json:"values,omitempty"`
+}
+
+func (m *PrometheusRequestParam) Reset()      { *m = PrometheusRequestParam{} }
+func (*PrometheusRequestParam) ProtoMessage() {}
+func (*PrometheusRequestParam) Descriptor() ([]byte, []int) {
+	return fileDescriptor_79b02382e213d0b2, []int{11}
+}
+func (m *PrometheusRequestParam) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *PrometheusRequestParam) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_PrometheusRequestParam.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *PrometheusRequestParam) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PrometheusRequestParam.Merge(m, src)
+}
+func (m *PrometheusRequestParam) XXX_Size() int {
+	return m.Size()
+}
+func (m *PrometheusRequestParam) XXX_DiscardUnknown() {
+	xxx_messageInfo_PrometheusRequestParam.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PrometheusRequestParam proto.InternalMessageInfo
+
+func (m *PrometheusRequestParam) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PrometheusRequestParam) GetValues() []string {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*PrometheusRequest)(nil), "queryrange.PrometheusRequest")
 	proto.RegisterType((*PrometheusResponseHeader)(nil), "queryrange.PrometheusResponseHeader")
 	proto.RegisterType((*PrometheusResponse)(nil), "queryrange.PrometheusResponse")
 	proto.RegisterType((*PrometheusData)(nil), "queryrange.PrometheusData")
+	proto.RegisterType((*PrometheusResponseStats)(nil), "queryrange.PrometheusResponseStats")
+	proto.RegisterType((*PrometheusResponseTimings)(nil), "queryrange.PrometheusResponseTimings")
+	proto.RegisterType((*PrometheusResponseSamples)(nil), "queryrange.PrometheusResponseSamples")
 	proto.RegisterType((*SampleStream)(nil), "queryrange.SampleStream")
 	proto.RegisterType((*CachedResponse)(nil), "queryrange.CachedResponse")
 	proto.RegisterType((*Extent)(nil), "queryrange.Extent")
 	proto.RegisterType((*CachingOptions)(nil), "queryrange.CachingOptions")
+	proto.RegisterType((*PrometheusRequestParam)(nil), "queryrange.PrometheusRequestParam")
 }
 
 func init() { proto.RegisterFile("queryrange.proto", fileDescriptor_79b02382e213d0b2) }
 
 var fileDescriptor_79b02382e213d0b2 = []byte{
-	// 833 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x55, 0xcd, 0x8e, 0x1b, 0x45,
-	0x10, 0xf6, 0xac, 0xed, 0xb1, 0xdd, 0x1b, 0x39, 0x9b, 0x4e, 0x04, 0xe3, 0x95, 0x98, 0xb1, 0x46,
-	0x1c, 0x16, 0x29, 0xb1, 0xa5, 0x45, 0x48, 0x5c, 0x40, 0x9b, 0x21, 0x8b, 0x02, 0x42, 0x10, 0xf5,
-	0x46, 0x1c, 0xb8, 0xa0, 0xb6, 0xa7, 0x18, 0x4f, 0x32, 0x7f, 0xe9, 0xee, 0x41, 0xf1, 0x01, 0x09,
-	0xe5, 0x09, 0x38, 0xf2, 0x08, 0x20, 0xf1, 0x1e, 0xe4, 0xb8, 0xc7, 0x88, 0xc3, 0xc0, 0x7a, 0x2f,
-	0x68, 0x4e, 0x79, 0x04, 0xd4, 0x3f, 0x63, 0xcf, 0x6e, 0x38, 0xe5, 0x62, 0x75, 0x55, 0x7d, 0x55,
-	0xf5, 0xd5, 0xd7, 0x3d, 0x65, 0x74, 0xf0, 0xac, 0x04, 0xb6, 0x66, 0x34, 0x8b, 0x60, 0x56, 0xb0,
-	0x5c, 0xe4, 0x18, 0xed, 0x3c, 0x87, 0xf7, 0xa2, 0x58, 0xac, 0xca, 0xc5, 0x6c, 0x99, 0xa7, 0xf3,
-	0x28, 0x8f, 0xf2, 0xb9, 0x82, 0x2c, 0xca, 0x1f, 0x94, 0xa5, 0x0c, 0x75, 0xd2, 0xa9, 0x87, 0x6e,
-	0x94, 0xe7, 0x51, 0x02, 0x3b, 0x54, 0x58, 0x32, 0x2a, 0xe2, 0x3c, 0x33, 0xf1, 0x93, 0x56, 0xb9,
-	0x65, 0xce, 0x04, 0x3c, 0x2f, 0x58, 0xfe, 0x04, 0x96, 0xc2, 0x58, 0xf3, 0xe2, 0x69, 0x34, 0x8f,
-	0xb3, 0x08, 0xb8, 0x00, 0x36, 0x5f, 0x26, 0x31, 0x64, 0x4d, 0xc8, 0x54, 0x98, 0x5c, 0xef, 0x40,
-	0xb3, 0xb5, 0x0e, 0xf9, 0x2f, 0xf6, 0xd0, 0xad, 0x47, 0x2c, 0x4f, 0x41, 0xac, 0xa0, 0xe4, 0x04,
-	0x9e, 0x95, 0xc0, 0x05, 0xc6, 0xa8, 0x57, 0x50, 0xb1, 0x72, 0xac, 0xa9, 0x75, 0x34, 0x22, 0xea,
-	0x8c, 0xef, 0xa0, 0x3e, 0x17, 0x94, 0x09, 0x67, 0x6f, 0x6a, 0x1d, 0x75, 0x89, 0x36, 0xf0, 0x01,
-	0xea, 0x42, 0x16, 0x3a, 0x5d, 0xe5, 0x93, 0x47, 0x99, 0xcb, 0x05, 0x14, 0x4e, 0x4f, 0xb9, 0xd4,
-	0x19, 0x7f, 0x82, 0x06, 0x22, 0x4e, 0x21, 0x2f, 0x85, 0xd3, 0x9f, 0x5a, 0x47, 0xfb, 0xc7, 0x93,
-	0x99, 0xa6, 0x34, 0x6b, 0x28, 0xcd, 0x1e, 0x98, 0xa1, 0x83, 0xe1, 0xcb, 0xca, 0xeb, 0xfc, 0xfa,
-	0xb7, 0x67, 0x91, 0x26, 0x47, 0xb6, 0x56, 0xf2, 0x3a, 0xb6, 0xe2, 0xa3, 0x0d, 0xfc, 0x10, 0x8d,
-	0x97, 0x74, 0xb9, 0x8a, 0xb3, 0xe8, 0x9b, 0x42, 0x66, 0x72, 0x67, 0xa0, 0x6a, 0x1f, 0xce, 0x5a,
-	0xb7, 0xf3, 0xd9, 0x15, 0x44, 0xd0, 0x93, 0xc5, 0xc9, 0xb5, 0x3c, 0xff, 0x31, 0x72, 0xda, 0x1a,
-	0xf0, 0x22, 0xcf, 0x38, 0x3c, 0x04, 0x1a, 0x02, 0xc3, 0x13, 0xd4, 0xfb, 0x9a, 0xa6, 0xa0, 0xa5,
-	0x08, 0xfa, 0x75, 0xe5, 0x59, 0xf7, 0x88, 0x72, 0xe1, 0xf7, 0x90, 0xfd, 0x2d, 0x4d, 0x4a, 0xe0,
-	0xce, 0xde, 0xb4, 0xbb, 0x0b, 0x1a, 0xa7, 0xff, 0xfb, 0x1e, 0xc2, 0x6f, 0x96, 0xc5, 0x3e, 0xb2,
-	0xcf, 0x04, 0x15, 0x25, 0x37, 0x25, 0x51, 0x5d, 0x79, 0x36, 0x57, 0x1e, 0x62, 0x22, 0xf8, 0x73,
-	0xd4, 0x7b, 0x40, 0x05, 0x55, 0x52, 0x5f, 0x1b, 0x68, 0x57, 0x51, 0x22, 0x82, 0x77, 0xe4, 0x40,
-	0x75, 0xe5, 0x8d, 0x43, 0x2a, 0xe8, 0xdd, 0x3c, 0x8d, 0x05, 0xa4, 0x85, 0x58, 0x13, 0x95, 0x8f,
-	0x3f, 0x42, 0xa3, 0x53, 0xc6, 0x72, 0xf6, 0x78, 0x5d, 0x80, 0xba, 0xa3, 0x51, 0xf0, 0x6e, 0x5d,
-	0x79, 0xb7, 0xa1, 0x71, 0xb6, 0x32, 0x76, 0x48, 0xfc, 0x01, 0xea, 0x2b, 0x43, 0xdd, 0xe1, 0x28,
-	0xb8, 0x5d, 0x57, 0xde, 0x4d, 0x95, 0xd2, 0x82, 0x6b, 0x04, 0x3e, 0x45, 0x03, 0x2d, 0x14, 0x77,
-	0xfa, 0xd3, 0xee, 0xd1, 0xfe, 0xf1, 0xfb, 0xff, 0x4f, 0xf6, 0xaa, 0xaa, 0x8d, 0x54, 0x4d, 0xae,
-	0xff, 0xc2, 0x42, 0xe3, 0xab, 0x93, 0xe1, 0x19, 0x42, 0x04, 0x78, 0x99, 0x08, 0x45, 0x5e, 0x6b,
-	0x35, 0xae, 0x2b, 0x0f, 0xb1, 0xad, 0x97, 0xb4, 0x10, 0xf8, 0x04, 0xd9, 0xda, 0x52, 0xb7, 0xb1,
-	0x7f, 0xec, 0xb4, 0x89, 0x9c, 0xd1, 0xb4, 0x48, 0xe0, 0x4c, 0x30, 0xa0, 0x69, 0x30, 0x36, 0x9a,
-	0xd9, 0xba, 0x12, 0x31, 0x79, 0xfe, 0x9f, 0x16, 0xba, 0xd1, 0x06, 0xe2, 0x9f, 0x90, 0x9d, 0xd0,
-	0x05, 0x24, 0xf2, 0xaa, 0x64, 0xc9, 0x5b, 0x33, 0xf3, 0x59, 0x7d, 0x25, 0xbd, 0x8f, 0x68, 0xcc,
-	0x02, 0x22, 0x6b, 0xfd, 0x55, 0x79, 0x6f, 0xf3, 0x91, 0xea, 0x32, 0xf7, 0x43, 0x5a, 0x08, 0x60,
-	0x92, 0x4f, 0x0a, 0x82, 0xc5, 0x4b, 0x62, 0x9a, 0xe2, 0x8f, 0xd1, 0x80, 0x2b, 0x3a, 0xdc, 0x8c,
-	0x34, 0x6e, 0xfa, 0x6b, 0x96, 0xbb, 0x41, 0x7e, 0x54, 0x2f, 0x8e, 0x34, 0x70, 0xff, 0x09, 0x1a,
-	0xcb, 0x87, 0x0f, 0xe1, 0xf6, 0xd5, 0x4d, 0x50, 0xf7, 0x29, 0xac, 0x8d, 0x8c, 0x83, 0xba, 0xf2,
-	0xa4, 0x49, 0xe4, 0x8f, 0xfc, 0x38, 0xe1, 0xb9, 0x80, 0x4c, 0x34, 0x6d, 0x70, 0x5b, 0xb9, 0x53,
-	0x15, 0x0a, 0x6e, 0x9a, 0x56, 0x0d, 0x94, 0x34, 0x07, 0xff, 0x0f, 0x0b, 0xd9, 0x1a, 0x84, 0xbd,
-	0x66, 0x45, 0xc8, 0x36, 0xdd, 0x60, 0x54, 0x57, 0x9e, 0x76, 0x34, 0xdb, 0x62, 0xa2, 0xb7, 0x85,
-	0xda, 0x20, 0x9a, 0x05, 0x64, 0xa1, 0x5e, 0x1b, 0x53, 0x34, 0x14, 0x8c, 0x2e, 0xe1, 0xfb, 0x38,
-	0x34, 0xcf, 0xae, 0x79, 0x23, 0xca, 0xfd, 0x45, 0x88, 0x3f, 0x45, 0x43, 0x66, 0xc6, 0x31, 0x5b,
-	0xe4, 0xce, 0x1b, 0x5b, 0xe4, 0x7e, 0xb6, 0x0e, 0x6e, 0xd4, 0x95, 0xb7, 0x45, 0x92, 0xed, 0xe9,
-	0xcb, 0xde, 0xb0, 0x7b, 0xd0, 0xf3, 0xef, 0x6a, 0x69, 0x76, 0x5f, 0x3f, 0x3e, 0x44, 0xc3, 0x30,
-	0xe6, 0x74, 0x91, 0x40, 0xa8, 0x88, 0x0f, 0xc9, 0xd6, 0x0e, 0x4e, 0xce, 0x2f, 0xdc, 0xce, 0xab,
-	0x0b, 0xb7, 0xf3, 0xfa, 0xc2, 0xb5, 0x7e, 0xde, 0xb8, 0xd6, 0x6f, 0x1b, 0xd7, 0x7a, 0xb9, 0x71,
-	0xad, 0xf3, 0x8d, 0x6b, 0xfd, 0xb3, 0x71, 0xad, 0x7f, 0x37, 0x6e, 0xe7, 0xf5, 0xc6, 0xb5, 0x7e,
-	0xb9, 0x74, 0x3b, 0xe7, 0x97, 0x6e, 0xe7, 0xd5, 0xa5, 0xdb, 0xf9, 0xae, 0xf5, 0x67, 0xb0, 0xb0,
-	0x15, 0xb7, 0x0f, 0xff, 0x0b, 0x00, 0x00, 0xff, 0xff, 0x19, 0x3b, 0xc7, 0x4d, 0x33, 0x06, 0x00,
-	0x00,
+	// 1192 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0x4f, 0x6f, 0x1b, 0x45,
+	0x14, 0xf7, 0x7a, 0xfd, 0x77, 0xd2, 0xba, 0xc9, 0x34, 0x49, 0xd7, 0x91, 0xd8, 0xb5, 0x16, 0x90,
+	0x82, 0xd4, 0x38, 0x22, 0x08, 0x81, 0x90, 0x40, 0xc9, 0x92, 0xa0, 0x16, 0x55, 0xad, 0x3b, 0x89,
+	0x8a, 0xc4, 0xa5, 0x9a, 0xd8, 0x83, 0xb3, 0xc4, 0xde, 0xdd, 0xcc, 0xce, 0x96, 0xf8, 0x80, 0xc4,
+	0x95, 0x1b, 0x47, 0x38, 0x71, 0xe5, 0xc0, 0xb7, 0xe0, 0x40, 0x8f, 0x11, 0xa7, 0xaa, 0x12, 0x0b,
+	0x71, 0x2e, 0x68, 0x4f, 0xfd, 0x08, 0x68, 0xfe, 0xac, 0x3d, 0x4e, 0x9c, 0x0a, 0x71, 0x59, 0xcd,
+	0x7b, 0xef, 0xf7, 0xde, 0xbc, 0xf7, 0x7b, 0x3b, 0xf3, 0x06, 0x2c, 0x9e, 0x24, 0x84, 0x8e, 0x28,
+	0x0e, 0xfa, 0xa4, 0x1d, 0xd1, 0x90, 0x85, 0x10, 0x4c, 0x35, 0x6b, 0x1b, 0x7d, 0x9f, 0x1d, 0x25,
+	0x87, 0xed, 0x6e, 0x38, 0xdc, 0xec, 0x87, 0xfd, 0x70, 0x53, 0x40, 0x0e, 0x93, 0xaf, 0x84, 0x24,
+	0x04, 0xb1, 0x92, 0xae, 0x6b, 0x76, 0x3f, 0x0c, 0xfb, 0x03, 0x32, 0x45, 0xf5, 0x12, 0x8a, 0x99,
+	0x1f, 0x06, 0xca, 0xbe, 0xad, 0x85, 0xeb, 0x86, 0x94, 0x91, 0xd3, 0x88, 0x86, 0x5f, 0x93, 0x2e,
+	0x53, 0xd2, 0x66, 0x74, 0xdc, 0xdf, 0xf4, 0x83, 0x3e, 0x89, 0x19, 0xa1, 0x9b, 0xdd, 0x81, 0x4f,
+	0x82, 0xdc, 0xa4, 0x22, 0x34, 0x2f, 0xef, 0x80, 0x83, 0x91, 0x34, 0xb9, 0x2f, 0x8b, 0x60, 0xa9,
+	0x43, 0xc3, 0x21, 0x61, 0x47, 0x24, 0x89, 0x11, 0x39, 0x49, 0x48, 0xcc, 0x20, 0x04, 0xa5, 0x08,
+	0xb3, 0x23, 0xcb, 0x68, 0x19, 0xeb, 0x75, 0x24, 0xd6, 0x70, 0x19, 0x94, 0x63, 0x86, 0x29, 0xb3,
+	0x8a, 0x2d, 0x63, 0xdd, 0x44, 0x52, 0x80, 0x8b, 0xc0, 0x24, 0x41, 0xcf, 0x32, 0x85, 0x8e, 0x2f,
+	0xb9, 0x6f, 0xcc, 0x48, 0x64, 0x95, 0x84, 0x4a, 0xac, 0xe1, 0xc7, 0xa0, 0xca, 0xfc, 0x21, 0x09,
+	0x13, 0x66, 0x95, 0x5b, 0xc6, 0xfa, 0xc2, 0x56, 0xb3, 0x2d, 0x53, 0x6a, 0xe7, 0x29, 0xb5, 0x77,
+	0x55, 0xd1, 0x5e, 0xed, 0x79, 0xea, 0x14, 0x7e, 0xfc, 0xcb, 0x31, 0x50, 0xee, 0xc3, 0xb7, 0x16,
+	0xf4, 0x5a, 0x15, 0x91, 0x8f, 0x14, 0xe0, 0x3d, 0xd0, 0xe8, 0xe2, 0xee, 0x91, 0x1f, 0xf4, 0x1f,
+	0x45, 0xdc, 0x33, 0xb6, 0xaa, 0x22, 0xf6, 0x5a, 0x5b, 0xeb, 0xce, 0xa7, 0x33, 0x08, 0xaf, 0xc4,
+	0x83, 0xa3, 0x4b, 0x7e, 0xaa, 0x34, 0x16, 0x5b, 0x35, 0x19, 0x5f, 0x08, 0x70, 0x1b, 0x54, 0x22,
+	0x4c, 0xf1, 0x30, 0xb6, 0xea, 0x2d, 0x73, 0x7d, 0x61, 0xcb, 0xd5, 0xe3, 0x5e, 0xe1, 0xac, 0xc3,
+	0xa1, 0x2a, 0xbe, 0xf2, 0x73, 0x0f, 0x80, 0xa5, 0xe3, 0xe2, 0x28, 0x0c, 0x62, 0x72, 0x8f, 0xe0,
+	0x1e, 0xa1, 0xb0, 0x09, 0x4a, 0x0f, 0xf1, 0x90, 0x48, 0x8a, 0xbd, 0x72, 0x96, 0x3a, 0xc6, 0x06,
+	0x12, 0x2a, 0xf8, 0x06, 0xa8, 0x3c, 0xc1, 0x83, 0x84, 0xc4, 0x56, 0xb1, 0x65, 0x4e, 0x8d, 0x4a,
+	0xe9, 0xfe, 0x59, 0x04, 0xf0, 0x6a, 0x58, 0xe8, 0x82, 0xca, 0x3e, 0xc3, 0x2c, 0x89, 0x55, 0x48,
+	0x90, 0xa5, 0x4e, 0x25, 0x16, 0x1a, 0xa4, 0x2c, 0xf0, 0x33, 0x50, 0xda, 0xc5, 0x0c, 0x5b, 0xc5,
+	0xab, 0x44, 0x4d, 0x23, 0x72, 0x84, 0xb7, 0xca, 0x0b, 0xc9, 0x52, 0xa7, 0xd1, 0xc3, 0x0c, 0xdf,
+	0x0d, 0x87, 0x3e, 0x23, 0xc3, 0x88, 0x8d, 0x90, 0xf0, 0x87, 0xef, 0x83, 0xfa, 0x1e, 0xa5, 0x21,
+	0x3d, 0x18, 0x45, 0x44, 0xf4, 0xbe, 0xee, 0xdd, 0xc9, 0x52, 0xe7, 0x36, 0xc9, 0x95, 0x9a, 0xc7,
+	0x14, 0x09, 0xdf, 0x01, 0x65, 0x21, 0x88, 0x7f, 0xa3, 0xee, 0xdd, 0xce, 0x52, 0xe7, 0x96, 0x70,
+	0xd1, 0xe0, 0x12, 0x01, 0xf7, 0x40, 0x55, 0x12, 0x15, 0x5b, 0x65, 0xc1, 0xfe, 0x5b, 0xd7, 0xb1,
+	0xaf, 0xb3, 0x9a, 0x53, 0x95, 0xfb, 0xc2, 0x2d, 0x50, 0xfb, 0x02, 0xd3, 0xc0, 0x0f, 0xfa, 0xb1,
+	0x55, 0x11, 0x64, 0xae, 0x66, 0xa9, 0x03, 0xbf, 0x51, 0x3a, 0x6d, 0xdf, 0x09, 0xce, 0xfd, 0xc3,
+	0x00, 0x8d, 0x59, 0x36, 0x60, 0x1b, 0x00, 0x44, 0xe2, 0x64, 0xc0, 0x44, 0xc1, 0x92, 0xdf, 0x46,
+	0x96, 0x3a, 0x80, 0x4e, 0xb4, 0x48, 0x43, 0xf0, 0x5f, 0x47, 0x4a, 0xa2, 0x83, 0x0b, 0x5b, 0x96,
+	0x9e, 0xfc, 0x3e, 0x1e, 0x46, 0x03, 0xb2, 0xcf, 0x28, 0xc1, 0x43, 0xaf, 0xa1, 0x78, 0xae, 0xc8,
+	0x48, 0x48, 0xf9, 0xc1, 0x87, 0xa0, 0xbc, 0x2f, 0x7e, 0x49, 0x53, 0xb4, 0xea, 0xcd, 0xd7, 0x57,
+	0x2f, 0xa0, 0x92, 0x4f, 0xf1, 0xef, 0xea, 0x7c, 0x0a, 0x9b, 0xfb, 0x9b, 0x01, 0xee, 0x5c, 0xe3,
+	0x07, 0x3b, 0xa0, 0x7a, 0xe0, 0x0f, 0x05, 0x47, 0x86, 0xd8, 0xed, 0xed, 0xd7, 0xef, 0xa6, 0xc0,
+	0xde, 0x2d, 0x95, 0x7b, 0x95, 0x49, 0x05, 0xca, 0xc3, 0xc0, 0x27, 0xa0, 0x2a, 0xab, 0x8c, 0xad,
+	0xe2, 0x7f, 0x89, 0xa8, 0xc0, 0xde, 0x4a, 0x96, 0x3a, 0x4b, 0xb1, 0x14, 0xb4, 0x1a, 0xf2, 0x60,
+	0xee, 0xf7, 0x26, 0x68, 0x5e, 0x9b, 0x0f, 0xfc, 0x00, 0xdc, 0xdc, 0x7b, 0x86, 0x07, 0x07, 0x21,
+	0xc3, 0x83, 0x03, 0x5f, 0x9d, 0x2d, 0xc3, 0x5b, 0xca, 0x52, 0xe7, 0x26, 0xd1, 0x0d, 0x68, 0x16,
+	0x07, 0x3f, 0x02, 0x0d, 0x49, 0xfb, 0x7e, 0x48, 0x99, 0xf0, 0x2c, 0x0a, 0x4f, 0xc8, 0x0f, 0x00,
+	0x9d, 0xb1, 0xa0, 0x4b, 0x48, 0xf8, 0x00, 0x2c, 0x3f, 0xe6, 0xa5, 0x75, 0x28, 0xe1, 0xa7, 0x9e,
+	0x5f, 0x28, 0x22, 0x82, 0x29, 0x22, 0x58, 0x59, 0xea, 0x2c, 0x9f, 0xcc, 0xb1, 0xa3, 0xb9, 0x5e,
+	0xbc, 0x84, 0xfb, 0x41, 0x40, 0xa8, 0xc8, 0x8f, 0x87, 0x29, 0x4d, 0x4b, 0xf0, 0x75, 0x03, 0x9a,
+	0xc5, 0x89, 0xda, 0x4f, 0x49, 0xf7, 0x71, 0x42, 0x12, 0x22, 0x1c, 0xcb, 0x5a, 0xed, 0xba, 0x01,
+	0xcd, 0xe2, 0x72, 0xc7, 0x29, 0x69, 0x95, 0x59, 0x47, 0x9d, 0x34, 0x5d, 0x74, 0x7f, 0x36, 0xe6,
+	0xf5, 0x42, 0x75, 0x0a, 0x3e, 0x02, 0x2b, 0x02, 0x2a, 0xaa, 0xc4, 0x87, 0x83, 0xdc, 0x20, 0x7a,
+	0x62, 0x7a, 0xcd, 0x2c, 0x75, 0x56, 0xd8, 0x3c, 0x00, 0x9a, 0xef, 0x07, 0xdf, 0x05, 0x0b, 0x1d,
+	0x82, 0x8f, 0xf5, 0xdf, 0xca, 0xf4, 0x6e, 0x65, 0xa9, 0xb3, 0x10, 0x4d, 0xd5, 0x48, 0xc7, 0xb8,
+	0xbf, 0x1b, 0xe0, 0x86, 0x7e, 0xd8, 0xe0, 0xb7, 0xa0, 0x32, 0xc0, 0x87, 0x64, 0xc0, 0xb3, 0xe0,
+	0xc7, 0x72, 0xa9, 0xad, 0xc6, 0xe4, 0x03, 0xae, 0xed, 0x60, 0x9f, 0x7a, 0x88, 0xff, 0xd3, 0x2f,
+	0x53, 0xe7, 0xff, 0x0c, 0x5d, 0x19, 0x66, 0xa7, 0x87, 0x23, 0x46, 0x28, 0x3f, 0xd3, 0x43, 0xc2,
+	0xa8, 0xdf, 0x45, 0x6a, 0x53, 0xf8, 0x21, 0xa8, 0xc6, 0x93, 0xf4, 0xf9, 0xfe, 0x8d, 0x7c, 0x7f,
+	0x99, 0xe5, 0xf4, 0x32, 0x78, 0x26, 0x6e, 0x7a, 0x94, 0xc3, 0xdd, 0x9f, 0x0c, 0xd0, 0xe0, 0x93,
+	0x8c, 0xf4, 0x26, 0xd7, 0x7d, 0x13, 0x98, 0xc7, 0x64, 0xa4, 0xee, 0xa2, 0x6a, 0x96, 0x3a, 0x5c,
+	0x44, 0xfc, 0xc3, 0xa7, 0x2d, 0x39, 0x65, 0x24, 0x60, 0xf9, 0x3e, 0x50, 0x3f, 0x7d, 0x7b, 0xc2,
+	0x34, 0x3d, 0xbc, 0x0a, 0x8a, 0xf2, 0x05, 0xdc, 0x00, 0x80, 0x9c, 0x46, 0x3e, 0x25, 0xf1, 0x53,
+	0xcc, 0xe4, 0x64, 0x97, 0x97, 0xdd, 0x54, 0x8b, 0xea, 0x6a, 0xbd, 0xc3, 0xdc, 0x5f, 0x0d, 0x50,
+	0x91, 0x31, 0xa1, 0x93, 0x3f, 0x11, 0x64, 0x93, 0xeb, 0x59, 0xea, 0x48, 0x45, 0xfe, 0x5a, 0x68,
+	0xca, 0xd7, 0x82, 0x6c, 0x9e, 0x48, 0x9a, 0x04, 0x3d, 0xf9, 0x6c, 0x68, 0x81, 0x1a, 0xa3, 0xb8,
+	0x4b, 0x9e, 0xfa, 0x3d, 0x35, 0x1e, 0xf2, 0xbb, 0x5c, 0xa8, 0xef, 0xf7, 0xe0, 0x27, 0xa0, 0x46,
+	0x55, 0xf5, 0xea, 0x15, 0xb1, 0x7c, 0xe5, 0x15, 0xb1, 0x13, 0x8c, 0xbc, 0x1b, 0x59, 0xea, 0x4c,
+	0x90, 0x68, 0xb2, 0xfa, 0xbc, 0x54, 0x33, 0x17, 0x4b, 0xee, 0x5d, 0xc9, 0xa4, 0x36, 0xfd, 0xd7,
+	0x40, 0xad, 0xe7, 0xc7, 0xfc, 0x5f, 0xeb, 0x89, 0xc4, 0x6b, 0x68, 0x22, 0xbb, 0xbb, 0x60, 0x75,
+	0xfe, 0xa4, 0xe7, 0xcf, 0x9c, 0x60, 0x32, 0xbf, 0x91, 0x58, 0xc3, 0x55, 0xa0, 0x3a, 0x27, 0x07,
+	0x37, 0x52, 0x92, 0xb7, 0x7d, 0x76, 0x6e, 0x17, 0x5e, 0x9c, 0xdb, 0x85, 0x57, 0xe7, 0xb6, 0xf1,
+	0xdd, 0xd8, 0x36, 0x7e, 0x19, 0xdb, 0xc6, 0xf3, 0xb1, 0x6d, 0x9c, 0x8d, 0x6d, 0xe3, 0xef, 0xb1,
+	0x6d, 0xfc, 0x33, 0xb6, 0x0b, 0xaf, 0xc6, 0xb6, 0xf1, 0xc3, 0x85, 0x5d, 0x38, 0xbb, 0xb0, 0x0b,
+	0x2f, 0x2e, 0xec, 0xc2, 0x97, 0xda, 0x93, 0xf2, 0xb0, 0x22, 0x2a, 0x7c, 0xef, 0xdf, 0x01, 0x00,
+	0xc4, 0x27, 0x01, 0xda, 0x79, 0x0a, 0x00, 0x00,
 }
 
 func (this *PrometheusRequest) Equal(that interface{}) bool {
@@ -616,6 +919,17 @@ func (this *PrometheusRequest) Equal(that interface{}) bool {
 	if !this.CachingOptions.Equal(&that1.CachingOptions) {
 		return false
 	}
+	if this.Stats != that1.Stats {
+		return false
+	}
+	if len(this.Params) != len(that1.Params) {
+		return false
+	}
+	for i := range this.Params {
+		if !this.Params[i].Equal(&that1.Params[i]) {
+			return false
+		}
+	}
 	return true
 }
 func (this *PrometheusResponseHeader) Equal(that interface{}) bool {
@@ -689,6 +1003,14 @@ func (this *PrometheusResponse) Equal(that interface{}) bool {
 			return false
 		}
 	}
+	if len(this.Warnings) != len(that1.Warnings) {
+		return false
+	}
+	for i := range this.Warnings {
+		if this.Warnings[i] != that1.Warnings[i] {
+			return false
+		}
+	}
 	return true
 }
 func (this *PrometheusData) Equal(that interface{}) bool {
@@ -721,16 +1043,19 @@ func (this *PrometheusData) Equal(that interface{}) bool {
 			return false
 		}
 	}
+	if !this.Stats.Equal(that1.Stats) {
+		return false
+	}
 	return true
 }
-func (this *SampleStream) Equal(that interface{}) bool {
+func (this *PrometheusResponseStats) Equal(that interface{}) bool {
 	if that == nil {
 		return this == nil
 	}
 
-	that1, ok := that.(*SampleStream)
+	that1, ok := that.(*PrometheusResponseStats)
 	if !ok {
-		that2, ok := that.(SampleStream)
+		that2, ok := that.(PrometheusResponseStats)
 		if ok {
 			that1 = &that2
 		} else {
@@ -742,32 +1067,22 @@ func (this *SampleStream) Equal(that interface{}) bool {
 	} else if this == nil {
 		return false
 	}
-	if len(this.Labels) != len(that1.Labels) {
+	if !this.Timings.Equal(&that1.Timings) {
 		return false
 	}
-	for i := range this.Labels {
-		if !this.Labels[i].Equal(that1.Labels[i]) {
-			return false
-		}
-	}
-	if len(this.Samples) != len(that1.Samples) {
+	if !this.Samples.Equal(that1.Samples) {
 		return false
 	}
-	for i := range this.Samples {
-		if !this.Samples[i].Equal(&that1.Samples[i]) {
-			return false
-		}
-	}
 	return true
 }
-func (this *CachedResponse) Equal(that interface{}) bool {
+func (this *PrometheusResponseTimings) Equal(that interface{}) bool {
 	if that == nil {
 		return this == nil
 	}
 
-	that1, ok := that.(*CachedResponse)
+	that1, ok := that.(*PrometheusResponseTimings)
 	if !ok {
-		that2, ok := that.(CachedResponse)
+		that2, ok := that.(PrometheusResponseTimings)
 		if ok {
 			that1 = &that2
 		} else {
@@ -779,27 +1094,34 @@ func (this *CachedResponse) Equal(that interface{}) bool {
 	} else if this == nil {
 		return false
 	}
-	if this.Key != that1.Key {
+	if this.EvalTotalTime != that1.EvalTotalTime {
 		return false
 	}
-	if len(this.Extents) != len(that1.Extents) {
+	if this.ResultSortTime != that1.ResultSortTime {
 		return false
 	}
-	for i := range this.Extents {
-		if !this.Extents[i].Equal(&that1.Extents[i]) {
-			return false
-		}
+	if this.QueryPreparationTime != that1.QueryPreparationTime {
+		return false
+	}
+	if this.InnerEvalTime != that1.InnerEvalTime {
+		return false
+	}
+	if this.ExecQueueTime != that1.ExecQueueTime {
+		return false
+	}
+	if this.ExecTotalTime != that1.ExecTotalTime {
+		return false
 	}
 	return true
 }
-func (this *Extent) Equal(that interface{}) bool {
+func (this *PrometheusResponseSamples) Equal(that interface{}) bool {
 	if that == nil {
 		return this == nil
 	}
 
-	that1, ok := that.(*Extent)
+	that1, ok := that.(*PrometheusResponseSamples)
 	if !ok {
-		that2, ok := that.(Extent)
+		that2, ok := that.(PrometheusResponseSamples)
 		if ok {
 			that1 = &that2
 		} else {
@@ -811,28 +1133,22 @@ func (this *Extent) Equal(that interface{}) bool {
 	} else if this == nil {
 		return false
 	}
-	if this.Start != that1.Start {
-		return false
-	}
-	if this.End != that1.End {
-		return false
-	}
-	if this.TraceId != that1.TraceId {
+	if this.TotalQueryableSamples != that1.TotalQueryableSamples {
 		return false
 	}
-	if !this.Response.Equal(that1.Response) {
+	if this.PeakSamples != that1.PeakSamples {
 		return false
 	}
 	return true
 }
-func (this *CachingOptions) Equal(that interface{}) bool {
+func (this *SampleStream) Equal(that interface{}) bool {
 	if that == nil {
 		return this == nil
 	}
 
-	that1, ok := that.(*CachingOptions)
+	that1, ok := that.(*SampleStream)
 	if !ok {
-		that2, ok := that.(CachingOptions)
+		that2, ok := that.(SampleStream)
 		if ok {
 			that1 = &that2
 		} else {
@@ -844,16 +1160,153 @@ func (this *CachingOptions) Equal(that interface{}) bool {
 	} else if this == nil {
 		return false
 	}
-	if this.Disabled != that1.Disabled {
+	if len(this.Labels) != len(that1.Labels) {
+		return false
+	}
+	for i := range this.Labels {
+		if !this.Labels[i].Equal(that1.Labels[i]) {
+			return false
+		}
+	}
+	if len(this.Samples) != len(that1.Samples) {
 		return false
 	}
+	for i := range this.Samples {
+		if !this.Samples[i].Equal(&that1.Samples[i]) {
+			return false
+		}
+	}
+	return true
+}
+func (this *CachedResponse) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*CachedResponse)
+	if !ok {
+		that2, ok := that.(CachedResponse)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Key != that1.Key {
+		return false
+	}
+	if len(this.Extents) != len(that1.Extents) {
+		return false
+	}
+	for i := range this.Extents {
+		if !this.Extents[i].Equal(&that1.Extents[i]) {
+			return false
+		}
+	}
+	if this.ExpiresAt != that1.ExpiresAt {
+		return false
+	}
+	return true
+}
+func (this *Extent) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*Extent)
+	if !ok {
+		that2, ok := that.(Extent)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Start != that1.Start {
+		return false
+	}
+	if this.End != that1.End {
+		return false
+	}
+	if this.TraceId != that1.TraceId {
+		return false
+	}
+	if !this.Response.Equal(that1.Response) {
+		return false
+	}
+	return true
+}
+func (this *CachingOptions) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*CachingOptions)
+	if !ok {
+		that2, ok := that.(CachingOptions)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Disabled != that1.Disabled {
+		return false
+	}
+	return true
+}
+func (this *PrometheusRequestParam) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*PrometheusRequestParam)
+	if !ok {
+		that2, ok := that.(PrometheusRequestParam)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Name != that1.Name {
+		return false
+	}
+	if len(this.Values) != len(that1.Values) {
+		return false
+	}
+	for i := range this.Values {
+		if this.Values[i] != that1.Values[i] {
+			return false
+		}
+	}
 	return true
 }
 func (this *PrometheusRequest) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 11)
+	s := make([]string, 0, 13)
 	s = append(s, "&queryrange.PrometheusRequest{")
 	s = append(s, "Path: "+fmt.Sprintf("%#v", this.Path)+",\n")
 	s = append(s, "Start: "+fmt.Sprintf("%#v", this.Start)+",\n")
@@ -862,6 +1315,14 @@ func (this *PrometheusRequest) GoString() string {
 	s = append(s, "Timeout: "+fmt.Sprintf("%#v", this.Timeout)+",\n")
 	s = append(s, "Query: "+fmt.Sprintf("%#v", this.Query)+",\n")
 	s = append(s, "CachingOptions: "+strings.Replace(this.CachingOptions.GoString(), `&`, ``, 1)+",\n")
+	s = append(s, "Stats: "+fmt.Sprintf("%#v", this.Stats)+",\n")
+	if this.Params != nil {
+		vs := make([]*PrometheusRequestParam, len(this.Params))
+		for i := range vs {
+			vs[i] = &this.Params[i]
+		}
+		s = append(s, "Params: "+fmt.Sprintf("%#v", vs)+",\n")
+	}
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -880,7 +1341,7 @@ func (this *PrometheusResponse) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 9)
+	s := make([]string, 0, 10)
 	s = append(s, "&queryrange.PrometheusResponse{")
 	s = append(s, "Status: "+fmt.Sprintf("%#v", this.Status)+",\n")
 	s = append(s, "Data: "+strings.Replace(this.Data.GoString(), `&`, ``, 1)+",\n")
@@ -889,6 +1350,7 @@ func (this *PrometheusResponse) GoString() string {
 	if this.Headers != nil {
 		s = append(s, "Headers: "+fmt.Sprintf("%#v", this.Headers)+",\n")
 	}
+	s = append(s, "Warnings: "+fmt.Sprintf("%#v", this.Warnings)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -896,7 +1358,7 @@ func (this *PrometheusData) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 6)
+	s := make([]string, 0, 7)
 	s = append(s, "&queryrange.PrometheusData{")
 	s = append(s, "ResultType: "+fmt.Sprintf("%#v", this.ResultType)+",\n")
 	if this.Result != nil {
@@ -906,6 +1368,48 @@ func (this *PrometheusData) GoString() string {
 		}
 		s = append(s, "Result: "+fmt.Sprintf("%#v", vs)+",\n")
 	}
+	if this.Stats != nil {
+		s = append(s, "Stats: "+fmt.Sprintf("%#v", this.Stats)+",\n")
+	}
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *PrometheusResponseStats) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&queryrange.PrometheusResponseStats{")
+	s = append(s, "Timings: "+strings.Replace(this.Timings.GoString(), `&`, ``, 1)+",\n")
+	if this.Samples != nil {
+		s = append(s, "Samples: "+fmt.Sprintf("%#v", this.Samples)+",\n")
+	}
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *PrometheusResponseTimings) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 10)
+	s = append(s, "&queryrange.PrometheusResponseTimings{")
+	s = append(s, "EvalTotalTime: "+fmt.Sprintf("%#v", this.EvalTotalTime)+",\n")
+	s = append(s, "ResultSortTime: "+fmt.Sprintf("%#v", this.ResultSortTime)+",\n")
+	s = append(s, "QueryPreparationTime: "+fmt.Sprintf("%#v", this.QueryPreparationTime)+",\n")
+	s = append(s, "InnerEvalTime: "+fmt.Sprintf("%#v", this.InnerEvalTime)+",\n")
+	s = append(s, "ExecQueueTime: "+fmt.Sprintf("%#v", this.ExecQueueTime)+",\n")
+	s = append(s, "ExecTotalTime: "+fmt.Sprintf("%#v", this.ExecTotalTime)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *PrometheusResponseSamples) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&queryrange.PrometheusResponseSamples{")
+	s = append(s, "TotalQueryableSamples: "+fmt.Sprintf("%#v", this.TotalQueryableSamples)+",\n")
+	s = append(s, "PeakSamples: "+fmt.Sprintf("%#v", this.PeakSamples)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -930,7 +1434,7 @@ func (this *CachedResponse) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 6)
+	s := make([]string, 0, 7)
 	s = append(s, "&queryrange.CachedResponse{")
 	s = append(s, "Key: "+fmt.Sprintf("%#v", this.Key)+",\n")
 	if this.Extents != nil {
@@ -940,6 +1444,7 @@ func (this *CachedResponse) GoString() string {
 		}
 		s = append(s, "Extents: "+fmt.Sprintf("%#v", vs)+",\n")
 	}
+	s = append(s, "ExpiresAt: "+fmt.Sprintf("%#v", this.ExpiresAt)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -968,6 +1473,17 @@ func (this *CachingOptions) GoString() string {
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
+func (this *PrometheusRequestParam) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&queryrange.PrometheusRequestParam{")
+	s = append(s, "Name: "+fmt.Sprintf("%#v", this.Name)+",\n")
+	s = append(s, "Values: "+fmt.Sprintf("%#v", this.Values)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
 func valueToGoStringQueryrange(v interface{}, typ string) string {
 	rv := reflect.ValueOf(v)
 	if rv.IsNil() {
@@ -996,6 +1512,27 @@ func (m *PrometheusRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Params) > 0 {
+		for iNdEx := len(m.Params) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Params[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintQueryrange(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x4a
+		}
+	}
+	if len(m.Stats) > 0 {
+		i -= len(m.Stats)
+		copy(dAtA[i:], m.Stats)
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Stats)))
+		i--
+		dAtA[i] = 0x42
+	}
 	{
 		size, err := m.CachingOptions.MarshalToSizedBuffer(dAtA[:i])
 		if err != nil {
@@ -1105,6 +1642,15 @@ func (m *PrometheusResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Warnings) > 0 {
+		for iNdEx := len(m.Warnings) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Warnings[iNdEx])
+			copy(dAtA[i:], m.Warnings[iNdEx])
+			i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Warnings[iNdEx])))
+			i--
+			dAtA[i] = 0x32
+		}
+	}
 	if len(m.Headers) > 0 {
 		for iNdEx := len(m.Headers) - 1; iNdEx >= 0; iNdEx-- {
 			{
@@ -1173,6 +1719,18 @@ func (m *PrometheusData) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.Stats != nil {
+		{
+			size, err := m.Stats.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintQueryrange(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0x1a
+	}
 	if len(m.Result) > 0 {
 		for iNdEx := len(m.Result) - 1; iNdEx >= 0; iNdEx-- {
 			{
@@ -1197,7 +1755,7 @@ func (m *PrometheusData) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
-func (m *SampleStream) Marshal() (dAtA []byte, err error) {
+func (m *PrometheusResponseStats) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
 	n, err := m.MarshalToSizedBuffer(dAtA[:size])
@@ -1207,48 +1765,42 @@ func (m *SampleStream) Marshal() (dAtA []byte, err error) {
 	return dAtA[:n], nil
 }
 
-func (m *SampleStream) MarshalTo(dAtA []byte) (int, error) {
+func (m *PrometheusResponseStats) MarshalTo(dAtA []byte) (int, error) {
 	size := m.Size()
 	return m.MarshalToSizedBuffer(dAtA[:size])
 }
 
-func (m *SampleStream) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+func (m *PrometheusResponseStats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if len(m.Samples) > 0 {
-		for iNdEx := len(m.Samples) - 1; iNdEx >= 0; iNdEx-- {
-			{
-				size, err := m.Samples[iNdEx].MarshalToSizedBuffer(dAtA[:i])
-				if err != nil {
-					return 0, err
-				}
-				i -= size
-				i = encodeVarintQueryrange(dAtA, i, uint64(size))
+	if m.Samples != nil {
+		{
+			size, err := m.Samples.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
 			}
-			i--
-			dAtA[i] = 0x12
+			i -= size
+			i = encodeVarintQueryrange(dAtA, i, uint64(size))
 		}
+		i--
+		dAtA[i] = 0x12
 	}
-	if len(m.Labels) > 0 {
-		for iNdEx := len(m.Labels) - 1; iNdEx >= 0; iNdEx-- {
-			{
-				size := m.Labels[iNdEx].Size()
-				i -= size
-				if _, err := m.Labels[iNdEx].MarshalTo(dAtA[i:]); err != nil {
-					return 0, err
-				}
-				i = encodeVarintQueryrange(dAtA, i, uint64(size))
-			}
-			i--
-			dAtA[i] = 0xa
+	{
+		size, err := m.Timings.MarshalToSizedBuffer(dAtA[:i])
+		if err != nil {
+			return 0, err
 		}
+		i -= size
+		i = encodeVarintQueryrange(dAtA, i, uint64(size))
 	}
+	i--
+	dAtA[i] = 0xa
 	return len(dAtA) - i, nil
 }
 
-func (m *CachedResponse) Marshal() (dAtA []byte, err error) {
+func (m *PrometheusResponseTimings) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
 	n, err := m.MarshalToSizedBuffer(dAtA[:size])
@@ -1258,41 +1810,56 @@ func (m *CachedResponse) Marshal() (dAtA []byte, err error) {
 	return dAtA[:n], nil
 }
 
-func (m *CachedResponse) MarshalTo(dAtA []byte) (int, error) {
+func (m *PrometheusResponseTimings) MarshalTo(dAtA []byte) (int, error) {
 	size := m.Size()
 	return m.MarshalToSizedBuffer(dAtA[:size])
 }
 
-func (m *CachedResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+func (m *PrometheusResponseTimings) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if len(m.Extents) > 0 {
-		for iNdEx := len(m.Extents) - 1; iNdEx >= 0; iNdEx-- {
-			{
-				size, err := m.Extents[iNdEx].MarshalToSizedBuffer(dAtA[:i])
-				if err != nil {
-					return 0, err
-				}
-				i -= size
-				i = encodeVarintQueryrange(dAtA, i, uint64(size))
-			}
-			i--
-			dAtA[i] = 0x12
-		}
+	if m.ExecTotalTime != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.ExecTotalTime))))
+		i--
+		dAtA[i] = 0x31
 	}
-	if len(m.Key) > 0 {
-		i -= len(m.Key)
-		copy(dAtA[i:], m.Key)
-		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Key)))
+	if m.ExecQueueTime != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.ExecQueueTime))))
 		i--
-		dAtA[i] = 0xa
+		dAtA[i] = 0x29
+	}
+	if m.InnerEvalTime != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.InnerEvalTime))))
+		i--
+		dAtA[i] = 0x21
+	}
+	if m.QueryPreparationTime != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.QueryPreparationTime))))
+		i--
+		dAtA[i] = 0x19
+	}
+	if m.ResultSortTime != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.ResultSortTime))))
+		i--
+		dAtA[i] = 0x11
+	}
+	if m.EvalTotalTime != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.EvalTotalTime))))
+		i--
+		dAtA[i] = 0x9
 	}
 	return len(dAtA) - i, nil
 }
 
-func (m *Extent) Marshal() (dAtA []byte, err error) {
+func (m *PrometheusResponseSamples) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
 	n, err := m.MarshalToSizedBuffer(dAtA[:size])
@@ -1302,19 +1869,152 @@ func (m *Extent) Marshal() (dAtA []byte, err error) {
 	return dAtA[:n], nil
 }
 
-func (m *Extent) MarshalTo(dAtA []byte) (int, error) {
+func (m *PrometheusResponseSamples) MarshalTo(dAtA []byte) (int, error) {
 	size := m.Size()
 	return m.MarshalToSizedBuffer(dAtA[:size])
 }
 
-func (m *Extent) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+func (m *PrometheusResponseSamples) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if m.Response != nil {
-		{
-			size, err := m.Response.MarshalToSizedBuffer(dAtA[:i])
+	if m.PeakSamples != 0 {
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.PeakSamples))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.TotalQueryableSamples != 0 {
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.TotalQueryableSamples))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *SampleStream) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SampleStream) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *SampleStream) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Samples) > 0 {
+		for iNdEx := len(m.Samples) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Samples[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintQueryrange(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if len(m.Labels) > 0 {
+		for iNdEx := len(m.Labels) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size := m.Labels[iNdEx].Size()
+				i -= size
+				if _, err := m.Labels[iNdEx].MarshalTo(dAtA[i:]); err != nil {
+					return 0, err
+				}
+				i = encodeVarintQueryrange(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *CachedResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CachedResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CachedResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.ExpiresAt != 0 {
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.ExpiresAt))
+		i--
+		dAtA[i] = 0x18
+	}
+	if len(m.Extents) > 0 {
+		for iNdEx := len(m.Extents) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Extents[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintQueryrange(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if len(m.Key) > 0 {
+		i -= len(m.Key)
+		copy(dAtA[i:], m.Key)
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Key)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Extent) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Extent) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Extent) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Response != nil {
+		{
+			size, err := m.Response.MarshalToSizedBuffer(dAtA[:i])
 			if err != nil {
 				return 0, err
 			}
@@ -1377,6 +2077,45 @@ func (m *CachingOptions) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *PrometheusRequestParam) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *PrometheusRequestParam) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *PrometheusRequestParam) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Values) > 0 {
+		for iNdEx := len(m.Values) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Values[iNdEx])
+			copy(dAtA[i:], m.Values[iNdEx])
+			i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Values[iNdEx])))
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if len(m.Name) > 0 {
+		i -= len(m.Name)
+		copy(dAtA[i:], m.Name)
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Name)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintQueryrange(dAtA []byte, offset int, v uint64) int {
 	offset -= sovQueryrange(v)
 	base := offset
@@ -1415,6 +2154,16 @@ func (m *PrometheusRequest) Size() (n int) {
 	}
 	l = m.CachingOptions.Size()
 	n += 1 + l + sovQueryrange(uint64(l))
+	l = len(m.Stats)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	if len(m.Params) > 0 {
+		for _, e := range m.Params {
+			l = e.Size()
+			n += 1 + l + sovQueryrange(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -1463,6 +2212,12 @@ func (m *PrometheusResponse) Size() (n int) {
 			n += 1 + l + sovQueryrange(uint64(l))
 		}
 	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovQueryrange(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -1482,6 +2237,67 @@ func (m *PrometheusData) Size() (n int) {
 			n += 1 + l + sovQueryrange(uint64(l))
 		}
 	}
+	if m.Stats != nil {
+		l = m.Stats.Size()
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	return n
+}
+
+func (m *PrometheusResponseStats) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = m.Timings.Size()
+	n += 1 + l + sovQueryrange(uint64(l))
+	if m.Samples != nil {
+		l = m.Samples.Size()
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	return n
+}
+
+func (m *PrometheusResponseTimings) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.EvalTotalTime != 0 {
+		n += 9
+	}
+	if m.ResultSortTime != 0 {
+		n += 9
+	}
+	if m.QueryPreparationTime != 0 {
+		n += 9
+	}
+	if m.InnerEvalTime != 0 {
+		n += 9
+	}
+	if m.ExecQueueTime != 0 {
+		n += 9
+	}
+	if m.ExecTotalTime != 0 {
+		n += 9
+	}
+	return n
+}
+
+func (m *PrometheusResponseSamples) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.TotalQueryableSamples != 0 {
+		n += 1 + sovQueryrange(uint64(m.TotalQueryableSamples))
+	}
+	if m.PeakSamples != 0 {
+		n += 1 + sovQueryrange(uint64(m.PeakSamples))
+	}
 	return n
 }
 
@@ -1522,6 +2338,9 @@ func (m *CachedResponse) Size() (n int) {
 			n += 1 + l + sovQueryrange(uint64(l))
 		}
 	}
+	if m.ExpiresAt != 0 {
+		n += 1 + sovQueryrange(uint64(m.ExpiresAt))
+	}
 	return n
 }
 
@@ -1560,6 +2379,25 @@ func (m *CachingOptions) Size() (n int) {
 	return n
 }
 
+func (m *PrometheusRequestParam) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	if len(m.Values) > 0 {
+		for _, s := range m.Values {
+			l = len(s)
+			n += 1 + l + sovQueryrange(uint64(l))
+		}
+	}
+	return n
+}
+
 func sovQueryrange(x uint64) (n int) {
 	return (math_bits.Len64(x|1) + 6) / 7
 }
@@ -1570,6 +2408,11 @@ func (this *PrometheusRequest) String() string {
 	if this == nil {
 		return "nil"
 	}
+	repeatedStringForParams := "[]PrometheusRequestParam{"
+	for _, f := range this.Params {
+		repeatedStringForParams += strings.Replace(strings.Replace(f.String(), "PrometheusRequestParam", "PrometheusRequestParam", 1), `&`, ``, 1) + ","
+	}
+	repeatedStringForParams += "}"
 	s := strings.Join([]string{`&PrometheusRequest{`,
 		`Path:` + fmt.Sprintf("%v", this.Path) + `,`,
 		`Start:` + fmt.Sprintf("%v", this.Start) + `,`,
@@ -1578,6 +2421,8 @@ func (this *PrometheusRequest) String() string {
 		`Timeout:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.Timeout), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
 		`Query:` + fmt.Sprintf("%v", this.Query) + `,`,
 		`CachingOptions:` + strings.Replace(strings.Replace(this.CachingOptions.String(), "CachingOptions", "CachingOptions", 1), `&`, ``, 1) + `,`,
+		`Stats:` + fmt.Sprintf("%v", this.Stats) + `,`,
+		`Params:` + repeatedStringForParams + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1608,6 +2453,7 @@ func (this *PrometheusResponse) String() string {
 		`ErrorType:` + fmt.Sprintf("%v", this.ErrorType) + `,`,
 		`Error:` + fmt.Sprintf("%v", this.Error) + `,`,
 		`Headers:` + repeatedStringForHeaders + `,`,
+		`Warnings:` + fmt.Sprintf("%v", this.Warnings) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1624,6 +2470,44 @@ func (this *PrometheusData) String() string {
 	s := strings.Join([]string{`&PrometheusData{`,
 		`ResultType:` + fmt.Sprintf("%v", this.ResultType) + `,`,
 		`Result:` + repeatedStringForResult + `,`,
+		`Stats:` + strings.Replace(this.Stats.String(), "PrometheusResponseStats", "PrometheusResponseStats", 1) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *PrometheusResponseStats) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&PrometheusResponseStats{`,
+		`Timings:` + strings.Replace(strings.Replace(this.Timings.String(), "PrometheusResponseTimings", "PrometheusResponseTimings", 1), `&`, ``, 1) + `,`,
+		`Samples:` + strings.Replace(this.Samples.String(), "PrometheusResponseSamples", "PrometheusResponseSamples", 1) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *PrometheusResponseTimings) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&PrometheusResponseTimings{`,
+		`EvalTotalTime:` + fmt.Sprintf("%v", this.EvalTotalTime) + `,`,
+		`ResultSortTime:` + fmt.Sprintf("%v", this.ResultSortTime) + `,`,
+		`QueryPreparationTime:` + fmt.Sprintf("%v", this.QueryPreparationTime) + `,`,
+		`InnerEvalTime:` + fmt.Sprintf("%v", this.InnerEvalTime) + `,`,
+		`ExecQueueTime:` + fmt.Sprintf("%v", this.ExecQueueTime) + `,`,
+		`ExecTotalTime:` + fmt.Sprintf("%v", this.ExecTotalTime) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *PrometheusResponseSamples) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&PrometheusResponseSamples{`,
+		`TotalQueryableSamples:` + fmt.Sprintf("%v", this.TotalQueryableSamples) + `,`,
+		`PeakSamples:` + fmt.Sprintf("%v", this.PeakSamples) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1656,6 +2540,7 @@ func (this *CachedResponse) String() string {
 	s := strings.Join([]string{`&CachedResponse{`,
 		`Key:` + fmt.Sprintf("%v", this.Key) + `,`,
 		`Extents:` + repeatedStringForExtents + `,`,
+		`ExpiresAt:` + fmt.Sprintf("%v", this.ExpiresAt) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1683,7 +2568,18 @@ func (this *CachingOptions) String() string {
 	}, "")
 	return s
 }
-func valueToStringQueryrange(v interface{}) string {
+func (this *PrometheusRequestParam) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&PrometheusRequestParam{`,
+		`Name:` + fmt.Sprintf("%v", this.Name) + `,`,
+		`Values:` + fmt.Sprintf("%v", this.Values) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func valueToStringQueryrange(v interface{}) string {
 	rv := reflect.ValueOf(v)
 	if rv.IsNil() {
 		return "nil"
@@ -1907,6 +2803,72 @@ func (m *PrometheusRequest) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Stats", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Stats = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Params", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Params = append(m.Params, PrometheusRequestParam{})
+			if err := m.Params[len(m.Params)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
@@ -2208,7 +3170,314 @@ func (m *PrometheusResponse) Unmarshal(dAtA []byte) error {
 			iNdEx = postIndex
 		case 5:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Headers", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Headers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Headers = append(m.Headers, &PrometheusResponseHeader{})
+			if err := m.Headers[len(m.Headers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipQueryrange(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PrometheusData) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowQueryrange
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PrometheusData: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PrometheusData: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResultType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ResultType = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Result", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Result = append(m.Result, SampleStream{})
+			if err := m.Result[len(m.Result)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Stats", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Stats == nil {
+				m.Stats = &PrometheusResponseStats{}
+			}
+			if err := m.Stats.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipQueryrange(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PrometheusResponseStats) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowQueryrange
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PrometheusResponseStats: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PrometheusResponseStats: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timings", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.Timings.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Samples", wireType)
 			}
 			var msglen int
 			for shift := uint(0); ; shift += 7 {
@@ -2235,8 +3504,10 @@ func (m *PrometheusResponse) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Headers = append(m.Headers, &PrometheusResponseHeader{})
-			if err := m.Headers[len(m.Headers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+			if m.Samples == nil {
+				m.Samples = &PrometheusResponseSamples{}
+			}
+			if err := m.Samples.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
 			}
 			iNdEx = postIndex
@@ -2264,7 +3535,7 @@ func (m *PrometheusResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *PrometheusData) Unmarshal(dAtA []byte) error {
+func (m *PrometheusResponseTimings) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -2287,17 +3558,136 @@ func (m *PrometheusData) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PrometheusData: wiretype end group for non-group")
+			return fmt.Errorf("proto: PrometheusResponseTimings: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PrometheusData: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: PrometheusResponseTimings: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ResultType", wireType)
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EvalTotalTime", wireType)
 			}
-			var stringLen uint64
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.EvalTotalTime = float64(math.Float64frombits(v))
+		case 2:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResultSortTime", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.ResultSortTime = float64(math.Float64frombits(v))
+		case 3:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field QueryPreparationTime", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.QueryPreparationTime = float64(math.Float64frombits(v))
+		case 4:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field InnerEvalTime", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.InnerEvalTime = float64(math.Float64frombits(v))
+		case 5:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExecQueueTime", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.ExecQueueTime = float64(math.Float64frombits(v))
+		case 6:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExecTotalTime", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.ExecTotalTime = float64(math.Float64frombits(v))
+		default:
+			iNdEx = preIndex
+			skippy, err := skipQueryrange(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PrometheusResponseSamples) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowQueryrange
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PrometheusResponseSamples: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PrometheusResponseSamples: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TotalQueryableSamples", wireType)
+			}
+			m.TotalQueryableSamples = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowQueryrange
@@ -2307,29 +3697,16 @@ func (m *PrometheusData) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				m.TotalQueryableSamples |= int64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthQueryrange
-			}
-			postIndex := iNdEx + intStringLen
-			if postIndex < 0 {
-				return ErrInvalidLengthQueryrange
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.ResultType = string(dAtA[iNdEx:postIndex])
-			iNdEx = postIndex
 		case 2:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Result", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PeakSamples", wireType)
 			}
-			var msglen int
+			m.PeakSamples = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowQueryrange
@@ -2339,26 +3716,11 @@ func (m *PrometheusData) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				m.PeakSamples |= int64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthQueryrange
-			}
-			postIndex := iNdEx + msglen
-			if postIndex < 0 {
-				return ErrInvalidLengthQueryrange
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Result = append(m.Result, SampleStream{})
-			if err := m.Result[len(m.Result)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
@@ -2599,6 +3961,25 @@ func (m *CachedResponse) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExpiresAt", wireType)
+			}
+			m.ExpiresAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ExpiresAt |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
@@ -2855,6 +4236,123 @@ func (m *CachingOptions) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *PrometheusRequestParam) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowQueryrange
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PrometheusRequestParam: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PrometheusRequestParam: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Values", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Values = append(m.Values, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipQueryrange(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipQueryrange(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -0,0 +1,184 @@
+package queryrange
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+)
+
+const errConflictingMatcher = "the query selector %s conflicts with the matcher %s required for the tenant"
+
+// RequiredMatchersMiddleware adds the label matchers required for the tenant to all the selectors of the
+// queries, to restrict the series they can select. The queries already selecting other values of the
+// required labels are rejected.
+func RequiredMatchersMiddleware(limits Limits) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return requiredMatchers{
+			next:   next,
+			limits: limits,
+		}
+	})
+}
+
+type requiredMatchers struct {
+	next   Handler
+	limits Limits
+}
+
+func (m requiredMatchers) Do(ctx context.Context, r Request) (Response, error) {
+	query, err := injectRequiredMatchers(ctx, r.GetQuery(), m.limits)
+	if err != nil {
+		return nil, err
+	}
+	if query == r.GetQuery() {
+		return m.next.Do(ctx, r)
+	}
+	return m.next.Do(ctx, r.WithQuery(query))
+}
+
+// injectRequiredMatchers returns the query with the matchers required for the tenants added to all its
+// selectors. The matchers required for any of the tenants of a query spanning multiple tenants are added.
+func injectRequiredMatchers(ctx context.Context, query string, limits Limits) (string, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return "", httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	var required []*labels.Matcher
+	for _, matcher := range requiredMatchersPerTenant(tenantIDs, limits) {
+		matchers, err := parser.ParseMetricSelector("{" + matcher + "}")
+		if err != nil {
+			return "", httpgrpc.Errorf(http.StatusInternalServerError, "invalid required matcher %q: %v", matcher, err)
+		}
+		required = append(required, matchers...)
+	}
+	if len(required) == 0 {
+		return query, nil
+	}
+
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+
+	// The vector selectors of the range vectors and of the subqueries are children of these nodes.
+	var conflict error
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		selector, ok := node.(*parser.VectorSelector)
+		if !ok || conflict != nil {
+			return nil
+		}
+		for _, matcher := range required {
+			if err := addMatcher(selector, matcher); err != nil {
+				conflict = err
+				return err
+			}
+		}
+		return nil
+	})
+	if conflict != nil {
+		return "", conflict
+	}
+	return expr.String(), nil
+}
+
+// requiredMatchersPerTenant returns the matchers required for any of the tenants.
+func requiredMatchersPerTenant(tenantIDs []string, limits Limits) []string {
+	var required []string
+	for _, tenantID := range tenantIDs {
+		required = append(required, limits.RequiredMatchers(tenantID)...)
+	}
+	return required
+}
+
+// addMatcher adds the matcher to the selector, unless it already has it. Returns an error if an equality
+// matcher is added to a selector whose matchers of the same label don't match its value, as the selector
+// would never select any series.
+func addMatcher(selector *parser.VectorSelector, matcher *labels.Matcher) error {
+	for _, existing := range selector.LabelMatchers {
+		if existing.Name != matcher.Name {
+			continue
+		}
+		if existing.Type == matcher.Type && existing.Value == matcher.Value {
+			return nil
+		}
+		if matcher.Type == labels.MatchEqual && !existing.Matches(matcher.Value) {
+			return httpgrpc.Errorf(http.StatusBadRequest, errConflictingMatcher, selector.String(), matcher.String())
+		}
+	}
+	selector.LabelMatchers = append(selector.LabelMatchers, matcher)
+	return nil
+}
+
+// injectRequiredMatchersHTTP returns the query request passed through the middlewares with the matchers
+// required for the tenants added to its query, whether it's in the URL or in the form body. The other
+// parameters are passed through byte for byte, eg. the time of the instant queries.
+func injectRequiredMatchersHTTP(r *http.Request, limits Limits) (*http.Request, error) {
+	inject := func(encoded string, values url.Values) (string, bool, error) {
+		query := values.Get("query")
+		if query == "" {
+			return encoded, false, nil
+		}
+		injected, err := injectRequiredMatchers(r.Context(), query, limits)
+		if err != nil || injected == query {
+			return encoded, false, err
+		}
+		return replaceParam(encoded, "query", injected), true, nil
+	}
+
+	rawQuery, changed, err := inject(r.URL.RawQuery, r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = rawQuery
+	}
+
+	if r.Body == nil || r.Body == http.NoBody || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return r, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	injected, changed, err := inject(string(body), values)
+	if err != nil || !changed {
+		return r, err
+	}
+	body = []byte(injected)
+	r = r.Clone(r.Context())
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return r, nil
+}
+
+// replaceParam returns the URL encoded parameters with the values of the parameter replaced by the
+// value, leaving the other parameters unchanged.
+func replaceParam(encoded, name, value string) string {
+	params := strings.Split(encoded, "&")
+	for i, param := range params {
+		key := param
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			key = param[:j]
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			params[i] = url.QueryEscape(name) + "=" + url.QueryEscape(value)
+		}
+	}
+	return strings.Join(params, "&")
+}
@@ -0,0 +1,137 @@
+package queryrange
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+func TestRequiredMatchersMiddleware(t *testing.T) {
+	for name, tc := range map[string]struct {
+		query            string
+		requiredMatchers []string
+		expectedQuery    string
+		expectedErr      string
+	}{
+		"no required matchers": {
+			query:         `sum(rate(http_requests_total[5m]))`,
+			expectedQuery: `sum(rate(http_requests_total[5m]))`,
+		},
+		"vector selector": {
+			query:            `up`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedQuery:    `up{cluster="x"}`,
+		},
+		"multiple required matchers": {
+			query:            `up{job="api"}`,
+			requiredMatchers: []string{`cluster="x"`, `namespace=~"a|b"`},
+			expectedQuery:    `up{cluster="x",job="api",namespace=~"a|b"}`,
+		},
+		"nested selectors": {
+			query:            `sum by(job) (rate(http_requests_total[5m])) / on(job) group_left() max(up)`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedQuery:    `sum by(job) (rate(http_requests_total{cluster="x"}[5m])) / on(job) group_left() max(up{cluster="x"})`,
+		},
+		"subquery": {
+			query:            `max_over_time(rate(http_requests_total[5m])[1h:1m])`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedQuery:    `max_over_time(rate(http_requests_total{cluster="x"}[5m])[1h:1m])`,
+		},
+		"matcher already present": {
+			query:            `up{cluster="x"}`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedQuery:    `up{cluster="x"}`,
+		},
+		"compatible matcher on the same label": {
+			query:            `up{cluster=~"x|y"}`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedQuery:    `up{cluster="x",cluster=~"x|y"}`,
+		},
+		"conflicting matcher": {
+			query:            `up{cluster="y"}`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedErr:      `rpc error: code = Code(400) desc = the query selector up{cluster="y"} conflicts with the matcher cluster="x" required for the tenant`,
+		},
+		"conflicting matcher in a subquery": {
+			query:            `max_over_time(up{cluster!="x"}[1h:1m])`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedErr:      `rpc error: code = Code(400) desc = the query selector up{cluster!="x"} conflicts with the matcher cluster="x" required for the tenant`,
+		},
+		"number literal": {
+			query:            `1 + 1`,
+			requiredMatchers: []string{`cluster="x"`},
+			expectedQuery:    `1 + 1`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var query string
+			next := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				query = r.GetQuery()
+				return &PrometheusResponse{Status: StatusSuccess}, nil
+			})
+
+			req := &PrometheusRequest{Start: 0, End: 3600 * 1e3, Step: 15 * 1e3, Query: tc.query}
+			_, err := RequiredMatchersMiddleware(fakeLimits{requiredMatchers: tc.requiredMatchers}).Wrap(next).Do(user.InjectOrgID(context.Background(), "1"), req)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				resp, ok := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, ok)
+				assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedQuery, query)
+		})
+	}
+}
+
+func TestInjectRequiredMatchersHTTP(t *testing.T) {
+	limits := fakeLimits{requiredMatchers: []string{`cluster="x"`}}
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	t.Run("query in the URL", func(t *testing.T) {
+		r, err := http.NewRequest("GET", "/api/v1/query?query=up&time=1", nil)
+		require.NoError(t, err)
+
+		injected, err := injectRequiredMatchersHTTP(r.WithContext(ctx), limits)
+		require.NoError(t, err)
+		assert.Equal(t, `up{cluster="x"}`, injected.URL.Query().Get("query"))
+		assert.Equal(t, "1", injected.URL.Query().Get("time"))
+		assert.Equal(t, "up", r.URL.Query().Get("query"))
+		assert.Equal(t, "query=up%7Bcluster%3D%22x%22%7D&time=1", injected.URL.RawQuery)
+	})
+
+	t.Run("query in the form body", func(t *testing.T) {
+		r, err := http.NewRequest("POST", "/api/v1/query", strings.NewReader("query=up&time=1"))
+		require.NoError(t, err)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		injected, err := injectRequiredMatchersHTTP(r.WithContext(ctx), limits)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(injected.Body)
+		require.NoError(t, err)
+		values, err := url.ParseQuery(string(body))
+		require.NoError(t, err)
+		assert.Equal(t, `up{cluster="x"}`, values.Get("query"))
+		assert.Equal(t, int64(len(body)), injected.ContentLength)
+	})
+
+	t.Run("conflicting query", func(t *testing.T) {
+		r, err := http.NewRequest("GET", "/api/v1/query?query="+url.QueryEscape(`up{cluster="y"}`), nil)
+		require.NoError(t, err)
+
+		_, err = injectRequiredMatchersHTTP(r.WithContext(ctx), limits)
+		require.Error(t, err)
+		resp, ok := httpgrpc.HTTPResponseFromError(err)
+		require.True(t, ok)
+		assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sort"
 	"time"
 
@@ -16,13 +17,17 @@ import (
 	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/uber/jaeger-client-go"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
 )
@@ -41,25 +46,44 @@ type CacheGenNumberLoader interface {
 
 // ResultsCacheConfig is the config for the results cache.
 type ResultsCacheConfig struct {
-	CacheConfig cache.Config `yaml:"cache"`
-	Compression string       `yaml:"compression"`
+	CacheConfig     cache.Config  `yaml:"cache"`
+	Compression     string        `yaml:"compression"`
+	MaxExtentLength time.Duration `yaml:"max_extent_length"`
+	TTLJitter       float64       `yaml:"ttl_jitter"`
+	StaleMaxAge     time.Duration `yaml:"stale_max_age"`
+
+	ResultAffectingParams flagext.StringSliceCSV `yaml:"result_affecting_params"`
 }
 
 // RegisterFlags registers flags.
 func (cfg *ResultsCacheConfig) RegisterFlags(f *flag.FlagSet) {
 	cfg.CacheConfig.RegisterFlagsWithPrefix("frontend.", "", f)
 
-	f.StringVar(&cfg.Compression, "frontend.compression", "", "Use compression in results cache. Supported values are: 'snappy' and '' (disable compression).")
+	f.StringVar(&cfg.Compression, "frontend.compression", compressionNone, "Compression of the results cache entries. Supported values are: 'none' (or ''), 'snappy' and 'gzip'. The entries are read whatever the compression they were written with, so it can be changed without flushing the cache.")
+	f.DurationVar(&cfg.MaxExtentLength, "frontend.results-cache.max-extent-length", 0, "Maximum time range of the extents stored in a results cache entry. Adjacent extents are merged, when reading and updating the entry, as long as the merged extent doesn't exceed this length. 0 for no limit.")
+	f.Float64Var(&cfg.TTLJitter, "frontend.results-cache.ttl-jitter", 0, "Fraction of the results cache expiration (the memcached or redis expiration, or the fifocache duration) the expiration of each entry is randomized by, in either direction, so that the entries written at the same time don't all expire together. The expiration is derived from the entry key, so it's the same on all the query-frontends. 0 to disable.")
+	f.DurationVar(&cfg.StaleMaxAge, "frontend.results-cache.stale-max-age", 0, "Maximum time after the expiration of a results cache entry (the memcached or redis expiration, or the fifocache duration) it's served for, if the query fails. The query is then served from the expired entry with a warning, as its results may be stale, and the entry is refreshed in the background. The entries are kept in the cache for this long after they expire. 0 to disable.")
+	cfg.ResultAffectingParams = []string{"query", "start", "end", "step", "time", "timeout", "lookback_delta"}
+	f.Var(&cfg.ResultAffectingParams, "frontend.results-cache.result-affecting-params", "Comma-separated list of the query parameters which affect the results of the queries, and are part of the results cache keys. The other parameters, eg. the _ cache buster added by Grafana, are forwarded but ignored by the results cache.")
 	flagext.DeprecatedFlag(f, "frontend.cache-split-interval", "Deprecated: The maximum interval expected for each request, results will be cached per single interval. This behavior is now determined by querier.split-queries-by-interval.")
 }
 
 func (cfg *ResultsCacheConfig) Validate() error {
 	switch cfg.Compression {
-	case "snappy", "":
+	case compressionNone, compressionSnappy, compressionGzip, "":
 		// valid
 	default:
 		return errors.Errorf("unsupported compression type: %s", cfg.Compression)
 	}
+	if cfg.MaxExtentLength < 0 {
+		return errors.Errorf("invalid max extent length %s: must not be negative", cfg.MaxExtentLength)
+	}
+	if cfg.TTLJitter < 0 || cfg.TTLJitter >= 1 {
+		return errors.Errorf("invalid TTL jitter %v: must be between 0 and 1", cfg.TTLJitter)
+	}
+	if cfg.StaleMaxAge < 0 {
+		return errors.Errorf("invalid stale max age %s: must not be negative", cfg.StaleMaxAge)
+	}
 
 	return cfg.CacheConfig.Validate()
 }
@@ -112,7 +136,40 @@ type constSplitter time.Duration
 // GenerateCacheKey generates a cache key based on the userID, Request and interval.
 func (t constSplitter) GenerateCacheKey(userID string, r Request) string {
 	currentInterval := r.GetStart() / int64(time.Duration(t)/time.Millisecond)
-	return fmt.Sprintf("%s:%s:%d:%d", userID, r.GetQuery(), r.GetStep(), currentInterval)
+	key := fmt.Sprintf("%s:%s:%d:%d", userID, normalizeQuery(r.GetQuery()), r.GetStep(), currentInterval)
+
+	// The other parameters may change the results, eg. lookback_delta. They're sorted by name.
+	for _, param := range r.GetParams() {
+		key += ":" + url.Values{param.Name: param.Values}.Encode()
+	}
+	return key
+}
+
+// normalizeQuery returns the canonical form of a PromQL expression, so that queries differing
+// only in formatting or in the order of label matchers share the same cache entries. Queries
+// which can't be parsed are returned unchanged.
+func normalizeQuery(query string) string {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return query
+	}
+
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if vs, ok := node.(*parser.VectorSelector); ok {
+			sort.Slice(vs.LabelMatchers, func(i, j int) bool {
+				a, b := vs.LabelMatchers[i], vs.LabelMatchers[j]
+				if a.Name != b.Name {
+					return a.Name < b.Name
+				}
+				if a.Type != b.Type {
+					return a.Type < b.Type
+				}
+				return a.Value < b.Value
+			})
+		}
+		return nil
+	})
+	return expr.String()
 }
 
 // ShouldCacheFn checks whether the current request should go to cache
@@ -131,6 +188,74 @@ type resultsCache struct {
 	merger               Merger
 	cacheGenNumberLoader CacheGenNumberLoader
 	shouldCache          ShouldCacheFn
+
+	extentsPerKey    prometheus.Histogram
+	compressionRatio prometheus.Histogram
+	metrics          *resultsCacheMetrics
+
+	// The expiration of the entries, set if the expired entries are served when the queries fail.
+	expiration     time.Duration
+	staleResponses prometheus.Counter
+	refreshes      *staleRefreshes
+}
+
+// resultsCacheMetrics tracks how much of the queries is served from the results cache, to compute
+// the hit ratio. The endpoint label is the type of the cached queries, only range queries are cached
+// for now.
+type resultsCacheMetrics struct {
+	hits   *prometheus.CounterVec
+	misses *prometheus.CounterVec
+	bytes  *prometheus.HistogramVec
+}
+
+func newResultsCacheMetrics(reg prometheus.Registerer) *resultsCacheMetrics {
+	return &resultsCacheMetrics{
+		hits: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "frontend_results_cache_hits_total",
+			Help:      "Total number of cached extents used to answer the queries.",
+		}, []string{"endpoint"}),
+		misses: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cortex",
+			Name:      "frontend_results_cache_misses_total",
+			Help:      "Total number of extents of the queries fetched from downstream because they weren't cached.",
+		}, []string{"endpoint"}),
+		bytes: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "cortex",
+			Name:      "frontend_results_cache_response_bytes",
+			Help:      "Size of the part of the responses served from the results cache (source=cache) or fetched from downstream (source=downstream), per query.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+		}, []string{"endpoint", "source"}),
+	}
+}
+
+// observe records the extents of a query served from the cache and fetched from downstream.
+func (m *resultsCacheMetrics) observe(r Request, cached, fetched []Response) {
+	endpoint := cacheEndpoint(r)
+	m.hits.WithLabelValues(endpoint).Add(float64(len(cached)))
+	m.misses.WithLabelValues(endpoint).Add(float64(len(fetched)))
+	if len(cached) > 0 {
+		m.bytes.WithLabelValues(endpoint, "cache").Observe(float64(responsesSize(cached)))
+	}
+	if len(fetched) > 0 {
+		m.bytes.WithLabelValues(endpoint, "downstream").Observe(float64(responsesSize(fetched)))
+	}
+}
+
+// cacheEndpoint returns the type of endpoint of the cached request.
+func cacheEndpoint(r Request) string {
+	if r.GetStep() > 0 {
+		return "range"
+	}
+	return "instant"
+}
+
+func responsesSize(responses []Response) int {
+	size := 0
+	for _, resp := range responses {
+		size += proto.Size(resp)
+	}
+	return size
 }
 
 // NewResultsCacheMiddleware creates results cache middleware from config.
@@ -150,18 +275,40 @@ func NewResultsCacheMiddleware(
 	shouldCache ShouldCacheFn,
 	reg prometheus.Registerer,
 ) (Middleware, cache.Cache, error) {
+	var expiration time.Duration
+	if cfg.StaleMaxAge > 0 {
+		expiration = cacheExpiration(cfg.CacheConfig)
+		cfg.CacheConfig = extendCacheExpiration(cfg.CacheConfig, cfg.StaleMaxAge)
+	}
+	cfg.CacheConfig.TTLJitter = cfg.TTLJitter
 	c, err := cache.New(cfg.CacheConfig, reg, logger)
 	if err != nil {
 		return nil, nil, err
 	}
-	if cfg.Compression == "snappy" {
-		c = cache.NewSnappy(c, logger)
-	}
-
 	if cacheGenNumberLoader != nil {
 		c = cache.NewCacheGenNumMiddleware(c)
 	}
 
+	extentsPerKey := promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Namespace: "cortex",
+		Name:      "frontend_results_cache_extents_per_key",
+		Help:      "Number of extents stored in a results cache entry, each time the entry is updated.",
+		Buckets:   []float64{1, 2, 4, 8, 16, 32, 64},
+	})
+	compressionRatio := promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Namespace: "cortex",
+		Name:      "frontend_results_cache_compression_ratio",
+		Help:      "Ratio of the uncompressed to the compressed size of the results cache entries, each time an entry is compressed.",
+		Buckets:   []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16},
+	})
+	staleResponses := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "frontend_results_cache_stale_responses_total",
+		Help:      "Total number of queries served from an expired results cache entry, because they failed.",
+	})
+	metrics := newResultsCacheMetrics(reg)
+	refreshes := newStaleRefreshes()
+
 	return MiddlewareFunc(func(next Handler) Handler {
 		return &resultsCache{
 			logger:               logger,
@@ -174,8 +321,40 @@ func NewResultsCacheMiddleware(
 			splitter:             splitter,
 			cacheGenNumberLoader: cacheGenNumberLoader,
 			shouldCache:          shouldCache,
+			extentsPerKey:        extentsPerKey,
+			compressionRatio:     compressionRatio,
+			metrics:              metrics,
+			expiration:           expiration,
+			staleResponses:       staleResponses,
+			refreshes:            refreshes,
+		}
+	}), &refreshesStoppingCache{Cache: c, refreshes: refreshes}, nil
+}
+
+// cacheKey returns the cache key of the request, ignoring its parameters which don't affect the results.
+func (s resultsCache) cacheKey(userID string, r Request) string {
+	return s.splitter.GenerateCacheKey(userID, withResultAffectingParams(r, s.cfg.ResultAffectingParams))
+}
+
+// withResultAffectingParams returns the request without its other parameters than the given ones.
+func withResultAffectingParams(r Request, names []string) Request {
+	promReq, ok := r.(*PrometheusRequest)
+	if !ok || len(promReq.Params) == 0 {
+		return r
+	}
+
+	params := make([]PrometheusRequestParam, 0, len(promReq.Params))
+	for _, param := range promReq.Params {
+		if util.StringsContain(names, param.Name) {
+			params = append(params, param)
 		}
-	}), c, nil
+	}
+	if len(params) == len(promReq.Params) {
+		return r
+	}
+	new := *promReq
+	new.Params = params
+	return &new
 }
 
 func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
@@ -188,12 +367,18 @@ func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
 		return s.next.Do(ctx, r)
 	}
 
+	// The cached results are invalidated per tenant, so the results of queries spanning
+	// multiple tenants aren't cached.
+	if tenantIDs, err := tenant.TenantIDsFromOrgID(userID); err != nil || len(tenantIDs) > 1 {
+		return s.next.Do(ctx, r)
+	}
+
 	if s.cacheGenNumberLoader != nil {
 		ctx = cache.InjectCacheGenNumber(ctx, s.cacheGenNumberLoader.GetResultsCacheGenNumber(userID))
 	}
 
 	var (
-		key      = s.splitter.GenerateCacheKey(userID, r)
+		key      = s.cacheKey(userID, r)
 		extents  []Extent
 		response Response
 	)
@@ -204,10 +389,16 @@ func (s resultsCache) Do(ctx context.Context, r Request) (Response, error) {
 		return s.next.Do(ctx, r)
 	}
 
-	cached, ok := s.get(ctx, key)
-	if ok {
-		response, extents, err = s.handleHit(ctx, r, cached)
-	} else {
+	entry, ok := s.getEntry(ctx, key)
+	switch {
+	case ok && s.expired(entry):
+		response, extents, err = s.handleMiss(ctx, r)
+		if err != nil && s.cfg.StaleMaxAge > 0 && canServeStale(ctx, err) {
+			return s.handleStale(ctx, r, key, entry.Extents, err)
+		}
+	case ok:
+		response, extents, err = s.handleHit(ctx, r, entry.Extents)
+	default:
 		response, extents, err = s.handleMiss(ctx, r)
 	}
 
@@ -272,6 +463,8 @@ func (s resultsCache) handleMiss(ctx context.Context, r Request) (Response, []Ex
 		return nil, nil, err
 	}
 
+	s.metrics.observe(r, nil, []Response{response})
+
 	if !s.shouldCacheResponse(ctx, response) {
 		return response, []Extent{}, nil
 	}
@@ -300,9 +493,19 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		return nil, nil, err
 	}
 	if len(requests) == 0 {
+		s.metrics.observe(r, responses, nil)
 		response, err := s.merger.MergeResponse(responses...)
-		// No downstream requests so no need to write back to the cache.
-		return response, nil, err
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// No downstream requests so no need to write back to the cache, unless the
+		// cached extents can be compacted.
+		if !s.hasMergeableExtents(extents, r.GetStep()) {
+			return response, nil, nil
+		}
+		mergedExtents, err := s.mergeExtents(ctx, extents, r.GetStep())
+		return response, mergedExtents, err
 	}
 
 	reqResps, err = DoRequests(ctx, s.next, requests, s.limits)
@@ -310,6 +513,12 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		return nil, nil, err
 	}
 
+	fetched := make([]Response, 0, len(reqResps))
+	for _, reqResp := range reqResps {
+		fetched = append(fetched, reqResp.Response)
+	}
+	s.metrics.observe(r, responses, fetched)
+
 	for _, reqResp := range reqResps {
 		responses = append(responses, reqResp.Response)
 		if !s.shouldCacheResponse(ctx, reqResp.Response) {
@@ -325,22 +534,53 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		return extents[i].Start < extents[j].Start
 	})
 
-	// Merge any extents - they're guaranteed not to overlap.
-	accumulator, err := newAccumulator(extents[0])
+	mergedExtents, err := s.mergeExtents(ctx, extents, r.GetStep())
 	if err != nil {
 		return nil, nil, err
 	}
+
+	response, err := s.merger.MergeResponse(responses...)
+	return response, mergedExtents, err
+}
+
+// canMergeExtents returns whether the next extent is adjacent to the accumulated one, and the
+// merged extent wouldn't exceed the max extent length.
+func (s resultsCache) canMergeExtents(acc, next Extent, step int64) bool {
+	if acc.End+step < next.Start {
+		return false
+	}
+	maxLength := int64(s.cfg.MaxExtentLength / time.Millisecond)
+	return maxLength <= 0 || next.End-acc.Start <= maxLength
+}
+
+// hasMergeableExtents returns whether some of the sorted extents can be merged.
+func (s resultsCache) hasMergeableExtents(extents []Extent, step int64) bool {
+	for i := 1; i < len(extents); i++ {
+		if s.canMergeExtents(extents[i-1], extents[i], step) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeExtents merges the adjacent extents, which must be sorted by start time. They're
+// guaranteed not to overlap.
+func (s resultsCache) mergeExtents(ctx context.Context, extents []Extent, step int64) ([]Extent, error) {
+	accumulator, err := newAccumulator(extents[0])
+	if err != nil {
+		return nil, err
+	}
 	mergedExtents := make([]Extent, 0, len(extents))
 
 	for i := 1; i < len(extents); i++ {
-		if accumulator.End+r.GetStep() < extents[i].Start {
+		if !s.canMergeExtents(accumulator.Extent, extents[i], step) {
 			mergedExtents, err = merge(mergedExtents, accumulator)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			accumulator, err = newAccumulator(extents[i])
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			continue
 		}
@@ -349,22 +589,16 @@ func (s resultsCache) handleHit(ctx context.Context, r Request, extents []Extent
 		accumulator.End = extents[i].End
 		currentRes, err := extents[i].toResponse()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		merged, err := s.merger.MergeResponse(accumulator.Response, currentRes)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		accumulator.Response = merged
 	}
 
-	mergedExtents, err = merge(mergedExtents, accumulator)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	response, err := s.merger.MergeResponse(responses...)
-	return response, mergedExtents, err
+	return merge(mergedExtents, accumulator)
 }
 
 type accumulator struct {
@@ -465,6 +699,14 @@ func (s resultsCache) filterRecentExtents(req Request, maxCacheFreshness time.Du
 }
 
 func (s resultsCache) get(ctx context.Context, key string) ([]Extent, bool) {
+	entry, ok := s.getEntry(ctx, key)
+	if !ok {
+		return nil, false
+	}
+	return entry.Extents, true
+}
+
+func (s resultsCache) getEntry(ctx context.Context, key string) (*CachedResponse, bool) {
 	found, bufs, _ := s.cache.Fetch(ctx, []string{cache.HashKey(key)})
 	if len(found) != 1 {
 		return nil, false
@@ -476,7 +718,7 @@ func (s resultsCache) get(ctx context.Context, key string) ([]Extent, bool) {
 
 	log.LogFields(otlog.Int("bytes", len(bufs[0])))
 
-	if err := proto.Unmarshal(bufs[0], &resp); err != nil {
+	if err := decodeCachedResponse(bufs[0], &resp); err != nil {
 		level.Error(log).Log("msg", "error unmarshalling cached value", "err", err)
 		log.Error(err)
 		return nil, false
@@ -493,20 +735,31 @@ func (s resultsCache) get(ctx context.Context, key string) ([]Extent, bool) {
 		}
 	}
 
-	return resp.Extents, true
+	return &resp, true
 }
 
 func (s resultsCache) put(ctx context.Context, key string, extents []Extent) {
 	buf, err := proto.Marshal(&CachedResponse{
-		Key:     key,
-		Extents: extents,
+		Key:       key,
+		Extents:   extents,
+		ExpiresAt: s.expiresAt(ctx, key),
 	})
 	if err != nil {
 		level.Error(s.logger).Log("msg", "error marshalling cached value", "err", err)
 		return
 	}
+	if s.cfg.Compression != compressionNone && s.cfg.Compression != "" {
+		compressed, err := compressEntry(s.cfg.Compression, buf)
+		if err != nil {
+			level.Error(s.logger).Log("msg", "error compressing cached value", "err", err)
+			return
+		}
+		s.compressionRatio.Observe(float64(len(buf)) / float64(len(compressed)))
+		buf = compressed
+	}
 
 	s.cache.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
+	s.extentsPerKey.Observe(float64(len(extents)))
 }
 
 func jaegerTraceID(ctx context.Context) string {
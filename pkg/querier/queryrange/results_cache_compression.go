@@ -0,0 +1,90 @@
+package queryrange
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+)
+
+// Supported compressions of the results cache entries.
+const (
+	compressionNone   = "none"
+	compressionSnappy = "snappy"
+	compressionGzip   = "gzip"
+)
+
+// compressedEntryMarker is the first byte of the compressed results cache entries, followed by the
+// compression of the entry. It can't be the first byte of an uncompressed entry, as protobuf field
+// numbers start at 1, nor of an entry written by the snappy cache wrapper used before, as these
+// entries are never empty. This way entries written with any compression can be read.
+const compressedEntryMarker = 0x00
+
+const (
+	entrySnappy byte = iota + 1
+	entryGzip
+)
+
+// compressEntry returns the cache entry compressed with the compression, marked with it.
+func compressEntry(compression string, buf []byte) ([]byte, error) {
+	switch compression {
+	case compressionSnappy:
+		return append([]byte{compressedEntryMarker, entrySnappy}, snappy.Encode(nil, buf)...), nil
+
+	case compressionGzip:
+		out := bytes.NewBuffer([]byte{compressedEntryMarker, entryGzip})
+		w := gzip.NewWriter(out)
+		if _, err := w.Write(buf); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+
+	default:
+		return buf, nil
+	}
+}
+
+// decodeCachedResponse decompresses the cache entry according to its marker, and unmarshals it.
+func decodeCachedResponse(buf []byte, resp *CachedResponse) error {
+	if len(buf) < 2 || buf[0] != compressedEntryMarker {
+		err := proto.Unmarshal(buf, resp)
+		if err == nil {
+			return nil
+		}
+		// The entry may have been written by the snappy cache wrapper used before.
+		decoded, snappyErr := snappy.Decode(nil, buf)
+		if snappyErr != nil {
+			return err
+		}
+		return proto.Unmarshal(decoded, resp)
+	}
+
+	switch buf[1] {
+	case entrySnappy:
+		decoded, err := snappy.Decode(nil, buf[2:])
+		if err != nil {
+			return err
+		}
+		return proto.Unmarshal(decoded, resp)
+
+	case entryGzip:
+		r, err := gzip.NewReader(bytes.NewReader(buf[2:]))
+		if err != nil {
+			return err
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return proto.Unmarshal(decoded, resp)
+
+	default:
+		return fmt.Errorf("unknown compression %d of the cache entry", buf[1])
+	}
+}
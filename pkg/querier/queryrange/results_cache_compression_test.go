@@ -0,0 +1,107 @@
+package queryrange
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+func TestDecodeCachedResponse(t *testing.T) {
+	expected := CachedResponse{Key: "key", Extents: []Extent{mkExtent(10, 20)}}
+	buf, err := proto.Marshal(&expected)
+	require.NoError(t, err)
+
+	snappyEntry, err := compressEntry(compressionSnappy, buf)
+	require.NoError(t, err)
+	gzipEntry, err := compressEntry(compressionGzip, buf)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		entry       []byte
+		expectedErr string
+	}{
+		"uncompressed": {entry: buf},
+		"snappy":       {entry: snappyEntry},
+		"gzip":         {entry: gzipEntry},
+		// Written by the snappy cache wrapper, without marker.
+		"legacy snappy":       {entry: snappy.Encode(nil, buf)},
+		"unknown compression": {entry: []byte{compressedEntryMarker, 42, 1, 2}, expectedErr: "unknown compression 42 of the cache entry"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var resp CachedResponse
+			err := decodeCachedResponse(tc.entry, &resp)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, expected, resp)
+		})
+	}
+}
+
+func TestResultsCache_MixedCompressions(t *testing.T) {
+	c := cache.NewMockCache()
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	calls := 0
+	next := HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		calls++
+		return parsedResponse, nil
+	})
+	newResultsCache := func(compression string, reg prometheus.Registerer) Handler {
+		rcm, _, err := NewResultsCacheMiddleware(
+			log.NewNopLogger(),
+			ResultsCacheConfig{CacheConfig: cache.Config{Cache: c}, Compression: compression},
+			constSplitter(day),
+			fakeLimits{},
+			PrometheusCodec,
+			PrometheusResponseExtractor{},
+			nil,
+			nil,
+			reg,
+		)
+		require.NoError(t, err)
+		return rcm.Wrap(next)
+	}
+
+	// The entry written by a gzip results cache is read by the others.
+	reg := prometheus.NewPedanticRegistry()
+	_, err := newResultsCache(compressionGzip, reg).Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+	var ratios uint64
+	for _, m := range metrics {
+		if m.GetName() == "cortex_frontend_results_cache_compression_ratio" {
+			ratios = m.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	assert.Equal(t, uint64(1), ratios)
+
+	for _, compression := range []string{compressionNone, compressionSnappy, compressionGzip} {
+		resp, err := newResultsCache(compression, nil).Do(ctx, parsedRequest)
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls, compression)
+		assert.Equal(t, parsedResponse, resp, compression)
+	}
+}
+
+func TestResultsCacheConfig_Validate_Compression(t *testing.T) {
+	for _, compression := range []string{"", compressionNone, compressionSnappy, compressionGzip} {
+		cfg := ResultsCacheConfig{Compression: compression, CacheConfig: cache.Config{Cache: cache.NewMockCache()}}
+		assert.NoError(t, cfg.Validate(), compression)
+	}
+	cfg := ResultsCacheConfig{Compression: "zstd", CacheConfig: cache.Config{Cache: cache.NewMockCache()}}
+	assert.EqualError(t, cfg.Validate(), "unsupported compression type: zstd")
+}
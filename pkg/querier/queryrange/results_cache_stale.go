@@ -0,0 +1,214 @@
+package queryrange
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+const warnStaleResults = "the query failed, so its results were served from an expired results cache entry: they may be stale or incomplete"
+
+// staleRefreshTimeout bounds the refreshes of the expired entries, as they don't have the deadline of
+// the query which triggered them. It's the default -querier.timeout.
+const staleRefreshTimeout = 2 * time.Minute
+
+// cacheExpiration returns the shortest expiration of the cache backends, or 0 if their entries don't expire.
+func cacheExpiration(cfg cache.Config) time.Duration {
+	expirations := []time.Duration{}
+	if cfg.EnableFifoCache {
+		expirations = append(expirations, cfg.Fifocache.Validity)
+	}
+	if cfg.MemcacheClient.Host != "" || cfg.MemcacheClient.Addresses != "" {
+		expirations = append(expirations, cfg.Memcache.Expiration)
+	}
+	if cfg.Redis.Endpoint != "" {
+		expirations = append(expirations, cfg.Redis.Expiration)
+	}
+	if len(expirations) == 0 {
+		return cfg.DefaultValidity
+	}
+
+	var shortest time.Duration
+	for _, expiration := range expirations {
+		if expiration == 0 {
+			expiration = cfg.DefaultValidity
+		}
+		if expiration > 0 && (shortest == 0 || expiration < shortest) {
+			shortest = expiration
+		}
+	}
+	return shortest
+}
+
+// extendCacheExpiration returns the cache config with the expiration of its backends extended by the stale
+// max age, so that the expired entries are kept for that long.
+func extendCacheExpiration(cfg cache.Config, staleMaxAge time.Duration) cache.Config {
+	extend := func(expiration time.Duration) time.Duration {
+		if expiration == 0 {
+			expiration = cfg.DefaultValidity
+		}
+		if expiration == 0 {
+			return 0
+		}
+		return expiration + staleMaxAge
+	}
+	cfg.Fifocache.Validity = extend(cfg.Fifocache.Validity)
+	cfg.Memcache.Expiration = extend(cfg.Memcache.Expiration)
+	cfg.Redis.Expiration = extend(cfg.Redis.Expiration)
+	return cfg
+}
+
+// expiresAt returns the time the entry of the key written now expires at, or 0 if the entries don't expire.
+// The jitter is derived from the key the cache backends store, prefixed with the cache generation number
+// if any, so that they evict the entry at the same time, once it's been stale for the max age.
+func (s resultsCache) expiresAt(ctx context.Context, key string) int64 {
+	if s.expiration <= 0 {
+		return 0
+	}
+	storedKey := cache.HashKey(key)
+	if s.cacheGenNumberLoader != nil {
+		storedKey = cache.ExtractCacheGenNumber(ctx) + storedKey
+	}
+	return int64(model.Now().Add(cache.JitteredExpiration(storedKey, s.expiration, s.cfg.TTLJitter)))
+}
+
+// expired returns whether the entry is expired, and so must only be served if the downstream fails.
+func (s resultsCache) expired(entry *CachedResponse) bool {
+	return entry.ExpiresAt > 0 && int64(model.Now()) >= entry.ExpiresAt
+}
+
+// canServeStale returns whether the query failing with the error can be served from an expired entry: the
+// errors caused by the query itself and the cancelled queries are returned as is.
+func canServeStale(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if resp, ok := httpgrpc.HTTPResponseFromError(err); ok && resp.Code/100 == 4 {
+		return false
+	}
+	return true
+}
+
+// handleStale serves the query from the extents of the expired entry, with a warning, and refreshes the
+// entry in the background. The error of the query is returned if the entry doesn't cover it.
+func (s resultsCache) handleStale(ctx context.Context, r Request, key string, extents []Extent, queryErr error) (Response, error) {
+	_, responses, err := partition(r, extents, s.extractor)
+	if err != nil || len(responses) == 0 {
+		return nil, queryErr
+	}
+	response, err := s.merger.MergeResponse(responses...)
+	if err != nil {
+		return nil, queryErr
+	}
+	if promResponse, ok := response.(*PrometheusResponse); ok {
+		promResponse.Warnings = append(promResponse.Warnings, warnStaleResults)
+	}
+
+	level.Warn(s.logger).Log("msg", "serving the query from an expired results cache entry", "query", r.GetQuery(), "err", queryErr)
+	s.staleResponses.Inc()
+	s.refresh(ctx, r, key)
+	return response, nil
+}
+
+// refresh executes the query in the background and stores its results, unless the entry of the key is
+// already being refreshed.
+func (s resultsCache) refresh(ctx context.Context, r Request, key string) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return
+	}
+
+	refreshCtx, done, ok := s.refreshes.start(key)
+	if !ok {
+		return
+	}
+	refreshCtx = user.InjectOrgID(refreshCtx, userID)
+	if gen := cache.ExtractCacheGenNumber(ctx); gen != "" {
+		refreshCtx = cache.InjectCacheGenNumber(refreshCtx, gen)
+	}
+
+	go func() {
+		defer done()
+
+		_, extents, err := s.handleMiss(refreshCtx, r)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to refresh the expired results cache entry", "query", r.GetQuery(), "err", err)
+			return
+		}
+		if len(extents) == 0 {
+			return
+		}
+		extents, err = s.filterRecentExtents(r, s.limits.MaxCacheFreshness(userID), extents)
+		if err != nil {
+			return
+		}
+		s.put(refreshCtx, key, extents)
+	}()
+}
+
+// staleRefreshes tracks the refreshes of the expired entries running in the background, at most one
+// per key, so that they're cancelled when the cache is stopped.
+type staleRefreshes struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mtx     sync.Mutex
+	keys    map[string]struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newStaleRefreshes() *staleRefreshes {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &staleRefreshes{ctx: ctx, cancel: cancel, keys: map[string]struct{}{}}
+}
+
+// start returns the context the refresh of the key runs on, and the function to call once it's done.
+// It returns false if the key is already being refreshed, or if the refreshes are stopped.
+func (r *staleRefreshes) start(key string) (context.Context, func(), bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.keys[key]; ok || r.stopped {
+		return nil, nil, false
+	}
+	r.keys[key] = struct{}{}
+	r.wg.Add(1)
+
+	ctx, cancel := context.WithTimeout(r.ctx, staleRefreshTimeout)
+	return ctx, func() {
+		cancel()
+		r.mtx.Lock()
+		delete(r.keys, key)
+		r.mtx.Unlock()
+		r.wg.Done()
+	}, true
+}
+
+// stop cancels the refreshes in progress, waiting for them to return, and prevents new ones.
+func (r *staleRefreshes) stop() {
+	r.mtx.Lock()
+	r.stopped = true
+	r.mtx.Unlock()
+
+	r.cancel()
+	r.wg.Wait()
+}
+
+// refreshesStoppingCache stops the refreshes of the expired entries before the cache, so that they
+// don't outlive it.
+type refreshesStoppingCache struct {
+	cache.Cache
+	refreshes *staleRefreshes
+}
+
+func (c *refreshesStoppingCache) Stop() {
+	c.refreshes.stop()
+	c.Cache.Stop()
+}
@@ -0,0 +1,252 @@
+package queryrange
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestCacheExpiration(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg                cache.Config
+		expectedExpiration time.Duration
+		expectedExtended   cache.Config
+	}{
+		"injected cache": {
+			cfg:                cache.Config{DefaultValidity: time.Hour},
+			expectedExpiration: time.Hour,
+		},
+		"no expiration": {
+			cfg:                cache.Config{EnableFifoCache: true},
+			expectedExpiration: 0,
+		},
+		"default validity": {
+			cfg:                cache.Config{EnableFifoCache: true, DefaultValidity: time.Hour},
+			expectedExpiration: time.Hour,
+		},
+		"shortest backend expiration": {
+			cfg: cache.Config{
+				EnableFifoCache: true,
+				Fifocache:       cache.FifoCacheConfig{Validity: 10 * time.Minute},
+				MemcacheClient:  cache.MemcachedClientConfig{Addresses: "memcached:11211"},
+				Memcache:        cache.MemcachedConfig{Expiration: time.Hour},
+			},
+			expectedExpiration: 10 * time.Minute,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedExpiration, cacheExpiration(tc.cfg))
+		})
+	}
+
+	extended := extendCacheExpiration(cache.Config{
+		DefaultValidity: time.Hour,
+		Memcache:        cache.MemcachedConfig{Expiration: 10 * time.Minute},
+	}, 30*time.Minute)
+	assert.Equal(t, 40*time.Minute, extended.Memcache.Expiration)
+	assert.Equal(t, 90*time.Minute, extended.Redis.Expiration)
+	assert.Equal(t, 90*time.Minute, extended.Fifocache.Validity)
+	assert.Equal(t, time.Duration(0), extendCacheExpiration(cache.Config{}, 30*time.Minute).Redis.Expiration)
+}
+
+func TestResultsCache_ServeStale(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), "1")
+	key := constSplitter(day).GenerateCacheKey("1", parsedRequest)
+
+	for name, tc := range map[string]struct {
+		staleMaxAge   time.Duration
+		downstreamErr error
+		expectedErr   error
+	}{
+		"served from the expired entry": {
+			staleMaxAge:   time.Hour,
+			downstreamErr: httpgrpc.Errorf(http.StatusServiceUnavailable, "unavailable"),
+		},
+		"served from the expired entry on a network error": {
+			staleMaxAge:   time.Hour,
+			downstreamErr: errors.New("connection refused"),
+		},
+		"not served on a query error": {
+			staleMaxAge:   time.Hour,
+			downstreamErr: httpgrpc.Errorf(http.StatusBadRequest, "bad query"),
+			expectedErr:   httpgrpc.Errorf(http.StatusBadRequest, "bad query"),
+		},
+		"disabled": {
+			downstreamErr: httpgrpc.Errorf(http.StatusServiceUnavailable, "unavailable"),
+			expectedErr:   httpgrpc.Errorf(http.StatusServiceUnavailable, "unavailable"),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := cache.NewMockCache()
+			reg := prometheus.NewPedanticRegistry()
+
+			var calls int32
+			next := HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+				// Only the first query after the expiration fails.
+				if atomic.AddInt32(&calls, 1) == 1 {
+					return nil, tc.downstreamErr
+				}
+				return parsedResponse, nil
+			})
+			rcm, _, err := NewResultsCacheMiddleware(
+				log.NewNopLogger(),
+				ResultsCacheConfig{CacheConfig: cache.Config{Cache: c, DefaultValidity: time.Hour}, StaleMaxAge: tc.staleMaxAge},
+				constSplitter(day),
+				fakeLimits{},
+				PrometheusCodec,
+				PrometheusResponseExtractor{},
+				nil,
+				nil,
+				reg,
+			)
+			require.NoError(t, err)
+			rc := rcm.Wrap(next)
+
+			// The entry expired a minute ago.
+			extent, err := toExtent(ctx, parsedRequest, parsedResponse)
+			require.NoError(t, err)
+			buf, err := proto.Marshal(&CachedResponse{
+				Key:       key,
+				Extents:   []Extent{extent},
+				ExpiresAt: int64(model.Now().Add(-time.Minute)),
+			})
+			require.NoError(t, err)
+			c.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
+
+			resp, err := rc.Do(ctx, parsedRequest)
+			if tc.expectedErr != nil {
+				require.Equal(t, tc.expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, parsedResponse.Data, resp.(*PrometheusResponse).Data)
+			assert.Equal(t, []string{warnStaleResults}, resp.(*PrometheusResponse).Warnings)
+			assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+				# HELP cortex_frontend_results_cache_stale_responses_total Total number of queries served from an expired results cache entry, because they failed.
+				# TYPE cortex_frontend_results_cache_stale_responses_total counter
+				cortex_frontend_results_cache_stale_responses_total 1
+			`), "cortex_frontend_results_cache_stale_responses_total"))
+
+			// The entry is refreshed in the background.
+			test.Poll(t, time.Second, true, func() interface{} {
+				entry, ok := rc.(*resultsCache).getEntry(ctx, key)
+				return ok && !rc.(*resultsCache).expired(entry)
+			})
+			assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestResultsCache_StopCancelsRefreshes(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), "1")
+	key := constSplitter(day).GenerateCacheKey("1", parsedRequest)
+
+	var calls int32
+	refreshing := make(chan context.Context)
+	next := HandlerFunc(func(ctx context.Context, req Request) (Response, error) {
+		// The first query fails, and its refresh hangs until it's cancelled.
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, httpgrpc.Errorf(http.StatusServiceUnavailable, "unavailable")
+		}
+		refreshing <- ctx
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	c := cache.NewMockCache()
+	rcm, stoppable, err := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{CacheConfig: cache.Config{Cache: c, DefaultValidity: time.Hour}, StaleMaxAge: time.Hour}, constSplitter(day), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, nil, nil, nil)
+	require.NoError(t, err)
+	rc := rcm.Wrap(next)
+
+	extent, err := toExtent(ctx, parsedRequest, parsedResponse)
+	require.NoError(t, err)
+	buf, err := proto.Marshal(&CachedResponse{Key: key, Extents: []Extent{extent}, ExpiresAt: int64(model.Now().Add(-time.Minute))})
+	require.NoError(t, err)
+	c.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
+
+	_, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+
+	// The refresh has a deadline, and is cancelled when the cache is stopped.
+	refreshCtx := <-refreshing
+	deadline, ok := refreshCtx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(staleRefreshTimeout), deadline, time.Minute)
+
+	stoppable.Stop()
+	assert.Equal(t, context.Canceled, refreshCtx.Err())
+
+	// No refresh is started once the cache is stopped.
+	_, _, ok = rc.(*resultsCache).refreshes.start(key)
+	assert.False(t, ok)
+}
+
+func TestResultsCache_ExpiresAtWithCacheGenNumber(t *testing.T) {
+	cfg := ResultsCacheConfig{CacheConfig: cache.Config{DefaultValidity: time.Hour}, StaleMaxAge: time.Hour, TTLJitter: 0.5}
+	cfg.CacheConfig.Cache = cache.NewMockCache()
+	rcm, _, err := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, constSplitter(day), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, newMockCacheGenNumberLoader(), nil, nil)
+	require.NoError(t, err)
+	rc := rcm.Wrap(nil).(*resultsCache)
+
+	// The expiration is jittered on the key stored by the backends, prefixed with the generation number.
+	ctx := cache.InjectCacheGenNumber(user.InjectOrgID(context.Background(), "1"), "42")
+	expected := model.Now().Add(cache.JitteredExpiration("42"+cache.HashKey("key"), time.Hour, 0.5))
+	require.NotEqual(t, cache.JitteredExpiration("42"+cache.HashKey("key"), time.Hour, 0.5), cache.JitteredExpiration(cache.HashKey("key"), time.Hour, 0.5))
+
+	rc.put(ctx, "key", []Extent{mkExtent(10, 20)})
+	entry, ok := rc.getEntry(ctx, "key")
+	require.True(t, ok)
+	assert.InDelta(t, int64(expected), entry.ExpiresAt, float64(time.Second/time.Millisecond))
+}
+
+func TestResultsCache_ExpiresAt(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg      ResultsCacheConfig
+		expected bool
+	}{
+		"stale entries disabled": {
+			cfg: ResultsCacheConfig{CacheConfig: cache.Config{DefaultValidity: time.Hour}},
+		},
+		"entries without expiration": {
+			cfg: ResultsCacheConfig{StaleMaxAge: time.Hour},
+		},
+		"stale entries enabled": {
+			cfg:      ResultsCacheConfig{CacheConfig: cache.Config{DefaultValidity: time.Hour}, StaleMaxAge: time.Hour},
+			expected: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc.cfg.CacheConfig.Cache = cache.NewMockCache()
+			rcm, _, err := NewResultsCacheMiddleware(log.NewNopLogger(), tc.cfg, constSplitter(day), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, nil, nil, nil)
+			require.NoError(t, err)
+			rc := rcm.Wrap(nil).(*resultsCache)
+
+			ctx := user.InjectOrgID(context.Background(), "1")
+			rc.put(ctx, "key", []Extent{mkExtent(10, 20)})
+			entry, ok := rc.getEntry(ctx, "key")
+			require.True(t, ok)
+			if !tc.expected {
+				assert.Equal(t, int64(0), entry.ExpiresAt)
+				return
+			}
+			assert.InDelta(t, int64(model.Now().Add(time.Hour)), entry.ExpiresAt, float64(time.Minute/time.Millisecond))
+			assert.False(t, rc.expired(entry))
+		})
+	}
+}
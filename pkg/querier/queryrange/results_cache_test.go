@@ -3,12 +3,15 @@ package queryrange
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/gogo/protobuf/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -351,21 +354,76 @@ func TestPartition(t *testing.T) {
 }
 
 type fakeLimits struct {
-	maxCacheFreshness time.Duration
+	maxQueryLength      time.Duration
+	maxQueryParallelism int
+	maxQuerySplits      int
+	maxCacheFreshness   time.Duration
+	allowPartialResults bool
+	queryDownsampling   bool
+	maxSamplesPerQuery  int
+	minStep             time.Duration
+	minStepClamp        bool
+	requiredMatchers    []string
+	blockedFunctions    []string
+	blockedQueries      []string
+	downstreamURL       string
 }
 
-func (fakeLimits) MaxQueryLength(string) time.Duration {
-	return 0 // Disable.
+func (f fakeLimits) MaxQueryLength(string) time.Duration {
+	return f.maxQueryLength
 }
 
-func (fakeLimits) MaxQueryParallelism(string) int {
+func (f fakeLimits) MaxQueryParallelism(string) int {
+	if f.maxQueryParallelism > 0 {
+		return f.maxQueryParallelism
+	}
 	return 14 // Flag default.
 }
 
+func (f fakeLimits) MaxQuerySplits(string) int {
+	return f.maxQuerySplits
+}
+
+func (f fakeLimits) AllowPartialResults(string) bool {
+	return f.allowPartialResults
+}
+
+func (f fakeLimits) QueryDownsampling(string) bool {
+	return f.queryDownsampling
+}
+
+func (f fakeLimits) MaxSamplesPerQuery(string) int {
+	return f.maxSamplesPerQuery
+}
+
+func (f fakeLimits) MinStep(string) time.Duration {
+	return f.minStep
+}
+
+func (f fakeLimits) MinStepClamp(string) bool {
+	return f.minStepClamp
+}
+
+func (f fakeLimits) RequiredMatchers(string) []string {
+	return f.requiredMatchers
+}
+
+func (f fakeLimits) BlockedQueryFunctions(string) []string {
+	return f.blockedFunctions
+}
+
+func (f fakeLimits) BlockedQueries(string) []string {
+	return f.blockedQueries
+}
+
 func (f fakeLimits) MaxCacheFreshness(string) time.Duration {
 	return f.maxCacheFreshness
 }
 
+func (f fakeLimits) DownstreamURL(string) string {
+	return f.downstreamURL
+}
+
 type fakeLimitsHighMaxCacheFreshness struct {
 	fakeLimits
 }
@@ -417,6 +475,173 @@ func TestResultsCache(t *testing.T) {
 	require.Equal(t, 2, calls)
 }
 
+func TestResultsCache_HitsAndMisses(t *testing.T) {
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			Cache: cache.NewMockCache(),
+		},
+	}
+	rcm, _, err := NewResultsCacheMiddleware(
+		log.NewNopLogger(),
+		cfg,
+		constSplitter(day),
+		fakeLimits{},
+		PrometheusCodec,
+		PrometheusResponseExtractor{},
+		nil,
+		nil,
+		prometheus.NewPedanticRegistry(),
+	)
+	require.NoError(t, err)
+
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		return parsedResponse, nil
+	}))
+	metrics := rc.(*resultsCache).metrics
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// The first request isn't cached.
+	_, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.hits.WithLabelValues("range")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.misses.WithLabelValues("range")))
+
+	// The same request is entirely served from the cache.
+	_, err = rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.hits.WithLabelValues("range")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.misses.WithLabelValues("range")))
+
+	// A longer request uses the cached extent, and fetches the rest.
+	_, err = rc.Do(ctx, parsedRequest.WithStartEnd(parsedRequest.GetStart(), parsedRequest.GetEnd()+100))
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.hits.WithLabelValues("range")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.misses.WithLabelValues("range")))
+
+	// The bytes are tracked both for the cached and the fetched extents.
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.bytes))
+}
+
+func TestResultsCache_ResultAffectingParams(t *testing.T) {
+	cfg := ResultsCacheConfig{
+		CacheConfig: cache.Config{
+			Cache: cache.NewMockCache(),
+		},
+		ResultAffectingParams: []string{"lookback_delta"},
+	}
+	rcm, _, err := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, constSplitter(day), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, nil, nil, nil)
+	require.NoError(t, err)
+
+	var calls []Request
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		calls = append(calls, req)
+		return parsedResponse, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	withParams := func(params ...PrometheusRequestParam) Request {
+		req := *parsedRequest
+		req.Params = params
+		return &req
+	}
+
+	// The first request is forwarded with all its parameters.
+	_, err = rc.Do(ctx, withParams(PrometheusRequestParam{Name: "_", Values: []string{"1"}}))
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+	assert.Equal(t, []PrometheusRequestParam{{Name: "_", Values: []string{"1"}}}, calls[0].GetParams())
+
+	// The parameters not affecting the results are ignored by the cache.
+	_, err = rc.Do(ctx, withParams(PrometheusRequestParam{Name: "_", Values: []string{"2"}}))
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+
+	// The parameters affecting the results are part of the cache key.
+	_, err = rc.Do(ctx, withParams(PrometheusRequestParam{Name: "_", Values: []string{"3"}}, PrometheusRequestParam{Name: "lookback_delta", Values: []string{"1m"}}))
+	require.NoError(t, err)
+	require.Len(t, calls, 2)
+}
+
+func TestResultsCache_IgnoresCacheBusters(t *testing.T) {
+	var cfg ResultsCacheConfig
+	flagext.DefaultValues(&cfg)
+	cfg.CacheConfig.Cache = cache.NewMockCache()
+	rcm, _, err := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, constSplitter(day), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, nil, nil, nil)
+	require.NoError(t, err)
+
+	var forwarded []string
+	rc := rcm.Wrap(HandlerFunc(func(ctx context.Context, req Request) (Response, error) {
+		r, err := PrometheusCodec.EncodeRequest(ctx, req)
+		require.NoError(t, err)
+		forwarded = append(forwarded, r.URL.RawQuery)
+		return parsedResponse, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// Grafana adds the _ parameter with the current time to the queries.
+	for _, buster := range []string{"1536716898000", "1536716899000"} {
+		r, err := http.NewRequest("GET", query+"&_="+buster, nil)
+		require.NoError(t, err)
+		req, err := PrometheusCodec.DecodeRequest(ctx, r)
+		require.NoError(t, err)
+		_, err = rc.Do(ctx, req)
+		require.NoError(t, err)
+	}
+
+	// The second query is served from the cache, the first one is forwarded with the parameter.
+	require.Len(t, forwarded, 1)
+	assert.Contains(t, forwarded[0], "_=1536716898000")
+}
+
+func TestResultsCache_KeysAreStableAcrossReplicas(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// The same query may be sent with its parameters in any order.
+	var keys []string
+	for _, rawQuery := range []string{
+		"end=1536716898&query=sum%28container_memory_rss%29+by+%28namespace%29&start=1536673680&step=120&lookback_delta=1m&timeout=10s",
+		"timeout=10s&lookback_delta=1m&step=120&start=1536673680&query=sum+by+%28namespace%29+%28container_memory_rss%29&end=1536716898",
+	} {
+		r, err := http.NewRequest("GET", "/api/v1/query_range?"+rawQuery, nil)
+		require.NoError(t, err)
+		req, err := PrometheusCodec.DecodeRequest(ctx, r)
+		require.NoError(t, err)
+
+		var cfg ResultsCacheConfig
+		flagext.DefaultValues(&cfg)
+		keys = append(keys, resultsCache{cfg: cfg, splitter: constSplitter(day)}.cacheKey("1", req))
+	}
+
+	// The keys don't depend on the process, so the query-frontends sharing an external cache
+	// share its entries.
+	require.Equal(t, keys[0], keys[1])
+	assert.Equal(t, "1:sum by(namespace) (container_memory_rss):120000:17785:lookback_delta=1m:timeout=10s", keys[0])
+	assert.Equal(t, "486f9acae873e876", cache.HashKey(keys[0]))
+}
+
+func TestResultsCache_SharedAcrossReplicas(t *testing.T) {
+	var cfg ResultsCacheConfig
+	flagext.DefaultValues(&cfg)
+	cfg.CacheConfig.Cache = cache.NewMockCache()
+
+	calls := 0
+	next := HandlerFunc(func(_ context.Context, req Request) (Response, error) {
+		calls++
+		return parsedResponse, nil
+	})
+
+	// Each replica has its own middleware, and they all use the same external cache.
+	ctx := user.InjectOrgID(context.Background(), "1")
+	for i := 0; i < 2; i++ {
+		rcm, _, err := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, constSplitter(day), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, nil, nil, nil)
+		require.NoError(t, err)
+		resp, err := rcm.Wrap(next).Do(ctx, parsedRequest)
+		require.NoError(t, err)
+		require.Equal(t, parsedResponse, resp)
+	}
+	assert.Equal(t, 1, calls)
+}
+
 func TestResultsCacheRecent(t *testing.T) {
 	var cfg ResultsCacheConfig
 	flagext.DefaultValues(&cfg)
@@ -515,6 +740,77 @@ func TestResultsCacheMaxFreshness(t *testing.T) {
 	}
 }
 
+func TestResultsCache_MergesAdjacentExtents(t *testing.T) {
+	req := &PrometheusRequest{
+		Path:  "/api/v1/query_range",
+		Start: 100,
+		End:   240,
+		Step:  10,
+		Query: "foo",
+	}
+
+	for name, tc := range map[string]struct {
+		maxExtentLength time.Duration
+		cached          []Extent
+		expected        [][2]int64
+	}{
+		"adjacent extents": {
+			cached:   []Extent{mkExtent(100, 130), mkExtent(130, 160), mkExtent(160, 240)},
+			expected: [][2]int64{{100, 240}},
+		},
+		"adjacent extents exceeding the max extent length": {
+			maxExtentLength: 60 * time.Millisecond,
+			cached:          []Extent{mkExtent(100, 130), mkExtent(130, 160), mkExtent(160, 240)},
+			expected:        [][2]int64{{100, 160}, {160, 240}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cfg ResultsCacheConfig
+			flagext.DefaultValues(&cfg)
+			cfg.CacheConfig.Cache = cache.NewMockCache()
+			cfg.MaxExtentLength = tc.maxExtentLength
+
+			rcm, _, err := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, constSplitter(day), fakeLimits{}, PrometheusCodec, PrometheusResponseExtractor{}, nil, nil, nil)
+			require.NoError(t, err)
+
+			calls := 0
+			rc := rcm.Wrap(HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				calls++
+				return mkAPIResponse(r.GetStart(), r.GetEnd(), r.GetStep()), nil
+			})).(*resultsCache)
+
+			ctx := user.InjectOrgID(context.Background(), "1")
+			key := constSplitter(day).GenerateCacheKey("1", req)
+			rc.put(ctx, key, tc.cached)
+
+			resp, err := rc.Do(ctx, req)
+			require.NoError(t, err)
+			require.Equal(t, 0, calls)
+			require.Equal(t, mkAPIResponse(100, 240, 10), resp)
+
+			// The cached extents have been compacted on read.
+			extents, ok := rc.get(ctx, key)
+			require.True(t, ok)
+			actual := make([][2]int64, 0, len(extents))
+			for _, e := range extents {
+				actual = append(actual, [2]int64{e.Start, e.End})
+
+				res, err := e.toResponse()
+				require.NoError(t, err)
+				require.Equal(t, mkAPIResponse(e.Start, e.End, 10).Data, res.(*PrometheusResponse).Data)
+			}
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestResultsCache_DoesNotMergeExtentsWithGaps(t *testing.T) {
+	rc := resultsCache{}
+	extents := []Extent{mkExtent(100, 120), mkExtent(200, 220)}
+	require.False(t, rc.hasMergeableExtents(extents, 10))
+	require.True(t, rc.hasMergeableExtents(append(extents, mkExtent(230, 240)), 10))
+}
+
 func Test_resultsCache_MissingData(t *testing.T) {
 	cfg := ResultsCacheConfig{
 		CacheConfig: cache.Config{
@@ -571,14 +867,18 @@ func TestConstSplitter_generateCacheKey(t *testing.T) {
 		interval time.Duration
 		want     string
 	}{
-		{"0", &PrometheusRequest{Start: 0, Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo{}:10:0"},
-		{"<30m", &PrometheusRequest{Start: toMs(10 * time.Minute), Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo{}:10:0"},
-		{"30m", &PrometheusRequest{Start: toMs(30 * time.Minute), Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo{}:10:1"},
-		{"91m", &PrometheusRequest{Start: toMs(91 * time.Minute), Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo{}:10:3"},
-		{"0", &PrometheusRequest{Start: 0, Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo{}:10:0"},
-		{"<1d", &PrometheusRequest{Start: toMs(22 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo{}:10:0"},
-		{"4d", &PrometheusRequest{Start: toMs(4 * 24 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo{}:10:4"},
-		{"3d5h", &PrometheusRequest{Start: toMs(77 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo{}:10:3"},
+		{"0", &PrometheusRequest{Start: 0, Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo:10:0"},
+		{"<30m", &PrometheusRequest{Start: toMs(10 * time.Minute), Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo:10:0"},
+		{"30m", &PrometheusRequest{Start: toMs(30 * time.Minute), Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo:10:1"},
+		{"91m", &PrometheusRequest{Start: toMs(91 * time.Minute), Step: 10, Query: "foo{}"}, 30 * time.Minute, "fake:foo:10:3"},
+		{"0", &PrometheusRequest{Start: 0, Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo:10:0"},
+		{"<1d", &PrometheusRequest{Start: toMs(22 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo:10:0"},
+		{"4d", &PrometheusRequest{Start: toMs(4 * 24 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo:10:4"},
+		{"3d5h", &PrometheusRequest{Start: toMs(77 * time.Hour), Step: 10, Query: "foo{}"}, 24 * time.Hour, "fake:foo:10:3"},
+		{"whitespaces", &PrometheusRequest{Start: 0, Step: 10, Query: "sum( rate(foo[5m]) )"}, 24 * time.Hour, "fake:sum(rate(foo[5m])):10:0"},
+		{"matchers order", &PrometheusRequest{Start: 0, Step: 10, Query: `foo{b="2", a="1"}`}, 24 * time.Hour, `fake:foo{a="1",b="2"}:10:0`},
+		{"unparsable", &PrometheusRequest{Start: 0, Step: 10, Query: "sum(foo"}, 24 * time.Hour, "fake:sum(foo:10:0"},
+		{"extra params", &PrometheusRequest{Start: 0, Step: 10, Query: "foo", Params: []PrometheusRequestParam{{Name: "dedup", Values: []string{"false"}}, {Name: "lookback_delta", Values: []string{"1m"}}}}, 24 * time.Hour, "fake:foo:10:0:dedup=false:lookback_delta=1m"},
 	}
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("%s - %s", tt.name, tt.interval), func(t *testing.T) {
@@ -7,7 +7,6 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/weaveworks/common/httpgrpc"
 
 	"github.com/cortexproject/cortex/pkg/util"
 )
@@ -67,8 +66,7 @@ func (r retry) Do(ctx context.Context, req Request) (Response, error) {
 		}
 
 		// Retry if we get a HTTP 500 or a non-HTTP error.
-		httpResp, ok := httpgrpc.HTTPResponseFromError(err)
-		if !ok || httpResp.Code/100 == 5 {
+		if isServerError(err) {
 			lastErr = err
 			level.Error(util.WithContext(ctx, r.log)).Log("msg", "error processing request", "try", tries, "err", err)
 			continue
@@ -51,11 +51,22 @@ var (
 type Config struct {
 	SplitQueriesByInterval time.Duration `yaml:"split_queries_by_interval"`
 	SplitQueriesByDay      bool          `yaml:"split_queries_by_day"`
+	SplitQueriesByTenant   bool          `yaml:"split_queries_by_tenant"`
 	AlignQueriesWithStep   bool          `yaml:"align_queries_with_step"`
 	ResultsCacheConfig     `yaml:"results_cache"`
 	CacheResults           bool `yaml:"cache_results"`
 	MaxRetries             int  `yaml:"max_retries"`
 	ShardedQueries         bool `yaml:"parallelise_shardable_queries"`
+	QueryExplainEnabled    bool `yaml:"query_explain_enabled"`
+
+	// Middlewares are custom middlewares, eg. rewriting the queries, applied to the range queries
+	// after the limits are enforced and before they're split and cached. They can only be set
+	// programmatically.
+	Middlewares []Middleware `yaml:"-"`
+
+	// HistoricalQueryThreshold is the age beyond which the range queries are routed to the historical
+	// downstream, set by the query-frontend when it's configured. The queries are split at it.
+	HistoricalQueryThreshold time.Duration `yaml:"-"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet.
@@ -63,9 +74,11 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&cfg.MaxRetries, "querier.max-retries-per-request", 5, "Maximum number of retries for a single request; beyond this, the downstream error is returned.")
 	f.BoolVar(&cfg.SplitQueriesByDay, "querier.split-queries-by-day", false, "Deprecated: Split queries by day and execute in parallel.")
 	f.DurationVar(&cfg.SplitQueriesByInterval, "querier.split-queries-by-interval", 0, "Split queries by an interval and execute in parallel, 0 disables it. You should use an a multiple of 24 hours (same as the storage bucketing scheme), to avoid queriers downloading and processing the same chunks. This also determines how cache keys are chosen when result caching is enabled")
+	f.BoolVar(&cfg.SplitQueriesByTenant, "querier.split-queries-by-tenant", false, "Execute the range queries spanning multiple tenants (passed as tenant IDs separated by '|' in the org ID) once per tenant, and merge their results, labelling each series with the tenant it belongs to in the __tenant_id__ label. When disabled, such queries are forwarded to the queriers as they are.")
 	f.BoolVar(&cfg.AlignQueriesWithStep, "querier.align-querier-with-step", false, "Mutate incoming queries to align their start and end with their step.")
 	f.BoolVar(&cfg.CacheResults, "querier.cache-results", false, "Cache query results.")
 	f.BoolVar(&cfg.ShardedQueries, "querier.parallelise-shardable-queries", false, "Perform query parallelisations based on storage sharding configuration and query ASTs. This feature is supported only by the chunks storage engine.")
+	f.BoolVar(&cfg.QueryExplainEnabled, "frontend.query-explain-enabled", false, "Answer the requests with the "+ExplainHeader+": true header with a JSON description of how the query would be split, served from the results cache and limited, instead of executing it.")
 	cfg.ResultsCacheConfig.RegisterFlags(f)
 }
 
@@ -109,7 +122,8 @@ func (q MiddlewareFunc) Wrap(h Handler) Handler {
 	return q(h)
 }
 
-// Middleware is a higher order Handler.
+// Middleware is a higher order Handler. The middlewares are given the parsed requests, and can
+// modify them before calling the next handler, as well as the responses it returns.
 type Middleware interface {
 	Wrap(Handler) Handler
 }
@@ -159,25 +173,48 @@ func NewTripperware(
 	// Metric used to keep track of each middleware execution duration.
 	metrics := NewInstrumentMiddlewareMetrics(registerer)
 
-	queryRangeMiddleware := []Middleware{LimitsMiddleware(limits)}
+	blocker := newQueryBlocker(limits, registerer)
+
+	queryRangeMiddleware := []Middleware{
+		LimitsMiddleware(limits),
+		InstrumentMiddleware("blocked_queries", metrics), blocker,
+		InstrumentMiddleware("blocked_functions", metrics), BlockedFunctionsMiddleware(limits),
+		InstrumentMiddleware("required_matchers", metrics), RequiredMatchersMiddleware(limits),
+		InstrumentMiddleware("min_step", metrics), MinStepMiddleware(limits),
+		InstrumentMiddleware("downsample", metrics), DownsampleMiddleware(limits),
+	}
+	if len(cfg.Middlewares) > 0 {
+		queryRangeMiddleware = append(queryRangeMiddleware, InstrumentMiddleware("custom", metrics), MergeMiddlewares(cfg.Middlewares...))
+	}
+	if cfg.SplitQueriesByTenant {
+		queryRangeMiddleware = append(queryRangeMiddleware, InstrumentMiddleware("split_by_tenant", metrics), SplitByTenantMiddleware(codec))
+	}
 	if cfg.AlignQueriesWithStep {
 		queryRangeMiddleware = append(queryRangeMiddleware, InstrumentMiddleware("step_align", metrics), StepAlignMiddleware)
 	}
+	if cfg.HistoricalQueryThreshold > 0 {
+		queryRangeMiddleware = append(queryRangeMiddleware, InstrumentMiddleware("split_historical", metrics), HistoricalSplitMiddleware(cfg.HistoricalQueryThreshold, limits, codec))
+	}
 	if cfg.SplitQueriesByInterval != 0 {
 		staticIntervalFn := func(_ Request) time.Duration { return cfg.SplitQueriesByInterval }
 		queryRangeMiddleware = append(queryRangeMiddleware, InstrumentMiddleware("split_by_interval", metrics), SplitByIntervalMiddleware(staticIntervalFn, limits, codec, registerer))
 	}
 
+	queryExplainer := explainer{cfg: cfg, limits: limits, codec: codec}
+
 	var c cache.Cache
 	if cfg.CacheResults {
 		shouldCache := func(r Request) bool {
-			return !r.GetCachingOptions().Disabled
+			// The stats of cached results wouldn't match the execution of the query.
+			return !r.GetCachingOptions().Disabled && r.GetStats() == ""
 		}
 		queryCacheMiddleware, cache, err := NewResultsCacheMiddleware(log, cfg.ResultsCacheConfig, constSplitter(cfg.SplitQueriesByInterval), limits, codec, cacheExtractor, cacheGenNumberLoader, shouldCache, registerer)
 		if err != nil {
 			return nil, nil, err
 		}
 		c = cache
+		queryExplainer.shouldCache = shouldCache
+		queryExplainer.cache = queryCacheMiddleware.Wrap(nil).(*resultsCache)
 		queryRangeMiddleware = append(queryRangeMiddleware, InstrumentMiddleware("results_cache", metrics), queryCacheMiddleware)
 	}
 
@@ -222,9 +259,33 @@ func NewTripperware(
 				if err != nil {
 					return nil, err
 				}
+
+				// Explaining a query doesn't execute it, so it isn't counted.
+				if cfg.QueryExplainEnabled && isExplainRequest(r) {
+					return queryExplainer.RoundTrip(r)
+				}
 				queriesPerTenant.WithLabelValues(op, user).Inc()
 
-				if !isQueryRange {
+				// The middlewares only decode JSON responses, so the range queries asking for a
+				// protobuf response are passed through, without splitting nor caching. Their
+				// length is still limited.
+				if !isQueryRange || acceptsProtobuf(r) {
+					if isQueryRange || strings.HasSuffix(r.URL.Path, "/series") {
+						if err := validateSeriesQueryLength(r, limits); err != nil {
+							return nil, err
+						}
+					}
+					if isQueryRange || strings.HasSuffix(r.URL.Path, "/query") {
+						if err := blocker.validateHTTP(r); err != nil {
+							return nil, err
+						}
+						if err := validateQueryFunctionsHTTP(r, limits); err != nil {
+							return nil, err
+						}
+						if r, err = injectRequiredMatchersHTTP(r, limits); err != nil {
+							return nil, err
+						}
+					}
 					return next.RoundTrip(r)
 				}
 				return queryrange.RoundTrip(r)
@@ -234,6 +295,24 @@ func NewTripperware(
 	}, c, nil
 }
 
+// Content type of the Prometheus remote read protobuf responses.
+const protobufContentType = "application/x-protobuf"
+
+// acceptsProtobuf returns whether the client accepts protobuf responses.
+func acceptsProtobuf(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if i := strings.Index(mediaType, ";"); i >= 0 {
+				mediaType = mediaType[:i]
+			}
+			if strings.EqualFold(strings.TrimSpace(mediaType), protobufContentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type roundTripper struct {
 	next    http.RoundTripper
 	handler Handler
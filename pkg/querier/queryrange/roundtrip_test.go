@@ -7,14 +7,17 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/util"
@@ -96,6 +99,185 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
+func TestRoundTrip_ProtobufPassthrough(t *testing.T) {
+	requests := atomic.NewInt32(0)
+	s := httptest.NewServer(
+		middleware.AuthenticateUser.Wrap(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Inc()
+				assert.Equal(t, query, r.RequestURI)
+				w.Header().Set("Content-Type", protobufContentType)
+				_, _ = w.Write([]byte("protobuf"))
+			}),
+		),
+	)
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	downstream := singleHostRoundTripper{
+		host: u.Host,
+		next: http.DefaultTransport,
+	}
+
+	tw, _, err := NewTripperware(Config{SplitQueriesByInterval: time.Hour},
+		util.Logger,
+		fakeLimits{},
+		PrometheusCodec,
+		nil,
+		chunk.SchemaConfig{},
+		promql.EngineOpts{},
+		0,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", query, http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/x-protobuf;q=1, application/json;q=0.5")
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	req = req.WithContext(ctx)
+	require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+
+	// The query isn't split, and the response is returned unchanged.
+	resp, err := tw(downstream).RoundTrip(req)
+	require.NoError(t, err)
+	bs, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "protobuf", string(bs))
+	assert.Equal(t, protobufContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestAcceptsProtobuf(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                       false,
+		"application/json":       false,
+		"application/x-protobuf": true,
+		"Application/X-Protobuf": true,
+		"application/json, application/x-protobuf;proto=prometheus.ReadResponse": true,
+	} {
+		r := httptest.NewRequest("GET", "/api/v1/query_range", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		assert.Equal(t, expected, acceptsProtobuf(r), accept)
+	}
+}
+
+func TestRoundTrip_CustomMiddlewares(t *testing.T) {
+	var downstreamQuery string
+	downstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		downstreamQuery = r.URL.Query().Get("query")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"status":"success","data":{"resultType":"matrix","result":[]}}`)),
+		}, nil
+	})
+
+	// Rewrites the query, and annotates the response.
+	rewrite := MiddlewareFunc(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, r Request) (Response, error) {
+			resp, err := next.Do(ctx, r.WithQuery(`sum(rate(foo{namespace="team-a"}[1m]))`))
+			if err != nil {
+				return nil, err
+			}
+			resp.(*PrometheusResponse).Warnings = append(resp.(*PrometheusResponse).Warnings, "rewritten")
+			return resp, nil
+		})
+	})
+
+	tw, _, err := NewTripperware(Config{Middlewares: []Middleware{rewrite}},
+		util.Logger,
+		fakeLimits{},
+		PrometheusCodec,
+		nil,
+		chunk.SchemaConfig{},
+		promql.EngineOpts{},
+		0,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", query, http.NoBody)
+	require.NoError(t, err)
+	ctx := user.InjectOrgID(context.Background(), "1")
+	req = req.WithContext(ctx)
+	require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+
+	resp, err := tw(downstream).RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `sum(rate(foo{namespace="team-a"}[1m]))`, downstreamQuery)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"warnings":["rewritten"]`)
+}
+
+func TestRoundTrip_InstantQueryPassthrough(t *testing.T) {
+	var downstreamRawQuery, downstreamBody string
+	downstream := RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+		downstreamRawQuery = r.URL.RawQuery
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		downstreamBody = string(body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"status":"success","data":{"resultType":"vector","result":[]}}`)),
+		}, nil
+	})
+
+	tw, _, err := NewTripperware(Config{AlignQueriesWithStep: true, SplitQueriesByInterval: time.Hour},
+		util.Logger,
+		fakeLimits{requiredMatchers: []string{`cluster="x"`}},
+		PrometheusCodec,
+		nil,
+		chunk.SchemaConfig{},
+		promql.EngineOpts{},
+		0,
+		nil,
+		nil,
+	)
+	require.NoError(t, err)
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// The time isn't aligned nor re-encoded, only the query gets the required matchers.
+	const params = "time=2015-07-01T20:10:51.781Z&query=up&timeout=1m"
+	const expected = "time=2015-07-01T20:10:51.781Z&query=up%7Bcluster%3D%22x%22%7D&timeout=1m"
+
+	t.Run("GET", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/query?"+params, http.NoBody)
+		require.NoError(t, err)
+		req = req.WithContext(ctx)
+		require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+
+		resp, err := tw(downstream).RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, expected, downstreamRawQuery)
+	})
+
+	t.Run("POST", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/api/v1/query", strings.NewReader(params))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(ctx)
+		require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+
+		resp, err := tw(downstream).RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, expected, downstreamBody)
+	})
+}
+
 type singleHostRoundTripper struct {
 	host string
 	next http.RoundTripper
@@ -2,10 +2,28 @@ package queryrange
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+const (
+	errTooManySplits = "the query would be split into too many sub-queries (sub-queries: %d, limit: %d)"
+
+	warnPartialResults = "partial results: the sub-queries for the time ranges %s failed and have been omitted from the results: %s"
 )
 
 type IntervalFn func(r Request) time.Duration
@@ -23,6 +41,11 @@ func SplitByIntervalMiddleware(interval IntervalFn, limits Limits, merger Merger
 				Name:      "frontend_split_queries_total",
 				Help:      "Total number of underlying query requests after the split by interval is applied",
 			}),
+			partialResultsCounter: promauto.With(registerer).NewCounter(prometheus.CounterOpts{
+				Namespace: "cortex",
+				Name:      "frontend_partial_results_total",
+				Help:      "Total number of queries returning partial results, because some of the sub-queries they were split into failed.",
+			}),
 		}
 	})
 }
@@ -34,32 +57,106 @@ type splitByInterval struct {
 	interval IntervalFn
 
 	// Metrics.
-	splitByCounter prometheus.Counter
+	splitByCounter        prometheus.Counter
+	partialResultsCounter prometheus.Counter
 }
 
 func (s splitByInterval) Do(ctx context.Context, r Request) (Response, error) {
 	// First we're going to build new requests, one for each day, taking care
 	// to line up the boundaries with step.
 	reqs := splitQuery(r, s.interval(r))
+
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	if maxSplits := validation.SmallestPositiveIntPerTenant(tenantIDs, s.limits.MaxQuerySplits); maxSplits > 0 && len(reqs) > maxSplits {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, errTooManySplits, len(reqs), maxSplits)
+	}
 	s.splitByCounter.Add(float64(len(reqs)))
 
-	reqResps, err := DoRequests(ctx, s.next, reqs, s.limits)
+	// The results of the successful sub-queries are returned if the others fail, when allowed for all the tenants.
+	allowPartialResults := validation.AllTrueBooleansPerTenant(tenantIDs, s.limits.AllowPartialResults)
+	reqResps, failures, err := doRequests(ctx, tracedSplits(s.next, reqs), reqs, s.limits, allowPartialResults)
 	if err != nil {
 		return nil, err
 	}
+	if len(failures) > 0 && len(reqResps) == 0 {
+		return nil, failures[0].Err
+	}
 
 	resps := make([]Response, 0, len(reqResps))
 	for _, reqResp := range reqResps {
 		resps = append(resps, reqResp.Response)
 	}
 
+	span, _ := opentracing.StartSpanFromContext(ctx, "query-frontend merge")
+	defer span.Finish()
+
 	response, err := s.merger.MergeResponse(resps...)
 	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(err))
 		return nil, err
 	}
+
+	if len(failures) > 0 {
+		promResponse, ok := response.(*PrometheusResponse)
+		if !ok {
+			return nil, failures[0].Err
+		}
+		promResponse.Warnings = append(promResponse.Warnings, partialResultsWarning(failures))
+		s.partialResultsCounter.Inc()
+	}
 	return response, nil
 }
 
+// partialResultsWarning returns the warning listing the time ranges of the failed sub-queries.
+func partialResultsWarning(failures []RequestError) string {
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Request.GetStart() < failures[j].Request.GetStart()
+	})
+
+	ranges := make([]string, 0, len(failures))
+	for _, f := range failures {
+		ranges = append(ranges, fmt.Sprintf("[%s, %s]", formatTimestamp(f.Request.GetStart()), formatTimestamp(f.Request.GetEnd())))
+	}
+
+	msg := failures[0].Err.Error()
+	if resp, ok := httpgrpc.HTTPResponseFromError(failures[0].Err); ok {
+		msg = string(resp.Body)
+	}
+	return fmt.Sprintf(warnPartialResults, strings.Join(ranges, ", "), msg)
+}
+
+func formatTimestamp(t int64) string {
+	return timestamp.Time(t).UTC().Format(time.RFC3339)
+}
+
+// tracedSplits wraps the handler so that each of the split requests is executed in its own span,
+// named after the position of the request in the split.
+func tracedSplits(next Handler, reqs []Request) Handler {
+	positions := make(map[Request]int, len(reqs))
+	for i, req := range reqs {
+		positions[req] = i + 1
+	}
+
+	return HandlerFunc(func(ctx context.Context, r Request) (Response, error) {
+		span, ctx := opentracing.StartSpanFromContext(ctx, fmt.Sprintf("query-frontend split %d/%d", positions[r], len(reqs)))
+		defer span.Finish()
+
+		span.SetTag("start", timestamp.Time(r.GetStart()).String())
+		span.SetTag("end", timestamp.Time(r.GetEnd()).String())
+
+		resp, err := next.Do(ctx, r)
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogFields(otlog.Error(err))
+		}
+		return resp, err
+	})
+}
+
 func splitQuery(r Request, interval time.Duration) []Request {
 	var reqs []Request
 	for start := r.GetStart(); start < r.GetEnd(); start = nextIntervalBoundary(start, r.GetStep(), interval) + r.GetStep() {
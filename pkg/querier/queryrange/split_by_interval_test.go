@@ -2,18 +2,29 @@ package queryrange
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/uber/jaeger-client-go"
+	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/user"
 	"go.uber.org/atomic"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
 )
 
 const seconds = 1e3 // 1e3 milliseconds per second.
@@ -96,6 +107,32 @@ func TestSplitQuery(t *testing.T) {
 			},
 			interval: 3 * time.Hour,
 		},
+		{
+			input: &PrometheusRequest{
+				Start:  0,
+				End:    2 * 24 * 3600 * seconds,
+				Step:   15 * seconds,
+				Query:  "foo",
+				Params: []PrometheusRequestParam{{Name: "lookback_delta", Values: []string{"1m"}}},
+			},
+			expected: []Request{
+				&PrometheusRequest{
+					Start:  0,
+					End:    (24 * 3600 * seconds) - (15 * seconds),
+					Step:   15 * seconds,
+					Query:  "foo",
+					Params: []PrometheusRequestParam{{Name: "lookback_delta", Values: []string{"1m"}}},
+				},
+				&PrometheusRequest{
+					Start:  24 * 3600 * seconds,
+					End:    2 * 24 * 3600 * seconds,
+					Step:   15 * seconds,
+					Query:  "foo",
+					Params: []PrometheusRequestParam{{Name: "lookback_delta", Values: []string{"1m"}}},
+				},
+			},
+			interval: day,
+		},
 		{
 			input: &PrometheusRequest{
 				Start: 0,
@@ -298,3 +335,332 @@ func TestSplitByDay(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitByInterval_MultiDayQueryMatchesUnsplitBaseline(t *testing.T) {
+	req := &PrometheusRequest{
+		Path:  "/api/v1/query_range",
+		Start: 3 * 3600 * seconds,
+		End:   (3*24*3600 + 5*3600) * seconds,
+		Step:  120 * seconds,
+		Query: "foo",
+	}
+
+	var (
+		mtx     sync.Mutex
+		subReqs []Request
+	)
+	downstream := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+		mtx.Lock()
+		subReqs = append(subReqs, r)
+		mtx.Unlock()
+
+		return mkAPIResponse(r.GetStart(), r.GetEnd(), r.GetStep()), nil
+	})
+
+	interval := func(_ Request) time.Duration { return day }
+	splitter := SplitByIntervalMiddleware(interval, fakeLimits{}, PrometheusCodec, nil).Wrap(downstream)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	actual, err := splitter.Do(ctx, req)
+	require.NoError(t, err)
+
+	// The query spans 4 days, so we expect one sub-query per day, each one not crossing the day boundary.
+	require.Len(t, subReqs, 4)
+	for _, r := range subReqs {
+		require.Equal(t, r.GetStart()/(24*3600*seconds), r.GetEnd()/(24*3600*seconds))
+		require.Equal(t, int64(0), (r.GetStart()-req.GetStart())%req.GetStep())
+	}
+
+	expected := mkAPIResponse(req.GetStart(), req.GetEnd(), req.GetStep())
+	require.Equal(t, expected, actual)
+}
+
+func TestSplitByInterval_Boundary(t *testing.T) {
+	req := &PrometheusRequest{Start: 0, End: 2 * 3600 * seconds, Step: 15 * 60 * seconds, Query: "foo"}
+	stale := math.Float64frombits(value.StaleNaN)
+	// End of the first split, at the last step before the interval boundary.
+	boundary := int64(3600*seconds) - req.GetStep()
+
+	// The responses of the splits also include the sample of the previous boundary, and the series is found
+	// stale at the first boundary by the second split only.
+	downstream := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+		var samples []client.Sample
+		for ts := r.GetStart() - r.GetStep(); ts <= r.GetEnd(); ts += r.GetStep() {
+			if ts < req.GetStart() {
+				continue
+			}
+			v := float64(ts)
+			if ts == boundary && ts < r.GetStart() {
+				v = stale
+			}
+			samples = append(samples, client.Sample{Value: v, TimestampMs: ts})
+		}
+		return &PrometheusResponse{
+			Status: StatusSuccess,
+			Data: PrometheusData{
+				ResultType: matrix,
+				Result:     []SampleStream{{Labels: []client.LabelAdapter{{Name: "a", Value: "b"}}, Samples: samples}},
+			},
+		}, nil
+	})
+
+	interval := func(_ Request) time.Duration { return time.Hour }
+	splitter := SplitByIntervalMiddleware(interval, fakeLimits{}, PrometheusCodec, nil).Wrap(downstream)
+	resp, err := splitter.Do(user.InjectOrgID(context.Background(), "1"), req)
+	require.NoError(t, err)
+
+	result := resp.(*PrometheusResponse).Data.Result
+	require.Len(t, result, 1)
+	require.Len(t, result[0].Samples, 9)
+	for i, sample := range result[0].Samples {
+		ts := int64(i) * req.GetStep()
+		assert.Equal(t, ts, sample.TimestampMs)
+		if ts == boundary {
+			assert.True(t, value.IsStaleNaN(sample.Value))
+		} else {
+			assert.Equal(t, float64(ts), sample.Value)
+		}
+	}
+}
+
+func TestSplitByInterval_MaxQuerySplits(t *testing.T) {
+	// The query spans 4 days, so it's split into 4 sub-queries.
+	req := &PrometheusRequest{
+		Path:  "/api/v1/query_range",
+		Start: 3 * 3600 * seconds,
+		End:   (3*24*3600 + 5*3600) * seconds,
+		Step:  120 * seconds,
+		Query: "foo",
+	}
+
+	for name, tc := range map[string]struct {
+		maxQuerySplits int
+		expectedErr    string
+	}{
+		"disabled":            {maxQuerySplits: 0},
+		"within the limit":    {maxQuerySplits: 4},
+		"exceeding the limit": {maxQuerySplits: 3, expectedErr: "the query would be split into too many sub-queries (sub-queries: 4, limit: 3)"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			calls := atomic.NewInt32(0)
+			downstream := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				calls.Inc()
+				return mkAPIResponse(r.GetStart(), r.GetEnd(), r.GetStep()), nil
+			})
+
+			interval := func(_ Request) time.Duration { return day }
+			splitter := SplitByIntervalMiddleware(interval, fakeLimits{maxQuerySplits: tc.maxQuerySplits}, PrometheusCodec, nil).Wrap(downstream)
+
+			_, err := splitter.Do(user.InjectOrgID(context.Background(), "1"), req)
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				require.Equal(t, int32(4), calls.Load())
+				return
+			}
+
+			resp, ok := httpgrpc.HTTPResponseFromError(err)
+			require.True(t, ok)
+			require.Equal(t, int32(http.StatusBadRequest), resp.Code)
+			require.Equal(t, tc.expectedErr, string(resp.Body))
+			require.Equal(t, int32(0), calls.Load())
+		})
+	}
+}
+
+func TestSplitByInterval_MaxQueryParallelism(t *testing.T) {
+	// The query spans 10 days, so it's split into 10 sub-queries.
+	req := &PrometheusRequest{
+		Path:  "/api/v1/query_range",
+		Start: 0,
+		End:   (10*24*3600 - 3600) * seconds,
+		Step:  120 * seconds,
+		Query: "foo",
+	}
+
+	var (
+		calls       = atomic.NewInt32(0)
+		inflight    = atomic.NewInt32(0)
+		maxInflight = atomic.NewInt32(0)
+	)
+	downstream := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+		calls.Inc()
+		current := inflight.Inc()
+		defer inflight.Dec()
+
+		for {
+			max := maxInflight.Load()
+			if current <= max || maxInflight.CAS(max, current) {
+				break
+			}
+		}
+
+		// Give other sub-queries the chance to run concurrently.
+		time.Sleep(20 * time.Millisecond)
+		return mkAPIResponse(r.GetStart(), r.GetEnd(), r.GetStep()), nil
+	})
+
+	interval := func(_ Request) time.Duration { return day }
+	splitter := SplitByIntervalMiddleware(interval, fakeLimits{maxQueryParallelism: 2}, PrometheusCodec, nil).Wrap(downstream)
+
+	_, err := splitter.Do(user.InjectOrgID(context.Background(), "1"), req)
+	require.NoError(t, err)
+
+	// Sub-queries exceeding the parallelism wait for the running ones to complete.
+	require.Equal(t, int32(10), calls.Load())
+	require.Equal(t, int32(2), maxInflight.Load())
+}
+
+func TestSplitByInterval_TracesEachSplit(t *testing.T) {
+	// The query spans 4 days, so it's split into 4 sub-queries.
+	req := &PrometheusRequest{
+		Path:  "/api/v1/query_range",
+		Start: 3 * 3600 * seconds,
+		End:   (3*24*3600 + 5*3600) * seconds,
+		Step:  120 * seconds,
+		Query: "foo",
+	}
+
+	for name, tc := range map[string]struct {
+		failingSplitStart int64
+		expectedSpans     []string
+	}{
+		"successful query": {
+			failingSplitStart: -1,
+			expectedSpans: []string{
+				"query-frontend split 1/4",
+				"query-frontend split 2/4",
+				"query-frontend split 3/4",
+				"query-frontend split 4/4",
+				"query-frontend merge",
+			},
+		},
+		"failed split": {
+			failingSplitStart: req.Start,
+			expectedSpans: []string{
+				"query-frontend split 1/4",
+				"query-frontend split 2/4",
+				"query-frontend split 3/4",
+				"query-frontend split 4/4",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reporter := jaeger.NewInMemoryReporter()
+			tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+			defer closer.Close()
+
+			prevTracer := opentracing.GlobalTracer()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(prevTracer)
+
+			downstream := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				if r.GetStart() == tc.failingSplitStart {
+					return nil, errors.New("split failed")
+				}
+				return mkAPIResponse(r.GetStart(), r.GetEnd(), r.GetStep()), nil
+			})
+
+			interval := func(_ Request) time.Duration { return day }
+			splitter := SplitByIntervalMiddleware(interval, fakeLimits{maxQueryParallelism: 1}, PrometheusCodec, nil).Wrap(downstream)
+
+			_, err := splitter.Do(user.InjectOrgID(context.Background(), "1"), req)
+			require.Equal(t, tc.failingSplitStart >= 0, err != nil)
+
+			// Spans are reported once finished, cancelled ones included.
+			var operations []string
+			for _, span := range reporter.GetSpans() {
+				s := span.(*jaeger.Span)
+				operations = append(operations, s.OperationName())
+
+				if s.OperationName() == "query-frontend split 1/4" {
+					assert.Equal(t, timestamp.Time(req.Start).String(), s.Tags()["start"])
+					assert.Equal(t, tc.failingSplitStart >= 0, s.Tags()["error"] == true)
+				}
+			}
+			assert.ElementsMatch(t, tc.expectedSpans, operations)
+		})
+	}
+}
+
+func TestSplitByInterval_PartialResults(t *testing.T) {
+	// The query spans 4 days, so it's split into 4 sub-queries.
+	req := &PrometheusRequest{
+		Path:  "/api/v1/query_range",
+		Start: 3 * 3600 * seconds,
+		End:   (3*24*3600 + 5*3600) * seconds,
+		Step:  120 * seconds,
+		Query: "foo",
+	}
+	splits := splitQuery(req, day)
+	require.Len(t, splits, 4)
+
+	serverErr := httpgrpc.Errorf(http.StatusInternalServerError, "querier unavailable")
+	badRequestErr := httpgrpc.Errorf(http.StatusBadRequest, "bad query")
+
+	for name, tc := range map[string]struct {
+		allowPartialResults bool
+		failures            map[int64]error
+		expectedErr         error
+		expectedWarnings    []string
+	}{
+		"disabled": {
+			failures:    map[int64]error{splits[1].GetStart(): serverErr},
+			expectedErr: serverErr,
+		},
+		"some sub-queries failing with a server error": {
+			allowPartialResults: true,
+			failures:            map[int64]error{splits[2].GetStart(): serverErr, splits[1].GetStart(): serverErr},
+			expectedWarnings: []string{
+				"partial results: the sub-queries for the time ranges [1970-01-02T00:00:00Z, 1970-01-02T23:58:00Z], [1970-01-03T00:00:00Z, 1970-01-03T23:58:00Z] failed and have been omitted from the results: querier unavailable",
+			},
+		},
+		"sub-query failing with a client error": {
+			allowPartialResults: true,
+			failures:            map[int64]error{splits[1].GetStart(): badRequestErr},
+			expectedErr:         badRequestErr,
+		},
+		"all the sub-queries failing": {
+			allowPartialResults: true,
+			failures: map[int64]error{
+				splits[0].GetStart(): serverErr,
+				splits[1].GetStart(): serverErr,
+				splits[2].GetStart(): serverErr,
+				splits[3].GetStart(): serverErr,
+			},
+			expectedErr: serverErr,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			downstream := HandlerFunc(func(_ context.Context, r Request) (Response, error) {
+				if err := tc.failures[r.GetStart()]; err != nil {
+					return nil, err
+				}
+				return mkAPIResponse(r.GetStart(), r.GetEnd(), r.GetStep()), nil
+			})
+
+			interval := func(_ Request) time.Duration { return day }
+			splitter := SplitByIntervalMiddleware(interval, fakeLimits{allowPartialResults: tc.allowPartialResults}, PrometheusCodec, nil).Wrap(downstream)
+
+			resp, err := splitter.Do(user.InjectOrgID(context.Background(), "1"), req)
+			if tc.expectedErr != nil {
+				require.Equal(t, tc.expectedErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			promResp := resp.(*PrometheusResponse)
+			require.Equal(t, StatusSuccess, promResp.Status)
+			require.Equal(t, tc.expectedWarnings, promResp.Warnings)
+
+			// Only the samples of the successful sub-queries are returned.
+			var expectedSamples int
+			for _, split := range splits {
+				if tc.failures[split.GetStart()] == nil {
+					expectedSamples += len(mkAPIResponse(split.GetStart(), split.GetEnd(), split.GetStep()).Data.Result[0].Samples)
+				}
+			}
+			require.Len(t, promResp.Data.Result, 1)
+			require.Len(t, promResp.Data.Result[0].Samples, expectedSamples)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package queryrange
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/tenant"
+)
+
+const (
+	// TenantIDLabel is the label added to the series of a query spanning multiple tenants,
+	// identifying the tenant each series belongs to.
+	TenantIDLabel = "__tenant_id__"
+
+	// An existing label clashing with TenantIDLabel is kept with this prefix.
+	originalLabelPrefix = "original_"
+)
+
+// SplitByTenantMiddleware executes the queries spanning multiple tenants once per tenant,
+// and merges their results.
+//
+// The query is evaluated independently for each tenant, so aggregations don't span tenants.
+// Each series of the merged result is labelled with the tenant it belongs to, so that
+// series with the same labels in different tenants are kept apart.
+func SplitByTenantMiddleware(merger Merger) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return splitByTenant{
+			next:   next,
+			merger: merger,
+		}
+	})
+}
+
+type splitByTenant struct {
+	next   Handler
+	merger Merger
+}
+
+func (s splitByTenant) Do(ctx context.Context, r Request) (Response, error) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	if len(tenantIDs) == 1 {
+		return s.next.Do(ctx, r)
+	}
+
+	resps := make([]Response, len(tenantIDs))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, tenantID := range tenantIDs {
+		i, tenantID := i, tenantID
+		g.Go(func() error {
+			resp, err := s.next.Do(user.InjectOrgID(gctx, tenantID), r)
+			if err != nil {
+				return err
+			}
+
+			resps[i] = withTenantIDLabel(resp, tenantID)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return s.merger.MergeResponse(resps...)
+}
+
+// withTenantIDLabel adds the tenant ID label to the series of the response.
+func withTenantIDLabel(resp Response, tenantID string) Response {
+	promResp, ok := resp.(*PrometheusResponse)
+	if !ok {
+		return resp
+	}
+
+	// The response may be shared with other requests, eg. through the results cache.
+	labelled := *promResp
+	labelled.Data.Result = make([]SampleStream, 0, len(promResp.Data.Result))
+	for _, stream := range promResp.Data.Result {
+		lbls := make([]client.LabelAdapter, 0, len(stream.Labels)+1)
+		for _, l := range stream.Labels {
+			if l.Name == TenantIDLabel {
+				l.Name = originalLabelPrefix + TenantIDLabel
+			}
+			lbls = append(lbls, l)
+		}
+		lbls = append(lbls, client.LabelAdapter{Name: TenantIDLabel, Value: tenantID})
+		sort.Slice(lbls, func(i, j int) bool {
+			return lbls[i].Name < lbls[j].Name
+		})
+
+		labelled.Data.Result = append(labelled.Data.Result, SampleStream{
+			Labels:  lbls,
+			Samples: stream.Samples,
+		})
+	}
+	return &labelled
+}
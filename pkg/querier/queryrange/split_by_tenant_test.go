@@ -0,0 +1,101 @@
+package queryrange
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+func TestSplitByTenant(t *testing.T) {
+	req := &PrometheusRequest{
+		Path:  "/api/v1/query_range",
+		Start: 0,
+		End:   60 * seconds,
+		Step:  30 * seconds,
+		Query: "up",
+	}
+
+	var (
+		mtx        sync.Mutex
+		queriedIDs []string
+	)
+	downstream := HandlerFunc(func(ctx context.Context, _ Request) (Response, error) {
+		orgID, err := user.ExtractOrgID(ctx)
+		require.NoError(t, err)
+
+		mtx.Lock()
+		queriedIDs = append(queriedIDs, orgID)
+		mtx.Unlock()
+
+		lbls := []client.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}}
+		if orgID == "b" {
+			lbls = append(lbls, client.LabelAdapter{Name: TenantIDLabel, Value: "original"})
+		}
+		return &PrometheusResponse{
+			Status: StatusSuccess,
+			Data: PrometheusData{
+				ResultType: matrix,
+				Result: []SampleStream{{
+					Labels:  lbls,
+					Samples: []client.Sample{{TimestampMs: 0, Value: 1}, {TimestampMs: 30000, Value: 1}},
+				}},
+			},
+		}, nil
+	})
+	splitter := SplitByTenantMiddleware(PrometheusCodec).Wrap(downstream)
+
+	t.Run("single tenant", func(t *testing.T) {
+		queriedIDs = nil
+		resp, err := splitter.Do(user.InjectOrgID(context.Background(), "a"), req)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"a"}, queriedIDs)
+		assert.Equal(t, []client.LabelAdapter{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}}, resp.(*PrometheusResponse).Data.Result[0].Labels)
+	})
+
+	t.Run("multiple tenants", func(t *testing.T) {
+		queriedIDs = nil
+		resp, err := splitter.Do(user.InjectOrgID(context.Background(), "a|b"), req)
+		require.NoError(t, err)
+
+		sort.Strings(queriedIDs)
+		assert.Equal(t, []string{"a", "b"}, queriedIDs)
+
+		result := resp.(*PrometheusResponse).Data.Result
+		require.Len(t, result, 2)
+		assert.Equal(t, []client.LabelAdapter{
+			{Name: "__name__", Value: "up"},
+			{Name: TenantIDLabel, Value: "a"},
+			{Name: "job", Value: "api"},
+		}, result[0].Labels)
+		assert.Equal(t, []client.LabelAdapter{
+			{Name: "__name__", Value: "up"},
+			{Name: TenantIDLabel, Value: "b"},
+			{Name: "job", Value: "api"},
+			{Name: "original___tenant_id__", Value: "original"},
+		}, result[1].Labels)
+		for _, stream := range result {
+			assert.Len(t, stream.Samples, 2)
+		}
+	})
+}
+
+func TestSplitByTenant_Error(t *testing.T) {
+	downstream := HandlerFunc(func(ctx context.Context, _ Request) (Response, error) {
+		if orgID, _ := user.ExtractOrgID(ctx); orgID == "b" {
+			return nil, errors.New("querier failed")
+		}
+		return &PrometheusResponse{Status: StatusSuccess, Data: PrometheusData{ResultType: matrix}}, nil
+	})
+
+	_, err := SplitByTenantMiddleware(PrometheusCodec).Wrap(downstream).Do(user.InjectOrgID(context.Background(), "a|b"), &PrometheusRequest{})
+	assert.EqualError(t, err, "querier failed")
+}
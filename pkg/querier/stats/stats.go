@@ -0,0 +1,131 @@
+// Package stats collects the resources used by the querier to execute a query, so that
+// they can be reported to the query-frontend.
+package stats
+
+import (
+	"context"
+	"math"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"go.uber.org/atomic"
+)
+
+type contextKey int
+
+var ctxKey = contextKey(0)
+
+// Stats of a query. Safe for concurrent use.
+type Stats struct {
+	fetchedSeries  atomic.Uint64
+	fetchedSamples atomic.Uint64
+}
+
+// ContextWithEmptyStats returns a context with empty stats, collected by the queries run with it.
+func ContextWithEmptyStats(ctx context.Context) (*Stats, context.Context) {
+	stats := &Stats{}
+	return stats, context.WithValue(ctx, ctxKey, stats)
+}
+
+// FromContext returns the stats of the context, or nil if it doesn't collect stats.
+func FromContext(ctx context.Context) *Stats {
+	stats, _ := ctx.Value(ctxKey).(*Stats)
+	return stats
+}
+
+// AddFetchedSeries adds n to the fetched series. No-op on nil stats.
+func (s *Stats) AddFetchedSeries(n uint64) {
+	if s != nil {
+		s.fetchedSeries.Add(n)
+	}
+}
+
+// AddFetchedSamples adds n to the fetched samples. No-op on nil stats.
+func (s *Stats) AddFetchedSamples(n uint64) {
+	if s != nil {
+		s.fetchedSamples.Add(n)
+	}
+}
+
+// FetchedSeries returns the number of series fetched, 0 on nil stats.
+func (s *Stats) FetchedSeries() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.fetchedSeries.Load()
+}
+
+// FetchedSamples returns the number of samples fetched, 0 on nil stats.
+func (s *Stats) FetchedSamples() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.fetchedSamples.Load()
+}
+
+// NewCountingSeriesSet returns a SeriesSet counting the series and samples iterated in the
+// stats of the context. It returns the set unchanged if the context doesn't collect stats.
+func NewCountingSeriesSet(ctx context.Context, set storage.SeriesSet) storage.SeriesSet {
+	stats := FromContext(ctx)
+	if stats == nil {
+		return set
+	}
+	return &countingSeriesSet{SeriesSet: set, stats: stats}
+}
+
+type countingSeriesSet struct {
+	storage.SeriesSet
+	stats *Stats
+}
+
+func (s *countingSeriesSet) Next() bool {
+	if !s.SeriesSet.Next() {
+		return false
+	}
+	s.stats.AddFetchedSeries(1)
+	return true
+}
+
+func (s *countingSeriesSet) At() storage.Series {
+	return &countingSeries{Series: s.SeriesSet.At(), stats: s.stats}
+}
+
+type countingSeries struct {
+	storage.Series
+	stats *Stats
+}
+
+func (s *countingSeries) Iterator() chunkenc.Iterator {
+	return &countingIterator{Iterator: s.Series.Iterator(), stats: s.stats, last: math.MinInt64}
+}
+
+// countingIterator counts the distinct samples the iterator is positioned at. Seeking to the
+// current sample, as done by PromQL for each step, doesn't count it again.
+type countingIterator struct {
+	chunkenc.Iterator
+	stats *Stats
+	last  int64
+}
+
+func (it *countingIterator) Next() bool {
+	if !it.Iterator.Next() {
+		return false
+	}
+	it.count()
+	return true
+}
+
+func (it *countingIterator) Seek(t int64) bool {
+	if !it.Iterator.Seek(t) {
+		return false
+	}
+	it.count()
+	return true
+}
+
+func (it *countingIterator) count() {
+	if t, _ := it.Iterator.At(); t != it.last {
+		it.last = t
+		it.stats.AddFetchedSamples(1)
+	}
+}
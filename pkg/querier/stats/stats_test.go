@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/querier/series"
+)
+
+func TestNewCountingSeriesSet(t *testing.T) {
+	set := func() *series.ConcreteSeriesSet {
+		return series.NewConcreteSeriesSet([]storage.Series{
+			series.NewConcreteSeries(labels.FromStrings("a", "1"), []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 3}}),
+			series.NewConcreteSeries(labels.FromStrings("a", "2"), []model.SamplePair{{Timestamp: 1, Value: 1}}),
+		}).(*series.ConcreteSeriesSet)
+	}
+
+	// Without stats, the set is returned unchanged.
+	s := set()
+	assert.Equal(t, s, NewCountingSeriesSet(context.Background(), s))
+
+	stats, ctx := ContextWithEmptyStats(context.Background())
+	counting := NewCountingSeriesSet(ctx, set())
+
+	require.True(t, counting.Next())
+	it := counting.At().Iterator()
+	require.True(t, it.Seek(2))
+	// Seeking to the current sample doesn't count it again.
+	require.True(t, it.Seek(2))
+	require.True(t, it.Next())
+	require.False(t, it.Next())
+
+	require.True(t, counting.Next())
+	require.False(t, counting.Next())
+
+	assert.Equal(t, uint64(2), stats.FetchedSeries())
+	assert.Equal(t, uint64(2), stats.FetchedSamples())
+	assert.Equal(t, stats, FromContext(ctx))
+}
+
+func TestStats_Nil(t *testing.T) {
+	var stats *Stats
+	stats.AddFetchedSeries(1)
+	stats.AddFetchedSamples(1)
+	assert.Equal(t, uint64(0), stats.FetchedSeries())
+	assert.Equal(t, uint64(0), stats.FetchedSamples())
+	assert.Nil(t, FromContext(context.Background()))
+}
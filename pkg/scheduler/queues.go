@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"math"
 	"math/rand"
 	"sort"
 
@@ -31,7 +32,8 @@ type userQueue struct {
 	// If not nil, only these queriers can handle user requests. If nil, all queriers can.
 	// We set this to nil if number of available queriers <= maxQueriers.
 	queriers    map[string]struct{}
-	maxQueriers int
+	maxQueriers float64
+	minQueriers int
 
 	// Seed for shuffle sharding of queriers. This seed is based on userID only and is therefore consistent
 	// between different frontends.
@@ -73,8 +75,10 @@ func (q *queues) deleteQueue(userID string) {
 // Returns existing or new queue for user.
 // MaxQueriers is used to compute which queriers should handle requests for this user.
 // If maxQueriers is <= 0, all queriers can handle this user's requests.
-// If maxQueriers has changed since the last call, queriers for this are recomputed.
-func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan *schedulerRequest {
+// If maxQueriers is < 1, it's the fraction of the connected queriers which can handle this user's requests,
+// and at least minQueriers of them do.
+// If maxQueriers or minQueriers have changed since the last call, queriers for this are recomputed.
+func (q *queues) getOrAddQueue(userID string, maxQueriers float64, minQueriers int) chan *schedulerRequest {
 	// Empty user is not allowed, as that would break our users list ("" is used for free spot).
 	if userID == "" {
 		return nil
@@ -110,9 +114,10 @@ func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan *schedulerRe
 		}
 	}
 
-	if uq.maxQueriers != maxQueriers {
+	if uq.maxQueriers != maxQueriers || uq.minQueriers != minQueriers {
 		uq.maxQueriers = maxQueriers
-		uq.queriers = shuffleQueriersForUser(uq.seed, maxQueriers, q.sortedQueriers, nil)
+		uq.minQueriers = minQueriers
+		uq.queriers = shuffleQueriersForUser(uq.seed, queriersToSelect(maxQueriers, minQueriers, len(q.sortedQueriers)), q.sortedQueriers, nil)
 	}
 
 	return uq.ch
@@ -193,10 +198,32 @@ func (q *queues) recomputeUserQueriers() {
 	scratchpad := make([]string, 0, len(q.sortedQueriers))
 
 	for _, uq := range q.userQueues {
-		uq.queriers = shuffleQueriersForUser(uq.seed, uq.maxQueriers, q.sortedQueriers, scratchpad)
+		uq.queriers = shuffleQueriersForUser(uq.seed, queriersToSelect(uq.maxQueriers, uq.minQueriers, len(q.sortedQueriers)), q.sortedQueriers, scratchpad)
 	}
 }
 
+// queriersToSelect returns the number of queriers which should handle the requests of a user, given the
+// configured max and min queriers and the number of connected queriers. 0 means all queriers.
+func queriersToSelect(maxQueriers float64, minQueriers, connectedQueriers int) int {
+	if maxQueriers <= 0 {
+		return 0
+	}
+	if maxQueriers >= 1 {
+		return int(maxQueriers)
+	}
+
+	// A fraction of the connected queriers, rounded up so that at least one querier is selected, and
+	// floored at the min queriers as long as enough queriers are connected.
+	selected := int(math.Ceil(maxQueriers * float64(connectedQueriers)))
+	if selected < minQueriers {
+		selected = minQueriers
+	}
+	if selected > connectedQueriers {
+		selected = connectedQueriers
+	}
+	return selected
+}
+
 // Scratchpad is used for shuffling, to avoid new allocations. If nil, new slice is allocated.
 // shuffleQueriersForUser returns nil if queriersToSelect is 0 or there are not enough queriers to select from.
 // In that case *all* queriers should be used.
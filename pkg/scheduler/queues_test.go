@@ -92,7 +92,7 @@ func TestQueuesWithQueriers(t *testing.T) {
 	// Add user queues.
 	for u := 0; u < users; u++ {
 		uid := fmt.Sprintf("user-%d", u)
-		getOrAdd(t, uq, uid, maxQueriersPerUser)
+		getOrAdd(t, uq, uid, float64(maxQueriersPerUser))
 
 		// Verify it has maxQueriersPerUser queriers assigned now.
 		qs := uq.userQueues[uid].queriers
@@ -135,6 +135,81 @@ func TestQueuesWithQueriers(t *testing.T) {
 	assert.InDelta(t, stdDev, 0, mean*0.2)
 }
 
+func TestQueuesWithFractionalMaxQueriers(t *testing.T) {
+	uq := newUserQueues(0)
+	for i := 0; i < 10; i++ {
+		uq.addQuerierConnection(fmt.Sprintf("querier-%d", i))
+	}
+
+	getOrAdd(t, uq, "user", 0.3)
+	assert.Len(t, uq.userQueues["user"].queriers, 3)
+
+	// The number of queriers follows the connected queriers.
+	for i := 10; i < 20; i++ {
+		uq.addQuerierConnection(fmt.Sprintf("querier-%d", i))
+	}
+	assert.Len(t, uq.userQueues["user"].queriers, 6)
+	assert.NoError(t, isConsistent(uq))
+
+	for i := 10; i < 15; i++ {
+		uq.removeQuerierConnection(fmt.Sprintf("querier-%d", i))
+	}
+	// 30% of 15 queriers, rounded up.
+	assert.Len(t, uq.userQueues["user"].queriers, 5)
+	assert.NoError(t, isConsistent(uq))
+}
+
+func TestQueuesWithMinQueriers(t *testing.T) {
+	uq := newUserQueues(0)
+	for i := 0; i < 3; i++ {
+		uq.addQuerierConnection(fmt.Sprintf("querier-%d", i))
+	}
+
+	// 30% of 3 queriers is 1 querier, floored at 2.
+	assert.NotNil(t, uq.getOrAddQueue("user", 0.3, 2))
+	assert.Len(t, uq.userQueues["user"].queriers, 2)
+	assert.NoError(t, isConsistent(uq))
+
+	// The queriers are recomputed when the min queriers changes.
+	assert.NotNil(t, uq.getOrAddQueue("user", 0.3, 0))
+	assert.Len(t, uq.userQueues["user"].queriers, 1)
+	assert.NoError(t, isConsistent(uq))
+
+	// All the queriers handle the requests when fewer than the min queriers are connected.
+	assert.NotNil(t, uq.getOrAddQueue("user", 0.3, 5))
+	assert.Nil(t, uq.userQueues["user"].queriers)
+	assert.NoError(t, isConsistent(uq))
+}
+
+func TestQueriersToSelect(t *testing.T) {
+	for _, tc := range []struct {
+		maxQueriers       float64
+		minQueriers       int
+		connectedQueriers int
+		expected          int
+	}{
+		{maxQueriers: 0, connectedQueriers: 10, expected: 0},
+		{maxQueriers: -1, connectedQueriers: 10, expected: 0},
+		{maxQueriers: 3, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 1, connectedQueriers: 10, expected: 1},
+		{maxQueriers: 0.3, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 0.25, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 0.01, connectedQueriers: 10, expected: 1},
+		{maxQueriers: 0.5, connectedQueriers: 0, expected: 0},
+		// The fraction is floored at the min queriers, capped at the connected queriers.
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 10, expected: 3},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 4, expected: 2},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 3, expected: 2},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 1, expected: 1},
+		{maxQueriers: 0.3, minQueriers: 2, connectedQueriers: 0, expected: 0},
+		// The min queriers only applies to a fraction.
+		{maxQueriers: 1, minQueriers: 2, connectedQueriers: 10, expected: 1},
+		{maxQueriers: 0, minQueriers: 2, connectedQueriers: 10, expected: 0},
+	} {
+		assert.Equal(t, tc.expected, queriersToSelect(tc.maxQueriers, tc.minQueriers, tc.connectedQueriers), "max queriers: %v, min queriers: %d, connected queriers: %d", tc.maxQueriers, tc.minQueriers, tc.connectedQueriers)
+	}
+}
+
 func TestQueuesConsistency(t *testing.T) {
 	uq := newUserQueues(0)
 	assert.NotNil(t, uq)
@@ -149,7 +224,7 @@ func TestQueuesConsistency(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		switch r.Int() % 6 {
 		case 0:
-			assert.NotNil(t, uq.getOrAddQueue(generateTenant(r), 3))
+			assert.NotNil(t, uq.getOrAddQueue(generateTenant(r), 3, 0))
 		case 1:
 			qid := generateQuerier(r)
 			_, _, luid := uq.getNextQueueForQuerier(lastUserIndexes[qid], qid)
@@ -166,6 +241,8 @@ func TestQueuesConsistency(t *testing.T) {
 				uq.removeQuerierConnection(q)
 				conns[q]--
 			}
+		case 5:
+			assert.NotNil(t, uq.getOrAddQueue(generateTenant(r), 0.5, 0))
 		}
 
 		assert.NoErrorf(t, isConsistent(uq), "last action %d", i)
@@ -180,11 +257,11 @@ func generateQuerier(r *rand.Rand) string {
 	return fmt.Sprint("querier-", r.Int()%5)
 }
 
-func getOrAdd(t *testing.T, uq *queues, tenant string, maxQueriers int) chan *schedulerRequest {
-	q := uq.getOrAddQueue(tenant, maxQueriers)
+func getOrAdd(t *testing.T, uq *queues, tenant string, maxQueriers float64) chan *schedulerRequest {
+	q := uq.getOrAddQueue(tenant, maxQueriers, 0)
 	assert.NotNil(t, q)
 	assert.NoError(t, isConsistent(uq))
-	assert.Equal(t, q, uq.getOrAddQueue(tenant, maxQueriers))
+	assert.Equal(t, q, uq.getOrAddQueue(tenant, maxQueriers, 0))
 	return q
 }
 
@@ -222,16 +299,18 @@ func isConsistent(uq *queues) error {
 			return fmt.Errorf("invalid user's index, expected=%d, got=%d", ix, q.index)
 		}
 
-		if q.maxQueriers == 0 && q.queriers != nil {
+		maxQueriers := queriersToSelect(q.maxQueriers, q.minQueriers, len(uq.sortedQueriers))
+
+		if maxQueriers == 0 && q.queriers != nil {
 			return fmt.Errorf("user %s has queriers, but maxQueriers=0", u)
 		}
 
-		if q.maxQueriers > 0 && len(uq.sortedQueriers) <= q.maxQueriers && q.queriers != nil {
+		if maxQueriers > 0 && len(uq.sortedQueriers) <= maxQueriers && q.queriers != nil {
 			return fmt.Errorf("user %s has queriers set despite not enough queriers available", u)
 		}
 
-		if q.maxQueriers > 0 && len(uq.sortedQueriers) > q.maxQueriers && len(q.queriers) != q.maxQueriers {
-			return fmt.Errorf("user %s has incorrect number of queriers, expected=%d, got=%d", u, len(q.queriers), q.maxQueriers)
+		if maxQueriers > 0 && len(uq.sortedQueriers) > maxQueriers && len(q.queriers) != maxQueriers {
+			return fmt.Errorf("user %s has incorrect number of queriers, expected=%d, got=%d", u, len(q.queriers), maxQueriers)
 		}
 	}
 
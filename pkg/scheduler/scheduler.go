@@ -16,8 +16,10 @@ import (
 	"go.uber.org/atomic"
 
 	"github.com/cortexproject/cortex/pkg/scheduler/schedulerpb"
+	"github.com/cortexproject/cortex/pkg/tenant"
 	chunk "github.com/cortexproject/cortex/pkg/util/grpcutil"
 	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 var (
@@ -109,8 +111,12 @@ func NewScheduler(cfg Config, limits Limits, log log.Logger, registerer promethe
 
 // Limits needed for the Query Frontend - interface used for decoupling.
 type Limits interface {
-	// Returns max queriers to use per tenant, or 0 if shuffle sharding is disabled.
-	MaxQueriersPerUser(user string) int
+	// Returns max queriers to use per tenant, the fraction of the available queriers if lower than 1,
+	// or 0 if shuffle sharding is disabled.
+	MaxQueriersPerUser(user string) float64
+
+	// Returns min queriers to use per tenant, when the max queriers is a fraction of the available queriers.
+	MinQueriersPerUser(user string) int
 }
 
 type schedulerRequest struct {
@@ -259,12 +265,18 @@ func (s *Scheduler) enqueueRequest(frontendContext context.Context, frontendAddr
 	req.enqueueTime = time.Now()
 	req.ctxCancel = cancel
 
-	maxQueriers := s.limits.MaxQueriersPerUser(userID)
+	// The limit of a query spanning multiple tenants is the strictest of the limits of its tenants.
+	tenantIDs, err := tenant.TenantIDsFromOrgID(userID)
+	if err != nil {
+		return err
+	}
+	maxQueriers := validation.SmallestPositiveFloat64PerTenant(tenantIDs, s.limits.MaxQueriersPerUser)
+	minQueriers := validation.SmallestPositiveIntPerTenant(tenantIDs, s.limits.MinQueriersPerUser)
 
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	queue := s.queues.getOrAddQueue(userID, maxQueriers)
+	queue := s.queues.getOrAddQueue(userID, maxQueriers, minQueriers)
 	if queue == nil {
 		// This can only happen if userID is "".
 		return errors.New("no queue found")
@@ -389,9 +389,13 @@ func verifyNoPendingRequestsLeft(t *testing.T, scheduler *Scheduler) {
 }
 
 type limits struct {
-	queriers int
+	queriers float64
 }
 
-func (l limits) MaxQueriersPerUser(_ string) int {
+func (l limits) MaxQueriersPerUser(_ string) float64 {
 	return l.queriers
 }
+
+func (l limits) MinQueriersPerUser(_ string) int {
+	return 0
+}
@@ -0,0 +1,62 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/weaveworks/common/user"
+)
+
+// The separator of the tenant IDs of a query spanning multiple tenants, eg. "tenant-a|tenant-b".
+const tenantIDsSeparator = "|"
+
+var errEmptyTenantID = errors.New("tenant ID must not be empty")
+
+// TenantIDs returns the sorted and de-duplicated IDs of the tenants in the context. A query
+// spanning multiple tenants carries all of them in the org ID, separated by '|'.
+func TenantIDs(ctx context.Context) ([]string, error) {
+	orgID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return TenantIDsFromOrgID(orgID)
+}
+
+// TenantIDsFromOrgID returns the sorted and de-duplicated IDs of the tenants in the org ID.
+func TenantIDsFromOrgID(orgID string) ([]string, error) {
+	tenantIDs := strings.Split(orgID, tenantIDsSeparator)
+	for _, tenantID := range tenantIDs {
+		if tenantID == "" {
+			return nil, errEmptyTenantID
+		}
+	}
+
+	sort.Strings(tenantIDs)
+
+	// De-duplicate the sorted tenant IDs in place.
+	unique := tenantIDs[:1]
+	for _, tenantID := range tenantIDs[1:] {
+		if tenantID != unique[len(unique)-1] {
+			unique = append(unique, tenantID)
+		}
+	}
+	return unique, nil
+}
+
+// JoinTenantIDs returns the org ID of a query spanning the given tenants.
+func JoinTenantIDs(tenantIDs []string) string {
+	return strings.Join(tenantIDs, tenantIDsSeparator)
+}
+
+// NormalizeOrgID returns the org ID with its tenant IDs sorted and de-duplicated, so that
+// queries spanning the same tenants share the same org ID.
+func NormalizeOrgID(orgID string) (string, error) {
+	tenantIDs, err := TenantIDsFromOrgID(orgID)
+	if err != nil {
+		return "", err
+	}
+	return JoinTenantIDs(tenantIDs), nil
+}
@@ -0,0 +1,50 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestTenantIDsFromOrgID(t *testing.T) {
+	for orgID, tc := range map[string]struct {
+		expected    []string
+		expectedErr error
+	}{
+		"tenant-a":                   {expected: []string{"tenant-a"}},
+		"tenant-b|tenant-a":          {expected: []string{"tenant-a", "tenant-b"}},
+		"tenant-c|tenant-a|tenant-c": {expected: []string{"tenant-a", "tenant-c"}},
+		"":                           {expectedErr: errEmptyTenantID},
+		"tenant-a||tenant-b":         {expectedErr: errEmptyTenantID},
+		"tenant-a|":                  {expectedErr: errEmptyTenantID},
+	} {
+		t.Run(orgID, func(t *testing.T) {
+			tenantIDs, err := TenantIDsFromOrgID(orgID)
+			if tc.expectedErr != nil {
+				assert.Equal(t, tc.expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, tenantIDs)
+		})
+	}
+}
+
+func TestTenantIDs(t *testing.T) {
+	_, err := TenantIDs(context.Background())
+	assert.Equal(t, user.ErrNoOrgID, err)
+
+	tenantIDs, err := TenantIDs(user.InjectOrgID(context.Background(), "b|a"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, tenantIDs)
+}
+
+func TestNormalizeOrgID(t *testing.T) {
+	orgID, err := NormalizeOrgID("c|a|b|a")
+	require.NoError(t, err)
+	assert.Equal(t, "a|b|c", orgID)
+	assert.Equal(t, orgID, JoinTenantIDs([]string{"a", "b", "c"}))
+}
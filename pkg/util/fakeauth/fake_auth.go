@@ -12,8 +12,12 @@ import (
 	"google.golang.org/grpc"
 )
 
-// SetupAuthMiddleware for the given server config.
-func SetupAuthMiddleware(config *server.Config, enabled bool, noGRPCAuthOn []string) middleware.Interface {
+// DefaultOrgID is the org ID injected by default when auth is disabled.
+const DefaultOrgID = "fake"
+
+// SetupAuthMiddleware for the given server config. When auth is disabled, the orgID is injected
+// in all the requests.
+func SetupAuthMiddleware(config *server.Config, enabled bool, orgID string, noGRPCAuthOn []string) middleware.Interface {
 	if enabled {
 		ignoredMethods := map[string]bool{}
 		for _, m := range noGRPCAuthOn {
@@ -39,33 +43,43 @@ func SetupAuthMiddleware(config *server.Config, enabled bool, noGRPCAuthOn []str
 		return middleware.AuthenticateUser
 	}
 
+	if orgID == "" {
+		orgID = DefaultOrgID
+	}
+
 	config.GRPCMiddleware = append(config.GRPCMiddleware,
-		fakeGRPCAuthUniaryMiddleware,
+		fakeGRPCAuthUniaryMiddleware(orgID),
 	)
 	config.GRPCStreamMiddleware = append(config.GRPCStreamMiddleware,
-		fakeGRPCAuthStreamMiddleware,
+		fakeGRPCAuthStreamMiddleware(orgID),
 	)
-	return fakeHTTPAuthMiddleware
+	return fakeHTTPAuthMiddleware(orgID)
 }
 
-var fakeHTTPAuthMiddleware = middleware.Func(func(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := user.InjectOrgID(r.Context(), "fake")
-		next.ServeHTTP(w, r.WithContext(ctx))
+func fakeHTTPAuthMiddleware(orgID string) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := user.InjectOrgID(r.Context(), orgID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
 	})
-})
+}
 
-var fakeGRPCAuthUniaryMiddleware = func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	ctx = user.InjectOrgID(ctx, "fake")
-	return handler(ctx, req)
+func fakeGRPCAuthUniaryMiddleware(orgID string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = user.InjectOrgID(ctx, orgID)
+		return handler(ctx, req)
+	}
 }
 
-var fakeGRPCAuthStreamMiddleware = func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	ctx := user.InjectOrgID(ss.Context(), "fake")
-	return handler(srv, serverStream{
-		ctx:          ctx,
-		ServerStream: ss,
-	})
+func fakeGRPCAuthStreamMiddleware(orgID string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := user.InjectOrgID(ss.Context(), orgID)
+		return handler(srv, serverStream{
+			ctx:          ctx,
+			ServerStream: ss,
+		})
+	}
 }
 
 type serverStream struct {
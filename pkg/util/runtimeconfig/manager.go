@@ -9,7 +9,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
@@ -36,15 +39,17 @@ type ManagerConfig struct {
 // RegisterFlags registers flags.
 func (mc *ManagerConfig) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&mc.LoadPath, "runtime-config.file", "", "File with the configuration that can be updated in runtime.")
-	f.DurationVar(&mc.ReloadPeriod, "runtime-config.reload-period", 10*time.Second, "How often to check runtime config file.")
+	f.DurationVar(&mc.ReloadPeriod, "runtime-config.reload-period", 10*time.Second, "How often to check runtime config file. The file is also reloaded when Cortex receives a SIGHUP.")
 }
 
 // Manager periodically reloads the configuration from a file, and keeps this
-// configuration available for clients.
+// configuration available for clients. The configuration is also reloaded on SIGHUP.
+// If the file can't be loaded, the previously loaded configuration is kept.
 type Manager struct {
 	services.Service
 
-	cfg ManagerConfig
+	cfg    ManagerConfig
+	sighup chan os.Signal
 
 	listenersMtx sync.Mutex
 	listeners    []chan interface{}
@@ -63,7 +68,8 @@ func NewRuntimeConfigManager(cfg ManagerConfig, registerer prometheus.Registerer
 	}
 
 	mgr := Manager{
-		cfg: cfg,
+		cfg:    cfg,
+		sighup: make(chan os.Signal, 1),
 		configLoadSuccess: promauto.With(registerer).NewGauge(prometheus.GaugeOpts{
 			Name: "cortex_runtime_config_last_reload_successful",
 			Help: "Whether the last runtime-config reload attempt was successful.",
@@ -74,6 +80,9 @@ func NewRuntimeConfigManager(cfg ManagerConfig, registerer prometheus.Registerer
 		}, []string{"sha256"}),
 	}
 
+	// Registered before starting, so that a SIGHUP doesn't terminate the process meanwhile.
+	signal.Notify(mgr.sighup, syscall.SIGHUP)
+
 	mgr.Service = services.NewBasicService(mgr.start, mgr.loop, mgr.stop)
 	return &mgr, nil
 }
@@ -136,6 +145,11 @@ func (om *Manager) loop(ctx context.Context) error {
 				// Log but don't stop on error - we don't want to halt all ingesters because of a typo
 				level.Error(util.Logger).Log("msg", "failed to load config", "err", err)
 			}
+		case <-om.sighup:
+			level.Info(util.Logger).Log("msg", "reloading runtime config on SIGHUP")
+			if err := om.loadConfig(); err != nil {
+				level.Error(util.Logger).Log("msg", "failed to load config", "err", err)
+			}
 		case <-ctx.Done():
 			return nil
 		}
@@ -191,6 +205,8 @@ func (om *Manager) callListeners(newValue interface{}) {
 
 // Stop stops the Manager
 func (om *Manager) stop(_ error) error {
+	signal.Stop(om.sighup)
+
 	om.listenersMtx.Lock()
 	defer om.listenersMtx.Unlock()
 
@@ -3,11 +3,13 @@ package runtimeconfig
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -19,6 +21,7 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/cortexproject/cortex/pkg/util/test"
 )
 
 type TestLimits struct {
@@ -254,3 +257,42 @@ func TestOverridesManager_StopClosesListenerChannels(t *testing.T) {
 		t.Fatal("channel not closed")
 	}
 }
+
+func TestOverridesManager_ReloadOnSIGHUP(t *testing.T) {
+	config, overridesManagerConfig := newTestOverridesManagerConfig(t, 555)
+	overridesManagerConfig.ReloadPeriod = time.Hour
+
+	invalid := atomic.NewBool(false)
+	loader := overridesManagerConfig.Loader
+	overridesManagerConfig.Loader = func(r io.Reader) (interface{}, error) {
+		if invalid.Load() {
+			return nil, errors.New("invalid config")
+		}
+		return loader(r)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	overridesManager, err := NewRuntimeConfigManager(overridesManagerConfig, reg)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), overridesManager))
+	defer func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), overridesManager))
+	}()
+	require.Equal(t, 555, overridesManager.GetConfig())
+
+	// The config is reloaded on SIGHUP, without waiting for the reload period.
+	config.Store(1111)
+	overridesManager.sighup <- syscall.SIGHUP
+	test.Poll(t, time.Second, 1111, func() interface{} {
+		return overridesManager.GetConfig()
+	})
+
+	// An invalid config is rejected, and the previous one is kept.
+	invalid.Store(true)
+	config.Store(2222)
+	overridesManager.sighup <- syscall.SIGHUP
+	test.Poll(t, time.Second, float64(0), func() interface{} {
+		return testutil.ToFloat64(overridesManager.configLoadSuccess)
+	})
+	require.Equal(t, 1111, overridesManager.GetConfig())
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/tracing"
+	"go.opentelemetry.io/otel/api/global"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+const (
+	BackendJaeger = "jaeger"
+	BackendOTLP   = "otlp"
+)
+
+// Config for tracing.
+type Config struct {
+	Backend string     `yaml:"backend"`
+	OTLP    OTLPConfig `yaml:"otlp"`
+}
+
+// OTLPConfig configures the exporter of traces to an OpenTelemetry collector.
+type OTLPConfig struct {
+	Endpoint string                 `yaml:"endpoint"`
+	Headers  flagext.StringSliceCSV `yaml:"headers"`
+	Insecure bool                   `yaml:"insecure"`
+}
+
+// RegisterFlags registers flag.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Backend, "tracing.backend", BackendJaeger, fmt.Sprintf("Backend traces are exported to. Supported values are: %s, %s. The %s backend is configured via the JAEGER_* environment variables, and it's enabled only if JAEGER_AGENT_HOST or JAEGER_SAMPLER_MANAGER_HOST_PORT is set.", BackendJaeger, BackendOTLP, BackendJaeger))
+	cfg.OTLP.RegisterFlags(f)
+}
+
+// RegisterFlags registers flag.
+func (cfg *OTLPConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Endpoint, "tracing.otlp.endpoint", "localhost:55680", "Address of the OpenTelemetry collector traces are exported to, when using the otlp backend.")
+	f.Var(&cfg.Headers, "tracing.otlp.headers", "Comma-separated list of name=value headers sent to the OpenTelemetry collector, when using the otlp backend.")
+	f.BoolVar(&cfg.Insecure, "tracing.otlp.insecure", false, "Disable TLS when connecting to the OpenTelemetry collector.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	switch cfg.Backend {
+	case BackendJaeger:
+		return nil
+	case BackendOTLP:
+		_, err := cfg.OTLP.headers()
+		return err
+	default:
+		return fmt.Errorf("unsupported tracing backend: %s", cfg.Backend)
+	}
+}
+
+func (cfg *OTLPConfig) headers() (map[string]string, error) {
+	headers := make(map[string]string, len(cfg.Headers))
+	for _, h := range cfg.Headers {
+		parts := strings.SplitN(h, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q, expected name=value", h)
+		}
+		headers[parts[0]] = parts[1]
+	}
+	return headers, nil
+}
+
+// Init registers the configured backend as the OpenTracing implementation. The returned
+// closer flushes the pending traces.
+func Init(cfg Config, serviceName string) (io.Closer, error) {
+	switch cfg.Backend {
+	case BackendJaeger:
+		return tracing.NewFromEnv(serviceName)
+	case BackendOTLP:
+		return installOTLP(cfg.OTLP, serviceName)
+	default:
+		return nil, fmt.Errorf("unsupported tracing backend: %s", cfg.Backend)
+	}
+}
+
+// installOTLP registers an OpenTelemetry tracer exporting to an OTLP collector, bridged to OpenTracing
+// so that the existing instrumentation is kept.
+func installOTLP(cfg OTLPConfig, serviceName string) (io.Closer, error) {
+	headers, err := cfg.headers()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []otlp.ExporterOption{otlp.WithAddress(cfg.Endpoint), otlp.WithHeaders(headers)}
+	if cfg.Insecure {
+		opts = append(opts, otlp.WithInsecure())
+	}
+
+	exporter, err := otlp.NewExporter(opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the OTLP exporter")
+	}
+
+	processor, err := sdktrace.NewBatchSpanProcessor(exporter)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the span processor")
+	}
+
+	provider, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String(serviceName))),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create the tracer provider")
+	}
+	provider.RegisterSpanProcessor(processor)
+
+	bridgeTracer, wrapperProvider := otbridge.NewTracerPair(provider.Tracer(serviceName))
+	opentracing.SetGlobalTracer(bridgeTracer)
+	global.SetTraceProvider(wrapperProvider)
+
+	return closerFunc(func() error {
+		// Unregistering the processor flushes the pending spans.
+		provider.UnregisterSpanProcessor(processor)
+		return exporter.Stop()
+	}), nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
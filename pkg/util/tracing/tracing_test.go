@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg         Config
+		expectedErr string
+	}{
+		"jaeger": {
+			cfg: Config{Backend: BackendJaeger},
+		},
+		"otlp with headers": {
+			cfg: Config{Backend: BackendOTLP, OTLP: OTLPConfig{Headers: []string{"authorization=Bearer token", "x-scope=a=b"}}},
+		},
+		"otlp with a malformed header": {
+			cfg:         Config{Backend: BackendOTLP, OTLP: OTLPConfig{Headers: []string{"authorization"}}},
+			expectedErr: `invalid OTLP header "authorization", expected name=value`,
+		},
+		"unsupported backend": {
+			cfg:         Config{Backend: "zipkin"},
+			expectedErr: "unsupported tracing backend: zipkin",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestOTLPConfig_Headers(t *testing.T) {
+	cfg := OTLPConfig{Headers: []string{"authorization=Bearer token", "x-scope=a=b"}}
+
+	headers, err := cfg.headers()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer token", "x-scope": "a=b"}, headers)
+}
@@ -3,9 +3,12 @@ package validation
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/prometheus/prometheus/pkg/relabel"
+	"golang.org/x/time/rate"
 
 	"github.com/cortexproject/cortex/pkg/util/flagext"
 )
@@ -69,9 +72,26 @@ type Limits struct {
 	MaxChunksPerQuery    int           `yaml:"max_chunks_per_query"`
 	MaxQueryLength       time.Duration `yaml:"max_query_length"`
 	MaxQueryParallelism  int           `yaml:"max_query_parallelism"`
+	MaxQuerySplits       int           `yaml:"max_query_splits"`
+	AllowPartialResults  bool          `yaml:"allow_partial_results"`
+	QueryDownsampling    bool          `yaml:"query_downsampling"`
+	MinStep              time.Duration `yaml:"min_step"`
+	MinStepClamp         bool          `yaml:"min_step_clamp"`
 	CardinalityLimit     int           `yaml:"cardinality_limit"`
 	MaxCacheFreshness    time.Duration `yaml:"max_cache_freshness"`
-	MaxQueriersPerTenant int           `yaml:"max_queriers_per_tenant"`
+	MaxQueriersPerTenant float64       `yaml:"max_queriers_per_tenant"`
+	MinQueriersPerTenant int           `yaml:"min_queriers_per_tenant"`
+	QueryTimeout         time.Duration `yaml:"query_timeout"`
+	QueryRate            float64       `yaml:"query_rate"`
+	QueryBurst           int           `yaml:"query_burst"`
+	MaxConcurrentQueries int           `yaml:"max_concurrent_queries"`
+	DownstreamURL        string        `yaml:"frontend_downstream_url"`
+	QueryBudget          float64       `yaml:"query_budget"`
+	QueryWeight          float64       `yaml:"query_weight"`
+
+	RequiredMatchers      flagext.StringSlice `yaml:"required_matchers"`
+	BlockedQueryFunctions flagext.StringSlice `yaml:"blocked_query_functions"`
+	BlockedQueries        flagext.StringSlice `yaml:"blocked_queries"`
 
 	// Ruler defaults and limits.
 	RulerEvaluationDelay        time.Duration `yaml:"ruler_evaluation_delay_duration"`
@@ -108,7 +128,7 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.BoolVar(&l.EnforceMetadataMetricName, "validation.enforce-metadata-metric-name", true, "Enforce every metadata has a metric name.")
 
 	f.IntVar(&l.MaxSeriesPerQuery, "ingester.max-series-per-query", 100000, "The maximum number of series for which a query can fetch samples from each ingester. This limit is enforced only in the ingesters (when querying samples not flushed to the storage yet) and it's a per-instance limit. This limit is ignored when running the Cortex blocks storage.")
-	f.IntVar(&l.MaxSamplesPerQuery, "ingester.max-samples-per-query", 1000000, "The maximum number of samples that a query can return. This limit only applies when running the Cortex chunks storage with -querier.ingester-streaming=false.")
+	f.IntVar(&l.MaxSamplesPerQuery, "ingester.max-samples-per-query", 1000000, "The maximum number of samples that a query can return. This limit only applies when running the Cortex chunks storage with -querier.ingester-streaming=false, and to the points per series of the range queries when -frontend.query-downsampling is enabled.")
 	f.IntVar(&l.MaxLocalSeriesPerUser, "ingester.max-series-per-user", 5000000, "The maximum number of active series per user, per ingester. 0 to disable.")
 	f.IntVar(&l.MaxLocalSeriesPerMetric, "ingester.max-series-per-metric", 50000, "The maximum number of active series per metric name, per ingester. 0 to disable.")
 	f.IntVar(&l.MaxGlobalSeriesPerUser, "ingester.max-global-series-per-user", 0, "The maximum number of active series per user, across the cluster. 0 to disable. Supported only if -distributor.shard-by-all-labels is true.")
@@ -123,9 +143,25 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&l.MaxChunksPerQuery, "store.query-chunk-limit", 2e6, "Maximum number of chunks that can be fetched in a single query. This limit is enforced when fetching chunks from the long-term storage. When running the Cortex chunks storage, this limit is enforced in the querier, while when running the Cortex blocks storage this limit is both enforced in the querier and store-gateway. 0 to disable.")
 	f.DurationVar(&l.MaxQueryLength, "store.max-query-length", 0, "Limit the query time range (end - start time). This limit is enforced in the query-frontend (on the received query), in the querier (on the query possibly split by the query-frontend) and in the chunks storage. 0 to disable.")
 	f.IntVar(&l.MaxQueryParallelism, "querier.max-query-parallelism", 14, "Maximum number of queries will be scheduled in parallel by the frontend.")
+	f.IntVar(&l.MaxQuerySplits, "frontend.max-query-splits", 0, "Maximum number of sub-queries a query can be split into by the query-frontend. Queries exceeding it are rejected before any sub-query is executed. 0 to disable.")
+	f.BoolVar(&l.AllowPartialResults, "frontend.allow-partial-results", false, "Return the results of the successful sub-queries, with a warning listing the omitted time ranges, when some of the sub-queries a query is split into fail with a server error, instead of failing the whole query. The results of such queries are incomplete.")
+	f.BoolVar(&l.QueryDownsampling, "frontend.query-downsampling", false, "Increase the step of the range queries which would return more points per series than -ingester.max-samples-per-query, instead of executing them as requested, and add a warning to their response. The results of such queries have a lower resolution than requested. Queries exceeding the 11,000 points per series allowed by the API are still rejected.")
+	f.DurationVar(&l.MinStep, "frontend.min-step", 0, "Minimum step of the range queries. The queries with a smaller step are rejected with HTTP 400, unless -frontend.min-step-clamp is enabled. 0 to disable.")
+	f.BoolVar(&l.MinStepClamp, "frontend.min-step-clamp", false, "Execute the range queries with a step below -frontend.min-step with the minimum step instead, and add a warning to their response, instead of rejecting them.")
+	f.Var(&l.RequiredMatchers, "frontend.required-matcher", "Label matcher, like cluster=\"x\", added to all the selectors of the queries, to restrict the series they can select. The queries selecting another value of the label are rejected with HTTP 400. Can be repeated to require multiple matchers.")
+	f.Var(&l.BlockedQueryFunctions, "frontend.blocked-query-function", "Name of a PromQL function, like holt_winters, the queries can't call. The queries calling it are rejected with HTTP 400. Can be repeated to block multiple functions.")
+	f.Var(&l.BlockedQueries, "frontend.blocked-query", "Regular expression matching the queries to reject with HTTP 403. The queries are blocked if the expression matches any part of them. Can be repeated to block multiple query patterns. Meant to be set per tenant in the runtime config, to block the queries overloading the cluster without restarting it.")
 	f.IntVar(&l.CardinalityLimit, "store.cardinality-limit", 1e5, "Cardinality limit for index queries. This limit is ignored when running the Cortex blocks storage. 0 to disable.")
 	f.DurationVar(&l.MaxCacheFreshness, "frontend.max-cache-freshness", 1*time.Minute, "Most recent allowed cacheable result per-tenant, to prevent caching very recent results that might still be in flux.")
-	f.IntVar(&l.MaxQueriersPerTenant, "frontend.max-queriers-per-tenant", 0, "Maximum number of queriers that can handle requests for a single tenant. If set to 0 or value higher than number of available queriers, *all* queriers will handle requests for the tenant. Each frontend (or query-scheduler, if used) will select the same set of queriers for the same tenant (given that all queriers are connected to all frontends / query-schedulers). This option only works with queriers connecting to the query-frontend / query-scheduler, not when using downstream URL.")
+	f.Float64Var(&l.MaxQueriersPerTenant, "frontend.max-queriers-per-tenant", 0, "Maximum number of queriers that can handle requests for a single tenant. If set to 0 or value higher than number of available queriers, *all* queriers will handle requests for the tenant. If set to a value between 0 and 1, it's the fraction of the available queriers, rounded up, and the number of queriers is updated as queriers connect and disconnect. Each frontend (or query-scheduler, if used) will select the same set of queriers for the same tenant (given that all queriers are connected to all frontends / query-schedulers). This option only works with queriers connecting to the query-frontend / query-scheduler, not when using downstream URL.")
+	f.IntVar(&l.MinQueriersPerTenant, "frontend.min-queriers-per-tenant", 0, "Minimum number of queriers that can handle requests for a single tenant, when -frontend.max-queriers-per-tenant is a fraction of the available queriers. If fewer queriers are available, all of them handle requests for the tenant. 0 to disable.")
+	f.DurationVar(&l.QueryTimeout, "frontend.query-timeout", 0, "Maximum time a query can run once the query-frontend forwarded it to a querier or to the downstream URL. The deadline is propagated to the querier, which cancels the query when it expires. 0 to disable.")
+	f.Float64Var(&l.QueryRate, "frontend.query-rate-limit", 0, "Per-tenant rate limit of the queries received by the query-frontend, in queries per second. Queries exceeding it are rejected with HTTP 429. The limit is enforced by each query-frontend replica independently, so the overall rate allowed is multiplied by the number of replicas. 0 to disable.")
+	f.IntVar(&l.QueryBurst, "frontend.query-burst-size", 10, "Per-tenant allowed burst of queries received by the query-frontend, on top of -frontend.query-rate-limit.")
+	f.IntVar(&l.MaxConcurrentQueries, "frontend.max-concurrent-queries-per-tenant", 0, "Maximum number of queries of a single tenant, including the sub-queries of split queries, executed by queriers at the same time. Further queries wait in the queue until the tenant's running queries complete. The limit is enforced by each query-frontend replica independently. This option only works with queriers connecting to the query-frontend, not when using downstream URL. 0 to disable.")
+	f.StringVar(&l.DownstreamURL, "frontend.tenant-downstream-url", "", "URL of the downstream Prometheus the query-frontend forwards the queries of the tenant to, instead of the global -frontend.downstream-url or the queriers. Queries spanning multiple tenants use it only if all their tenants have the same downstream URL. This limit is meant to be set in the per-tenant overrides, to isolate the queries of heavy tenants.")
+	f.Float64Var(&l.QueryBudget, "frontend.query-budget", 0, "Querier-seconds a tenant can use per -frontend.query-budget-window, as reported by queriers. The queries of a tenant exceeding it are delayed by -frontend.query-budget-throttle-delay before being queued, but not rejected. The budget is accounted by each query-frontend replica independently. This option only works with queriers connecting to the query-frontend, not when using downstream URL or the query-scheduler. 0 to disable.")
+	f.Float64Var(&l.QueryWeight, "frontend.query-weight", 1, "Share of the queriers a tenant gets relative to the other tenants with queued queries, however many queries it queued: a tenant with a weight of 2 gets twice as many of its queries dispatched to the queriers as a tenant with a weight of 1, as long as both have queued queries. Queries spanning multiple tenants get the lowest weight of their tenants. The weights are applied by each query-frontend replica independently. This option only works with queriers connecting to the query-frontend, not when using downstream URL or the query-scheduler. Values lower than or equal to 0 are treated as 1.")
 
 	f.DurationVar(&l.RulerEvaluationDelay, "ruler.evaluation-delay-duration", 0, "Duration to delay the evaluation of rules to ensure the underlying metrics have been pushed to Cortex.")
 	f.IntVar(&l.RulerTenantShardSize, "ruler.tenant-shard-size", 0, "The default tenant's shard size when the shuffle-sharding strategy is used by ruler. When this setting is specified in the per-tenant overrides, a value of 0 disables shuffle sharding for the tenant.")
@@ -148,6 +184,12 @@ func (l *Limits) Validate(shardByAllLabels bool) error {
 		return errMaxGlobalSeriesPerUserValidation
 	}
 
+	for _, pattern := range l.BlockedQueries {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid blocked query pattern %q: %v", pattern, err)
+		}
+	}
+
 	return nil
 }
 
@@ -318,17 +360,106 @@ func (o *Overrides) MaxCacheFreshness(userID string) time.Duration {
 	return o.getOverridesForUser(userID).MaxCacheFreshness
 }
 
-// MaxQueriersPerUser returns the maximum number of queriers that can handle requests for this user.
-func (o *Overrides) MaxQueriersPerUser(userID string) int {
+// MaxQueriersPerUser returns the maximum number of queriers that can handle requests for this user,
+// or the fraction of the available queriers if lower than 1.
+func (o *Overrides) MaxQueriersPerUser(userID string) float64 {
 	return o.getOverridesForUser(userID).MaxQueriersPerTenant
 }
 
+// MinQueriersPerUser returns the minimum number of queriers that can handle requests for this user,
+// when the max queriers is a fraction of the available queriers.
+func (o *Overrides) MinQueriersPerUser(userID string) int {
+	return o.getOverridesForUser(userID).MinQueriersPerTenant
+}
+
+// QueryTimeout returns the maximum time a query forwarded by the frontend can run for this user.
+func (o *Overrides) QueryTimeout(userID string) time.Duration {
+	return o.getOverridesForUser(userID).QueryTimeout
+}
+
+// QueryRate returns the limit on the rate of queries received by the frontend for this user.
+func (o *Overrides) QueryRate(userID string) rate.Limit {
+	return rate.Limit(o.getOverridesForUser(userID).QueryRate)
+}
+
+// QueryBurst returns the burst of queries allowed by the frontend for this user.
+func (o *Overrides) QueryBurst(userID string) int {
+	return o.getOverridesForUser(userID).QueryBurst
+}
+
+// MaxConcurrentQueries returns the limit to the number of queries of this user executed
+// by queriers at the same time.
+func (o *Overrides) MaxConcurrentQueries(userID string) int {
+	return o.getOverridesForUser(userID).MaxConcurrentQueries
+}
+
+// DownstreamURL returns the downstream URL the frontend forwards the queries of this user to,
+// or an empty string if not overridden.
+func (o *Overrides) DownstreamURL(userID string) string {
+	return o.getOverridesForUser(userID).DownstreamURL
+}
+
+// QueryBudget returns the querier-seconds this user can use per budget window before
+// the frontend delays its queries.
+func (o *Overrides) QueryBudget(userID string) float64 {
+	return o.getOverridesForUser(userID).QueryBudget
+}
+
+// QueryWeight returns the share of the queriers this user gets relative to the other users.
+func (o *Overrides) QueryWeight(userID string) float64 {
+	return o.getOverridesForUser(userID).QueryWeight
+}
+
 // MaxQueryParallelism returns the limit to the number of sub-queries the
 // frontend will process in parallel.
 func (o *Overrides) MaxQueryParallelism(userID string) int {
 	return o.getOverridesForUser(userID).MaxQueryParallelism
 }
 
+// MaxQuerySplits returns the limit to the number of sub-queries the
+// frontend can split a query into.
+func (o *Overrides) MaxQuerySplits(userID string) int {
+	return o.getOverridesForUser(userID).MaxQuerySplits
+}
+
+// AllowPartialResults returns whether the frontend can return partial results when some
+// of the sub-queries fail.
+func (o *Overrides) AllowPartialResults(userID string) bool {
+	return o.getOverridesForUser(userID).AllowPartialResults
+}
+
+// QueryDownsampling returns whether the frontend increases the step of the range queries
+// returning too many points per series.
+func (o *Overrides) QueryDownsampling(userID string) bool {
+	return o.getOverridesForUser(userID).QueryDownsampling
+}
+
+// MinStep returns the minimum step of the range queries.
+func (o *Overrides) MinStep(userID string) time.Duration {
+	return o.getOverridesForUser(userID).MinStep
+}
+
+// MinStepClamp returns whether the range queries with a step below the minimum are executed
+// with the minimum step instead of being rejected.
+func (o *Overrides) MinStepClamp(userID string) bool {
+	return o.getOverridesForUser(userID).MinStepClamp
+}
+
+// RequiredMatchers returns the label matchers added to all the selectors of the queries.
+func (o *Overrides) RequiredMatchers(userID string) []string {
+	return o.getOverridesForUser(userID).RequiredMatchers
+}
+
+// BlockedQueryFunctions returns the names of the PromQL functions the queries can't call.
+func (o *Overrides) BlockedQueryFunctions(userID string) []string {
+	return o.getOverridesForUser(userID).BlockedQueryFunctions
+}
+
+// BlockedQueries returns the regular expressions matching the queries to reject.
+func (o *Overrides) BlockedQueries(userID string) []string {
+	return o.getOverridesForUser(userID).BlockedQueries
+}
+
 // EnforceMetricName whether to enforce the presence of a metric name.
 func (o *Overrides) EnforceMetricName(userID string) bool {
 	return o.getOverridesForUser(userID).EnforceMetricName
@@ -404,6 +535,11 @@ func (o *Overrides) StoreGatewayTenantShardSize(userID string) int {
 	return o.getOverridesForUser(userID).StoreGatewayTenantShardSize
 }
 
+// UserLimits returns the limits of the user, from its overrides or the defaults.
+func (o *Overrides) UserLimits(userID string) Limits {
+	return *o.getOverridesForUser(userID)
+}
+
 func (o *Overrides) getOverridesForUser(userID string) *Limits {
 	if o.tenantLimits != nil {
 		l := o.tenantLimits(userID)
@@ -413,3 +549,61 @@ func (o *Overrides) getOverridesForUser(userID string) *Limits {
 	}
 	return o.defaultLimits
 }
+
+// AllTrueBooleansPerTenant returns true only if the limit is true for all the tenants, and
+// for at least one of them.
+func AllTrueBooleansPerTenant(tenantIDs []string, f func(string) bool) bool {
+	for _, tenantID := range tenantIDs {
+		if !f(tenantID) {
+			return false
+		}
+	}
+	return len(tenantIDs) > 0
+}
+
+// SmallestPositiveIntPerTenant returns the smallest positive value of the limit across the
+// tenants, or 0 if it isn't positive for any of them.
+func SmallestPositiveIntPerTenant(tenantIDs []string, f func(string) int) int {
+	var result int
+	for _, tenantID := range tenantIDs {
+		if v := f(tenantID); v > 0 && (result == 0 || v < result) {
+			result = v
+		}
+	}
+	return result
+}
+
+// SmallestPositiveFloat64PerTenant returns the smallest positive value of the limit across the
+// tenants, or 0 if it isn't positive for any of them.
+func SmallestPositiveFloat64PerTenant(tenantIDs []string, f func(string) float64) float64 {
+	var result float64
+	for _, tenantID := range tenantIDs {
+		if v := f(tenantID); v > 0 && (result == 0 || v < result) {
+			result = v
+		}
+	}
+	return result
+}
+
+// SmallestPositiveDurationPerTenant returns the smallest positive value of the limit across the
+// tenants, or 0 if it isn't positive for any of them.
+func SmallestPositiveDurationPerTenant(tenantIDs []string, f func(string) time.Duration) time.Duration {
+	var result time.Duration
+	for _, tenantID := range tenantIDs {
+		if v := f(tenantID); v > 0 && (result == 0 || v < result) {
+			result = v
+		}
+	}
+	return result
+}
+
+// LargestDurationPerTenant returns the largest value of the limit across the tenants.
+func LargestDurationPerTenant(tenantIDs []string, f func(string) time.Duration) time.Duration {
+	var result time.Duration
+	for _, tenantID := range tenantIDs {
+		if v := f(tenantID); v > result {
+			result = v
+		}
+	}
+	return result
+}
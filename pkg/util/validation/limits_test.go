@@ -1,13 +1,17 @@
 package validation
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
 )
 
 func TestLimits_Validate(t *testing.T) {
@@ -33,6 +37,10 @@ func TestLimits_Validate(t *testing.T) {
 			shardByAllLabels: true,
 			expected:         nil,
 		},
+		"invalid blocked query pattern": {
+			limits:   Limits{BlockedQueries: flagext.StringSlice{`rate(`}},
+			expected: errors.New("invalid blocked query pattern \"rate(\": error parsing regexp: missing closing ): `rate(`"),
+		},
 	}
 
 	for testName, testData := range tests {
@@ -113,3 +121,38 @@ metric_relabel_configs:
 
 	assert.Equal(t, []*relabel.Config{&exp}, l.MetricRelabelConfigs)
 }
+
+func TestAllTrueBooleansPerTenant(t *testing.T) {
+	values := map[string]bool{"a": true, "b": true, "c": false}
+	f := func(tenantID string) bool { return values[tenantID] }
+
+	assert.True(t, AllTrueBooleansPerTenant([]string{"a", "b"}, f))
+	assert.False(t, AllTrueBooleansPerTenant([]string{"a", "c"}, f))
+	assert.False(t, AllTrueBooleansPerTenant(nil, f))
+}
+
+func TestLargestDurationPerTenant(t *testing.T) {
+	values := map[string]time.Duration{"a": 0, "b": 10 * time.Second, "c": 5 * time.Second}
+	f := func(tenantID string) time.Duration { return values[tenantID] }
+
+	assert.Equal(t, 10*time.Second, LargestDurationPerTenant([]string{"a", "b", "c"}, f))
+	assert.Equal(t, time.Duration(0), LargestDurationPerTenant([]string{"a"}, f))
+	assert.Equal(t, time.Duration(0), LargestDurationPerTenant(nil, f))
+}
+
+func TestSmallestPositivePerTenant(t *testing.T) {
+	values := map[string]int{"a": 0, "b": 10, "c": 5, "d": -1}
+	f := func(tenantID string) int { return values[tenantID] }
+
+	assert.Equal(t, 5, SmallestPositiveIntPerTenant([]string{"a", "b", "c", "d"}, f))
+	assert.Equal(t, 10, SmallestPositiveIntPerTenant([]string{"a", "b"}, f))
+	assert.Equal(t, 0, SmallestPositiveIntPerTenant([]string{"a", "d"}, f))
+	assert.Equal(t, 0, SmallestPositiveIntPerTenant(nil, f))
+
+	assert.Equal(t, 0.5, SmallestPositiveFloat64PerTenant([]string{"a", "b", "c"}, func(tenantID string) float64 {
+		return float64(values[tenantID]) / 10
+	}))
+	assert.Equal(t, 5*time.Second, SmallestPositiveDurationPerTenant([]string{"a", "b", "c"}, func(tenantID string) time.Duration {
+		return time.Duration(values[tenantID]) * time.Second
+	}))
+}